@@ -3,6 +3,7 @@ package surf
 import (
 	_http "net/http"
 	"net/url"
+	"time"
 
 	"github.com/enetx/http"
 )
@@ -17,10 +18,12 @@ import (
 //   - Cookies and sessions
 //   - Request/Response middleware
 //   - Headers (User-Agent, custom headers)
-//   - Proxy configuration
+//   - Proxy configuration, including per-request proxies, proxy pools and proxy routing
 //   - Timeout settings
 //   - Redirect policies
 //   - Impersonate browser headers
+//   - Rate and concurrency limits, adaptive throttling
+//   - Circuit breaker
 //
 // Known limitations:
 //   - Retry logic is NOT supported (implemented in Request.Do(), not in transport)
@@ -83,10 +86,55 @@ func (s *TransportAdapter) RoundTrip(req *_http.Request) (*_http.Response, error
 	}
 
 	if err := s.client.applyReqMW(sreq); err != nil {
+		sreq.unsent()
 		return nil, err
 	}
 
-	_resp, err := s.client.cli.Transport.RoundTrip(sreq.request)
+	builder := s.client.builder
+
+	if err := s.client.routeProxy(sreq); err != nil {
+		sreq.unsent()
+		return nil, err
+	}
+
+	cli, err := s.client.proxyClient(sreq.proxy)
+	if err != nil {
+		sreq.unsent()
+		return nil, err
+	}
+
+	if builder != nil && builder.breaker != nil {
+		if err := builder.breaker.allow(sreq); err != nil {
+			sreq.unsent()
+			return nil, err
+		}
+	}
+
+	release, err := s.client.admit(sreq)
+	if err != nil {
+		if builder != nil && builder.breaker != nil {
+			builder.breaker.record(sreq, circuitIgnored)
+		}
+
+		sreq.unsent()
+
+		return nil, err
+	}
+
+	sent := time.Now()
+
+	_resp, err := cli.Transport.RoundTrip(sreq.request)
+
+	s.client.settle(sreq, sent, _resp, err, release)
+
+	if builder != nil && builder.proxyPool != nil && sreq.request.Context().Err() == nil {
+		builder.proxyPool.report(sreq.proxy, time.Since(sent), err)
+	}
+
+	if builder != nil && builder.breaker != nil {
+		builder.breaker.observe(sreq, _resp, err)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,258 @@
+package surf
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AltSvcCache remembers the HTTP/3 alternative services advertised by origins in the Alt-Svc
+// response header (RFC 7838). With HTTP3() enabled, the first request to an origin is sent over
+// TCP and later requests are upgraded to HTTP/3 once the origin has advertised it, as browsers do.
+//
+// Alternatives that fail are marked broken and skipped for an exponentially growing period.
+// Beyond _altSvcMaxEntries origins, expired entries and then the ones expiring first are dropped.
+// The cache is safe for concurrent use and can be persisted with Save and Load.
+type AltSvcCache struct {
+	mu      sync.Mutex
+	entries map[string]*altSvcEntry
+}
+
+// altSvcEntry is the HTTP/3 alternative recorded for an origin.
+type altSvcEntry struct {
+	Origin      string    `json:"origin"`                 // host:port of the origin
+	Alternative string    `json:"alternative"`            // host:port of the HTTP/3 endpoint, host may be empty
+	Expires     time.Time `json:"expires"`                // End of the advertised freshness lifetime
+	BrokenUntil time.Time `json:"broken_until,omitzero"`  // Alternative is skipped until this time
+	BrokenCount int       `json:"broken_count,omitempty"` // Consecutive failures, drives the backoff
+}
+
+// NewAltSvcCache creates an empty Alt-Svc cache.
+func NewAltSvcCache() *AltSvcCache { return &AltSvcCache{entries: make(map[string]*altSvcEntry)} }
+
+// Len returns the number of origins with a known HTTP/3 alternative.
+func (c *AltSvcCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Clear removes all entries.
+func (c *AltSvcCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+// Save writes the unexpired entries as JSON.
+func (c *AltSvcCache) Save(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]*altSvcEntry, 0, len(c.entries))
+
+	for _, e := range c.entries {
+		if e.Expires.After(now) {
+			entries = append(entries, e)
+		}
+	}
+
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// Load reads entries written by Save, replacing entries for the same origins.
+// Expired entries are skipped.
+func (c *AltSvcCache) Load(r io.Reader) error {
+	var entries []*altSvcEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for _, e := range entries {
+		if e.Origin != "" && e.Alternative != "" && e.Expires.After(now) {
+			c.store(e)
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the address of the usable HTTP/3 alternative for origin.
+func (c *AltSvcCache) lookup(origin string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[origin]
+	if !ok {
+		return "", false
+	}
+
+	now := time.Now()
+
+	if !e.Expires.After(now) {
+		delete(c.entries, origin)
+		return "", false
+	}
+
+	if e.BrokenUntil.After(now) {
+		return "", false
+	}
+
+	host, port, err := net.SplitHostPort(e.Alternative)
+	if err != nil {
+		return "", false
+	}
+
+	if host == "" {
+		host, _, _ = net.SplitHostPort(origin)
+	}
+
+	return net.JoinHostPort(host, port), true
+}
+
+// update applies the Alt-Svc header values of a response from origin. A header replaces
+// the previously advertised alternatives; "clear" or a zero max age removes them.
+func (c *AltSvcCache) update(origin string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	alternative, maxAge, ok := parseAltSvc(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !ok || maxAge <= 0 {
+		delete(c.entries, origin)
+		return
+	}
+
+	expires := time.Now().Add(maxAge)
+
+	if e, found := c.entries[origin]; found && e.Alternative == alternative {
+		e.Expires = expires
+		return
+	}
+
+	c.store(&altSvcEntry{Origin: origin, Alternative: alternative, Expires: expires})
+}
+
+// advertise records alternative for origin for maxAge unless origin already has an entry,
+// so that alternatives learned from Alt-Svc headers and their failure state are kept.
+func (c *AltSvcCache) advertise(origin, alternative string, maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[origin]; ok && e.Expires.After(time.Now()) {
+		return
+	}
+
+	c.store(&altSvcEntry{Origin: origin, Alternative: alternative, Expires: time.Now().Add(maxAge)})
+}
+
+// store records e, making room for a new origin beyond _altSvcMaxEntries by dropping the expired
+// entries, then the entry expiring first.
+func (c *AltSvcCache) store(e *altSvcEntry) {
+	if _, ok := c.entries[e.Origin]; !ok && len(c.entries) >= _altSvcMaxEntries {
+		now := time.Now()
+
+		var first *altSvcEntry
+
+		for origin, entry := range c.entries {
+			if !entry.Expires.After(now) {
+				delete(c.entries, origin)
+				continue
+			}
+
+			if first == nil || entry.Expires.Before(first.Expires) {
+				first = entry
+			}
+		}
+
+		if first != nil && len(c.entries) >= _altSvcMaxEntries {
+			delete(c.entries, first.Origin)
+		}
+	}
+
+	c.entries[e.Origin] = e
+}
+
+// markBroken excludes the alternative of origin, doubling the period on every consecutive failure.
+func (c *AltSvcCache) markBroken(origin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[origin]
+	if !ok {
+		return
+	}
+
+	backoff := min(_altSvcBrokenBackoff<<min(e.BrokenCount, 16), _altSvcBrokenMaxBackoff)
+
+	e.BrokenCount++
+	e.BrokenUntil = time.Now().Add(backoff)
+}
+
+// markWorking resets the failure state of the alternative of origin.
+func (c *AltSvcCache) markWorking(origin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[origin]; ok {
+		e.BrokenCount = 0
+		e.BrokenUntil = time.Time{}
+	}
+}
+
+// parseAltSvc returns the first h3 alternative in the Alt-Svc header values with its max age.
+// The boolean result is false when the header clears the alternatives or advertises no h3 endpoint.
+func parseAltSvc(values []string) (string, time.Duration, bool) {
+	for _, value := range values {
+		for alt := range strings.SplitSeq(value, ",") {
+			params := strings.Split(alt, ";")
+
+			protocol, authority, _ := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if protocol == "clear" {
+				return "", 0, false
+			}
+
+			if protocol != "h3" {
+				continue
+			}
+
+			authority = strings.Trim(authority, `"`)
+			if _, _, err := net.SplitHostPort(authority); err != nil {
+				continue
+			}
+
+			maxAge := _altSvcMaxAge
+
+			for _, param := range params[1:] {
+				key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if key != "ma" {
+					continue
+				}
+
+				if seconds, err := strconv.ParseUint(strings.Trim(val, `"`), 10, 64); err == nil {
+					maxAge = time.Duration(min(seconds, 1<<31)) * time.Second
+				}
+			}
+
+			return authority, maxAge, true
+		}
+	}
+
+	return "", 0, false
+}
@@ -343,17 +343,77 @@ func (b *Body) Contains(pattern any) bool {
 		return false
 	}
 
+	return containsPattern(r.Ok(), pattern)
+}
+
+// peekReader passes a body through while keeping its first bytes, and calls inspect with them
+// once limit bytes are read, the body ends or it is closed. Bodies are matched against patterns
+// this way as they are read, instead of reading them in the response middlewares, which run
+// under the middleware lock of the client.
+type peekReader struct {
+	io.ReadCloser
+	mu      sync.Mutex
+	peek    []byte
+	limit   int
+	inspect func(peek []byte) // Reset once called
+}
+
+// newPeekReader returns a peekReader of body calling inspect with its first limit bytes.
+func newPeekReader(body io.ReadCloser, limit int, inspect func(peek []byte)) *peekReader {
+	return &peekReader{ReadCloser: body, limit: limit, inspect: inspect}
+}
+
+func (r *peekReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	r.mu.Lock()
+
+	if r.inspect != nil {
+		r.peek = append(r.peek, p[:min(n, r.limit-len(r.peek))]...)
+	}
+
+	done := len(r.peek) >= r.limit || err != nil
+
+	r.mu.Unlock()
+
+	if done {
+		r.finish()
+	}
+
+	return n, err
+}
+
+func (r *peekReader) Close() error {
+	r.finish()
+	return r.ReadCloser.Close()
+}
+
+// finish calls inspect with the bytes kept, at most once.
+func (r *peekReader) finish() {
+	r.mu.Lock()
+	inspect, peek := r.inspect, r.peek
+	r.inspect, r.peek = nil, nil
+	r.mu.Unlock()
+
+	if inspect != nil {
+		inspect(peek)
+	}
+}
+
+// containsPattern reports whether content contains pattern (byte slice or string, case-insensitive,
+// or *regexp.Regexp).
+func containsPattern(content g.Bytes, pattern any) bool {
 	switch p := pattern.(type) {
 	case []byte:
-		return r.Ok().Lower().Contains(g.Bytes(p).Lower())
+		return content.Lower().Contains(g.Bytes(p).Lower())
 	case g.Bytes:
-		return r.Ok().Lower().Contains(p.Lower())
+		return content.Lower().Contains(p.Lower())
 	case string:
-		return r.Ok().String().Lower().Contains(g.String(p).Lower())
+		return content.String().Lower().Contains(g.String(p).Lower())
 	case g.String:
-		return r.Ok().String().Lower().Contains(p.Lower())
+		return content.String().Lower().Contains(p.Lower())
 	case *regexp.Regexp:
-		return r.Ok().String().Regexp().Match(p)
+		return content.String().Regexp().Match(p)
 	}
 
 	return false
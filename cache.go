@@ -0,0 +1,667 @@
+package surf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+)
+
+// CacheStatus reports how the HTTP cache of the client answered a request.
+type CacheStatus int
+
+const (
+	CacheNone        CacheStatus = iota // The client has no cache, or the request bypassed it
+	CacheMiss                           // The response was received from the server
+	CacheHit                            // A fresh stored response was served
+	CacheRevalidated                    // A stored response was served after a 304 Not Modified
+	CacheStale                          // A stale stored response was served
+)
+
+// String returns the name of the cache status.
+func (s CacheStatus) String() string {
+	switch s {
+	case CacheMiss:
+		return "miss"
+	case CacheHit:
+		return "hit"
+	case CacheRevalidated:
+		return "revalidated"
+	case CacheStale:
+		return "stale"
+	default:
+		return "none"
+	}
+}
+
+// CacheStore stores the encoded responses of the HTTP cache by key. Implementations must be safe
+// for concurrent use. MemoryCache and DiskCache are provided.
+type CacheStore interface {
+	// Get returns the value stored for key.
+	Get(key string) ([]byte, bool)
+	// Set stores value for key, replacing any previous value.
+	Set(key string, value []byte)
+	// Delete removes the value stored for key.
+	Delete(key string)
+}
+
+// CacheSettings provides a fluent interface for configuring the HTTP cache of the client.
+type CacheSettings struct {
+	builder *Builder
+	cache   *httpCache
+}
+
+// Shared makes the cache a shared cache (RFC 9111): s-maxage is honored, and responses marked
+// private or to requests with an Authorization header, unless explicitly allowed, are not stored.
+func (cs *CacheSettings) Shared() *CacheSettings {
+	cs.cache.shared = true
+	return cs
+}
+
+// Offline serves every request from the cache, stale responses included, without contacting the
+// servers. Requests without a stored response get a 504 Gateway Timeout response, as requests with
+// Cache-Control: only-if-cached do.
+func (cs *CacheSettings) Offline() *CacheSettings {
+	cs.cache.offline = true
+	return cs
+}
+
+// MaxEntrySize sets the largest response body stored, 10MB by default.
+func (cs *CacheSettings) MaxEntrySize(size int64) *CacheSettings {
+	cs.cache.maxEntry = size
+	return cs
+}
+
+// Set applies the cache settings to the client.
+func (cs *CacheSettings) Set() *Builder {
+	cs.builder.cache = cs.cache
+	return cs.builder
+}
+
+// httpCache stores responses to GET requests and serves them per RFC 9111.
+type httpCache struct {
+	store        CacheStore
+	shared       bool
+	offline      bool
+	maxEntry     int64
+	revalidating sync.Map // Keys of the responses revalidated in the background
+}
+
+func newHTTPCache(store CacheStore) *httpCache {
+	return &httpCache{store: store, maxEntry: _cacheMaxEntrySize}
+}
+
+// cacheEntry is a stored response with the request headers it varies on.
+type cacheEntry struct {
+	Status       int         `json:"status"`
+	Proto        string      `json:"proto"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	Vary         http.Header `json:"vary,omitempty"` // Request headers named by Vary
+	RequestTime  time.Time   `json:"request_time"`   // When the request was sent
+	ResponseTime time.Time   `json:"response_time"`  // When the response was received
+	Validated    bool        `json:"-"`              // Conditional headers were added to the request
+}
+
+// cacheLookup is the answer of the cache to a request before it is sent.
+type cacheLookup struct {
+	resp   *http.Response // Response served without contacting the server
+	status CacheStatus
+	entry  *cacheEntry // Stored response validated by the request, or served on errors
+	bypass bool        // The request is neither served nor stored
+}
+
+// cacheKey returns the key of the responses to req.
+func cacheKey(req *http.Request) string {
+	u := *req.URL
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String()
+}
+
+// cacheControl returns the directives of the Cache-Control headers, with lowercase names and
+// unquoted values.
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+
+	for _, line := range h.Values(header.CACHE_CONTROL) {
+		for directive := range strings.SplitSeq(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+
+	return directives
+}
+
+// seconds returns the delta-seconds value of a directive.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return time.Duration(min(n, int64(1<<31))) * time.Second, true
+}
+
+// heuristicStatus reports whether responses with code are heuristically cacheable.
+func heuristicStatus(code int) bool {
+	switch code {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+
+	return false
+}
+
+// date returns the Date header of the entry, or when it was received.
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get(header.DATE)); err == nil {
+		return date
+	}
+
+	return e.ResponseTime
+}
+
+// lifetime returns the freshness lifetime of the entry: s-maxage in a shared cache, max-age,
+// Expires, or 10% of the time since Last-Modified for heuristically cacheable responses.
+func (e *cacheEntry) lifetime(shared bool) time.Duration {
+	directives := cacheControl(e.Header)
+
+	if shared {
+		if d, ok := seconds(directives, "s-maxage"); ok {
+			return d
+		}
+	}
+
+	if d, ok := seconds(directives, "max-age"); ok {
+		return d
+	}
+
+	if expires := e.Header.Get(header.EXPIRES); expires != "" {
+		// Invalid dates, such as 0, represent a time in the past.
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+
+		return max(t.Sub(e.date()), 0)
+	}
+
+	_, public := directives["public"]
+	if !public && !heuristicStatus(e.Status) {
+		return 0
+	}
+
+	modified, err := http.ParseTime(e.Header.Get(header.LAST_MODIFIED))
+	if err != nil {
+		return 0
+	}
+
+	return min(max(e.date().Sub(modified)/10, 0), _cacheHeuristicMax)
+}
+
+// age returns the current age of the entry (RFC 9111, section 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparent := max(e.ResponseTime.Sub(e.date()), 0)
+
+	var value time.Duration
+	if n, err := strconv.ParseInt(e.Header.Get(header.AGE), 10, 64); err == nil && n > 0 {
+		value = time.Duration(n) * time.Second
+	}
+
+	corrected := value + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// matches reports whether the request headers named by the Vary header of the entry match req.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for _, name := range e.varies() {
+		if name == "*" || !slices.Equal(e.Vary.Values(name), req.Header.Values(name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// varies returns the header names of the Vary header of the entry.
+func (e *cacheEntry) varies() []string {
+	var names []string
+
+	for _, line := range e.Header.Values(header.VARY) {
+		for name := range strings.SplitSeq(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+// response returns the stored response of the entry for req, with its current age.
+func (e *cacheEntry) response(req *http.Request, now time.Time) *http.Response {
+	h := e.Header.Clone()
+	h.Set(header.AGE, strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+
+	major, minor, ok := http.ParseHTTPVersion(e.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         e.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// load returns the entry stored for key.
+func (c *httpCache) load(key string) *cacheEntry {
+	data, ok := c.store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(data, entry); err != nil {
+		c.store.Delete(key)
+		return nil
+	}
+
+	return entry
+}
+
+// save stores entry for key.
+func (c *httpCache) save(key string, entry *cacheEntry) {
+	if data, err := json.Marshal(entry); err == nil {
+		c.store.Set(key, data)
+	}
+}
+
+// gatewayTimeout returns the 504 response to a request that must be served from the cache
+// without a usable stored response.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// lookup answers req from the stored responses: it serves a fresh response, a stale response
+// allowed by max-stale, stale-while-revalidate or the offline mode, and otherwise adds the
+// validators of the stored response to the request.
+func (c *httpCache) lookup(req *Request) cacheLookup {
+	r := req.request
+
+	// Unsafe requests are sent, and invalidate the stored responses once answered.
+	if r.Method != http.MethodGet {
+		return cacheLookup{}
+	}
+
+	directives := cacheControl(r.Header)
+	if _, ok := directives["no-store"]; ok {
+		return cacheLookup{bypass: true}
+	}
+
+	// Range and conditional requests are answered by the server, never with a stored response.
+	for _, name := range []string{
+		header.RANGE, header.IF_RANGE, header.IF_MATCH, header.IF_NONE_MATCH,
+		header.IF_MODIFIED_SINCE, header.IF_UNMODIFIED_SINCE,
+	} {
+		if r.Header.Get(name) != "" {
+			return cacheLookup{bypass: true}
+		}
+	}
+
+	_, onlyIfCached := directives["only-if-cached"]
+	onlyIfCached = onlyIfCached || c.offline
+
+	key := cacheKey(r)
+
+	entry := c.load(key)
+	if entry == nil || !entry.matches(r) {
+		if onlyIfCached {
+			return cacheLookup{resp: gatewayTimeout(r), status: CacheMiss}
+		}
+
+		return cacheLookup{}
+	}
+
+	now := time.Now()
+	stored := cacheControl(entry.Header)
+
+	lifetime := entry.lifetime(c.shared)
+	age := entry.age(now)
+	staleness := age - lifetime
+
+	fresh := age < lifetime
+
+	if maxAge, ok := seconds(directives, "max-age"); ok && age > maxAge {
+		fresh = false
+	}
+
+	if minFresh, ok := seconds(directives, "min-fresh"); ok && lifetime-age < minFresh {
+		fresh = false
+	}
+
+	_, noCache := directives["no-cache"]
+	if _, ok := stored["no-cache"]; ok || req.revalidate {
+		noCache = true
+	}
+
+	// Pragma: no-cache is honored from requests without Cache-Control.
+	if len(r.Header.Values(header.CACHE_CONTROL)) == 0 && r.Header.Get(header.PRAGMA) == "no-cache" {
+		noCache = true
+	}
+
+	if fresh && !noCache {
+		return cacheLookup{resp: entry.response(r, now), status: CacheHit}
+	}
+
+	if c.offline {
+		return cacheLookup{resp: entry.response(r, now), status: CacheStale}
+	}
+
+	_, mustRevalidate := stored["must-revalidate"]
+	if _, ok := stored["proxy-revalidate"]; ok && c.shared {
+		mustRevalidate = true
+	}
+
+	if !noCache && !mustRevalidate {
+		if value, ok := directives["max-stale"]; ok {
+			if maxStale, valid := seconds(directives, "max-stale"); value == "" || valid && staleness <= maxStale {
+				return cacheLookup{resp: entry.response(r, now), status: CacheStale}
+			}
+		}
+
+		if swr, ok := seconds(stored, "stale-while-revalidate"); ok && staleness <= swr && !onlyIfCached {
+			c.background(req, key)
+			return cacheLookup{resp: entry.response(r, now), status: CacheStale}
+		}
+	}
+
+	if onlyIfCached {
+		return cacheLookup{resp: gatewayTimeout(r), status: CacheMiss}
+	}
+
+	if etag := entry.Header.Get(header.ETAG); etag != "" {
+		r.Header.Set(header.IF_NONE_MATCH, etag)
+		entry.Validated = true
+	}
+
+	if modified := entry.Header.Get(header.LAST_MODIFIED); modified != "" {
+		r.Header.Set(header.IF_MODIFIED_SINCE, modified)
+		entry.Validated = true
+	}
+
+	return cacheLookup{entry: entry}
+}
+
+// background revalidates the response stored for key with a copy of req, once at a time.
+func (c *httpCache) background(req *Request, key string) {
+	if _, loaded := c.revalidating.LoadOrStore(key, g.Unit{}); loaded {
+		return
+	}
+
+	r := req.cli.Get(g.String(req.request.URL.String())).
+		WithContext(context.WithoutCancel(req.request.Context()))
+	if r.err != nil {
+		c.revalidating.Delete(key)
+		return
+	}
+
+	r.request.Header = req.request.Header.Clone()
+	r.proxy = req.proxy
+	r.revalidate = true
+
+	go func() {
+		defer c.revalidating.Delete(key)
+
+		if resp := r.Do(); resp.IsOk() && resp.Ok().Body != nil {
+			resp.Ok().Body.Close()
+		}
+	}()
+}
+
+// staleIfError returns the stale response of entry when stale-if-error, in the response or the
+// request, allows it to be served after a failed request.
+func (c *httpCache) staleIfError(req *Request, entry *cacheEntry) *http.Response {
+	if entry == nil {
+		return nil
+	}
+
+	now := time.Now()
+	staleness := entry.age(now) - entry.lifetime(c.shared)
+
+	for _, directives := range []map[string]string{cacheControl(req.request.Header), cacheControl(entry.Header)} {
+		if sie, ok := seconds(directives, "stale-if-error"); ok && staleness <= sie {
+			return entry.response(req.request, now)
+		}
+	}
+
+	return nil
+}
+
+// handle handles the response resp of req, sent at sent, with entry the stored response validated
+// by the request: a 304 response updates and serves the stored response, a server error may serve
+// it stale, storable responses are stored once their body is read, and successful unsafe requests
+// invalidate the stored responses of their URL. Responses reached through redirects belong to
+// another URL and are neither stored nor merged into entry.
+func (c *httpCache) handle(req *Request, sent time.Time, resp *http.Response, entry *cacheEntry) (*http.Response, CacheStatus) {
+	r := req.request
+	key := cacheKey(r)
+
+	if r.Method != http.MethodGet {
+		if resp.StatusCode < 400 && r.Method != http.MethodHead && r.Method != http.MethodOptions &&
+			r.Method != http.MethodTrace {
+			c.invalidate(r, resp)
+		}
+
+		return resp, CacheNone
+	}
+
+	if resp.Request != nil && resp.Request.URL.String() != r.URL.String() {
+		return resp, CacheNone
+	}
+
+	now := time.Now()
+
+	if entry != nil && entry.Validated && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		for name, values := range resp.Header {
+			if name != http.CanonicalHeaderKey(header.CONTENT_LENGTH) {
+				entry.Header[name] = values
+			}
+		}
+
+		entry.RequestTime, entry.ResponseTime, entry.Validated = sent, now, false
+		c.save(key, entry)
+
+		return entry.response(r, now), CacheRevalidated
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		if stale := c.staleIfError(req, entry); stale != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			return stale, CacheStale
+		}
+	}
+
+	if stored := c.storable(r, resp); stored != nil {
+		stored.RequestTime, stored.ResponseTime = sent, now
+		resp.Body = &cacheBody{ReadCloser: resp.Body, limit: c.maxEntry, done: func(body []byte) {
+			stored.Body = body
+			c.save(key, stored)
+		}}
+	}
+
+	return resp, CacheMiss
+}
+
+// storable returns the entry of resp when it may be stored (RFC 9111, section 3).
+func (c *httpCache) storable(r *http.Request, resp *http.Response) *cacheEntry {
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusPartialContent ||
+		resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	directives := cacheControl(resp.Header)
+
+	if _, ok := directives["no-store"]; ok {
+		return nil
+	}
+
+	_, public := directives["public"]
+
+	if c.shared {
+		if _, ok := directives["private"]; ok {
+			return nil
+		}
+
+		if r.Header.Get(header.AUTHORIZATION) != "" {
+			_, mustRevalidate := directives["must-revalidate"]
+			_, sMaxAge := directives["s-maxage"]
+
+			if !public && !mustRevalidate && !sMaxAge {
+				return nil
+			}
+		}
+	}
+
+	entry := &cacheEntry{
+		Status: resp.StatusCode,
+		Proto:  resp.Proto,
+		Header: resp.Header.Clone(),
+		Vary:   make(http.Header),
+	}
+
+	for _, name := range entry.varies() {
+		if name == "*" {
+			return nil
+		}
+
+		if values := r.Header.Values(name); len(values) != 0 {
+			entry.Vary[name] = values
+		}
+	}
+
+	_, maxAge := directives["max-age"]
+	explicit := maxAge || resp.Header.Get(header.EXPIRES) != ""
+
+	if _, ok := directives["s-maxage"]; ok && c.shared {
+		explicit = true
+	}
+
+	if !explicit && !public && !heuristicStatus(resp.StatusCode) {
+		return nil
+	}
+
+	// Keep only responses that can be served, fresh or stale, or revalidated.
+	_, swr := directives["stale-while-revalidate"]
+	_, sie := directives["stale-if-error"]
+
+	if entry.lifetime(c.shared) <= 0 && !swr && !sie && resp.Header.Get(header.ETAG) == "" &&
+		resp.Header.Get(header.LAST_MODIFIED) == "" {
+		return nil
+	}
+
+	return entry
+}
+
+// invalidate removes the responses stored for the URL of an unsafe request, and for its Location
+// and Content-Location on the same host.
+func (c *httpCache) invalidate(r *http.Request, resp *http.Response) {
+	c.store.Delete(cacheKey(r))
+
+	for _, name := range []string{header.LOCATION, header.CONTENT_LOCATION} {
+		location := resp.Header.Get(name)
+		if location == "" {
+			continue
+		}
+
+		if u, err := r.URL.Parse(location); err == nil && u.Host == r.URL.Host {
+			c.store.Delete(cacheKey(&http.Request{URL: u}))
+		}
+	}
+}
+
+// cacheBody stores a response body once it is read to the end.
+type cacheBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+	done  func([]byte)
+	over  bool // The body is larger than the limit
+}
+
+func (cb *cacheBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+
+	if !cb.over {
+		if int64(cb.buf.Len()+n) > cb.limit {
+			cb.over = true
+			cb.buf = bytes.Buffer{}
+		} else {
+			cb.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !cb.over && cb.done != nil {
+		cb.done(bytes.Clone(cb.buf.Bytes()))
+		cb.done = nil
+	}
+
+	return n, err
+}
+
+// PurgeCache removes the response stored by the HTTP cache of the client for rawURL.
+func (c *Client) PurgeCache(rawURL g.String) {
+	if c.builder == nil || c.builder.cache == nil {
+		return
+	}
+
+	r, err := http.NewRequest(http.MethodGet, rawURL.Std(), nil)
+	if err != nil {
+		return
+	}
+
+	c.builder.cache.store.Delete(cacheKey(r))
+}
@@ -0,0 +1,196 @@
+package surf
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/enetx/g"
+)
+
+// MemoryCache is an in-memory CacheStore that evicts the least recently used responses to stay
+// within a byte budget. It is safe for concurrent use.
+type MemoryCache struct {
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List // Front is the most recently used entry
+	size     int64
+	maxBytes int64
+}
+
+// memoryEntry is a value of a MemoryCache.
+type memoryEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache creates an empty in-memory store holding up to maxBytes of keys and values.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{entries: make(map[string]*list.Element), lru: list.New(), maxBytes: maxBytes}
+}
+
+// Get returns the value stored for key and marks it as recently used.
+func (mc *MemoryCache) Get(key string) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	mc.lru.MoveToFront(e)
+
+	return e.Value.(*memoryEntry).value, true
+}
+
+// Set stores value for key and evicts the least recently used values over the budget. Values
+// larger than the budget are not stored.
+func (mc *MemoryCache) Set(key string, value []byte) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.remove(key)
+
+	size := int64(len(key) + len(value))
+	if size > mc.maxBytes {
+		return
+	}
+
+	mc.entries[key] = mc.lru.PushFront(&memoryEntry{key: key, value: value})
+	mc.size += size
+
+	for mc.size > mc.maxBytes {
+		mc.remove(mc.lru.Back().Value.(*memoryEntry).key)
+	}
+}
+
+// Delete removes the value stored for key.
+func (mc *MemoryCache) Delete(key string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.remove(key)
+}
+
+// remove removes the value of key. Called with mc.mu held.
+func (mc *MemoryCache) remove(key string) {
+	e, ok := mc.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := mc.lru.Remove(e).(*memoryEntry)
+	delete(mc.entries, key)
+	mc.size -= int64(len(entry.key) + len(entry.value))
+}
+
+// Len returns the number of stored values.
+func (mc *MemoryCache) Len() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return len(mc.entries)
+}
+
+// Size returns the bytes of the stored keys and values.
+func (mc *MemoryCache) Size() int64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.size
+}
+
+// DiskCache is a CacheStore keeping every value in a file of a directory, named after the
+// SHA-256 of its key, so that responses survive restarts and can be shared by processes. Values
+// are written to a temporary file and renamed into place. Write errors leave the value unstored.
+type DiskCache struct {
+	dir g.String
+}
+
+// NewDiskCache creates a store in dir, created on the first write if it does not exist.
+func NewDiskCache(dir g.String) *DiskCache { return &DiskCache{dir: dir} }
+
+// path returns the file of key.
+func (dc *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dc.dir.Std(), hex.EncodeToString(sum[:]))
+}
+
+// Get returns the value stored for key.
+func (dc *DiskCache) Get(key string) ([]byte, bool) {
+	value, err := os.ReadFile(dc.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Set stores value for key.
+func (dc *DiskCache) Set(key string, value []byte) {
+	if err := os.MkdirAll(dc.dir.Std(), 0o700); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dc.dir.Std(), ".tmp-*")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(value)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), dc.path(key))
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Delete removes the value stored for key.
+func (dc *DiskCache) Delete(key string) { os.Remove(dc.path(key)) }
+
+// Clear removes every value of the store. Other files of the directory are left in place.
+func (dc *DiskCache) Clear() error {
+	entries, err := os.ReadDir(dc.dir.Std())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isCacheFile(entry.Name()) {
+			if err := os.Remove(filepath.Join(dc.dir.Std(), entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isCacheFile reports whether name is the file of a key: its SHA-256 in lowercase hex.
+func isCacheFile(name string) bool {
+	if len(name) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
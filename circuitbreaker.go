@@ -0,0 +1,428 @@
+package surf
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+)
+
+// CircuitState is the state of the circuit of a host.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Requests are sent, failures are counted
+	CircuitOpen                         // Requests fail with ErrCircuitOpen until the cool-down ends
+	CircuitHalfOpen                     // A limited number of probe requests are sent
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitStats reports the state of the circuit of a host, or of a host through a proxy.
+type CircuitStats struct {
+	Host     g.String     // Host and port of the requests
+	Proxy    g.String     // Proxy of the requests, empty unless the circuits are kept per proxy
+	State    CircuitState // Current state of the circuit
+	Failures int          // Consecutive failures counted while closed
+	Until    time.Time    // End of the cool-down of an open circuit
+}
+
+// CircuitBreakerSettings provides a fluent interface for configuring the circuit breaker of the
+// client.
+type CircuitBreakerSettings struct {
+	builder *Builder
+	breaker *circuitBreaker
+}
+
+// Threshold sets the number of consecutive failures that open the circuit of a host, 5 by default.
+func (cbs *CircuitBreakerSettings) Threshold(failures int) *CircuitBreakerSettings {
+	cbs.breaker.threshold = failures
+	return cbs
+}
+
+// CoolDown sets how long an open circuit rejects requests before probes are sent, 30s by default.
+func (cbs *CircuitBreakerSettings) CoolDown(coolDown time.Duration) *CircuitBreakerSettings {
+	cbs.breaker.coolDown = coolDown
+	return cbs
+}
+
+// HalfOpenProbes sets the number of probe requests sent at once through a half-open circuit,
+// 1 by default.
+func (cbs *CircuitBreakerSettings) HalfOpenProbes(probes int) *CircuitBreakerSettings {
+	cbs.breaker.probes = probes
+	return cbs
+}
+
+// PerProxy keeps a circuit per host and proxy, so that a proxy blocked by a host does not open
+// the circuit of the host for the other proxies.
+func (cbs *CircuitBreakerSettings) PerProxy() *CircuitBreakerSettings {
+	cbs.breaker.perProxy = true
+	return cbs
+}
+
+// FailureStatus sets the status codes of the responses counted as failures, 429, 500, 502, 503
+// and 504 by default.
+func (cbs *CircuitBreakerSettings) FailureStatus(codes ...int) *CircuitBreakerSettings {
+	cbs.breaker.codes = g.SliceOf(codes...)
+	return cbs
+}
+
+// FailureBody counts the responses whose body contains one of the patterns as failures, such as
+// a captcha page. Patterns are matched like Body.Contains against the beginning of the body as it
+// is read, so the outcome of a response is recorded once its body is read or closed.
+func (cbs *CircuitBreakerSettings) FailureBody(patterns ...any) *CircuitBreakerSettings {
+	cbs.breaker.patterns = append(cbs.breaker.patterns, patterns...)
+	return cbs
+}
+
+// FailureError sets the predicate of the transport errors counted as failures. By default every
+// error counts, except those of requests whose context is done.
+func (cbs *CircuitBreakerSettings) FailureError(fn func(err error) bool) *CircuitBreakerSettings {
+	cbs.breaker.failureError = fn
+	return cbs
+}
+
+// Set applies the circuit breaker settings to the client. Every attempt of a request, including
+// retries, is checked against the circuit of its host before it is sent: while the circuit is
+// open, Request.Do returns ErrCircuitOpen without dialing.
+func (cbs *CircuitBreakerSettings) Set() *Builder {
+	cbs.builder.breaker = cbs.breaker
+
+	if len(cbs.breaker.patterns) == 0 {
+		return cbs.builder
+	}
+
+	return cbs.builder.addRespMW(cbs.breaker.inspect, 0)
+}
+
+// circuitBreaker keeps the circuits of the hosts requested by a client.
+type circuitBreaker struct {
+	mu           sync.Mutex
+	circuits     map[circuitKey]*list.Element
+	lru          *list.List // Circuits, the most recently used at the front
+	threshold    int
+	coolDown     time.Duration
+	probes       int
+	perProxy     bool
+	codes        g.Slice[int]
+	patterns     []any
+	failureError func(error) bool
+}
+
+// circuitOutcome is the outcome of an attempt recorded in its circuit.
+type circuitOutcome int
+
+const (
+	circuitIgnored circuitOutcome = iota // Not counted, such as a canceled request
+	circuitSuccess
+	circuitFailure
+)
+
+// circuitKey identifies a circuit.
+type circuitKey struct{ host, proxy g.String }
+
+// circuit is the state of the requests to a host.
+type circuit struct {
+	key      circuitKey
+	state    CircuitState
+	failures int       // Consecutive failures while closed
+	probes   int       // Probes in flight while half-open
+	until    time.Time // End of the cool-down while open, of the probes while half-open
+}
+
+// newCircuitBreaker creates a circuit breaker with the default settings.
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		circuits:  make(map[circuitKey]*list.Element),
+		lru:       list.New(),
+		threshold: _circuitThreshold,
+		coolDown:  _circuitCoolDown,
+		probes:    _circuitHalfOpenProbes,
+		codes: g.SliceOf(
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		),
+	}
+}
+
+// key returns the key of the circuit of req.
+func (cb *circuitBreaker) key(req *Request) circuitKey {
+	key := circuitKey{host: g.String(req.request.URL.Host)}
+	if cb.perProxy {
+		key.proxy = req.proxy
+	}
+
+	return key
+}
+
+// allow reports whether an attempt of req may be sent, and takes a probe of a half-open circuit.
+// Open circuits whose cool-down ended become half-open.
+func (cb *circuitBreaker) allow(req *Request) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	key := cb.key(req)
+
+	c := cb.circuit(key)
+	if c == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	switch c.state {
+	case CircuitOpen:
+		if now.Before(c.until) {
+			return &ErrCircuitOpen{fmt.Sprintf("%s, retry in %s", key, c.until.Sub(now).Round(time.Millisecond))}
+		}
+
+		c.state, c.probes = CircuitHalfOpen, 0
+	case CircuitHalfOpen:
+		// Probes that never reported, such as responses dropped by a middleware, are released
+		// after a cool-down.
+		if !now.Before(c.until) {
+			c.probes = 0
+		}
+	default:
+		return nil
+	}
+
+	if c.probes >= max(cb.probes, 1) {
+		return &ErrCircuitOpen{fmt.Sprintf("%s, half-open", key)}
+	}
+
+	c.probes++
+	c.until = now.Add(cb.coolDown)
+
+	return nil
+}
+
+// observe records the outcome of an attempt of req. Responses are recorded by inspect when body
+// patterns are set, once their body is read.
+func (cb *circuitBreaker) observe(req *Request, resp *http.Response, err error) {
+	switch {
+	case req.request.Context().Err() != nil, errors.Is(err, context.Canceled):
+		cb.record(req, circuitIgnored)
+	case err != nil:
+		if cb.failureError == nil || cb.failureError(err) {
+			cb.record(req, circuitFailure)
+		} else {
+			cb.record(req, circuitIgnored)
+		}
+	case cb.codes.Contains(resp.StatusCode):
+		cb.record(req, circuitFailure)
+	case len(cb.patterns) == 0:
+		cb.record(req, circuitSuccess)
+	}
+}
+
+// release releases the probe of an attempt of req whose response resp is not returned, such as
+// a Digest challenge or a retried response, when observe left its outcome to inspect.
+func (cb *circuitBreaker) release(req *Request, resp *http.Response) {
+	if len(cb.patterns) != 0 && !cb.codes.Contains(resp.StatusCode) {
+		cb.record(req, circuitIgnored)
+	}
+}
+
+// inspect records the outcome of a response, a failure when its body contains a pattern. The
+// patterns are matched against the beginning of the body as it is read, outside the response
+// middlewares, and the outcome is recorded once it is read or closed.
+// Responses served by the HTTP cache were not received from the host and are not recorded.
+func (cb *circuitBreaker) inspect(r *Response) error {
+	if cb.codes.Contains(int(r.StatusCode)) || r.request.cached {
+		return nil
+	}
+
+	if r.Body == nil || r.Body.Reader == nil {
+		cb.record(r.request, circuitSuccess)
+		return nil
+	}
+
+	r.Body.Reader = newPeekReader(r.Body.Reader, _circuitPeekSize, func(peek []byte) {
+		for _, pattern := range cb.patterns {
+			if containsPattern(peek, pattern) {
+				cb.record(r.request, circuitFailure)
+				return
+			}
+		}
+
+		cb.record(r.request, circuitSuccess)
+	})
+
+	return nil
+}
+
+// record updates the circuit of req with the outcome of an attempt. Ignored attempts only release
+// their probe of a half-open circuit.
+func (cb *circuitBreaker) record(req *Request, outcome circuitOutcome) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	key := cb.key(req)
+
+	c := cb.circuit(key)
+	if c == nil {
+		if outcome != circuitFailure {
+			return
+		}
+
+		c = cb.add(key)
+	}
+
+	switch {
+	case outcome == circuitIgnored:
+		if c.state == CircuitHalfOpen && c.probes > 0 {
+			c.probes--
+		}
+	case outcome == circuitFailure:
+		c.failures++
+
+		if c.state == CircuitHalfOpen || c.failures >= max(cb.threshold, 1) {
+			c.state, c.probes = CircuitOpen, 0
+			c.until = time.Now().Add(cb.coolDown)
+		}
+	default:
+		if c.state != CircuitOpen {
+			cb.remove(key)
+		}
+	}
+}
+
+// circuit returns the circuit of key, or nil when it is closed without failures. Called with
+// cb.mu held.
+func (cb *circuitBreaker) circuit(key circuitKey) *circuit {
+	e := cb.circuits[key]
+	if e == nil {
+		return nil
+	}
+
+	cb.lru.MoveToFront(e)
+
+	return e.Value.(*circuit)
+}
+
+// add adds a circuit for key, forgetting the least recently used circuits beyond
+// _circuitMaxCircuits. Called with cb.mu held.
+func (cb *circuitBreaker) add(key circuitKey) *circuit {
+	c := &circuit{key: key}
+	cb.circuits[key] = cb.lru.PushFront(c)
+
+	for cb.lru.Len() > _circuitMaxCircuits {
+		delete(cb.circuits, cb.lru.Remove(cb.lru.Back()).(*circuit).key)
+	}
+
+	return c
+}
+
+// remove removes the circuit of key. Called with cb.mu held.
+func (cb *circuitBreaker) remove(key circuitKey) {
+	if e := cb.circuits[key]; e != nil {
+		cb.lru.Remove(e)
+		delete(cb.circuits, key)
+	}
+}
+
+// stats returns the state of the circuits that are not closed, or have counted failures. Open
+// circuits whose cool-down has ended are reported half-open, as the next request finds them.
+func (cb *circuitBreaker) stats() g.Slice[CircuitStats] {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	stats := make(g.Slice[CircuitStats], 0, len(cb.circuits))
+
+	for key, e := range cb.circuits {
+		c := e.Value.(*circuit)
+		s := CircuitStats{Host: key.host, Proxy: key.proxy, State: c.state, Failures: c.failures}
+		if c.state == CircuitOpen {
+			if now.Before(c.until) {
+				s.Until = c.until
+			} else {
+				s.State = CircuitHalfOpen
+			}
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// reset closes the circuits of the hosts, all circuits when none is given.
+func (cb *circuitBreaker) reset(hosts ...g.String) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if len(hosts) == 0 {
+		clear(cb.circuits)
+		cb.lru.Init()
+
+		return
+	}
+
+	for key := range cb.circuits {
+		if g.SliceOf(hosts...).Contains(key.host) {
+			cb.remove(key)
+		}
+	}
+}
+
+// String returns the host of the key, with its proxy.
+func (k circuitKey) String() string {
+	if k.proxy.IsEmpty() {
+		return k.host.Std()
+	}
+
+	return k.host.Std() + " via " + k.proxy.Std()
+}
+
+// Circuits returns the state of the circuits of the circuit breaker of the client that are open,
+// half-open or have counted failures. Hosts without a circuit are closed.
+func (c *Client) Circuits() g.Slice[CircuitStats] {
+	if c.builder == nil || c.builder.breaker == nil {
+		return nil
+	}
+
+	return c.builder.breaker.stats()
+}
+
+// CircuitState returns the state of the circuit of a host, given as in the URLs of the requests,
+// with a port if they have one. With per-proxy circuits, the most restrictive state is returned.
+func (c *Client) CircuitState(host g.String) CircuitState {
+	state := CircuitClosed
+
+	for _, s := range c.Circuits() {
+		if s.Host == host && (s.State == CircuitOpen || state == CircuitClosed) {
+			state = s.State
+		}
+	}
+
+	return state
+}
+
+// ResetCircuits closes the circuits of the hosts, or every circuit when no host is given.
+func (c *Client) ResetCircuits(hosts ...g.String) {
+	if c.builder != nil && c.builder.breaker != nil {
+		c.builder.breaker.reset(hosts...)
+	}
+}
@@ -31,6 +31,10 @@ type Client struct {
 	builder   *Builder               // Associated builder for configuration
 	transport http.RoundTripper      // HTTP transport (can be HTTP/1.1, HTTP/2, or HTTP/3)
 	tlsConfig *tls.Config            // TLS configuration for secure connections
+	altsvc    *AltSvcCache           // HTTP/3 alternatives advertised by origins
+	resolver  *Resolver              // Caching DNS resolver, nil unless configured
+	svcb      svcbCache              // Service endpoints from HTTPS records, keyed by origin
+	proxies   proxyTransports        // Transports of the proxies selected per request
 	reqMWs    *middleware[*Request]  // Priority-ordered request middlewares
 	respMWs   *middleware[*Response] // Priority-ordered response middlewares
 	boundary  func() g.String        // Custom boundary generator for multipart requests
@@ -80,11 +84,16 @@ func (c *Client) applyRespMW(resp *Response) error {
 
 // CloseIdleConnections closes idle connections while keeping the client usable.
 // Safe to call periodically to free resources during long-running operations.
-func (c *Client) CloseIdleConnections() { c.cli.CloseIdleConnections() }
+func (c *Client) CloseIdleConnections() {
+	c.cli.CloseIdleConnections()
+	c.proxies.closeIdleConnections()
+}
 
 // Close completely shuts down the client and releases all resources.
 // After calling Close, the client should not be used.
 func (c *Client) Close() error {
+	c.proxies.close()
+
 	if closer, ok := c.transport.(interface{ Close() error }); ok {
 		return closer.Close()
 	}
@@ -105,6 +114,18 @@ func (c *Client) GetTransport() http.RoundTripper { return c.transport }
 // GetTLSConfig returns the tls.Config used by the Client.
 func (c *Client) GetTLSConfig() *tls.Config { return c.tlsConfig }
 
+// GetAltSvc returns the Alt-Svc cache used by the Client with HTTP/3 enabled, or nil.
+func (c *Client) GetAltSvc() *AltSvcCache { return c.altsvc }
+
+// GetResolver returns the caching DNS resolver used by the Client, or nil.
+func (c *Client) GetResolver() *Resolver { return c.resolver }
+
+// GetCookieJar returns the CookieJar of a Client configured with SessionJar, or nil.
+func (c *Client) GetCookieJar() *CookieJar {
+	jar, _ := c.cli.Jar.(*CookieJar)
+	return jar
+}
+
 // Builder returns a new Builder instance associated with this client.
 // The builder allows for method chaining to configure various client options.
 func (c *Client) Builder() *Builder {
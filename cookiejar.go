@@ -0,0 +1,720 @@
+package surf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieFormat is a file format of the cookies of a CookieJar.
+type CookieFormat int
+
+const (
+	CookieJSON     CookieFormat = iota // JSON array of the cookies, as written by CookieJar.Save
+	CookieNetscape                     // Netscape cookies.txt, as read and written by curl and wget
+	CookieBrowser                      // JSON export of browser extensions such as EditThisCookie
+)
+
+// CookieJar is a cookie jar whose cookies can be listed, saved and loaded in the JSON,
+// Netscape cookies.txt and browser extension formats, and saved to a file after every change.
+// Cookies are matched to requests as in RFC 6265 with the public suffix list, as in Session.
+// It is safe for concurrent use.
+type CookieJar struct {
+	mu       sync.Mutex
+	saving   sync.Mutex            // Orders the writes of the autosave file
+	cookies  map[string]*jarCookie // By domain, path and name
+	autosave g.String
+	format   CookieFormat
+	saveErr  error       // Error of the last write of the autosave file
+	dirty    bool        // Changed since the last write of the autosave file
+	timer    *time.Timer // Pending write of the autosave file
+	sequence uint64      // Creation order of the cookies
+}
+
+// jarCookie is a cookie of a CookieJar with its domain and path resolved.
+type jarCookie struct {
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Domain   string        `json:"domain"`              // Without a leading dot
+	Path     string        `json:"path"`                // Path of the cookie
+	Expires  time.Time     `json:"expires,omitzero"`    // Zero for session cookies
+	HostOnly bool          `json:"host_only,omitempty"` // Sent to Domain only, not its subdomains
+	Secure   bool          `json:"secure,omitempty"`    // Sent over HTTPS only
+	HttpOnly bool          `json:"http_only,omitempty"` // Hidden from scripts
+	SameSite http.SameSite `json:"same_site,omitempty"` // SameSite attribute
+	created  uint64        // Creation order, kept when the cookie is replaced
+}
+
+// NewCookieJar creates an empty cookie jar.
+func NewCookieJar() *CookieJar { return &CookieJar{cookies: make(map[string]*jarCookie)} }
+
+// key returns the key of the cookie in the jar.
+func (c *jarCookie) key() string { return c.Domain + ";" + c.Path + ";" + c.Name }
+
+// expired reports whether the cookie expired at now.
+func (c *jarCookie) expired(now time.Time) bool { return !c.Expires.IsZero() && !c.Expires.After(now) }
+
+// cookie returns the cookie as sent in a Set-Cookie header.
+func (c *jarCookie) cookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Expires:  c.Expires,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	}
+
+	if !c.HostOnly {
+		cookie.Domain = c.Domain
+	}
+
+	return cookie
+}
+
+// SetCookies stores the cookies received in a response from u, implementing http.CookieJar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+
+	for _, cookie := range cookies {
+		c, remove, ok := newJarCookie(u, cookie, now)
+		if !ok {
+			continue
+		}
+
+		if remove {
+			if _, found := j.cookies[c.key()]; found {
+				delete(j.cookies, c.key())
+				j.changed()
+			}
+
+			continue
+		}
+
+		j.store(c)
+		j.changed()
+	}
+}
+
+// store adds c to the jar, keeping the creation order of the cookie it replaces. Called with
+// j.mu held.
+func (j *CookieJar) store(c *jarCookie) {
+	if old, ok := j.cookies[c.key()]; ok {
+		c.created = old.created
+	} else {
+		j.sequence++
+		c.created = j.sequence
+	}
+
+	j.cookies[c.key()] = c
+}
+
+// Cookies returns the cookies to send in a request to u, implementing http.CookieJar. Cookies
+// with longer paths are sent first, then the older ones (RFC 6265, section 5.4).
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	host, ok := jarHost(u.Hostname())
+	if !ok {
+		return nil
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	j.mu.Lock()
+
+	now := time.Now()
+
+	var matched []*jarCookie
+
+	for key, c := range j.cookies {
+		if c.expired(now) {
+			delete(j.cookies, key)
+			continue
+		}
+
+		if c.matches(host, path, u.Scheme == "https") {
+			matched = append(matched, c)
+		}
+	}
+
+	j.mu.Unlock()
+
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+
+		return matched[a].created < matched[b].created
+	})
+
+	cookies := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		cookies[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+
+	return cookies
+}
+
+// matches reports whether the cookie is sent in a request to host and path, over HTTPS when
+// secure (RFC 6265, sections 5.1.3 and 5.1.4).
+func (c *jarCookie) matches(host, path string, secure bool) bool {
+	if c.Secure && !secure {
+		return false
+	}
+
+	if host != c.Domain && (c.HostOnly || !strings.HasSuffix(host, "."+c.Domain)) {
+		return false
+	}
+
+	switch {
+	case path == c.Path:
+		return true
+	case !strings.HasPrefix(path, c.Path):
+		return false
+	default:
+		return strings.HasSuffix(c.Path, "/") || path[len(c.Path)] == '/'
+	}
+}
+
+// newJarCookie resolves the domain, path and expiry of cookie received from u, as the
+// cookiejar.Jar does. remove reports a cookie deleting a stored one, ok a valid cookie.
+func newJarCookie(u *url.URL, cookie *http.Cookie, now time.Time) (c *jarCookie, remove, ok bool) {
+	if cookie.Name == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false, false
+	}
+
+	host, ok := jarHost(u.Hostname())
+	if !ok {
+		return nil, false, false
+	}
+
+	domain, hostOnly, ok := cookieDomain(host, cookie.Domain)
+	if !ok {
+		return nil, false, false
+	}
+
+	path := cookie.Path
+	if path == "" || path[0] != '/' {
+		path = defaultCookiePath(u.Path)
+	}
+
+	c = &jarCookie{
+		Name:     cookie.Name,
+		Value:    cookie.Value,
+		Domain:   domain,
+		Path:     path,
+		HostOnly: hostOnly,
+		Secure:   cookie.Secure,
+		HttpOnly: cookie.HttpOnly,
+		SameSite: cookie.SameSite,
+	}
+
+	switch {
+	case cookie.MaxAge < 0:
+		return c, true, true
+	case cookie.MaxAge > 0:
+		c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	case !cookie.Expires.IsZero():
+		if !cookie.Expires.After(now) {
+			return c, true, true
+		}
+
+		c.Expires = cookie.Expires
+	}
+
+	return c, false, true
+}
+
+// jarHost returns host as the cookies of the jar are matched against: lower-cased, without a
+// trailing dot and with its internationalized labels in punycode, as the cookiejar.Jar does.
+func jarHost(host string) (string, bool) {
+	host, err := idna.Punycode.ToASCII(strings.TrimSuffix(strings.ToLower(host), "."))
+	return host, err == nil && host != ""
+}
+
+// cookieDomain returns the domain of a cookie set by host with the Domain attribute domain, and
+// whether the cookie is host-only (RFC 6265, section 5.3).
+func cookieDomain(host, domain string) (string, bool, bool) {
+	if domain = strings.TrimPrefix(domain, "."); domain == "" {
+		return host, true, true
+	}
+
+	domain, ok := jarHost(domain)
+	if !ok {
+		return "", false, false
+	}
+
+	if net.ParseIP(host) != nil {
+		if domain == host {
+			return host, true, true
+		}
+
+		return "", false, false
+	}
+
+	// A public suffix is only allowed as the host itself, as a host-only cookie.
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+		if domain == host {
+			return host, true, true
+		}
+
+		return "", false, false
+	}
+
+	if domain != host && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+
+	return domain, false, true
+}
+
+// defaultCookiePath returns the default path of cookies set from a request path
+// (RFC 6265, section 5.1.4).
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || path[0] != '/' {
+		return "/"
+	}
+
+	return path[:i]
+}
+
+// All returns the unexpired cookies of the jar, sorted by domain, path and name, with the domain
+// they are sent to.
+func (j *CookieJar) All() g.Slice[*http.Cookie] {
+	entries := j.entries()
+	cookies := make(g.Slice[*http.Cookie], 0, len(entries))
+
+	for _, c := range entries {
+		cookie := c.cookie()
+		cookie.Domain = c.Domain
+		cookies = append(cookies, cookie)
+	}
+
+	return cookies
+}
+
+// Len returns the number of unexpired cookies of the jar.
+func (j *CookieJar) Len() int { return len(j.entries()) }
+
+// Clear removes all cookies.
+func (j *CookieJar) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	clear(j.cookies)
+	j.changed()
+}
+
+// entries returns the unexpired cookies sorted by domain, path and name, and removes the expired
+// ones.
+func (j *CookieJar) entries() []*jarCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]*jarCookie, 0, len(j.cookies))
+
+	for key, c := range j.cookies {
+		if c.expired(now) {
+			delete(j.cookies, key)
+			continue
+		}
+
+		entries = append(entries, c)
+	}
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].key() < entries[b].key() })
+
+	return entries
+}
+
+// Autosave saves the cookies to the file path in format after they change, replacing the file
+// atomically. The changes are written in the background, at most once a second, so that requests
+// do not wait for the file. Call Flush or Close to write them at once,
+// such as before the program exits. A failed write is reported by AutosaveErr, the next change
+// or Flush writes the file again.
+func (j *CookieJar) Autosave(path g.String, format CookieFormat) *CookieJar {
+	j.mu.Lock()
+	j.autosave, j.format = path, format
+	j.mu.Unlock()
+
+	return j
+}
+
+// changed marks the jar changed and schedules a write of the autosave file, unless one is
+// pending. Called with j.mu held.
+func (j *CookieJar) changed() {
+	j.dirty = true
+
+	if !j.autosave.IsEmpty() && j.timer == nil {
+		j.timer = time.AfterFunc(_cookieJarSaveDelay, func() { j.Flush() })
+	}
+}
+
+// Flush writes the changes of the cookies to the autosave file at once, and returns the error
+// of the write. It does nothing without changes or an autosave file.
+func (j *CookieJar) Flush() error {
+	j.saving.Lock()
+	defer j.saving.Unlock()
+
+	j.mu.Lock()
+
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+
+	path, format, dirty := j.autosave, j.format, j.dirty
+	j.dirty = false
+
+	j.mu.Unlock()
+
+	if path.IsEmpty() || !dirty {
+		return nil
+	}
+
+	err := j.SaveFile(path, format)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A failed write is retried by the next Flush
+	if j.saveErr = err; err != nil {
+		j.dirty = true
+	}
+
+	return err
+}
+
+// Close writes the pending changes to the autosave file and stops saving the cookies to it.
+func (j *CookieJar) Close() error {
+	err := j.Flush()
+
+	j.mu.Lock()
+	j.autosave = ""
+	j.mu.Unlock()
+
+	return err
+}
+
+// AutosaveErr returns the error of the last write of the autosave file, nil when it succeeded.
+func (j *CookieJar) AutosaveErr() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.saveErr
+}
+
+// Save writes the unexpired cookies in format.
+func (j *CookieJar) Save(w io.Writer, format CookieFormat) error {
+	entries := j.entries()
+
+	switch format {
+	case CookieJSON:
+		return json.NewEncoder(w).Encode(entries)
+	case CookieNetscape:
+		return writeNetscapeCookies(w, entries)
+	case CookieBrowser:
+		return json.NewEncoder(w).Encode(browserCookies(entries))
+	default:
+		return fmt.Errorf("unknown cookie format %d", format)
+	}
+}
+
+// SaveFile writes the unexpired cookies in format to the file path, replacing it atomically.
+func (j *CookieJar) SaveFile(path g.String, format CookieFormat) error {
+	dir := filepath.Dir(path.Std())
+
+	tmp, err := os.CreateTemp(dir, ".cookies-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err := j.Save(tmp, format); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path.Std())
+}
+
+// Load reads cookies in format and adds them to the jar, replacing cookies with the same domain,
+// path and name. Expired cookies are skipped.
+func (j *CookieJar) Load(r io.Reader, format CookieFormat) error {
+	var (
+		entries []*jarCookie
+		err     error
+	)
+
+	switch format {
+	case CookieJSON:
+		err = json.NewDecoder(r).Decode(&entries)
+	case CookieNetscape:
+		entries, err = readNetscapeCookies(r)
+	case CookieBrowser:
+		var cookies []browserCookie
+		if err = json.NewDecoder(r).Decode(&cookies); err == nil {
+			entries = fromBrowserCookies(cookies)
+		}
+	default:
+		err = fmt.Errorf("unknown cookie format %d", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	j.add(entries)
+
+	return nil
+}
+
+// LoadFile reads cookies in format from the file path and adds them to the jar.
+func (j *CookieJar) LoadFile(path g.String, format CookieFormat) error {
+	f, err := os.Open(path.Std())
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return j.Load(f, format)
+}
+
+// add stores loaded cookies in the jar.
+func (j *CookieJar) add(entries []*jarCookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+
+	for _, c := range entries {
+		domain, ok := jarHost(strings.TrimPrefix(c.Domain, "."))
+		if c.Domain = domain; !ok || c.Name == "" || c.expired(now) {
+			continue
+		}
+
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = "/"
+		}
+
+		j.store(c)
+		j.changed()
+	}
+}
+
+// writeNetscapeCookies writes cookies in the Netscape cookies.txt format.
+func writeNetscapeCookies(w io.Writer, entries []*jarCookie) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n\n")
+
+	for _, c := range entries {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+
+		if c.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!c.HostOnly), c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+
+	return bw.Flush()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+
+	return "FALSE"
+}
+
+// readNetscapeCookies reads cookies in the Netscape cookies.txt format. Expiry 0 marks session
+// cookies, and the #HttpOnly_ prefix of curl HttpOnly cookies.
+func readNetscapeCookies(r io.Reader) ([]*jarCookie, error) {
+	var entries []*jarCookie
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(text, "#HttpOnly_")
+		if httpOnly {
+			text = text[len("#HttpOnly_"):]
+		}
+
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "")
+		}
+
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 fields, got %d", line, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expiry %q", line, fields[4])
+		}
+
+		c := &jarCookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   fields[0],
+			Path:     fields[2],
+			HostOnly: !strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(fields[0], "."),
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+
+		entries = append(entries, c)
+	}
+
+	return entries, scanner.Err()
+}
+
+// browserCookie is a cookie in the JSON export of browser extensions.
+type browserCookie struct {
+	Domain         string  `json:"domain"`
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	HostOnly       bool    `json:"hostOnly"`
+	HttpOnly       bool    `json:"httpOnly"`
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	SameSite       string  `json:"sameSite,omitempty"`
+	Secure         bool    `json:"secure"`
+	Session        bool    `json:"session"`
+	StoreID        string  `json:"storeId,omitempty"`
+	Value          string  `json:"value"`
+}
+
+// browserCookies converts cookies to the browser extension format.
+func browserCookies(entries []*jarCookie) []browserCookie {
+	cookies := make([]browserCookie, 0, len(entries))
+
+	for _, c := range entries {
+		cookie := browserCookie{
+			Domain:   c.Domain,
+			HostOnly: c.HostOnly,
+			HttpOnly: c.HttpOnly,
+			Name:     c.Name,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			Session:  c.Expires.IsZero(),
+			StoreID:  "0",
+			Value:    c.Value,
+		}
+
+		if !c.HostOnly {
+			cookie.Domain = "." + c.Domain
+		}
+
+		if !c.Expires.IsZero() {
+			cookie.ExpirationDate = float64(c.Expires.UnixMilli()) / 1000
+		}
+
+		switch c.SameSite {
+		case http.SameSiteLaxMode:
+			cookie.SameSite = "lax"
+		case http.SameSiteStrictMode:
+			cookie.SameSite = "strict"
+		case http.SameSiteNoneMode:
+			cookie.SameSite = "no_restriction"
+		default:
+			cookie.SameSite = "unspecified"
+		}
+
+		cookies = append(cookies, cookie)
+	}
+
+	return cookies
+}
+
+// fromBrowserCookies converts cookies from the browser extension format.
+func fromBrowserCookies(cookies []browserCookie) []*jarCookie {
+	entries := make([]*jarCookie, 0, len(cookies))
+
+	for _, cookie := range cookies {
+		c := &jarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			HostOnly: cookie.HostOnly,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+
+		if !cookie.Session && cookie.ExpirationDate > 0 {
+			sec, frac := math.Modf(cookie.ExpirationDate)
+			c.Expires = time.Unix(int64(sec), int64(frac*1e9))
+		}
+
+		switch strings.ToLower(cookie.SameSite) {
+		case "lax":
+			c.SameSite = http.SameSiteLaxMode
+		case "strict":
+			c.SameSite = http.SameSiteStrictMode
+		case "no_restriction", "none":
+			c.SameSite = http.SameSiteNoneMode
+		}
+
+		entries = append(entries, c)
+	}
+
+	return entries
+}
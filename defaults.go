@@ -20,6 +20,10 @@ const (
 	// Prevents hanging on unresponsive servers during connection establishment.
 	_dialerTimeout = 10 * time.Second
 
+	// _happyEyeballsDelay is how long a connection attempt is pending before the next address
+	// of the host is tried, the Connection Attempt Delay recommended by RFC 8305.
+	_happyEyeballsDelay = 250 * time.Millisecond
+
 	// _tlsHandshakeTimeout is the default timeout for completing TLS handshakes.
 	// Prevents hanging during SSL/TLS negotiation with slow or unresponsive servers.
 	_tlsHandshakeTimeout = 10 * time.Second
@@ -71,6 +75,10 @@ const (
 	// Set to 0 to disable (no timeout for writes).
 	_http2WriteByteTimeout = 10 * time.Second
 
+	// _http2MaxOrigins is the maximum number of origins remembered as served over HTTP/2 for
+	// WebSocket handshakes with extended CONNECT.
+	_http2MaxOrigins = 1024
+
 	// HTTP/3 (QUIC) Transport timeouts
 	// _quicHandshakeTimeout is the timeout for QUIC handshake completion.
 	// Similar to TLS handshake timeout but for QUIC protocol.
@@ -84,6 +92,149 @@ const (
 	// Helps maintain connections through NAT and detect dead connections.
 	_quicKeepAlivePeriod = 15 * time.Second
 
+	// _masqueInitialPacketSize is the initial packet size of QUIC connections to CONNECT-UDP proxies.
+	// Leaves room for tunneled QUIC packets of at least 1200 bytes in HTTP datagrams.
+	_masqueInitialPacketSize = 1350
+
+	// _masqueRetryBackoff is how long CONNECT-UDP is not attempted again after a proxy
+	// failed to open a tunnel over both HTTP/3 and HTTP/2.
+	_masqueRetryBackoff = 5 * time.Minute
+
+	// Alt-Svc cache
+	// _altSvcMaxAge is the freshness lifetime of an alternative advertised without ma.
+	// Matches the RFC 7838 default of 24 hours.
+	_altSvcMaxAge = 24 * time.Hour
+
+	// _altSvcBrokenBackoff is the initial period a failed HTTP/3 alternative is skipped.
+	// Doubles on every consecutive failure.
+	_altSvcBrokenBackoff = 5 * time.Minute
+
+	// _altSvcBrokenMaxBackoff caps the period a failed HTTP/3 alternative is skipped.
+	_altSvcBrokenMaxBackoff = 48 * time.Hour
+
+	// _altSvcMaxEntries is the maximum number of origins with a recorded HTTP/3 alternative.
+	_altSvcMaxEntries = 1024
+
+	// DNS resolver cache
+	// _resolverMaxTTL is the default maximum time positive DNS answers are cached.
+	_resolverMaxTTL = time.Hour
+
+	// _resolverNegativeTTL is the default maximum time NXDOMAIN and empty answers are cached.
+	_resolverNegativeTTL = 30 * time.Second
+
+	// _resolverMaxEntries is the maximum number of cached DNS answers.
+	_resolverMaxEntries = 4096
+
+	// _resolverQueryTimeout limits an upstream exchange shared by identical queries, which outlives
+	// the contexts of the queries waiting for it.
+	_resolverQueryTimeout = 10 * time.Second
+
+	// HTTPS records and Encrypted Client Hello
+	// _svcbNegativeTTL is how long an origin without a usable HTTPS record is not looked up again.
+	_svcbNegativeTTL = 5 * time.Minute
+
+	// _svcbMaxEntries is the maximum number of origins whose HTTPS records are cached.
+	_svcbMaxEntries = 4096
+
+	// _echRetryTTL is how long the retry configurations of a server rejecting ECH are used.
+	_echRetryTTL = time.Hour
+
+	// _echMaxEntries is the maximum number of servers whose ECH retry configurations are cached.
+	_echMaxEntries = 1024
+
+	// Retry policies
+	// _backoffBase is the default maximum wait before the first retry of a Backoff policy.
+	_backoffBase = 200 * time.Millisecond
+
+	// _backoffCap is the default maximum wait before a retry of a Backoff policy.
+	_backoffCap = 30 * time.Second
+
+	// Circuit breaker
+	// _circuitThreshold is the default number of consecutive failures that open a circuit.
+	_circuitThreshold = 5
+
+	// _circuitCoolDown is the default time an open circuit rejects requests.
+	_circuitCoolDown = 30 * time.Second
+
+	// _circuitHalfOpenProbes is the default number of probes sent at once through a half-open circuit.
+	_circuitHalfOpenProbes = 1
+
+	// _circuitPeekSize is how much of a response body is searched for failure patterns.
+	_circuitPeekSize = 64 << 10
+
+	// _circuitMaxCircuits is the maximum number of open circuits, or circuits with failures, kept
+	// before the least recently used are forgotten.
+	_circuitMaxCircuits = 4096
+
+	// Rate limits
+	// _rateLimitMaxBuckets is the number of token buckets above which full buckets, then the least
+	// recently used, are removed when they have no waiting requests.
+	_rateLimitMaxBuckets = 1024
+
+	// Adaptive throttling
+	// _throttleMaxConcurrency is the default maximum number of requests in flight to a host.
+	_throttleMaxConcurrency = 32
+
+	// _throttleHeadroom is the default fraction of a quota below which requests are spread out.
+	_throttleHeadroom = 0.2
+
+	// _throttleDecrease is the default factor of the requests in flight after a throttled response.
+	_throttleDecrease = 0.5
+
+	// _throttleMaxHosts is the number of throttled hosts above which idle hosts are removed.
+	_throttleMaxHosts = 1024
+
+	// HTTP cache
+	// _cacheMaxEntrySize is the default size of the largest response body stored by the cache.
+	_cacheMaxEntrySize = 10 << 20
+
+	// _cacheHeuristicMax caps the heuristic freshness lifetime of responses with Last-Modified.
+	_cacheHeuristicMax = 24 * time.Hour
+
+	// Cookie jar
+	// _cookieJarSaveDelay is how long the changes of a cookie jar wait to be written to its autosave file.
+	_cookieJarSaveDelay = time.Second
+
+	// Proxy pool
+	// _proxyPoolMaxFailures is the number of consecutive connection failures that quarantine a proxy.
+	_proxyPoolMaxFailures = 3
+
+	// _proxyPoolQuarantine is how long a failing or banned proxy is not used.
+	_proxyPoolQuarantine = 5 * time.Minute
+
+	// _proxyPoolCheckTimeout is the timeout of a health check request through a proxy.
+	_proxyPoolCheckTimeout = 10 * time.Second
+
+	// _proxyPoolCheckDrainSize is how much of a health check response body is read to reuse the
+	// connection.
+	_proxyPoolCheckDrainSize = 64 << 10
+
+	// _proxyPoolBanPeekSize is how much of a response body is searched for banned patterns.
+	_proxyPoolBanPeekSize = 64 << 10
+
+	// _proxyPoolMaxHosts is the maximum number of hosts bound to proxies by ProxyStickyHost.
+	_proxyPoolMaxHosts = 4096
+
+	// _proxyPoolMaxSessions is the maximum number of sessions bound to proxies by ProxyStickySession.
+	_proxyPoolMaxSessions = 4096
+
+	// _proxyMaxTransports is the number of per-request proxy transports above which the least
+	// recently used are closed.
+	_proxyMaxTransports = 256
+
+	// Digest authentication
+	// _digestAuthRounds is the number of times a request is sent again answering Digest challenges:
+	// once for the challenge and once for a stale nonce.
+	_digestAuthRounds = 2
+
+	// OAuth2
+	// _oauth2ExpirySkew is how long before their expiry OAuth2 access tokens are refreshed.
+	_oauth2ExpirySkew = 10 * time.Second
+
+	// AWS Signature Version 4
+	// _sigV4MaxPresignExpiry is the longest validity of presigned URLs accepted by AWS.
+	_sigV4MaxPresignExpiry = 7 * 24 * time.Hour
+
 	// _maxResponseHeaderBytes is the maximum size of response headers in HTTP/3.
 	// Limits memory usage when receiving large headers. Default 10MB.
 	_maxResponseHeaderBytes = 10 << 20
@@ -0,0 +1,145 @@
+package surf
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+	"github.com/enetx/surf/internal/httpauth"
+)
+
+// digestAuth answers the Digest challenges of origin servers (RFC 7616). Challenges are cached per
+// protection space, the origin and realm of the server, and answered preemptively by the next
+// requests to the space with an incremented nonce count.
+type digestAuth struct {
+	username   string
+	password   string
+	mu         sync.Mutex
+	challenges []*digestChallenge
+}
+
+// digestChallenge is a Digest challenge of a protection space (RFC 7616 section 3.3).
+type digestChallenge struct {
+	*httpauth.Digest
+	origin string     // scheme://host:port of the challenged request
+	domain []*url.URL // URIs of the protection space, the whole origin when empty
+}
+
+// authorize sets the Authorization header of req answering the cached challenge of its
+// protection space, unless the header was set otherwise.
+func (d *digestAuth) authorize(req *Request) {
+	h := req.request.Header
+
+	if auth := h.Get(header.AUTHORIZATION); auth != "" && !strings.HasPrefix(auth, "Digest ") {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.challenges {
+		if c.applies(req.request.URL) {
+			if auth, ok := c.authorization(d.username, d.password, req); ok {
+				h.Set(header.AUTHORIZATION, auth)
+			}
+
+			return
+		}
+	}
+}
+
+// challenge stores the strongest supported Digest challenge of a 401 response to req. Reports
+// whether req should be sent again: it was sent without Digest credentials, or with a nonce the
+// server reports as stale.
+func (d *digestAuth) challenge(req *Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized || resp.Request.URL.String() != req.request.URL.String() {
+		return false
+	}
+
+	var best *digestChallenge
+
+	for _, c := range httpauth.ParseChallenges(resp.Header.Values(header.WWW_AUTHENTICATE)) {
+		if !strings.EqualFold(c.Scheme, "Digest") {
+			continue
+		}
+
+		if challenge := newDigestChallenge(req.request.URL, c.Params); challenge != nil &&
+			(best == nil || challenge.Better(best.Digest)) {
+			best = challenge
+		}
+	}
+
+	if best == nil || best.QOP == "auth-int" && !req.replayable() {
+		return false
+	}
+
+	sent := strings.HasPrefix(req.request.Header.Get(header.AUTHORIZATION), "Digest ")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.challenges = slices.DeleteFunc(d.challenges, func(c *digestChallenge) bool {
+		return c.origin == best.origin && c.Realm == best.Realm
+	})
+
+	d.challenges = append(d.challenges, best)
+
+	return !sent || best.Stale
+}
+
+// newDigestChallenge returns the challenge of the auth-params for a request to u, or nil when
+// it is not supported.
+func newDigestChallenge(u *url.URL, params map[string]string) *digestChallenge {
+	digest := httpauth.NewDigest(params)
+	if !digest.Better(nil) {
+		return nil
+	}
+
+	c := &digestChallenge{Digest: digest, origin: origin(u)}
+
+	for _, uri := range digest.Domain {
+		if ref, err := u.Parse(uri); err == nil {
+			c.domain = append(c.domain, ref)
+		}
+	}
+
+	return c
+}
+
+// origin returns the scheme://host:port of u, with the default port of the scheme.
+func origin(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443", "ws": "80", "wss": "443"}[u.Scheme]
+	}
+
+	return strings.ToLower(u.Scheme + "://" + u.Hostname() + ":" + port)
+}
+
+// applies reports whether u is within the protection space of the challenge.
+func (c *digestChallenge) applies(u *url.URL) bool {
+	if len(c.domain) == 0 {
+		return origin(u) == c.origin
+	}
+
+	for _, d := range c.domain {
+		if origin(d) == origin(u) && strings.HasPrefix(u.EscapedPath(), d.EscapedPath()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorization computes the Digest credentials of req with the next nonce count. Reports false
+// for auth-int when the body of req cannot be replayed.
+func (c *digestChallenge) authorization(username, password string, req *Request) (string, bool) {
+	if c.QOP == "auth-int" && !req.replayable() {
+		return "", false
+	}
+
+	return c.Authorization(username, password, req.request.Method, req.request.URL.RequestURI(), req.bodyBytes), true
+}
@@ -0,0 +1,305 @@
+package surf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSOverHTTPS is a configuration struct for DNS over HTTPS (RFC 8484) settings.
+type DNSOverHTTPS struct {
+	builder         *Builder
+	rootCAs         *x509.CertPool
+	get             bool
+	clientTransport bool
+}
+
+// GET sends queries as GET requests with the base64url encoded message in the dns
+// query parameter instead of POST requests.
+func (doh *DNSOverHTTPS) GET() *DNSOverHTTPS {
+	doh.get = true
+	return doh
+}
+
+// ClientTransport sends queries through the transport of the client, so lookups share its
+// proxy and TLS/HTTP/2 fingerprint like the DoH requests of the impersonated browser.
+func (doh *DNSOverHTTPS) ClientTransport() *DNSOverHTTPS {
+	doh.clientTransport = true
+	return doh
+}
+
+// RootCAs verifies the certificate of the endpoint with the certificate authorities of pool
+// instead of the system roots. Ignored with ClientTransport.
+func (doh *DNSOverHTTPS) RootCAs(pool *x509.CertPool) *DNSOverHTTPS {
+	doh.rootCAs = pool
+	return doh
+}
+
+// AdGuard sets up DNS over HTTPS with AdGuard DNS.
+func (doh *DNSOverHTTPS) AdGuard() *Builder {
+	return doh.AddProvider("https://dns.adguard-dns.com/dns-query", "94.140.14.14:443", "94.140.15.15:443")
+}
+
+// Google sets up DNS over HTTPS with Google Public DNS.
+func (doh *DNSOverHTTPS) Google() *Builder {
+	return doh.AddProvider("https://dns.google/dns-query", "8.8.8.8:443", "8.8.4.4:443")
+}
+
+// Cloudflare sets up DNS over HTTPS with Cloudflare DNS.
+func (doh *DNSOverHTTPS) Cloudflare() *Builder {
+	return doh.AddProvider("https://cloudflare-dns.com/dns-query", "1.1.1.1:443", "1.0.0.1:443")
+}
+
+// Quad9 sets up DNS over HTTPS with Quad9 DNS.
+func (doh *DNSOverHTTPS) Quad9() *Builder {
+	return doh.AddProvider("https://dns.quad9.net/dns-query", "9.9.9.9:443", "149.112.112.112:443")
+}
+
+// Switch sets up DNS over HTTPS with SWITCH DNS.
+func (doh *DNSOverHTTPS) Switch() *Builder {
+	return doh.AddProvider("https://dns.switch.ch/dns-query", "130.59.31.248:443", "130.59.31.251:443")
+}
+
+// CIRAShield sets up DNS over HTTPS with CIRA Canadian Shield DNS.
+func (doh *DNSOverHTTPS) CIRAShield() *Builder {
+	return doh.AddProvider(
+		"https://private.canadianshield.cira.ca/dns-query",
+		"149.112.121.10:443",
+		"149.112.122.10:443",
+	)
+}
+
+// Ali sets up DNS over HTTPS with AliDNS.
+func (doh *DNSOverHTTPS) Ali() *Builder {
+	return doh.AddProvider("https://dns.alidns.com/dns-query", "223.5.5.5:443", "223.6.6.6:443")
+}
+
+// Quad101 sets up DNS over HTTPS with Quad101 DNS.
+func (doh *DNSOverHTTPS) Quad101() *Builder {
+	return doh.AddProvider("https://dns.twnic.tw/dns-query", "101.101.101.101:443", "101.102.103.104:443")
+}
+
+// SB sets up DNS over HTTPS with Secure DNS (dns.sb).
+func (doh *DNSOverHTTPS) SB() *Builder {
+	return doh.AddProvider("https://doh.dns.sb/dns-query", "185.222.222.222:443", "45.11.45.11:443")
+}
+
+// Forge sets up DNS over HTTPS with DNS Forge.
+func (doh *DNSOverHTTPS) Forge() *Builder {
+	return doh.AddProvider("https://dnsforge.de/dns-query", "176.9.93.198:443", "176.9.1.117:443")
+}
+
+// LibreDNS sets up DNS over HTTPS with LibreDNS.
+func (doh *DNSOverHTTPS) LibreDNS() *Builder {
+	return doh.AddProvider("https://doh.libredns.gr/dns-query", "116.202.176.26:443")
+}
+
+// AddProvider sets up DNS over HTTPS with a custom DoH endpoint URL.
+// The optional bootstrap addresses (ip:port) are used to reach the endpoint without resolving
+// its host name; without them the host name is resolved by the system resolver. The certificate
+// of the endpoint is verified for its host name, unless queries are sent with ClientTransport.
+func (doh *DNSOverHTTPS) AddProvider(endpoint g.String, addresses ...g.String) *Builder {
+	get, clientTransport, rootCAs := doh.get, doh.clientTransport, doh.rootCAs
+
+	return doh.builder.addCliMW(func(client *Client) error {
+		u, err := url.Parse(endpoint.Std())
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid DNS-over-HTTPS endpoint %q", endpoint)
+		}
+
+		r := &dohResolver{
+			endpoint:  u,
+			addresses: g.TransformSlice(addresses, g.String.Std),
+			get:       get,
+		}
+
+		if clientTransport {
+			r.transport = func() http.RoundTripper { return client.GetClient().Transport }
+		} else {
+			transport := &http.Transport{
+				DialContext: r.dialBootstrap,
+				TLSClientConfig: &tls.Config{
+					ServerName:         u.Hostname(),
+					RootCAs:            rootCAs,
+					ClientSessionCache: tls.NewLRUClientSessionCache(0),
+				},
+				ForceAttemptHTTP2: true,
+				IdleConnTimeout:   _idleConnTimeout,
+			}
+
+			r.transport = func() http.RoundTripper { return transport }
+		}
+
+		return dnsResolverMW(client, &net.Resolver{PreferGo: true, Dial: r.dial})
+	}, 0)
+}
+
+// dohResolver sends the DNS messages of a net.Resolver to a DoH endpoint.
+type dohResolver struct {
+	endpoint  *url.URL
+	addresses []string
+	transport func() http.RoundTripper
+	get       bool
+}
+
+// dial returns a connection speaking DNS over TCP framing, each query becomes a DoH request.
+func (r *dohResolver) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	return &dnsConn{ctx: ctx, exchange: r.exchange}, nil
+}
+
+// dialBootstrap connects to the first reachable bootstrap address of the endpoint.
+func (r *dohResolver) dialBootstrap(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+
+	if len(r.addresses) == 0 {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	var err error
+
+	for _, bootstrap := range r.addresses {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, bootstrap); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// exchange sends the DNS query and returns the response message.
+func (r *dohResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, &ErrDNSOverHTTPS{"malformed DNS query"}
+	}
+
+	// Lookups of the endpoint itself are answered locally, they would
+	// otherwise recurse when queries go through the client transport.
+	if answer, ok := r.answerEndpoint(ctx, query); ok {
+		return answer, nil
+	}
+
+	// RFC 8484 4.1: the ID should be 0 to maximize HTTP cache friendliness.
+	id := binary.BigEndian.Uint16(query)
+
+	msg := bytes.Clone(query)
+	binary.BigEndian.PutUint16(msg, 0)
+
+	req, err := r.request(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ErrDNSOverHTTPS{fmt.Sprintf("%s responded with %s", r.endpoint.Host, resp.Status)}
+	}
+
+	if ct := resp.Header.Get(header.CONTENT_TYPE); !strings.HasPrefix(ct, "application/dns-message") {
+		return nil, &ErrDNSOverHTTPS{fmt.Sprintf("unexpected content type %q", ct)}
+	}
+
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(answer) < 12 {
+		return nil, &ErrDNSOverHTTPS{"malformed DNS response"}
+	}
+
+	binary.BigEndian.PutUint16(answer, id)
+
+	return answer, nil
+}
+
+// request builds the RFC 8484 GET or POST request for msg.
+func (r *dohResolver) request(ctx context.Context, msg []byte) (*http.Request, error) {
+	var (
+		req *http.Request
+		err error
+	)
+
+	if r.get {
+		u := *r.endpoint
+		query := u.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(msg))
+		u.RawQuery = query.Encode()
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint.String(), bytes.NewReader(msg))
+		if err == nil {
+			req.Header.Set(header.CONTENT_TYPE, "application/dns-message")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(header.ACCEPT, "application/dns-message")
+
+	return req, nil
+}
+
+// answerEndpoint answers an A or AAAA query for the host of the endpoint with the bootstrap
+// addresses or, without them, the addresses returned by the system resolver.
+func (r *dohResolver) answerEndpoint(ctx context.Context, query []byte) ([]byte, bool) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, false
+	}
+
+	q, err := p.Question()
+	if err != nil || (q.Type != dnsmessage.TypeA && q.Type != dnsmessage.TypeAAAA) {
+		return nil, false
+	}
+
+	host := r.endpoint.Hostname()
+	if !strings.EqualFold(strings.TrimSuffix(q.Name.String(), "."), host) {
+		return nil, false
+	}
+
+	var addrs []netip.Addr
+
+	if len(r.addresses) == 0 {
+		addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	for _, address := range r.addresses {
+		if addrPort, err := netip.ParseAddrPort(address); err == nil {
+			addrs = append(addrs, addrPort.Addr())
+		}
+	}
+
+	answer, err := addressAnswer(h, q, addrs, uint32(time.Minute.Seconds()))
+
+	return answer, err == nil
+}
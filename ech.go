@@ -0,0 +1,125 @@
+package surf
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	utls "github.com/enetx/utls"
+)
+
+// echCache remembers the retry_configs of servers that rejected ECH. A nil list records
+// that a server sent none, so that GREASE ECH is sent to it instead. It holds at most
+// _echMaxEntries servers.
+type echCache struct {
+	mu      sync.Mutex
+	entries map[string]echEntry
+}
+
+// echEntry is the retry ECHConfigList of a host.
+type echEntry struct {
+	list    []byte
+	expires time.Time
+}
+
+// get returns the unexpired retry ECHConfigList of host.
+func (c *echCache) get(host string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[host]
+	if !ok {
+		return nil, false
+	}
+
+	if !time.Now().Before(e.expires) {
+		delete(c.entries, host)
+		return nil, false
+	}
+
+	return e.list, true
+}
+
+// set stores the retry ECHConfigList of host for ttl.
+func (c *echCache) set(host string, list []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]echEntry)
+	}
+
+	if _, ok := c.entries[host]; !ok && len(c.entries) >= _echMaxEntries {
+		c.evict()
+	}
+
+	c.entries[host] = echEntry{list: list, expires: time.Now().Add(ttl)}
+}
+
+// evict removes the expired entries or, when none has expired, the one expiring first. Called
+// with c.mu held.
+func (c *echCache) evict() {
+	now := time.Now()
+
+	var (
+		oldest  string
+		expires time.Time
+	)
+
+	for host, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, host)
+			continue
+		}
+
+		if expires.IsZero() || e.expires.Before(expires) {
+			oldest, expires = host, e.expires
+		}
+	}
+
+	if len(c.entries) >= _echMaxEntries {
+		delete(c.entries, oldest)
+	}
+}
+
+// echConfigList returns the ECHConfigList to offer to the origin host:port: retry configs
+// received earlier, the list set with Builder.ECHConfig or the one published in the HTTPS
+// record of the origin. HTTPS records are not looked up through a proxy, whose name resolution
+// the lookup would bypass. Returns nil when ECH is not enabled or the origin has no ECH configuration.
+func (rt *roundtripper) echConfigList(ctx context.Context, host, port string) []byte {
+	builder := rt.ja.builder
+
+	if !builder.ech && builder.echConfig == nil {
+		return nil
+	}
+
+	if list, ok := rt.ech.get(host); ok {
+		return list
+	}
+
+	if builder.echConfig != nil {
+		return builder.echConfig
+	}
+
+	if rt.proxied {
+		return nil
+	}
+
+	if endpoint := builder.cli.svcb.lookup(ctx, builder.cli.dialer.Resolver, host, port); endpoint != nil {
+		return endpoint.ech
+	}
+
+	return nil
+}
+
+// hasECHExtension reports whether the spec has an encrypted_client_hello extension,
+// which uTLS replaces with real ECH when a configuration is available.
+func hasECHExtension(spec utls.ClientHelloSpec) bool {
+	for _, ext := range spec.Extensions {
+		if _, ok := ext.(*utls.GREASEEncryptedClientHelloExtension); ok {
+			return true
+		}
+	}
+
+	return false
+}
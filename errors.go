@@ -1,6 +1,10 @@
 package surf
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/enetx/g"
+)
 
 // Custom error types for surf HTTP client operations.
 // These errors provide specific information about different failure scenarios
@@ -9,9 +13,39 @@ import "fmt"
 type (
 	// ErrWebSocketUpgrade indicates that a request received a WebSocket upgrade response.
 	// This error is returned when the server responds with HTTP 101 Switching Protocols
-	// for WebSocket connections, which require special handling.
+	// for WebSocket connections, which require special handling. Use Request.Upgrade
+	// or Client.WebSocket to open a WebSocket connection.
 	ErrWebSocketUpgrade struct{ Msg string }
 
+	// ErrWebSocketHandshake indicates that the WebSocket opening handshake failed.
+	// This error is returned by Request.Upgrade when the request cannot be upgraded
+	// or the server response does not satisfy RFC 6455.
+	ErrWebSocketHandshake struct{ Msg string }
+
+	// ErrConnectUDP indicates that a CONNECT-UDP (RFC 9298) tunnel through the proxy could not
+	// be established for HTTP/3. Unless HTTP/3 is forced, the request falls back to TCP.
+	ErrConnectUDP struct{ Msg string }
+
+	// ErrDNSOverHTTPS indicates that a DNS-over-HTTPS (RFC 8484) endpoint did not answer
+	// a query with a DNS message.
+	ErrDNSOverHTTPS struct{ Msg string }
+
+	// ErrNoProxyAvailable indicates that a ProxyPool has no proxy to send a request through,
+	// because it is empty or all of its proxies are quarantined.
+	ErrNoProxyAvailable struct{ Msg string }
+
+	// ErrCircuitOpen indicates that a request was not sent because the circuit breaker of the
+	// client opened the circuit of its host after consecutive failures.
+	ErrCircuitOpen struct{ Msg string }
+
+	// ErrOAuth2 indicates that an OAuth2 access token could not be obtained from the token
+	// endpoint, or that the OAuth2 settings are incomplete.
+	ErrOAuth2 struct{ Msg string }
+
+	// ErrSigV4 indicates that the AWS Signature Version 4 settings are incomplete, or that a URL
+	// cannot be presigned.
+	ErrSigV4 struct{ Msg string }
+
 	// ErrUserAgentType indicates an invalid user agent type was provided.
 	// This error is returned when the user agent parameter is not of a supported type
 	// (string, g.String, slices, etc.).
@@ -21,6 +55,14 @@ type (
 	// This error is used to handle HTTP 101 responses that require protocol upgrades.
 	Err101ResponseCode struct{ Msg string }
 
+	// ErrAttempts indicates that a request failed after it was sent. Attempts records every
+	// attempt of the request, the last one included, and Err is the error of the request,
+	// returned by Unwrap, so that errors.Is and errors.As match it.
+	ErrAttempts struct {
+		Attempts g.Slice[Attempt]
+		Err      error
+	}
+
 	// ErrHTTP2Fallback indicates that an HTTPS request attempted HTTP/2 first,
 	// then tried to fall back to HTTP/1.1, but both attempts failed.
 	//
@@ -36,6 +78,34 @@ func (e *ErrWebSocketUpgrade) Error() string {
 	return fmt.Sprintf("%s received an unexpected response, switching protocols to WebSocket", e.Msg)
 }
 
+func (e *ErrWebSocketHandshake) Error() string {
+	return fmt.Sprintf("websocket handshake failed: %s", e.Msg)
+}
+
+func (e *ErrConnectUDP) Error() string {
+	return fmt.Sprintf("CONNECT-UDP proxy tunnel failed: %s", e.Msg)
+}
+
+func (e *ErrDNSOverHTTPS) Error() string {
+	return fmt.Sprintf("DNS-over-HTTPS query failed: %s", e.Msg)
+}
+
+func (e *ErrNoProxyAvailable) Error() string {
+	return fmt.Sprintf("no proxy available: %s", e.Msg)
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit open: %s", e.Msg)
+}
+
+func (e *ErrOAuth2) Error() string {
+	return fmt.Sprintf("oauth2 token: %s", e.Msg)
+}
+
+func (e *ErrSigV4) Error() string {
+	return fmt.Sprintf("sigv4: %s", e.Msg)
+}
+
 func (e *ErrUserAgentType) Error() string {
 	return fmt.Sprintf("unsupported user agent type: %s", e.Msg)
 }
@@ -44,6 +114,16 @@ func (e *Err101ResponseCode) Error() string {
 	return fmt.Sprintf("%s received a 101 response status code", e.Msg)
 }
 
+func (e *ErrAttempts) Error() string {
+	if e.Attempts.Len() <= 1 {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts.Len())
+}
+
+func (e *ErrAttempts) Unwrap() error { return e.Err }
+
 func (e *ErrHTTP2Fallback) Error() string {
 	return fmt.Sprintf("surf: HTTP/2 request failed: %v; HTTP/1.1 fallback failed: %v", e.HTTP2, e.HTTP1)
 }
@@ -18,17 +18,17 @@ func main() {
 		Cycle().
 		Take(100)
 
+	// One client for all requests: connections are reused per proxy.
+	cli := surf.NewClient().
+		Builder().
+		ProxySelector(func(*surf.Request) g.String { return ps.Random() }).
+		Build().
+		Unwrap()
+
 	p := pool.New[*surf.Response]().Limit(10)
 
 	for url := range urls {
-		p.Go(
-			surf.NewClient().
-				Builder().
-				Proxy(ps.Random()).
-				Build().
-				Unwrap().
-				Get(url).Do,
-		)
+		p.Go(cli.Get(url).Do)
 	}
 
 	for r := range p.Wait() {
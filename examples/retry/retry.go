@@ -36,7 +36,7 @@ func simpleGetRetry() {
 		return
 	}
 
-	fmt.Println("StatusCode:", r.Ok().StatusCode, "Attempts:", r.Ok().Attempts)
+	fmt.Println("StatusCode:", r.Ok().StatusCode, "Attempts:", r.Ok().Attempts.Len())
 }
 
 func postRetryWithBody() {
@@ -76,7 +76,7 @@ func postRetryWithBody() {
 		return
 	}
 
-	fmt.Printf("Final StatusCode: %d, Total Attempts: %d\n", r.Ok().StatusCode, r.Ok().Attempts+1)
+	fmt.Printf("Final StatusCode: %d, Total Attempts: %d\n", r.Ok().StatusCode, r.Ok().Attempts.Len())
 	fmt.Println("Response:", r.Ok().Body.String().UnwrapOrDefault())
 }
 
@@ -134,6 +134,6 @@ func multipartRetry() {
 		return
 	}
 
-	fmt.Printf("Final StatusCode: %d, Total Attempts: %d\n", r.Ok().StatusCode, r.Ok().Attempts+1)
+	fmt.Printf("Final StatusCode: %d, Total Attempts: %d\n", r.Ok().StatusCode, r.Ok().Attempts.Len())
 	fmt.Println("Response:", r.Ok().Body.String().UnwrapOrDefault())
 }
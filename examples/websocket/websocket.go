@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/enetx/surf"
+)
+
+func main() {
+	cli := surf.NewClient().Builder().Impersonate().Chrome().Build().Unwrap()
+
+	r := cli.WebSocket("wss://echo.websocket.org")
+	if r.IsErr() {
+		log.Fatal(r.Err())
+	}
+
+	ws := r.Ok()
+	defer ws.Close()
+
+	// the echo server greets with a message of its own
+	if _, msg, err := ws.ReadMessage(); err == nil {
+		fmt.Println(string(msg))
+	}
+
+	if err := ws.WriteText("hello from surf"); err != nil {
+		log.Fatal(err)
+	}
+
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(msg))
+}
@@ -0,0 +1,225 @@
+package surf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// addressFamily selects the IP address families connections are made over and their order.
+type addressFamily int
+
+const (
+	anyFamily  addressFamily = iota // Both families, starting with the first address of the resolver
+	preferIPv4                      // Both families, starting with IPv4
+	preferIPv6                      // Both families, starting with IPv6
+	ipv4Only                        // IPv4 addresses only
+	ipv6Only                        // IPv6 addresses only
+)
+
+// network returns the network name hosts are looked up with.
+func (f addressFamily) network() string {
+	switch f {
+	case ipv4Only:
+		return "ip4"
+	case ipv6Only:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// order filters addrs to the allowed families and interleaves IPv4 and IPv6 addresses,
+// starting with the preferred family (RFC 8305 section 4).
+func (f addressFamily) order(addrs []netip.Addr) []netip.Addr {
+	var v4, v6 []netip.Addr
+
+	for _, addr := range addrs {
+		if addr = addr.Unmap(); addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+
+	switch f {
+	case ipv4Only:
+		return v4
+	case ipv6Only:
+		return v6
+	}
+
+	first, second := v6, v4
+	if f == preferIPv4 || f == anyFamily && len(addrs) != 0 && addrs[0].Unmap().Is4() {
+		first, second = v4, v6
+	}
+
+	ordered := make([]netip.Addr, 0, len(addrs))
+
+	for i := range max(len(first), len(second)) {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+
+	return ordered
+}
+
+// lookupIPs resolves host with resolver, or the default resolver without one, and returns
+// its addresses of the allowed families in the order connections are attempted.
+func lookupIPs(ctx context.Context, resolver *net.Resolver, family addressFamily, host string) ([]netip.Addr, error) {
+	var ips []netip.Addr
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else {
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+
+		if ips, err = resolver.LookupNetIP(ctx, family.network(), host); err != nil {
+			return nil, err
+		}
+	}
+
+	ips = family.order(ips)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+
+	return ips, nil
+}
+
+// lookupAddrs resolves the host of addr like lookupIPs and returns the host:port addresses
+// connections are attempted to.
+func lookupAddrs(ctx context.Context, resolver *net.Resolver, family addressFamily, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := lookupIPs(ctx, resolver, family, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+
+	return addrs, nil
+}
+
+// happyEyeballs dials addrs in order and returns the first connection established. Each attempt
+// starts when the previous one fails or after delay, whichever comes first (RFC 8305 section 5);
+// with a negative delay the addresses are tried one after another. Connections established after
+// the first one are closed with closeConn.
+func happyEyeballs[T any](
+	ctx context.Context,
+	addrs []string,
+	delay time.Duration,
+	dial func(context.Context, string) (T, error),
+	closeConn func(T),
+) (T, error) {
+	type result struct {
+		conn T
+		err  error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(addrs))
+
+	var (
+		next, pending int
+		firstErr      error
+	)
+
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+
+	for pending > 0 {
+		var timeout <-chan time.Time
+		if next < len(addrs) && delay >= 0 {
+			timeout = time.After(delay)
+		}
+
+		select {
+		case r := <-results:
+			pending--
+
+			if r.err == nil {
+				go func(pending int) {
+					for range pending {
+						if r := <-results; r.err == nil {
+							closeConn(r.conn)
+						}
+					}
+				}(pending)
+
+				return r.conn, nil
+			}
+
+			if firstErr == nil {
+				firstErr = r.err
+			}
+
+			if next < len(addrs) {
+				start()
+			}
+		case <-timeout:
+			start()
+		}
+	}
+
+	var zero T
+
+	return zero, firstErr
+}
+
+// happyEyeballsDelay returns the configured Happy Eyeballs delay or the default one.
+func (b *Builder) happyEyeballsDelay() time.Duration {
+	if b.fallbackDelay == 0 {
+		return _happyEyeballsDelay
+	}
+
+	return b.fallbackDelay
+}
+
+// dial connects to addr with the client dialer. With an address family or Happy Eyeballs
+// option, the host is resolved here and its addresses are raced in the order of the family.
+func (c *Client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	b := c.builder
+	if b == nil || b.family == anyFamily && b.fallbackDelay == 0 {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := lookupAddrs(ctx, c.dialer.Resolver, b.family, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return happyEyeballs(ctx, addrs, b.happyEyeballsDelay(),
+		func(ctx context.Context, addr string) (net.Conn, error) {
+			return c.dialer.DialContext(ctx, network, addr)
+		},
+		func(conn net.Conn) { conn.Close() },
+	)
+}
@@ -20,6 +20,7 @@ const (
 	AUTHORIZATION                       = "authorization"
 	CACHE_CONTROL                       = "cache-control"
 	CACHE_STATUS                        = "cache-status"
+	CAPSULE_PROTOCOL                    = "capsule-protocol"
 	CDN_CACHE_CONTROL                   = "cdn-cache-control"
 	CONNECTION                          = "connection"
 	CONTENT_DISPOSITION                 = "content-disposition"
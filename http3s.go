@@ -9,11 +9,14 @@ import (
 	"math/rand/v2"
 	"net"
 	"net/url"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/enetx/g"
 	"github.com/enetx/http"
 	"github.com/enetx/http3"
+	"github.com/enetx/surf/header"
 	"github.com/enetx/surf/pkg/quicconn"
 	"github.com/quic-go/quic-go"
 	"github.com/wzshiming/socks5"
@@ -108,46 +111,107 @@ func (h *HTTP3Settings) Set() *Builder {
 }
 
 // uquicTransport implements http.RoundTripper with HTTP/3 support.
-// It provides SOCKS5 proxy compatibility and automatic fallback to HTTP/2
-// when HTTP/3 is unavailable or for non-SOCKS5 proxies.
+// It provides SOCKS5 and HTTPS (CONNECT-UDP) proxy compatibility and automatic
+// fallback to HTTP/2 when HTTP/3 is unavailable or for other proxies. Unless HTTP/3 is forced,
+// requests use HTTP/3 only for origins with an h3 alternative in the Alt-Svc cache or,
+// with HTTPSRecords, an h3 endpoint in their HTTPS record.
 type uquicTransport struct {
 	http3tr           *http3.Transport
 	quictr            *quic.Transport
 	pconn             net.PacketConn
 	fallbackTransport http.RoundTripper
+	altsvc            *AltSvcCache
+	svcb              *svcbCache // HTTPS records of origins, nil unless enabled with HTTPSRecords
+	masque            *masqueDialer
 	tlsConfig         *tls.Config
 	dialer            *net.Dialer
 	settings          g.MapOrd[uint64, uint64]
 	proxy             string
+	chain             bool          // requests go through a proxy chain, which cannot relay QUIC
+	connectUDP        bool          // relay QUIC through HTTPS proxies with CONNECT-UDP, enabled with MASQUE
+	fallbackDelay     time.Duration // Happy Eyeballs delay between direct connection attempts
+	family            addressFamily // IP address families dialed and their order
 }
 
 // newUQUICTransport creates a new HTTP/3 transport with the given settings.
 func newUQUICTransport(settings g.MapOrd[uint64, uint64], c *Client, builder *Builder) (*uquicTransport, error) {
 	ut := &uquicTransport{
-		tlsConfig: c.tlsConfig.Clone(),
-		dialer:    c.GetDialer(),
-		settings:  settings,
-		proxy:     builder.proxy.Std(),
+		tlsConfig:     c.tlsConfig.Clone(),
+		dialer:        c.GetDialer(),
+		settings:      settings,
+		proxy:         builder.proxy.Std(),
+		chain:         builder.proxyChain != nil,
+		connectUDP:    builder.masque,
+		family:        builder.family,
+		fallbackDelay: builder.happyEyeballsDelay(),
 	}
 
 	if !builder.forceHTTP3 {
 		ut.fallbackTransport = c.GetTransport()
-	}
 
-	if ut.proxy != "" && !isSOCKS5Proxy(ut.proxy) {
-		if ut.fallbackTransport == nil {
-			return nil, errors.New("HTTP/3 requires SOCKS5 proxy for UDP relay")
+		ut.altsvc = builder.altsvc
+		if ut.altsvc == nil {
+			ut.altsvc = NewAltSvcCache()
+		}
+
+		c.altsvc = ut.altsvc
+
+		if builder.httpsRecords {
+			ut.svcb = &c.svcb
 		}
-		return ut, nil
 	}
 
-	if err := ut.initTransport(); err != nil {
+	if err := ut.init(); err != nil {
 		return nil, err
 	}
 
 	return ut, nil
 }
 
+// withProxy returns a transport with the configuration of ut sending requests through proxy,
+// falling back to the fallback transport.
+func (ut *uquicTransport) withProxy(proxy string, fallback http.RoundTripper) (*uquicTransport, error) {
+	clone := &uquicTransport{
+		tlsConfig:         ut.tlsConfig.Clone(),
+		dialer:            ut.dialer,
+		settings:          ut.settings,
+		proxy:             proxy,
+		connectUDP:        ut.connectUDP,
+		family:            ut.family,
+		fallbackDelay:     ut.fallbackDelay,
+		fallbackTransport: fallback,
+		altsvc:            ut.altsvc,
+		svcb:              ut.svcb,
+	}
+
+	if err := clone.init(); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+// init sets up the proxy dialer and the QUIC and HTTP/3 transports for the proxy of ut.
+func (ut *uquicTransport) init() error {
+	if ut.tcpOnly() {
+		if ut.fallbackTransport == nil {
+			return errors.New("HTTP/3 requires SOCKS5 proxy or HTTPS proxy with MASQUE for UDP relay")
+		}
+		return nil
+	}
+
+	if ut.masqueProxy() {
+		masque, err := newMASQUEDialer(ut.proxy, ut.tlsConfig, ut.dialer, ut.resolve)
+		if err != nil {
+			return err
+		}
+
+		ut.masque = masque
+	}
+
+	return ut.initTransport()
+}
+
 // initTransport initializes the underlying QUIC and HTTP/3 transports.
 func (ut *uquicTransport) initTransport() error {
 	if ut.proxy == "" {
@@ -177,25 +241,47 @@ func (ut *uquicTransport) initTransport() error {
 	return nil
 }
 
-// createUDPPacketConn creates a UDP listener, preferring IPv4.
+// createUDPPacketConn creates a UDP socket for the address family: a dual-stack socket unless
+// one family is required, IPv4 where IPv6 is not available.
 func (ut *uquicTransport) createUDPPacketConn() (net.PacketConn, error) {
-	if conn, err := net.ListenUDP("udp4", nil); err == nil {
+	switch ut.family {
+	case ipv4Only:
+		return net.ListenUDP("udp4", nil)
+	case ipv6Only:
+		return net.ListenUDP("udp6", nil)
+	}
+
+	if conn, err := net.ListenUDP("udp", nil); err == nil {
 		return conn, nil
 	}
 
-	return net.ListenUDP("udp6", nil)
+	return net.ListenUDP("udp4", nil)
 }
 
-// dial establishes a QUIC connection, routing through SOCKS5 proxy if configured.
+// dial establishes a QUIC connection, routing through SOCKS5 or CONNECT-UDP proxy if configured.
+// The connection goes to the Alt-Svc alternative of the origin when one is cached,
+// the TLS server name stays the origin host.
 func (ut *uquicTransport) dial(
 	ctx context.Context,
 	addr string,
 	tlsCfg *tls.Config,
 	cfg *quic.Config,
 ) (*quic.Conn, error) {
-	resolved, err := ut.resolve(ctx, addr)
+	target := addr
+	if ut.altsvc != nil {
+		if alternative, ok := ut.altsvc.lookup(addr); ok {
+			target = alternative
+		}
+	}
+
+	addrs, err := lookupAddrs(ctx, ut.dialer.Resolver, ut.family, target)
 	if err != nil {
-		return nil, fmt.Errorf("DNS resolution: %w", err)
+		hinted := ut.hinted(ctx, addr, target)
+		if len(hinted) == 0 {
+			return nil, fmt.Errorf("DNS resolution: %w", err)
+		}
+
+		addrs = hinted
 	}
 
 	host, _, _ := net.SplitHostPort(addr)
@@ -204,11 +290,20 @@ func (ut *uquicTransport) dial(
 		tlsCfg.ServerName = host
 	}
 
+	if ut.masque != nil {
+		return ut.dialMASQUE(ctx, addrs[0], tlsCfg, cfg)
+	}
+
 	if ut.proxy != "" {
-		return ut.dialSOCKS5(ctx, resolved, tlsCfg, cfg)
+		return ut.dialSOCKS5(ctx, addrs[0], tlsCfg, cfg)
 	}
 
-	return ut.dialDirect(ctx, resolved, tlsCfg, cfg)
+	return happyEyeballs(ctx, addrs, ut.fallbackDelay,
+		func(ctx context.Context, resolved string) (*quic.Conn, error) {
+			return ut.dialDirect(ctx, resolved, tlsCfg, cfg)
+		},
+		func(conn *quic.Conn) { conn.CloseWithError(0, "") },
+	)
 }
 
 // dialSOCKS5 establishes a QUIC connection through a SOCKS5 proxy using UDP ASSOCIATE.
@@ -258,6 +353,35 @@ func (ut *uquicTransport) dialSOCKS5(
 	return quicConn, nil
 }
 
+// dialMASQUE establishes a QUIC connection through a CONNECT-UDP tunnel of an HTTPS proxy.
+func (ut *uquicTransport) dialMASQUE(
+	ctx context.Context,
+	resolved string,
+	tlsCfg *tls.Config,
+	cfg *quic.Config,
+) (*quic.Conn, error) {
+	targetUDP, err := net.ResolveUDPAddr("udp", resolved)
+	if err != nil {
+		return nil, fmt.Errorf("resolve target UDP addr: %w", err)
+	}
+
+	packetConn, err := ut.masque.listenPacket(ctx, targetUDP)
+	if err != nil {
+		return nil, err
+	}
+
+	quicConn, err := quic.DialEarly(ctx, packetConn, targetUDP, tlsCfg, cfg)
+	if err != nil {
+		packetConn.Close()
+		return nil, fmt.Errorf("QUIC dial: %w", err)
+	}
+
+	// The tunnel is not closed by quic-go, it ends with the connection.
+	context.AfterFunc(quicConn.Context(), func() { packetConn.Close() })
+
+	return quicConn, nil
+}
+
 // dialDirect establishes a direct QUIC connection without proxy.
 func (ut *uquicTransport) dialDirect(
 	ctx context.Context,
@@ -273,48 +397,105 @@ func (ut *uquicTransport) dialDirect(
 	return ut.quictr.Dial(ctx, udpAddr, tlsCfg, cfg)
 }
 
-// resolve resolves a hostname to an IP address, preferring IPv4.
+// resolve resolves a hostname to the first IP address of the address family.
 func (ut *uquicTransport) resolve(ctx context.Context, addr string) (string, error) {
-	host, port, err := net.SplitHostPort(addr)
+	addrs, err := lookupAddrs(ctx, ut.dialer.Resolver, ut.family, addr)
 	if err != nil {
-		return "", fmt.Errorf("split host/port: %w", err)
+		return "", fmt.Errorf("DNS lookup: %w", err)
+	}
+
+	return addrs[0], nil
+}
+
+// hinted returns the ipv4hint and ipv6hint addresses published in the HTTPS record of origin
+// in the order of the address family, joined with the port of target, for use when target
+// does not resolve.
+func (ut *uquicTransport) hinted(ctx context.Context, origin, target string) []string {
+	if ut.svcb == nil || ut.proxied() {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(origin)
+	if err != nil {
+		return nil
+	}
+
+	endpoint := ut.svcb.lookup(ctx, ut.dialer.Resolver, host, port)
+	if endpoint == nil {
+		return nil
 	}
 
-	if net.ParseIP(host) != nil {
-		return addr, nil
+	_, port, _ = net.SplitHostPort(target)
+
+	var hinted []string
+	for _, hint := range ut.family.order(endpoint.hints) {
+		hinted = append(hinted, net.JoinHostPort(hint.String(), port))
 	}
 
-	resolver := net.DefaultResolver
-	if ut.dialer != nil && ut.dialer.Resolver != nil {
-		resolver = ut.dialer.Resolver
+	return hinted
+}
+
+// advertised records the h3 endpoint published in the HTTPS record of origin as its HTTP/3
+// alternative when the Alt-Svc cache has none, so that the first request skips the TCP
+// round-trip. Reports whether origin has a usable alternative.
+func (ut *uquicTransport) advertised(ctx context.Context, origin string) bool {
+	if ut.svcb == nil || ut.proxied() {
+		return false
 	}
 
-	ips, err := resolver.LookupIPAddr(ctx, host)
+	host, port, err := net.SplitHostPort(origin)
 	if err != nil {
-		return "", fmt.Errorf("DNS lookup: %w", err)
+		return false
 	}
 
-	if len(ips) == 0 {
-		return "", fmt.Errorf("no IP addresses found for %s", host)
+	endpoint := ut.svcb.lookup(ctx, ut.dialer.Resolver, host, port)
+	if endpoint == nil || !endpoint.supports("h3") {
+		return false
 	}
 
-	for _, ip := range ips {
-		if ip.IP.To4() != nil {
-			return net.JoinHostPort(ip.IP.String(), port), nil
-		}
+	if endpoint.port != 0 {
+		port = strconv.Itoa(int(endpoint.port))
+	}
+
+	ut.altsvc.advertise(origin, net.JoinHostPort("", port), endpoint.ttl)
+
+	_, ok := ut.altsvc.lookup(origin)
+
+	return ok
+}
+
+// handleUpgradeRequest opens a WebSocket over an existing HTTP/3 connection with extended
+// CONNECT (RFC 9220) when the server has enabled it, otherwise the handshake is passed
+// to the fallback transport.
+func (ut *uquicTransport) handleUpgradeRequest(req *http.Request) (*http.Response, error) {
+	creq, pw := extendedConnectRequest(req, "websocket")
+
+	resp, err := ut.http3tr.RoundTripOpt(creq, http3.RoundTripOpt{OnlyCachedConn: true})
+	if err == nil {
+		return extendedConnectResponse(resp, pw), nil
+	}
+
+	pw.Close()
+
+	if err := req.Context().Err(); err != nil {
+		return nil, err
 	}
 
-	return net.JoinHostPort(ips[0].IP.String(), port), nil
+	if ut.fallbackTransport == nil {
+		return nil, fmt.Errorf("websocket over HTTP/3: %w", err)
+	}
+
+	return ut.roundTripFallback(req)
 }
 
 // RoundTrip executes an HTTP/3 request with automatic fallback to HTTP/2.
 func (ut *uquicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if ut.proxy != "" && !isSOCKS5Proxy(ut.proxy) {
+	if ut.tcpOnly() {
 		if ut.fallbackTransport != nil {
 			return ut.fallbackTransport.RoundTrip(req)
 		}
 
-		return nil, errors.New("non-SOCKS5 proxy requires HTTP/2 fallback")
+		return nil, errors.New("proxy without UDP relay requires HTTP/2 fallback")
 	}
 
 	if ut.http3tr == nil {
@@ -329,11 +510,56 @@ func (ut *uquicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		req = cloneRequestWithScheme(req, "https")
 	}
 
+	if isUpgradeRequest(req) {
+		return ut.handleUpgradeRequest(req)
+	}
+
+	if ut.altsvc == nil {
+		resp, err := ut.http3tr.RoundTrip(req)
+		if err != nil {
+			return ut.handleError(req, err)
+		}
+
+		return resp, nil
+	}
+
+	if req.URL.Scheme != "https" {
+		return ut.fallbackTransport.RoundTrip(req)
+	}
+
+	origin := authority(req.URL)
+
+	if _, ok := ut.altsvc.lookup(origin); !ok && !ut.advertised(req.Context(), origin) {
+		return ut.roundTripFallback(req)
+	}
+
 	resp, err := ut.http3tr.RoundTrip(req)
 	if err != nil {
+		if req.Context().Err() == nil && isHTTP3UnsupportedError(err) {
+			ut.altsvc.markBroken(origin)
+		}
+
 		return ut.handleError(req, err)
 	}
 
+	ut.altsvc.markWorking(origin)
+	ut.altsvc.update(origin, resp.Header.Values(header.ALT_SVC))
+
+	return resp, nil
+}
+
+// roundTripFallback sends the request over the fallback transport and records
+// the HTTP/3 alternative advertised in the response.
+func (ut *uquicTransport) roundTripFallback(req *http.Request) (*http.Response, error) {
+	resp, err := ut.fallbackTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ut.altsvc != nil && req.URL.Scheme == "https" {
+		ut.altsvc.update(authority(req.URL), resp.Header.Values(header.ALT_SVC))
+	}
+
 	return resp, nil
 }
 
@@ -360,7 +586,7 @@ func (ut *uquicTransport) handleError(req *http.Request, err error) (*http.Respo
 		req.Body = body
 	}
 
-	return ut.fallbackTransport.RoundTrip(req)
+	return ut.roundTripFallback(req)
 }
 
 // CloseIdleConnections closes idle connections while keeping the transport usable.
@@ -390,6 +616,10 @@ func (ut *uquicTransport) Close() error {
 		ut.pconn.Close()
 	}
 
+	if ut.masque != nil {
+		ut.masque.Close()
+	}
+
 	if ut.fallbackTransport != nil {
 		if c, ok := ut.fallbackTransport.(interface{ Close() error }); ok {
 			c.Close()
@@ -417,6 +647,11 @@ func isHTTP3UnsupportedError(err error) bool {
 		return false
 	}
 
+	var connectErr *ErrConnectUDP
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
 	var appErr *quic.ApplicationError
 	if errors.As(err, &appErr) {
 		return true
@@ -452,6 +687,33 @@ func isHTTP3UnsupportedError(err error) bool {
 	return false
 }
 
+// tcpOnly reports whether the requests of ut are sent through a proxy that cannot relay QUIC:
+// a proxy chain, or a proxy other than SOCKS5 and, with MASQUE, HTTPS.
+func (ut *uquicTransport) tcpOnly() bool {
+	return ut.chain || ut.proxy != "" && !isSOCKS5Proxy(ut.proxy) && !ut.masqueProxy()
+}
+
+// proxied reports whether the requests of ut go through a proxy or a proxy chain, whose
+// name resolution local HTTPS record lookups would bypass.
+func (ut *uquicTransport) proxied() bool { return ut.proxy != "" || ut.chain }
+
+// masqueProxy reports whether QUIC is relayed through the proxy of ut with CONNECT-UDP.
+func (ut *uquicTransport) masqueProxy() bool { return ut.connectUDP && isHTTPSProxy(ut.proxy) }
+
+// isHTTPSProxy reports whether the proxy is an HTTPS proxy.
+func isHTTPSProxy(proxyURL string) bool {
+	if proxyURL == "" {
+		return false
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "https"
+}
+
 func isSOCKS5Proxy(proxyURL string) bool {
 	if proxyURL == "" {
 		return false
@@ -0,0 +1,184 @@
+package surf
+
+import (
+	"io"
+	"slices"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/surf/pkg/httpsig"
+)
+
+// HTTPSignatureSettings provides a fluent interface for signing the requests of the client with
+// HTTP Message Signatures (RFC 9421).
+type HTTPSignatureSettings struct {
+	builder *Builder
+	keyID   g.String
+	key     any
+	signer  httpsig.Signer
+}
+
+// Label sets the label of the signatures, sig1 by default.
+func (hss *HTTPSignatureSettings) Label(label g.String) *HTTPSignatureSettings {
+	hss.signer.Label = label.Std()
+	return hss
+}
+
+// Components sets the covered components, derived components such as @method, @target-uri,
+// @authority or @path, and lower-case header field names. By default @method, @target-uri and
+// content-digest are covered.
+func (hss *HTTPSignatureSettings) Components(components ...g.String) *HTTPSignatureSettings {
+	hss.signer.Components = g.TransformSlice(components, g.String.Std)
+	return hss
+}
+
+// Expires sets the validity of the signatures from their creation.
+func (hss *HTTPSignatureSettings) Expires(expires time.Duration) *HTTPSignatureSettings {
+	hss.signer.Expires = expires
+	return hss
+}
+
+// Nonce adds a random nonce parameter to the signatures.
+func (hss *HTTPSignatureSettings) Nonce() *HTTPSignatureSettings {
+	hss.signer.Nonce = true
+	return hss
+}
+
+// Tag sets the application-specific tag parameter of the signatures.
+func (hss *HTTPSignatureSettings) Tag(tag g.String) *HTTPSignatureSettings {
+	hss.signer.Tag = tag.Std()
+	return hss
+}
+
+// Set applies the signature settings to the client. Requests are signed after the request
+// middlewares, every time they are sent. When content-digest is covered, the Content-Digest
+// header of the body is computed, and left out of the signatures of requests without a body or
+// with a streamed body, such as multipart bodies without Multipart.Retry.
+// An unsupported key fails Build.
+func (hss *HTTPSignatureSettings) Set() *Builder {
+	signer := hss.signer
+	hss.builder.httpSigner = &signer
+
+	return hss.builder.addCliMW(func(*Client) error {
+		key, err := httpsig.NewKey(hss.keyID.Std(), hss.key)
+		if err != nil {
+			return err
+		}
+
+		signer.Key = key
+
+		return nil
+	}, 0)
+}
+
+// httpSignatureKey is a key of VerifyHTTPSignature, converted when the client is built.
+type httpSignatureKey struct {
+	keyID g.String
+	key   any
+}
+
+// signHTTPMessage sets the Signature-Input and Signature headers of req.
+func signHTTPMessage(signer *httpsig.Signer, req *Request) error {
+	if slices.Contains(signer.Components, "content-digest") {
+		if len(req.bodyBytes) == 0 {
+			// Empty and streamed bodies have no digest to cover.
+			copied := *signer
+			copied.Components = slices.DeleteFunc(slices.Clone(signer.Components),
+				func(c string) bool { return c == "content-digest" })
+			signer = &copied
+		} else {
+			setContentDigest(req)
+		}
+	}
+
+	input, signature, err := signer.Sign(httpsig.RequestMessage(req.request))
+	if err != nil {
+		return err
+	}
+
+	req.request.Header.Set("Signature-Input", input)
+	req.request.Header.Set("Signature", signature)
+
+	return nil
+}
+
+// setContentDigest sets the sha-256 Content-Digest header of the body of req (RFC 9530).
+func setContentDigest(req *Request) {
+	digest, _ := httpsig.ContentDigest(req.bodyBytes, httpsig.DigestSHA256)
+	req.request.Header.Set("Content-Digest", digest)
+}
+
+// verifyHTTPSignatureMW verifies the signature of the response with the verifier. When the
+// signature covers the Content-Digest header, the digest of the body is verified as it is read.
+func verifyHTTPSignatureMW(verifier *httpsig.Verifier, resp *Response) error {
+	components, err := verifier.Verify(httpsig.ResponseMessage(resp.response))
+	if err != nil {
+		resp.response.Body.Close()
+		return err
+	}
+
+	if !slices.Contains(components, "content-digest") || resp.Body == nil || resp.Body.Reader == nil {
+		return nil
+	}
+
+	digest, err := httpsig.NewDigestVerifier(resp.response.Header.Get("Content-Digest"))
+	if err != nil {
+		resp.response.Body.Close()
+		return err
+	}
+
+	// The digest is computed over the content as sent, before it is decoded.
+	resp.digest = &digestReader{ReadCloser: resp.Body.Reader, verifier: digest}
+	resp.Body.Reader = resp.digest
+
+	return nil
+}
+
+// verifyDecodedDigestMW verifies the content digest of a decoded body at the end of the decoded
+// content, as decoders may stop reading before the end of the encoded content.
+func verifyDecodedDigestMW(resp *Response) error {
+	if resp.digest != nil && resp.Body != nil && resp.Body.Reader != io.ReadCloser(resp.digest) {
+		resp.Body.Reader = &decodedDigestReader{ReadCloser: resp.Body.Reader, digest: resp.digest}
+	}
+
+	return nil
+}
+
+// digestReader computes the digest of a body as it is read. The read reaching the end of the body
+// returns an *httpsig.ErrSignature instead of io.EOF when the Content-Digest does not match.
+type digestReader struct {
+	io.ReadCloser
+	verifier *httpsig.DigestVerifier
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.verifier.Write(p[:n])
+
+	if err == io.EOF {
+		if verr := r.verifier.Verify(); verr != nil {
+			err = verr
+		}
+	}
+
+	return n, err
+}
+
+// decodedDigestReader reads the decoded content of a digestReader, reading the rest of the
+// encoded content at the end of the decoded content to verify its digest.
+type decodedDigestReader struct {
+	io.ReadCloser
+	digest *digestReader
+}
+
+func (r *decodedDigestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	if err == io.EOF {
+		if _, derr := io.Copy(io.Discard, r.digest); derr != nil {
+			err = derr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,223 @@
+package httpauth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Digest is a Digest challenge (RFC 7616 section 3.3).
+type Digest struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string   // Upper-case algorithm name, MD5 when absent
+	QOP       string   // auth, auth-int or empty for RFC 2069 servers
+	Domain    []string // URIs of the protection space, as sent by the server
+	Userhash  bool
+	Stale     bool
+	Count     uint32 // Requests made with the nonce
+}
+
+// NewDigest returns the Digest challenge of the auth-params of a challenge. auth is preferred
+// when both qop values are offered.
+func NewDigest(params map[string]string) *Digest {
+	d := &Digest{Algorithm: "MD5"}
+
+	for name, value := range params {
+		switch name {
+		case "realm":
+			d.Realm = value
+		case "nonce":
+			d.Nonce = value
+		case "opaque":
+			d.Opaque = value
+		case "algorithm":
+			d.Algorithm = strings.ToUpper(value)
+		case "userhash":
+			d.Userhash = strings.EqualFold(value, "true")
+		case "stale":
+			d.Stale = strings.EqualFold(value, "true")
+		case "domain":
+			d.Domain = strings.Fields(value)
+		case "qop":
+			for qop := range strings.SplitSeq(value, ",") {
+				switch strings.TrimSpace(qop) {
+				case "auth":
+					d.QOP = "auth"
+				case "auth-int":
+					if d.QOP == "" {
+						d.QOP = "auth-int"
+					}
+				}
+			}
+		}
+	}
+
+	return d
+}
+
+// Better reports whether the challenge uses a supported algorithm stronger than the one of other,
+// a nil other when it is supported at all.
+func (d *Digest) Better(other *Digest) bool {
+	if d.hash() == nil || d.Nonce == "" {
+		return false
+	}
+
+	return other == nil || d.strength() > other.strength()
+}
+
+// strength ranks the algorithms of the challenges offered together, preferring SHA-512-256.
+func (d *Digest) strength() int {
+	switch strings.TrimSuffix(d.Algorithm, "-SESS") {
+	case "SHA-512-256":
+		return 3
+	case "SHA-256":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// hash returns the hash function of the algorithm, or nil when it is not supported.
+func (d *Digest) hash() func() hash.Hash {
+	switch strings.TrimSuffix(d.Algorithm, "-SESS") {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	default:
+		return nil
+	}
+}
+
+// Authorization computes the Digest credentials of a request for uri with the next nonce count
+// (RFC 7616 section 3.4). body is covered with qop=auth-int.
+func (d *Digest) Authorization(username, password, method, uri string, body []byte) string {
+	newHash := d.hash()
+
+	h := func(s []byte) string {
+		hh := newHash()
+		hh.Write(s)
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	d.Count++
+
+	cnonce := make([]byte, 16)
+	rand.Read(cnonce)
+
+	var (
+		nc = fmt.Sprintf("%08x", d.Count)
+		cn = hex.EncodeToString(cnonce)
+	)
+
+	ha1 := h([]byte(username + ":" + d.Realm + ":" + password))
+	if strings.HasSuffix(d.Algorithm, "-SESS") {
+		ha1 = h([]byte(ha1 + ":" + d.Nonce + ":" + cn))
+	}
+
+	a2 := method + ":" + uri
+	if d.QOP == "auth-int" {
+		a2 += ":" + h(body)
+	}
+
+	ha2 := h([]byte(a2))
+
+	response := h([]byte(ha1 + ":" + d.Nonce + ":" + ha2))
+	if d.QOP != "" {
+		response = h([]byte(ha1 + ":" + d.Nonce + ":" + nc + ":" + cn + ":" + d.QOP + ":" + ha2))
+	}
+
+	if d.Userhash {
+		username = h([]byte(username + ":" + d.Realm))
+	}
+
+	var b strings.Builder
+
+	b.WriteString("Digest ")
+
+	// Usernames a quoted-string cannot carry are sent as an RFC 8187 ext-value.
+	if quotable(username) {
+		b.WriteString("username=" + quote(username))
+	} else {
+		b.WriteString("username*=UTF-8''" + extValue(username))
+	}
+
+	fmt.Fprintf(&b, `, realm=%s, nonce=%s, uri=%s, algorithm=%s, response="%s"`,
+		quote(d.Realm), quote(d.Nonce), quote(uri), d.Algorithm, response)
+
+	if d.QOP != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s"`, d.QOP, nc, cn)
+	}
+
+	if d.Opaque != "" {
+		b.WriteString(", opaque=" + quote(d.Opaque))
+	}
+
+	if d.Userhash {
+		b.WriteString(`, userhash=true`)
+	}
+
+	return b.String()
+}
+
+// quotable reports whether s is printable ASCII, the characters of an RFC 7616 username quoted-string.
+func quotable(s string) bool {
+	for i := range len(s) {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// quote returns s as a quoted-string (RFC 9110 section 5.6.4), escaping only quotes and backslashes.
+func quote(s string) string {
+	var b strings.Builder
+
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+
+	for i := range len(s) {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// extValue percent-encodes s as the value-chars of an RFC 8187 ext-value.
+func extValue(s string) string {
+	const hexUpper = "0123456789ABCDEF"
+
+	var b strings.Builder
+
+	for i := range len(s) {
+		c := s[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexUpper[c>>4])
+			b.WriteByte(hexUpper[c&15])
+		}
+	}
+
+	return b.String()
+}
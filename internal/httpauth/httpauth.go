@@ -0,0 +1,71 @@
+// Package httpauth parses the challenges of WWW-Authenticate and Proxy-Authenticate headers and
+// answers Digest challenges, for origin servers and proxies alike.
+package httpauth
+
+import "strings"
+
+// Challenge is a challenge of a WWW-Authenticate or Proxy-Authenticate header.
+type Challenge struct {
+	Scheme string
+	Params map[string]string // Lower-case auth-param names
+}
+
+// ParseChallenges parses the challenges of authenticate header values, several challenges
+// possibly sharing a value (RFC 9110 section 11.6.1). token68 credentials are not supported.
+func ParseChallenges(values []string) []Challenge {
+	var challenges []Challenge
+
+	for _, s := range values {
+		for {
+			s = strings.TrimLeft(s, ", \t")
+			if s == "" {
+				break
+			}
+
+			end := strings.IndexAny(s, " \t,=")
+			if end < 0 {
+				end = len(s)
+			}
+
+			token := s[:end]
+			rest := strings.TrimLeft(s[end:], " \t")
+
+			if !strings.HasPrefix(rest, "=") || len(challenges) == 0 {
+				challenges = append(challenges, Challenge{Scheme: token, Params: make(map[string]string)})
+				s = rest
+
+				continue
+			}
+
+			rest = strings.TrimLeft(rest[1:], " \t")
+
+			var value strings.Builder
+
+			if strings.HasPrefix(rest, `"`) {
+				i := 1
+				for ; i < len(rest) && rest[i] != '"'; i++ {
+					if rest[i] == '\\' && i+1 < len(rest) {
+						i++
+					}
+
+					value.WriteByte(rest[i])
+				}
+
+				rest = rest[min(i+1, len(rest)):]
+			} else {
+				end := strings.IndexAny(rest, ", \t")
+				if end < 0 {
+					end = len(rest)
+				}
+
+				value.WriteString(rest[:end])
+				rest = rest[end:]
+			}
+
+			challenges[len(challenges)-1].Params[strings.ToLower(token)] = value.String()
+			s = rest
+		}
+	}
+
+	return challenges
+}
@@ -0,0 +1,90 @@
+package httpauth_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/enetx/surf/internal/httpauth"
+)
+
+func TestParseChallenges(t *testing.T) {
+	t.Parallel()
+
+	challenges := httpauth.ParseChallenges([]string{
+		`Basic realm="api", Digest realm="a \"b\"", nonce=abc, qop="auth,auth-int"`,
+		`Bearer error="invalid_token"`,
+	})
+
+	if len(challenges) != 3 {
+		t.Fatalf("expected 3 challenges, got %+v", challenges)
+	}
+
+	if c := challenges[1]; c.Scheme != "Digest" || c.Params["realm"] != `a "b"` || c.Params["nonce"] != "abc" {
+		t.Errorf("unexpected Digest challenge %+v", c)
+	}
+
+	if c := challenges[2]; c.Scheme != "Bearer" || c.Params["error"] != "invalid_token" {
+		t.Errorf("unexpected Bearer challenge %+v", c)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	t.Parallel()
+
+	md5Digest := httpauth.NewDigest(map[string]string{"realm": "api", "nonce": "n", "qop": "auth-int, auth"})
+	sha256Digest := httpauth.NewDigest(map[string]string{"nonce": "n", "algorithm": "sha-256"})
+	unsupported := httpauth.NewDigest(map[string]string{"nonce": "n", "algorithm": "SHA-1"})
+
+	if md5Digest.QOP != "auth" || sha256Digest.Algorithm != "SHA-256" {
+		t.Fatalf("unexpected challenges %+v %+v", md5Digest, sha256Digest)
+	}
+
+	if !sha256Digest.Better(md5Digest) || md5Digest.Better(sha256Digest) || unsupported.Better(nil) {
+		t.Error("expected SHA-256 to be preferred and SHA-1 to be unsupported")
+	}
+
+	// RFC 2069 credentials are deterministic.
+	legacy := httpauth.NewDigest(map[string]string{"realm": "api", "nonce": "n", "opaque": "o"})
+
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	response := h(h("user:api:pass") + ":n:" + h("GET:/path"))
+	auth := legacy.Authorization("user", "pass", "GET", "/path", nil)
+
+	if want := `Digest username="user", realm="api", nonce="n", uri="/path", algorithm=MD5, response="` +
+		response + `", opaque="o"`; auth != want {
+		t.Errorf("expected %s, got %s", want, auth)
+	}
+
+	if auth := md5Digest.Authorization("user", "pass", "GET", "/", nil); !strings.Contains(auth, "nc=00000001") ||
+		!strings.Contains(md5Digest.Authorization("user", "pass", "GET", "/", nil), "nc=00000002") {
+		t.Errorf("expected incremented nonce counts, got %s", auth)
+	}
+}
+
+func TestDigestQuoting(t *testing.T) {
+	t.Parallel()
+
+	d := httpauth.NewDigest(map[string]string{"realm": `a "b" \ café`, "nonce": "n", "opaque": `o"`})
+
+	auth := d.Authorization(`us"er`, "pass", "GET", "/", nil)
+	if !strings.HasPrefix(auth, `Digest username="us\"er", realm="a \"b\" \\ café", `) ||
+		!strings.HasSuffix(auth, `, opaque="o\""`) {
+		t.Errorf("expected quoted-string parameters, got %s", auth)
+	}
+
+	if auth := d.Authorization("Jäsøn Doe", "pass", "GET", "/", nil); !strings.HasPrefix(auth,
+		`Digest username*=UTF-8''J%C3%A4s%C3%B8n%20Doe, `) {
+		t.Errorf("expected an ext-value username, got %s", auth)
+	}
+
+	hashed := httpauth.NewDigest(map[string]string{"realm": "api", "nonce": "n", "userhash": "true"})
+	if auth := hashed.Authorization("Jäsøn", "pass", "GET", "/", nil); !strings.HasPrefix(auth, `Digest username="`) {
+		t.Errorf("expected a quoted hashed username, got %s", auth)
+	}
+}
@@ -0,0 +1,129 @@
+// Package md4 implements the MD4 hash algorithm (RFC 1320), needed by NTLM. MD4 is
+// cryptographically broken and must not be used for anything else.
+package md4
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Size is the size of an MD4 checksum in bytes.
+const Size = 16
+
+// BlockSize is the block size of MD4 in bytes.
+const BlockSize = 64
+
+type digest struct {
+	s   [4]uint32
+	x   [BlockSize]byte
+	nx  int
+	len uint64
+}
+
+// New returns a new hash.Hash computing the MD4 checksum.
+func New() hash.Hash {
+	d := new(digest)
+	d.Reset()
+
+	return d
+}
+
+func (d *digest) Reset() {
+	d.s = [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	d.nx = 0
+	d.len = 0
+}
+
+func (d *digest) Size() int { return Size }
+
+func (d *digest) BlockSize() int { return BlockSize }
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+
+	if d.nx > 0 {
+		copied := copy(d.x[d.nx:], p)
+		d.nx += copied
+		p = p[copied:]
+
+		if d.nx < BlockSize {
+			return n, nil
+		}
+
+		d.block(d.x[:])
+		d.nx = 0
+	}
+
+	for len(p) >= BlockSize {
+		d.block(p[:BlockSize])
+		p = p[BlockSize:]
+	}
+
+	d.nx = copy(d.x[:], p)
+
+	return n, nil
+}
+
+func (d *digest) Sum(in []byte) []byte {
+	// Padding is computed on a copy so that the caller can keep writing.
+	c := *d
+
+	var tail [BlockSize + 8]byte
+	tail[0] = 0x80
+
+	pad := 56 - int(c.len%BlockSize)
+	if pad <= 0 {
+		pad += BlockSize
+	}
+
+	binary.LittleEndian.PutUint64(tail[pad:], c.len<<3)
+	c.Write(tail[:pad+8])
+
+	var sum [Size]byte
+	for i, s := range c.s {
+		binary.LittleEndian.PutUint32(sum[4*i:], s)
+	}
+
+	return append(in, sum[:]...)
+}
+
+// Shifts and word orders of the rounds (RFC 1320 section 3.4).
+var (
+	shifts1 = [4]int{3, 7, 11, 19}
+	shifts2 = [4]int{3, 5, 9, 13}
+	shifts3 = [4]int{3, 9, 11, 15}
+
+	order3 = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+)
+
+// block processes a 64-byte block.
+func (d *digest) block(p []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(p[4*i:])
+	}
+
+	a, b, c, e := d.s[0], d.s[1], d.s[2], d.s[3]
+
+	for i := range 16 {
+		f := b&c | ^b&e
+		a, b, c, e = e, bits.RotateLeft32(a+f+x[i], shifts1[i%4]), b, c
+	}
+
+	for i := range 16 {
+		g := b&c | b&e | c&e
+		a, b, c, e = e, bits.RotateLeft32(a+g+x[(i%4)*4+i/4]+0x5a827999, shifts2[i%4]), b, c
+	}
+
+	for i := range 16 {
+		h := b ^ c ^ e
+		a, b, c, e = e, bits.RotateLeft32(a+h+x[order3[i]]+0x6ed9eba1, shifts3[i%4]), b, c
+	}
+
+	d.s[0] += a
+	d.s[1] += b
+	d.s[2] += c
+	d.s[3] += e
+}
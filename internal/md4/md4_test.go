@@ -0,0 +1,36 @@
+package md4_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/enetx/surf/internal/md4"
+)
+
+func TestMD4(t *testing.T) {
+	t.Parallel()
+
+	// Test suite of RFC 1320 appendix A.5.
+	testCases := []struct{ input, sum string }{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"a", "bde52cb31de33e46245e05fbdbd6fb24"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"abcdefghijklmnopqrstuvwxyz", "d79e1c308aa5bbcdeea8ed63df412da9"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "043f8582f241db351ce627e153e7f0e4"},
+		{strings.Repeat("1234567890", 8), "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+
+	for _, tc := range testCases {
+		h := md4.New()
+
+		// Written in two parts to cover buffered writes.
+		h.Write([]byte(tc.input[:len(tc.input)/3]))
+		h.Write([]byte(tc.input[len(tc.input)/3:]))
+
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != tc.sum {
+			t.Errorf("md4(%q) = %s, expected %s", tc.input, sum, tc.sum)
+		}
+	}
+}
@@ -16,6 +16,10 @@ import (
 // or other applications. This struct allows configuring various TLS ClientHello specifications
 // to mimic different browsers and applications for advanced HTTP client behavior.
 //
+// JA applies to TLS over TCP only. HTTP/3 connections send the ClientHello and QUIC transport
+// parameters of quic-go, which has no hook to replace them, so JA4 over QUIC does not match the
+// impersonated browser.
+//
 // Reference: https://lwthiker.com/networks/2022/06/17/tls-fingerprinting.html
 type JA struct {
 	spec    utls.ClientHelloSpec // Custom TLS ClientHello specification
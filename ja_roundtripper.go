@@ -3,9 +3,12 @@ package surf
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/enetx/http"
@@ -20,13 +23,16 @@ type roundtripper struct {
 	http1tr            *http.Transport
 	http1trFallback    *http.Transport
 	http2tr            *http2.Transport
+	http2Origins       originSet // authorities served over HTTP/2, used for WebSocket handshakes
 	clientSessionCache utls.ClientSessionCache
+	ech                echCache // ECH configurations of hosts
 	ja                 *JA
+	proxied            bool // connections go through a proxy or a proxy chain
 }
 
 // newRoundTripper creates a new roundtripper wrapping the given base transport
 // and using JA configuration.
-func newRoundTripper(ja *JA, base http.RoundTripper) http.RoundTripper {
+func newRoundTripper(ja *JA, base http.RoundTripper) *roundtripper {
 	http1tr, ok := base.(*http.Transport)
 	if !ok {
 		panic("surf: underlying transport must be *http.Transport")
@@ -35,6 +41,7 @@ func newRoundTripper(ja *JA, base http.RoundTripper) http.RoundTripper {
 	rt := &roundtripper{
 		http1tr: http1tr,
 		ja:      ja,
+		proxied: !ja.builder.proxy.IsEmpty() || ja.builder.proxyChain != nil,
 	}
 
 	if ja.builder.cli.tlsConfig.ClientSessionCache != nil {
@@ -76,13 +83,19 @@ func (rt *roundtripper) handleHTTPSRequest(req *http.Request) (*http.Response, e
 		return rt.http1tr.RoundTrip(req)
 	}
 
+	if isUpgradeRequest(req) {
+		return rt.handleUpgradeRequest(req)
+	}
+
 	// Try HTTP/2 first
 	resp, err := rt.http2tr.RoundTrip(req)
 	if err == nil {
+		rt.http2Origins.add(authority(req.URL))
 		return resp, nil
 	}
 
 	h2Err := err
+	rt.http2Origins.remove(authority(req.URL))
 
 	// HTTP/2 failed - fallback to HTTP/1.1
 	if err := req.Context().Err(); err != nil {
@@ -111,6 +124,94 @@ func (rt *roundtripper) handleHTTPSRequest(req *http.Request) (*http.Response, e
 	return resp, nil
 }
 
+// handleUpgradeRequest sends a WebSocket handshake the way browsers do: over HTTP/2 with
+// extended CONNECT (RFC 8441) when the origin is already served over HTTP/2 and the server
+// has enabled it, otherwise as an HTTP/1.1 Upgrade on a connection that offers only
+// http/1.1 in ALPN.
+func (rt *roundtripper) handleUpgradeRequest(req *http.Request) (*http.Response, error) {
+	if !rt.http2Origins.contains(authority(req.URL)) {
+		return rt.http1trFallback.RoundTrip(req)
+	}
+
+	creq, pw := extendedConnectRequest(req, "websocket")
+	creq.Header[":protocol"] = []string{"websocket"}
+
+	resp, err := rt.http2tr.RoundTrip(creq)
+	if err == nil {
+		return extendedConnectResponse(resp, pw), nil
+	}
+
+	pw.Close()
+
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	return rt.http1trFallback.RoundTrip(req)
+}
+
+// authority returns the host:port pair identifying the connection for u.
+func authority(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// originSet is a set of authorities holding at most _http2MaxOrigins, arbitrary members being
+// dropped beyond it.
+type originSet struct {
+	mu      sync.Mutex
+	origins map[string]struct{}
+}
+
+// add adds origin to the set.
+func (s *originSet) add(origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.origins == nil {
+		s.origins = make(map[string]struct{})
+	}
+
+	if _, ok := s.origins[origin]; !ok {
+		for member := range s.origins {
+			if len(s.origins) < _http2MaxOrigins {
+				break
+			}
+
+			delete(s.origins, member)
+		}
+	}
+
+	s.origins[origin] = struct{}{}
+}
+
+// remove removes origin from the set.
+func (s *originSet) remove(origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.origins, origin)
+}
+
+// contains reports whether origin is in the set.
+func (s *originSet) contains(origin string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.origins[origin]
+
+	return ok
+}
+
+// isUpgradeRequest reports whether req is a WebSocket opening handshake.
+func isUpgradeRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
 // CloseIdleConnections closes all idle connections.
 func (rt *roundtripper) CloseIdleConnections() {
 	if rt.http1tr != nil {
@@ -207,14 +308,37 @@ func (rt *roundtripper) tlsHandshake(ctx context.Context, network, addr string,
 		defer cancel()
 	}
 
-	rawConn, err := rt.http1tr.DialContext(ctx, network, addr)
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return nil, err
+		host, port = addr, "443"
+	}
+
+	echList := rt.echConfigList(ctx, host, port)
+
+	uconn, err := rt.handshake(ctx, network, addr, host, echList, forceHTTP1)
+
+	// The server rejected ECH: retry once with the configurations it sent back or,
+	// without any, with GREASE ECH as browsers do.
+	var rejection *utls.ECHRejectionError
+	if echList != nil && errors.As(err, &rejection) {
+		rt.ech.set(host, rejection.RetryConfigList, _echRetryTTL)
+		uconn, err = rt.handshake(ctx, network, addr, host, rejection.RetryConfigList, forceHTTP1)
 	}
 
-	host, _, err := net.SplitHostPort(addr)
+	return uconn, err
+}
+
+// handshake dials addr and performs the uTLS handshake, offering ECH with echList
+// when the spec has an encrypted_client_hello extension.
+func (rt *roundtripper) handshake(
+	ctx context.Context,
+	network, addr, host string,
+	echList []byte,
+	forceHTTP1 bool,
+) (*utls.UConn, error) {
+	rawConn, err := rt.http1tr.DialContext(ctx, network, addr)
 	if err != nil {
-		host = addr
+		return nil, err
 	}
 
 	specr := rt.ja.getSpec()
@@ -238,6 +362,12 @@ func (rt *roundtripper) tlsHandshake(ctx context.Context, network, addr string,
 		KeyLogWriter:           rt.ja.builder.cli.tlsConfig.KeyLogWriter,
 	}
 
+	if len(echList) != 0 && hasECHExtension(spec) {
+		config.EncryptedClientHelloConfigList = echList
+		// Certificates are not verified, including the one of the public name on rejection.
+		config.EncryptedClientHelloRejectionVerify = func(utls.ConnectionState) error { return nil }
+	}
+
 	if supportsResumption(spec) && rt.clientSessionCache != nil {
 		config.ClientSessionCache = rt.clientSessionCache
 		config.PreferSkipResumptionOnNilExtension = true
@@ -252,7 +382,7 @@ func (rt *roundtripper) tlsHandshake(ctx context.Context, network, addr string,
 
 	if err = uconn.HandshakeContext(ctx); err != nil {
 		uconn.Close()
-		return nil, fmt.Errorf("uTLS.HandshakeContext() error: %+v", err)
+		return nil, fmt.Errorf("uTLS.HandshakeContext() error: %w", err)
 	}
 
 	return uconn, nil
@@ -0,0 +1,418 @@
+package surf
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enetx/http"
+	"github.com/enetx/http2"
+	"github.com/enetx/http3"
+	"github.com/enetx/surf/header"
+	"github.com/enetx/surf/pkg/quicconn"
+	"github.com/quic-go/quic-go"
+)
+
+// masqueDialer opens CONNECT-UDP tunnels (RFC 9298) through an HTTPS proxy, carrying
+// QUIC end-to-end when HTTP/3 is used with an HTTP proxy.
+//
+// The proxy is reached over HTTP/3, with UDP payloads sent as HTTP datagrams when the
+// proxy supports them, or over HTTP/2 extended CONNECT (RFC 8441) with DATAGRAM capsules
+// on the stream. Both are attempted on first use and the protocol that succeeds is kept
+// until it fails. When neither works, tunnels are not attempted again for a while so that
+// requests fall back to TCP without delay.
+type masqueDialer struct {
+	proxy     *url.URL
+	header    http.Header
+	tlsConfig *tls.Config
+	resolve   func(ctx context.Context, addr string) (string, error)
+	h2tr      *http2.Transport
+	h3tr      *http3.Transport
+
+	mu          sync.Mutex
+	proto       string    // "h3" or "h2" once a tunnel has been established
+	failedUntil time.Time // tunnels are not attempted before this time
+
+	h3mu   sync.Mutex
+	h3quic *quic.Conn
+	h3conn *http3.ClientConn
+}
+
+// newMASQUEDialer creates a CONNECT-UDP dialer for the given https proxy URL.
+func newMASQUEDialer(
+	proxy string,
+	tlsConfig *tls.Config,
+	dialer *net.Dialer,
+	resolve func(ctx context.Context, addr string) (string, error),
+) (*masqueDialer, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	m := &masqueDialer{
+		proxy:   u,
+		header:  make(http.Header),
+		resolve: resolve,
+	}
+
+	if u.User != nil && u.User.Username() != "" {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		m.header.Set(header.PROXY_AUTHORIZATION, "Basic "+auth)
+	}
+
+	m.tlsConfig = new(tls.Config)
+	if tlsConfig != nil {
+		m.tlsConfig = tlsConfig.Clone()
+	}
+
+	m.tlsConfig.ServerName = u.Hostname()
+
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+
+	m.h2tr = &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			cfg := m.tlsConfig.Clone()
+			cfg.NextProtos = []string{"h2"}
+
+			return (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, network, addr)
+		},
+	}
+
+	m.h3tr = &http3.Transport{
+		EnableDatagrams:        true,
+		MaxResponseHeaderBytes: _maxResponseHeaderBytes,
+	}
+
+	return m, nil
+}
+
+// listenPacket opens a tunnel to target and returns it as a packet connection for QUIC.
+func (m *masqueDialer) listenPacket(ctx context.Context, target *net.UDPAddr) (net.PacketConn, error) {
+	m.mu.Lock()
+	proto, failed := m.proto, time.Now().Before(m.failedUntil)
+	m.mu.Unlock()
+
+	if failed {
+		return nil, &ErrConnectUDP{"proxy does not support CONNECT-UDP"}
+	}
+
+	if proto != "" {
+		pconn, err := m.open(ctx, proto, target)
+		if err != nil {
+			m.mu.Lock()
+			m.proto = ""
+			m.mu.Unlock()
+
+			return nil, &ErrConnectUDP{err.Error()}
+		}
+
+		return pconn, nil
+	}
+
+	type result struct {
+		pconn net.PacketConn
+		proto string
+		err   error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, 2)
+
+	for _, proto := range []string{"h3", "h2"} {
+		go func() {
+			pconn, err := m.open(ctx, proto, target)
+			results <- result{pconn, proto, err}
+		}()
+	}
+
+	var errs []error
+
+	for pending := 2; pending > 0; pending-- {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.proto, r.err))
+			continue
+		}
+
+		m.mu.Lock()
+		m.proto = r.proto
+		m.mu.Unlock()
+
+		// The losing attempt is aborted by cancel, close its tunnel if it still succeeds.
+		if pending == 2 {
+			go func() {
+				if r := <-results; r.err == nil {
+					r.pconn.Close()
+				}
+			}()
+		}
+
+		return r.pconn, nil
+	}
+
+	if ctx.Err() == nil {
+		m.mu.Lock()
+		m.failedUntil = time.Now().Add(_masqueRetryBackoff)
+		m.mu.Unlock()
+	}
+
+	return nil, &ErrConnectUDP{errors.Join(errs...).Error()}
+}
+
+// open establishes a tunnel to target with the given protocol.
+func (m *masqueDialer) open(ctx context.Context, proto string, target *net.UDPAddr) (net.PacketConn, error) {
+	req, err := m.request(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+
+	if proto == "h3" {
+		conn, err = m.openHTTP3(ctx, req)
+	} else {
+		conn, err = m.openHTTP2(ctx, req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := conn.(*quicconn.DatagramConn); ok {
+		return quicconn.New(conn, target, quicconn.EncapRaw), nil
+	}
+
+	return quicconn.New(conn, target, quicconn.EncapConnectUDP), nil
+}
+
+// request builds the CONNECT-UDP request for target using the default URI template
+// https://proxy/.well-known/masque/udp/{target_host}/{target_port}/.
+func (m *masqueDialer) request(ctx context.Context, target *net.UDPAddr) (*http.Request, error) {
+	host := strings.ReplaceAll(target.IP.String(), ":", "%3A")
+
+	u, err := url.Parse(fmt.Sprintf("https://%s/.well-known/masque/udp/%s/%d/", m.proxy.Host, host, target.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Proto = "connect-udp"
+	req.Header = m.header.Clone()
+	req.Header.Set(header.CAPSULE_PROTOCOL, "?1")
+
+	return req, nil
+}
+
+// openHTTP3 sends the request on the HTTP/3 connection to the proxy.
+func (m *masqueDialer) openHTTP3(ctx context.Context, req *http.Request) (net.Conn, error) {
+	qconn, cc, err := m.http3Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	str, err := cc.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake is bound to ctx, the tunnel outlives it.
+	stop := context.AfterFunc(ctx, func() {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+		str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+	})
+	defer stop()
+
+	if err := str.SendRequestHeader(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := str.ReadResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+		str.Close()
+
+		return nil, fmt.Errorf("proxy response status: %s", resp.Status)
+	}
+
+	if !stop() {
+		return nil, ctx.Err()
+	}
+
+	select {
+	case <-cc.ReceivedSettings():
+	case <-ctx.Done():
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+		str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+
+		return nil, ctx.Err()
+	}
+
+	local, remote := qconn.LocalAddr(), qconn.RemoteAddr()
+
+	if cc.Settings().EnableDatagrams {
+		return quicconn.NewDatagram(str, local, remote, func() error {
+			str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+			return str.Close()
+		}), nil
+	}
+
+	return &tunnelConn{
+		Reader: str,
+		Writer: str,
+		close: func() error {
+			str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+			return str.Close()
+		},
+		local:  local,
+		remote: remote,
+	}, nil
+}
+
+// http3Conn returns the HTTP/3 connection to the proxy, dialing it when needed.
+func (m *masqueDialer) http3Conn(ctx context.Context) (*quic.Conn, *http3.ClientConn, error) {
+	m.h3mu.Lock()
+	defer m.h3mu.Unlock()
+
+	if m.h3conn != nil && m.h3quic.Context().Err() == nil {
+		return m.h3quic, m.h3conn, nil
+	}
+
+	addr, err := m.resolve(ctx, m.proxy.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := m.tlsConfig.Clone()
+	cfg.NextProtos = []string{http3.NextProtoH3}
+
+	qconn, err := quic.DialAddr(ctx, addr, cfg, &quic.Config{
+		Versions:             []quic.Version{quic.Version1},
+		EnableDatagrams:      true,
+		InitialPacketSize:    _masqueInitialPacketSize,
+		HandshakeIdleTimeout: _quicHandshakeTimeout,
+		MaxIdleTimeout:       _quicMaxIdleTimeout,
+		KeepAlivePeriod:      _quicKeepAlivePeriod,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.h3quic = qconn
+	m.h3conn = m.h3tr.NewClientConn(qconn)
+
+	return m.h3quic, m.h3conn, nil
+}
+
+// openHTTP2 sends the request as an extended CONNECT over HTTP/2 to the proxy.
+func (m *masqueDialer) openHTTP2(ctx context.Context, req *http.Request) (net.Conn, error) {
+	// The stream lives as long as the request context, so it is detached from ctx
+	// once the proxy has accepted the tunnel.
+	tctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	pr, pw := io.Pipe()
+
+	req = req.WithContext(tctx)
+	req.Body = pr
+	req.ContentLength = -1
+	req.Header[":protocol"] = []string{req.Proto}
+
+	resp, err := m.h2tr.RoundTrip(req)
+	if err != nil {
+		pw.Close()
+		cancel()
+
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		pw.Close()
+		resp.Body.Close()
+		cancel()
+
+		return nil, fmt.Errorf("proxy response status: %s", resp.Status)
+	}
+
+	if !stop() {
+		pw.Close()
+		resp.Body.Close()
+
+		return nil, ctx.Err()
+	}
+
+	return &tunnelConn{
+		Reader: resp.Body,
+		Writer: pw,
+		close: func() error {
+			pw.Close()
+			cancel()
+
+			return resp.Body.Close()
+		},
+		local:  &net.UDPAddr{},
+		remote: &net.UDPAddr{},
+	}, nil
+}
+
+// Close closes the connections to the proxy.
+func (m *masqueDialer) Close() error {
+	m.h3mu.Lock()
+	defer m.h3mu.Unlock()
+
+	if m.h3quic != nil {
+		m.h3quic.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+		m.h3quic, m.h3conn = nil, nil
+	}
+
+	m.h2tr.CloseIdleConnections()
+
+	return nil
+}
+
+// tunnelConn is a CONNECT-UDP stream exposed as a net.Conn.
+type tunnelConn struct {
+	io.Reader
+	io.Writer
+	close         func() error
+	local, remote net.Addr
+	once          sync.Once
+	err           error
+}
+
+func (c *tunnelConn) Close() error {
+	c.once.Do(func() { c.err = c.close() })
+	return c.err
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr              { return c.local }
+func (c *tunnelConn) RemoteAddr() net.Addr             { return c.remote }
+func (c *tunnelConn) SetDeadline(time.Time) error      { return nil }
+func (c *tunnelConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *tunnelConn) SetWriteDeadline(time.Time) error { return nil }
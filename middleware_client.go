@@ -14,7 +14,6 @@ import (
 	"github.com/enetx/http"
 	"github.com/enetx/http/cookiejar"
 	"github.com/enetx/http2"
-	"github.com/enetx/surf/pkg/connectproxy"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -36,7 +35,7 @@ func defaultTLSConfigMW(client *Client) error {
 // Configures connection pooling, timeouts, and enables HTTP/2 support by default.
 func defaultTransportMW(client *Client) error {
 	transport := &http.Transport{
-		DialContext:           client.dialer.DialContext,
+		DialContext:           client.dialContext,
 		DisableCompression:    true,
 		ExpectContinueTimeout: 1 * time.Second,
 		ForceAttemptHTTP2:     true,
@@ -96,6 +95,13 @@ func sessionMW(client *Client) error {
 	return nil
 }
 
+// cookieJarMW sets jar as the cookie jar of the client and enables TLS session resumption.
+func cookieJarMW(client *Client, jar *CookieJar) error {
+	client.GetClient().Jar = jar
+	client.GetTLSConfig().ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	return nil
+}
+
 // disableKeepAliveMW disables the keep-alive setting for the client's transport.
 func disableKeepAliveMW(client *Client) error {
 	client.GetTransport().(*http.Transport).DisableKeepAlives = true
@@ -238,22 +244,44 @@ func dnsMW(client *Client, dns g.String) error {
 		return fmt.Errorf("invalid DNS address %q: port out of range", dns)
 	}
 
-	client.GetDialer().Resolver = &net.Resolver{
+	return dnsResolverMW(client, &net.Resolver{
 		PreferGo: true,
-		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
 			var dialer net.Dialer
-			return dialer.DialContext(ctx, "udp", dns.Std())
+			return dialer.DialContext(ctx, network, dns.Std())
 		},
+	})
+}
+
+// dnsResolverMW configures a custom DNS server, DNS over TLS (DoT) or DNS over HTTPS (DoH)
+// for the client. Replaces the default DNS resolver, or becomes an upstream of the caching
+// resolver when one is configured.
+func dnsResolverMW(client *Client, resolver *net.Resolver) error {
+	if client.resolver != nil {
+		client.resolver.addUpstream(resolver.Dial)
+		return nil
 	}
 
+	client.GetDialer().Resolver = resolver
+
 	return nil
 }
 
-// dnsTLSMW configures DNS over TLS (DoT) for the client.
-// Replaces the default DNS resolver with a secure DNS-over-TLS resolver
-// to encrypt DNS queries and protect against DNS manipulation.
-func dnsTLSMW(client *Client, resolver *net.Resolver) error {
-	client.GetDialer().Resolver = resolver
+// resolverMW installs the caching resolver in the client dialer.
+// A resolver already set on the dialer becomes one of its upstreams.
+func resolverMW(client *Client, rs *ResolverSettings) error {
+	resolver, err := newResolver(rs)
+	if err != nil {
+		return err
+	}
+
+	if current := client.GetDialer().Resolver; current != nil && current.Dial != nil {
+		resolver.addUpstream(current.Dial)
+	}
+
+	client.GetDialer().Resolver = resolver.netResolver()
+	client.resolver = resolver
+
 	return nil
 }
 
@@ -289,26 +317,18 @@ func proxyMW(client *Client, proxy g.String) error {
 		return errors.New("transport is not *http.Transport")
 	}
 
-	if proxy.IsEmpty() {
-		transport.Proxy = nil
-		return nil
-	}
+	return setProxy(client, transport, proxy)
+}
 
-	dialer, err := connectproxy.NewDialer(proxy.Std())
-	if err != nil {
-		return fmt.Errorf("create proxy dialer: %w", err)
-	}
+// setProxy routes the connections of transport through proxy, or directly when proxy is empty.
+func setProxy(client *Client, transport *http.Transport, proxy g.String) error {
+	transport.Proxy = nil
 
-	// Pass custom DNS resolver to proxy dialer if configured.
-	// This ensures DNS queries go through the custom DNS server, not through the proxy.
-	// Target hostnames are pre-resolved locally before being sent to the proxy.
-	if client.dialer != nil && client.dialer.Resolver != nil {
-		dialer.SetResolver(client.dialer.Resolver)
+	if proxy.IsEmpty() {
+		return nil
 	}
 
-	transport.DialContext = dialer.DialContext
-
-	return nil
+	return setProxyChain(client, transport, &proxyChain{proxies: g.SliceOf(proxy), timeouts: make([]time.Duration, 1)})
 }
 
 // h2cMW configures HTTP/2 Cleartext (H2C) support for the client.
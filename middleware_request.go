@@ -1,6 +1,7 @@
 package surf
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -9,6 +10,8 @@ import (
 	"github.com/enetx/g"
 	"github.com/enetx/http/httptrace"
 	"github.com/enetx/surf/header"
+
+	utls "github.com/enetx/utls"
 )
 
 // defaultUserAgentMW sets the default User-Agent header for surf requests.
@@ -97,6 +100,25 @@ func remoteAddrMW(req *Request) error {
 	return nil
 }
 
+// echAcceptedMW configures request tracing to capture whether the server accepted
+// Encrypted Client Hello on the connection used for the request.
+func echAcceptedMW(req *Request) error {
+	req.WithContext(httptrace.WithClientTrace(req.GetRequest().Context(),
+		&httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				switch conn := info.Conn.(type) {
+				case interface{ ConnectionState() utls.ConnectionState }:
+					req.echAccepted = conn.ConnectionState().ECHAccepted
+				case interface{ ConnectionState() tls.ConnectionState }:
+					req.echAccepted = conn.ConnectionState().ECHAccepted
+				}
+			},
+		},
+	))
+
+	return nil
+}
+
 // bearerAuthMW configures Bearer token authentication for HTTP requests.
 // Adds an Authorization header with the Bearer token format if a token is provided.
 // Only sets the header if the token is not empty, allowing conditional authentication.
@@ -0,0 +1,376 @@
+package surf
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+	"github.com/enetx/surf/internal/httpauth"
+)
+
+// OAuth2Settings provides a fluent interface for authorizing the requests of the client with
+// OAuth 2.0 access tokens (RFC 6749) obtained from a token endpoint, cached until shortly before
+// they expire and refreshed automatically.
+type OAuth2Settings struct {
+	builder      *Builder
+	tokenURL     g.String
+	grant        string
+	clientID     g.String
+	clientSecret g.String
+	username     g.String
+	password     g.String
+	refreshToken g.String
+	scopes       g.Slice[g.String]
+	params       g.MapOrd[g.String, g.String]
+	authInBody   bool
+	skew         time.Duration
+}
+
+// TokenURL sets the URL of the token endpoint.
+func (oas *OAuth2Settings) TokenURL(tokenURL g.String) *OAuth2Settings {
+	oas.tokenURL = tokenURL
+	return oas
+}
+
+// ClientCredentials obtains tokens with the client credentials grant for the client id and secret.
+func (oas *OAuth2Settings) ClientCredentials(id, secret g.String) *OAuth2Settings {
+	oas.grant = "client_credentials"
+	return oas.Client(id, secret)
+}
+
+// Password obtains tokens with the resource owner password credentials grant, then refreshes
+// them with the refresh token of the response when the server issues one.
+func (oas *OAuth2Settings) Password(username, password g.String) *OAuth2Settings {
+	oas.grant = "password"
+	oas.username, oas.password = username, password
+
+	return oas
+}
+
+// RefreshToken obtains tokens with the refresh token grant. Refresh tokens rotated by the server
+// replace token for the next refreshes.
+func (oas *OAuth2Settings) RefreshToken(token g.String) *OAuth2Settings {
+	oas.grant = "refresh_token"
+	oas.refreshToken = token
+
+	return oas
+}
+
+// Client sets the credentials the client authenticates to the token endpoint with, sent with HTTP
+// Basic authentication unless AuthInBody is set. A public client has an empty secret.
+func (oas *OAuth2Settings) Client(id, secret g.String) *OAuth2Settings {
+	oas.clientID, oas.clientSecret = id, secret
+	return oas
+}
+
+// AuthInBody sends the client credentials as client_id and client_secret parameters of the token
+// requests instead of HTTP Basic authentication.
+func (oas *OAuth2Settings) AuthInBody() *OAuth2Settings {
+	oas.authInBody = true
+	return oas
+}
+
+// Scopes sets the scopes requested for the tokens.
+func (oas *OAuth2Settings) Scopes(scopes ...g.String) *OAuth2Settings {
+	oas.scopes = append(oas.scopes, scopes...)
+	return oas
+}
+
+// Param adds a parameter to the token requests, such as audience or resource.
+func (oas *OAuth2Settings) Param(name, value g.String) *OAuth2Settings {
+	oas.params.Insert(name, value)
+	return oas
+}
+
+// ExpirySkew sets how long before their expiry tokens are refreshed, 10 seconds by default.
+func (oas *OAuth2Settings) ExpirySkew(skew time.Duration) *OAuth2Settings {
+	oas.skew = skew
+	return oas
+}
+
+// Set applies the OAuth2 settings to the client. Token requests are sent through the client, with
+// its proxies, fingerprint and middlewares. Requests with an Authorization header are left as is.
+// A request rejected with a 401 response and a Bearer invalid_token challenge is sent once more
+// with a new token. Token endpoint errors are returned by Request.Do as *ErrOAuth2.
+func (oas *OAuth2Settings) Set() *Builder {
+	source := &oauth2Source{settings: *oas, client: oas.builder.cli, refresh: oas.refreshToken.Std()}
+	oas.builder.oauth2 = source
+
+	oas.builder.addCliMW(func(*Client) error {
+		switch {
+		case oas.tokenURL.IsEmpty():
+			return &ErrOAuth2{"token URL is not set"}
+		case oas.grant == "":
+			return &ErrOAuth2{"grant is not set"}
+		}
+
+		return nil
+	}, 0)
+
+	return oas.builder
+}
+
+// oauth2TokenRequest marks the context of token requests, which are not authorized with tokens.
+type oauth2TokenRequest struct{}
+
+// oauth2Token is an access token and its expiry, zero when the server did not set one.
+type oauth2Token struct {
+	access string
+	expiry time.Time
+}
+
+// oauth2Fetch is a token request shared by the requests waiting for it.
+type oauth2Fetch struct {
+	done  chan g.Unit
+	token *oauth2Token
+	err   error
+}
+
+// oauth2Source caches the token of a client and refreshes it with a single token request at a time.
+type oauth2Source struct {
+	settings OAuth2Settings
+	client   *Client
+	mu       sync.Mutex
+	token    *oauth2Token
+	refresh  string // Refresh token of the next refresh, if any
+	fetch    *oauth2Fetch
+}
+
+// authorize sets the Authorization header of req with the cached or a new access token. It runs
+// after the request middlewares, outside of them, as the token request is sent through the client.
+func (s *oauth2Source) authorize(req *Request) error {
+	ctx := req.request.Context()
+
+	if ctx.Value(oauth2TokenRequest{}) != nil || req.request.Header.Get(header.AUTHORIZATION) != "" {
+		return nil
+	}
+
+	token, err := s.get(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	req.bearer = token.access
+	req.request.Header.Set(header.AUTHORIZATION, "Bearer "+token.access)
+
+	return nil
+}
+
+// reauthorize sets a new access token on req when resp rejects the token authorize set on req as
+// invalid, and reports whether it did.
+func (s *oauth2Source) reauthorize(req *Request, resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusUnauthorized || req.bearer == "" ||
+		req.request.Header.Get(header.AUTHORIZATION) != "Bearer "+req.bearer {
+		return false, nil
+	}
+
+	invalid := false
+
+	for _, c := range httpauth.ParseChallenges(resp.Header.Values(header.WWW_AUTHENTICATE)) {
+		if strings.EqualFold(c.Scheme, "Bearer") && c.Params["error"] == "invalid_token" {
+			invalid = true
+		}
+	}
+
+	if !invalid {
+		return false, nil
+	}
+
+	token, err := s.get(req.request.Context(), req.bearer)
+	if err != nil {
+		return false, err
+	}
+
+	req.bearer = token.access
+	req.request.Header.Set(header.AUTHORIZATION, "Bearer "+token.access)
+
+	return true, nil
+}
+
+// get returns the cached token unless it expires within the skew or is the rejected token,
+// otherwise waits for a new token, requesting it unless another request already does.
+func (s *oauth2Source) get(ctx context.Context, rejected string) (*oauth2Token, error) {
+	s.mu.Lock()
+
+	if t := s.token; t != nil && t.access != rejected &&
+		(t.expiry.IsZero() || time.Now().Add(s.settings.skew).Before(t.expiry)) {
+		s.mu.Unlock()
+		return t, nil
+	}
+
+	fetch := s.fetch
+	if fetch == nil {
+		fetch = &oauth2Fetch{done: make(chan g.Unit)}
+		s.fetch = fetch
+
+		// The token request outlives the request waiting for it, other requests may wait as well.
+		go s.request(context.WithoutCancel(ctx), fetch)
+	}
+
+	s.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// request obtains a token from the token endpoint for fetch.
+func (s *oauth2Source) request(ctx context.Context, fetch *oauth2Fetch) {
+	token, refresh, err := s.exchange(ctx)
+
+	s.mu.Lock()
+
+	if err == nil {
+		s.token = token
+
+		if refresh != "" {
+			s.refresh = refresh
+		}
+	}
+
+	s.fetch = nil
+	s.mu.Unlock()
+
+	fetch.token, fetch.err = token, err
+	close(fetch.done)
+}
+
+// exchange obtains a token with the refresh token if any, otherwise with the grant of the
+// settings, returning the token and the refresh token of the response.
+func (s *oauth2Source) exchange(ctx context.Context) (*oauth2Token, string, error) {
+	s.mu.Lock()
+	refresh := s.refresh
+	s.mu.Unlock()
+
+	token, rotated, err := s.tokenRequest(ctx, refresh)
+	if err != nil && refresh != "" && s.settings.grant == "password" {
+		// The refresh token expired or was revoked, the password grant issues a new one.
+		return s.tokenRequest(ctx, "")
+	}
+
+	return token, rotated, err
+}
+
+// tokenRequest sends a token request with the refresh token grant, or the grant of the settings
+// when refresh is empty.
+func (s *oauth2Source) tokenRequest(ctx context.Context, refresh string) (*oauth2Token, string, error) {
+	oas := &s.settings
+
+	form := g.NewMapOrd[string, string]()
+
+	if refresh != "" && oas.grant != "client_credentials" {
+		form.Insert("grant_type", "refresh_token")
+		form.Insert("refresh_token", refresh)
+	} else {
+		form.Insert("grant_type", oas.grant)
+
+		if oas.grant == "password" {
+			form.Insert("username", oas.username.Std())
+			form.Insert("password", oas.password.Std())
+		}
+	}
+
+	if len(oas.scopes) != 0 {
+		form.Insert("scope", oas.scopes.Join(" ").Std())
+	}
+
+	for name, value := range oas.params.Iter() {
+		form.Insert(name.Std(), value.Std())
+	}
+
+	req := s.client.Post(oas.tokenURL).
+		WithContext(context.WithValue(ctx, oauth2TokenRequest{}, true)).
+		SetHeaders(g.Map[g.String, g.String]{header.ACCEPT: "application/json"})
+
+	if !oas.clientID.IsEmpty() {
+		if oas.authInBody {
+			form.Insert("client_id", oas.clientID.Std())
+
+			if !oas.clientSecret.IsEmpty() {
+				form.Insert("client_secret", oas.clientSecret.Std())
+			}
+		} else if req.request != nil {
+			// RFC 6749 section 2.3.1: the credentials are form-urlencoded before Basic encoding.
+			req.request.SetBasicAuth(url.QueryEscape(oas.clientID.Std()), url.QueryEscape(oas.clientSecret.Std()))
+		}
+	}
+
+	result := req.Body(form).Do()
+	if result.IsErr() {
+		return nil, "", &ErrOAuth2{result.Err().Error()}
+	}
+
+	resp := result.Ok()
+
+	content := resp.Body.Bytes()
+	if content.IsErr() {
+		return nil, "", &ErrOAuth2{content.Err().Error()}
+	}
+
+	fields := parseTokenResponse(resp.Headers.Get(header.CONTENT_TYPE).Std(), content.Ok())
+
+	if !resp.StatusCode.IsSuccess() || fields["access_token"] == "" {
+		msg := fields["error"]
+
+		switch {
+		case msg == "":
+			msg = "token endpoint responded with " + strconv.Itoa(int(resp.StatusCode))
+		case fields["error_description"] != "":
+			msg += ": " + fields["error_description"]
+		}
+
+		return nil, "", &ErrOAuth2{msg}
+	}
+
+	token := &oauth2Token{access: fields["access_token"]}
+
+	if seconds, err := strconv.ParseFloat(fields["expires_in"], 64); err == nil && seconds > 0 {
+		token.expiry = time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	}
+
+	return token, fields["refresh_token"], nil
+}
+
+// parseTokenResponse returns the parameters of a JSON token response, or of a form-encoded one
+// returned by some servers.
+func parseTokenResponse(contentType string, content g.Bytes) map[string]string {
+	fields := make(map[string]string)
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" ||
+		mediaType == "text/plain" {
+		if values, err := url.ParseQuery(string(content)); err == nil {
+			for name := range values {
+				fields[name] = values.Get(name)
+			}
+
+			return fields
+		}
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return fields
+	}
+
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			fields[name] = v
+		case float64:
+			fields[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,251 @@
+package connectproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/enetx/http"
+	"github.com/enetx/surf/internal/httpauth"
+	"github.com/enetx/surf/internal/md4"
+)
+
+// maxAuthRounds limits the CONNECT requests answering Proxy-Authenticate challenges of a tunnel:
+// the NTLM negotiate and authenticate messages, or a Digest response and a stale nonce retry.
+const maxAuthRounds = 3
+
+// proxyAuth answers the Proxy-Authenticate challenges of a 407 response to a CONNECT request
+// with Digest (RFC 7616) or NTLMv2 (MS-NLMP) credentials. A proxyAuth is used for a single tunnel:
+// NTLM authenticates the connection the handshake takes place on.
+type proxyAuth struct {
+	dialer     *proxyDialer
+	negotiated bool // NTLM negotiate message sent, the challenge is expected next
+	rounds     int
+}
+
+// authorize returns the Proxy-Authorization header answering the challenges of resp
+// to a CONNECT request for uri, or false when no supported challenge can be answered.
+func (a *proxyAuth) authorize(resp *http.Response, uri string) (string, bool) {
+	c := a.dialer
+	if c.username == "" || a.rounds >= maxAuthRounds {
+		return "", false
+	}
+
+	a.rounds++
+
+	var (
+		digest *httpauth.Digest
+		ntlm   []byte
+		offers bool
+	)
+
+	values := resp.Header.Values("Proxy-Authenticate")
+
+	// NTLM challenges are token68 credentials, parsed apart from the auth-params of the others.
+	for _, value := range values {
+		scheme, token, _ := strings.Cut(strings.TrimSpace(value), " ")
+
+		if strings.EqualFold(scheme, "NTLM") {
+			offers = true
+
+			if token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token)); err == nil && len(token) > 0 {
+				ntlm = token
+			}
+		}
+	}
+
+	for _, c := range httpauth.ParseChallenges(values) {
+		if challenge := httpauth.NewDigest(c.Params); strings.EqualFold(c.Scheme, "Digest") && challenge.Better(digest) {
+			digest = challenge
+		}
+	}
+
+	switch {
+	case a.negotiated && ntlm != nil:
+		a.negotiated = false
+
+		msg, err := ntlmAuthenticate(ntlm, c.username, c.password)
+		if err != nil {
+			return "", false
+		}
+
+		return "NTLM " + base64.StdEncoding.EncodeToString(msg), true
+	case digest != nil:
+		c.authMu.Lock()
+		c.digest = digest
+		c.authMu.Unlock()
+
+		return c.digestAuthorization(uri)
+	case offers && !a.negotiated:
+		a.negotiated = true
+		return "NTLM " + base64.StdEncoding.EncodeToString(ntlmNegotiate()), true
+	}
+
+	return "", false
+}
+
+// digestAuthorization returns the Proxy-Authorization answering the last Digest challenge
+// of the proxy for uri, or false without one.
+func (c *proxyDialer) digestAuthorization(uri string) (string, bool) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.digest == nil {
+		return "", false
+	}
+
+	return c.digest.Authorization(c.username, c.password, http.MethodConnect, uri, nil), true
+}
+
+// NTLM negotiate flags (MS-NLMP section 2.2.2.5).
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiate returns the NTLM NEGOTIATE_MESSAGE, without domain and workstation.
+func ntlmNegotiate() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmFlags)
+
+	return msg
+}
+
+// ntlmAuthenticate returns the NTLMv2 AUTHENTICATE_MESSAGE answering the CHALLENGE_MESSAGE
+// challenge. A username of the form DOMAIN\user authenticates in DOMAIN.
+func ntlmAuthenticate(challenge []byte, username, password string) ([]byte, error) {
+	if len(challenge) < 32 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge")
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+
+	var targetInfo []byte
+
+	if len(challenge) >= 48 {
+		length := int(binary.LittleEndian.Uint16(challenge[40:]))
+		offset := int(binary.LittleEndian.Uint32(challenge[44:]))
+
+		if offset+length > len(challenge) {
+			return nil, errors.New("invalid NTLM challenge target info")
+		}
+
+		targetInfo = challenge[offset : offset+length]
+	}
+
+	domain, user, ok := strings.Cut(username, `\`)
+	if !ok {
+		domain, user = "", username
+	}
+
+	// NTOWFv2 (MS-NLMP section 3.3.2).
+	md := md4.New()
+	md.Write(utf16le(password))
+
+	mac := hmac.New(md5.New, md.Sum(nil))
+	mac.Write(utf16le(strings.ToUpper(user) + domain))
+	ntowf := mac.Sum(nil)
+
+	clientChallenge := make([]byte, 8)
+	rand.Read(clientChallenge)
+
+	timestamp, hasTimestamp := ntlmTimestamp(targetInfo)
+	if !hasTimestamp {
+		// Windows FILETIME: 100-nanosecond intervals since January 1, 1601.
+		timestamp = make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().UnixNano()/100+116444736000000000))
+	}
+
+	temp := make([]byte, 0, 28+len(targetInfo)+4)
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	mac = hmac.New(md5.New, ntowf)
+	mac.Write(serverChallenge)
+	mac.Write(temp)
+	ntResponse := append(mac.Sum(nil), temp...)
+
+	// LMv2 is omitted when the server sent a timestamp (MS-NLMP section 3.1.5.1.2).
+	lmResponse := make([]byte, 24)
+	if !hasTimestamp {
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(serverChallenge)
+		mac.Write(clientChallenge)
+		lmResponse = append(mac.Sum(nil), clientChallenge...)
+	}
+
+	fields := [][]byte{lmResponse, ntResponse, utf16le(domain), utf16le(user), nil, nil}
+
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	binary.LittleEndian.PutUint32(msg[60:], flags&ntlmFlags|ntlmNegotiateUnicode)
+
+	for i, field := range fields {
+		header := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(header, uint16(len(field)))
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(msg)))
+
+		msg = append(msg, field...)
+	}
+
+	return msg, nil
+}
+
+// ntlmTimestamp returns the MsvAvTimestamp of the target info of a challenge.
+func ntlmTimestamp(targetInfo []byte) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+
+		if id == 0 || len(targetInfo) < 4+length {
+			break
+		}
+
+		if id == 7 && length == 8 {
+			return targetInfo[4:12], true
+		}
+
+		targetInfo = targetInfo[4+length:]
+	}
+
+	return nil, false
+}
+
+// utf16le encodes s in UTF-16 little-endian.
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+
+	return b
+}
@@ -0,0 +1,391 @@
+package connectproxy_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/enetx/surf/internal/md4"
+	"github.com/enetx/surf/pkg/connectproxy"
+)
+
+// authProxyServer starts a CONNECT proxy calling authorize with every request read from a
+// connection and the number of requests read from it before. A nil response tunnels to the
+// target, otherwise the response is written and the next request is read from the connection
+// unless it closes it. Returns the proxy address and the number of CONNECT requests.
+func authProxyServer(t *testing.T, authorize func(req *http.Request, round int) *http.Response) (string, *atomic.Int32) {
+	t.Helper()
+
+	listener := listen(t)
+
+	var requests atomic.Int32
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				br := bufio.NewReader(conn)
+
+				for round := 0; ; round++ {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+
+					requests.Add(1)
+
+					if resp := authorize(req, round); resp != nil {
+						resp.Write(conn)
+
+						if resp.Close {
+							return
+						}
+
+						continue
+					}
+
+					upstream, err := net.Dial("tcp", req.Host)
+					if err != nil {
+						conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+						return
+					}
+					defer upstream.Close()
+
+					conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+					go io.Copy(upstream, br)
+					io.Copy(conn, upstream)
+
+					return
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &requests
+}
+
+func challenge(header string, close bool) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusProxyAuthRequired,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Proxy-Authenticate": strings.Split(header, "\n")},
+		Body:          io.NopCloser(strings.NewReader("authentication required")),
+		ContentLength: int64(len("authentication required")),
+		Close:         close,
+	}
+}
+
+// digestParams parses the auth-params of a Digest Proxy-Authorization header.
+func digestParams(header string) map[string]string {
+	params := make(map[string]string)
+
+	for param := range strings.SplitSeq(strings.TrimPrefix(header, "Digest "), ", ") {
+		name, value, _ := strings.Cut(param, "=")
+		params[name] = strings.Trim(value, `"`)
+	}
+
+	return params
+}
+
+// digestProxy answers requests without valid SHA-256 Digest credentials for user:pass with
+// a challenge offering MD5 and SHA-256.
+func digestProxy(t *testing.T, close bool) (string, *atomic.Int32) {
+	t.Helper()
+
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	return authProxyServer(t, func(req *http.Request, _ int) *http.Response {
+		p := digestParams(req.Header.Get("Proxy-Authorization"))
+
+		ha1 := sha("user:proxy:pass")
+		ha2 := sha("CONNECT:" + p["uri"])
+		expected := sha(ha1 + ":nonce-1:" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+
+		if p["algorithm"] == "SHA-256" && p["uri"] == req.Host && p["opaque"] == "op" && p["response"] == expected {
+			return nil
+		}
+
+		return challenge("Digest realm=\"proxy\", nonce=\"nonce-1\", qop=\"auth\", algorithm=MD5, opaque=\"op\"\n"+
+			"Digest realm=\"proxy\", nonce=\"nonce-1\", qop=\"auth,auth-int\", algorithm=SHA-256, opaque=\"op\"", close)
+	})
+}
+
+func dialEcho(t *testing.T, dialer interface {
+	DialContext(context.Context, string, string) (net.Conn, error)
+}, target string,
+) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		return fmt.Errorf("expected the echo through the tunnel, got %q, %v", buf, err)
+	}
+
+	return nil
+}
+
+func TestDigestProxyAuth(t *testing.T) {
+	t.Parallel()
+
+	for _, close := range []bool{false, true} {
+		t.Run(fmt.Sprintf("close=%t", close), func(t *testing.T) {
+			target := echoServer(t)
+			proxy, requests := digestProxy(t, close)
+
+			dialer, err := connectproxy.NewDialer("http://user:pass@" + proxy)
+			if err != nil {
+				t.Fatalf("failed to create dialer: %v", err)
+			}
+
+			if err := dialEcho(t, dialer, target); err != nil {
+				t.Fatal(err)
+			}
+
+			if requests.Load() != 2 {
+				t.Fatalf("expected the challenge to be answered once, got %d requests", requests.Load())
+			}
+
+			// The challenge is answered preemptively by the next tunnels.
+			if err := dialEcho(t, dialer, target); err != nil {
+				t.Fatal(err)
+			}
+
+			if requests.Load() != 3 {
+				t.Fatalf("expected a preemptive Digest response, got %d requests", requests.Load())
+			}
+		})
+	}
+}
+
+// endless is a reader that never ends.
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+
+	return len(p), nil
+}
+
+func TestDigestProxyAuthLargeChallenge(t *testing.T) {
+	t.Parallel()
+
+	target := echoServer(t)
+
+	// The challenge has an endless body, the connection cannot be reused.
+	proxy, requests := authProxyServer(t, func(req *http.Request, _ int) *http.Response {
+		if strings.HasPrefix(req.Header.Get("Proxy-Authorization"), "Digest ") {
+			return nil
+		}
+
+		resp := challenge(`Digest realm="proxy", nonce="nonce-1", algorithm=MD5`, false)
+		resp.Body = io.NopCloser(endless{})
+		resp.ContentLength = -1
+		resp.TransferEncoding = []string{"chunked"}
+
+		return resp
+	})
+
+	dialer, err := connectproxy.NewDialer("http://user:pass@" + proxy)
+	if err != nil {
+		t.Fatalf("failed to create dialer: %v", err)
+	}
+
+	if err := dialEcho(t, dialer, target); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() != 2 {
+		t.Fatalf("expected the challenge to be answered on a new connection, got %d requests", requests.Load())
+	}
+}
+
+func TestDigestProxyAuthWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	proxy, requests := digestProxy(t, false)
+
+	dialer, err := connectproxy.NewDialer("http://user:wrong@" + proxy)
+	if err != nil {
+		t.Fatalf("failed to create dialer: %v", err)
+	}
+
+	err = dialEcho(t, dialer, echoServer(t))
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected a 407 status error, got %v", err)
+	}
+
+	if requests.Load() > 4 {
+		t.Fatalf("expected a bounded number of attempts, got %d requests", requests.Load())
+	}
+}
+
+// ntlmChallenge returns a CHALLENGE_MESSAGE with a timestamp in the target info.
+func ntlmChallenge(serverChallenge []byte) []byte {
+	targetInfo := []byte{7, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+
+	msg := make([]byte, 48)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], 0xe28a8215)
+	copy(msg[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+
+	return append(msg, targetInfo...)
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, r)
+	}
+
+	return b
+}
+
+// verifyNTLMv2 checks the NTLMv2 response of an AUTHENTICATE_MESSAGE for the credentials.
+func verifyNTLMv2(msg, serverChallenge []byte, domain, user, password string) error {
+	if len(msg) < 64 || string(msg[:8]) != "NTLMSSP\x00" || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		return fmt.Errorf("not an AUTHENTICATE_MESSAGE")
+	}
+
+	field := func(i int) []byte {
+		length := int(binary.LittleEndian.Uint16(msg[12+8*i:]))
+		offset := int(binary.LittleEndian.Uint32(msg[16+8*i:]))
+		return msg[offset : offset+length]
+	}
+
+	if !bytes.Equal(field(2), utf16le(domain)) || !bytes.Equal(field(3), utf16le(user)) {
+		return fmt.Errorf("unexpected domain or user")
+	}
+
+	nt := field(1)
+
+	md := md4.New()
+	md.Write(utf16le(password))
+
+	mac := hmac.New(md5.New, md.Sum(nil))
+	mac.Write(utf16le(strings.ToUpper(user) + domain))
+
+	mac = hmac.New(md5.New, mac.Sum(nil))
+	mac.Write(serverChallenge)
+	mac.Write(nt[16:])
+
+	if !hmac.Equal(mac.Sum(nil), nt[:16]) {
+		return fmt.Errorf("invalid NTProofStr")
+	}
+
+	if !bytes.Equal(nt[24:32], []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		return fmt.Errorf("expected the timestamp of the challenge")
+	}
+
+	return nil
+}
+
+func TestNTLMProxyAuth(t *testing.T) {
+	t.Parallel()
+
+	target := echoServer(t)
+	serverChallenge := []byte("8bytes!!")
+	failures := make(chan error, 1)
+
+	proxy, requests := authProxyServer(t, func(req *http.Request, round int) *http.Response {
+		auth := req.Header.Get("Proxy-Authorization")
+		token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "NTLM "))
+
+		switch {
+		case round == 0:
+			return challenge("NTLM\nBasic realm=\"proxy\"", false)
+		case round == 1 && strings.HasPrefix(auth, "NTLM ") && len(token) >= 12 && token[8] == 1:
+			return challenge("NTLM "+base64.StdEncoding.EncodeToString(ntlmChallenge(serverChallenge)), false)
+		case round == 2:
+			if err := verifyNTLMv2(token, serverChallenge, "CORP", "user", "pass"); err != nil {
+				failures <- err
+				return &http.Response{StatusCode: http.StatusForbidden, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+			}
+
+			return nil
+		}
+
+		failures <- fmt.Errorf("unexpected request %d on the connection: %q", round, auth)
+
+		return &http.Response{StatusCode: http.StatusForbidden, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+	})
+
+	dialer, err := connectproxy.NewDialer(`http://CORP%5Cuser:pass@` + proxy)
+	if err != nil {
+		t.Fatalf("failed to create dialer: %v", err)
+	}
+
+	if err := dialEcho(t, dialer, target); err != nil {
+		select {
+		case failure := <-failures:
+			t.Fatalf("%v: %v", err, failure)
+		default:
+			t.Fatal(err)
+		}
+	}
+
+	if requests.Load() != 3 {
+		t.Fatalf("expected the NTLM handshake on a single connection, got %d requests", requests.Load())
+	}
+}
+
+func TestProxyAuthWithoutCredentials(t *testing.T) {
+	t.Parallel()
+
+	proxy, requests := digestProxy(t, false)
+
+	dialer, err := connectproxy.NewDialer("http://" + proxy)
+	if err != nil {
+		t.Fatalf("failed to create dialer: %v", err)
+	}
+
+	if err := dialEcho(t, dialer, echoServer(t)); err == nil {
+		t.Fatal("expected the challenge to fail without credentials")
+	}
+
+	if requests.Load() != 1 {
+		t.Fatalf("expected no answer to the challenge without credentials, got %d requests", requests.Load())
+	}
+}
@@ -0,0 +1,120 @@
+package connectproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrProxyHop reports the hop of a proxy chain that failed. Hops are numbered from 1.
+type ErrProxyHop struct {
+	Hop   int
+	Proxy string
+	Err   error
+}
+
+func (e *ErrProxyHop) Error() string {
+	return fmt.Sprintf("proxy chain hop %d (%s): %v", e.Hop, e.Proxy, e.Err)
+}
+
+func (e *ErrProxyHop) Unwrap() error { return e.Err }
+
+// Chain tunnels connections through several proxies in turn: the connection to every hop is
+// established through the tunnel of the hop before it, and the target is dialed from the last
+// hop. Every hop has its own credentials, headers and Timeout.
+type Chain struct {
+	// Hops are the proxies of the chain, from the first one dialed to the one connecting to
+	// the target. DialTCPContext of the first hop establishes the connection to the chain,
+	// LookupNetIP of the last hop resolves the targets.
+	Hops []*proxyDialer
+}
+
+// NewChain creates a chain dialing the proxies in order. Every proxy URL accepts the
+// schemes, credentials and parameters of NewDialer.
+func NewChain(proxies ...string) (*Chain, error) {
+	if len(proxies) == 0 {
+		return nil, &ErrProxyEmpty{}
+	}
+
+	chain := &Chain{Hops: make([]*proxyDialer, 0, len(proxies))}
+
+	for i, proxy := range proxies {
+		hop, err := NewDialer(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		hop.nextHop = i < len(proxies)-1
+
+		if i > 0 {
+			hop.DialTCPContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				return chain.dialHop(ctx, i-1, network, address)
+			}
+		}
+
+		chain.Hops = append(chain.Hops, hop)
+	}
+
+	return chain, nil
+}
+
+// SetResolver sets the DNS resolver of every hop. It resolves the address of the first hop and
+// the targets of the last hop locally. The addresses of the other hops are resolved by the hop
+// before them, which may reach names unknown outside its network, unless ResolveHops was called.
+func (c *Chain) SetResolver(r *net.Resolver) {
+	for _, hop := range c.Hops {
+		hop.SetResolver(r)
+	}
+}
+
+// ResolveHops resolves the addresses of the hops after the first one locally, with the resolver
+// set with SetResolver, instead of by the hop before them.
+func (c *Chain) ResolveHops() {
+	for _, hop := range c.Hops {
+		hop.nextHop = false
+	}
+}
+
+func (c *Chain) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address through every hop of the chain. Errors are reported
+// as *ErrProxyHop with the hop that failed.
+func (c *Chain) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if len(c.Hops) == 0 {
+		return nil, &ErrProxyEmpty{}
+	}
+
+	return c.dialHop(ctx, len(c.Hops)-1, network, address)
+}
+
+// dialHop connects to address through the hop at index i, attributing errors to the hop
+// unless they were already reported by a hop before it.
+func (c *Chain) dialHop(ctx context.Context, i int, network, address string) (net.Conn, error) {
+	hop := c.Hops[i]
+
+	conn, err := hop.DialContext(ctx, network, address)
+	if err == nil {
+		return conn, nil
+	}
+
+	var hopErr *ErrProxyHop
+	if errors.As(err, &hopErr) {
+		return nil, err
+	}
+
+	return nil, &ErrProxyHop{Hop: i + 1, Proxy: hop.ProxyURL.Redacted(), Err: err}
+}
+
+// Close closes the HTTP/2 connections kept by the hops.
+func (c *Chain) Close() error {
+	var errs []error
+
+	for _, hop := range c.Hops {
+		errs = append(errs, hop.Close())
+	}
+
+	return errors.Join(errs...)
+}
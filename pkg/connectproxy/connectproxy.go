@@ -10,29 +10,50 @@ import (
 	"io"
 	"maps"
 	"net"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/enetx/http"
 	"github.com/enetx/http2"
+	"github.com/enetx/surf/internal/httpauth"
 	_ "github.com/enetx/surf/pkg/socks4"
 	"golang.org/x/net/proxy"
 )
 
 type (
-	ErrProxyURL      struct{ Msg string }
-	ErrProxyStatus   struct{ Msg string }
+	ErrProxyURL struct{ Msg string }
+
+	// ErrProxyStatus reports a CONNECT request answered with a status other than 200 OK, such as
+	// 502 Bad Gateway when the proxy cannot reach the target. Msg is the status line of the response.
+	ErrProxyStatus struct{ Msg string }
+
 	ErrPasswordEmpty struct{ Msg string }
+	ErrProxyTimeout  struct{ Msg string }
 	ErrProxyEmpty    struct{}
 )
 
 func (e *ErrProxyURL) Error() string      { return fmt.Sprintf("bad proxy url: %s", e.Msg) }
 func (e *ErrProxyStatus) Error() string   { return fmt.Sprintf("proxy response status: %s", e.Msg) }
 func (e *ErrPasswordEmpty) Error() string { return fmt.Sprintf("password is empty: %s", e.Msg) }
+func (e *ErrProxyTimeout) Error() string  { return fmt.Sprintf("proxy tunnel timed out: %s", e.Msg) }
 func (e *ErrProxyEmpty) Error() string    { return "proxy is not set" }
 
+// StatusCode returns the status code of the proxy response, or 0 if Msg does not start with one.
+func (e *ErrProxyStatus) StatusCode() int {
+	code, _, _ := strings.Cut(e.Msg, " ")
+
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
 type proxyDialer struct {
 	ProxyURL      *url.URL
 	DefaultHeader http.Header
@@ -40,10 +61,32 @@ type proxyDialer struct {
 	// overridden dialer allow to control establishment of TCP connection
 	Dialer net.Dialer
 
+	// DialTCPContext, when set, establishes the TCP connections to the proxy server instead of Dialer.
+	DialTCPContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// LookupNetIP, when set, resolves target hostnames locally instead of Dialer.Resolver.
+	// The first address returned is sent to the proxy.
+	LookupNetIP func(ctx context.Context, host string) ([]netip.Addr, error)
+
+	// Timeout, when positive, limits the time to establish a tunnel through the proxy,
+	// including the connection to the proxy server.
+	Timeout time.Duration
+
 	// DialTLSContext allows user to control establishment of TLS connection.
 	// MUST return connection with completed Handshake, and NegotiatedProtocol.
 	DialTLSContext func(ctx context.Context, network, address string) (net.Conn, string, error)
 
+	// nextHop is set on the hops of a chain before the last one: the address of the next hop is
+	// sent to the proxy unresolved, unless the chain resolves hops locally
+	nextHop bool
+
+	// credentials of the proxy URL, answering Digest and NTLM challenges
+	username string
+	password string
+
+	authMu sync.Mutex
+	digest *httpauth.Digest // last Digest challenge, answered preemptively by later tunnels
+
 	h2Mu   sync.Mutex
 	h2Conn *http2.ClientConn
 	conn   net.Conn
@@ -63,15 +106,15 @@ func (c *proxyDialer) SetResolver(r *net.Resolver) {
 // dialerProxy is an adapter that implements proxy.Dialer interface
 // using net.Dialer to support custom DNS resolver with proxies.
 type dialerProxy struct {
-	dialer *net.Dialer
+	dialer *proxyDialer
 }
 
 func (d *dialerProxy) Dial(network, addr string) (net.Conn, error) {
-	return d.dialer.Dial(network, addr)
+	return d.DialContext(context.Background(), network, addr)
 }
 
 func (d *dialerProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	return d.dialer.DialContext(ctx, network, addr)
+	return d.dialer.dialTCP(ctx, network, addr)
 }
 
 const (
@@ -127,6 +170,8 @@ func NewDialer(proxy string) (*proxyDialer, error) {
 			auth := parsed.User.Username() + ":" + password
 			basicAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
 			proxyDialer.DefaultHeader.Add("Proxy-Authorization", basicAuth)
+
+			proxyDialer.username, proxyDialer.password = parsed.User.Username(), password
 		}
 	}
 
@@ -139,11 +184,58 @@ func (c *proxyDialer) Dial(network, address string) (net.Conn, error) {
 
 type ContextKeyHeader struct{}
 
-func (c *proxyDialer) connectHTTP1(req *http.Request, conn net.Conn) error {
+// connectHTTP1 sends the CONNECT request over conn, answering Digest and NTLM challenges of the
+// proxy. The NTLM handshake takes place on conn; when the proxy closes the connection after a
+// challenge, the next request is sent over a connection from redial. Returns the tunnel.
+func (c *proxyDialer) connectHTTP1(req *http.Request, conn net.Conn, redial func() (net.Conn, error)) (net.Conn, error) {
 	req.Proto = "HTTP/1.1"
 	req.ProtoMajor = 1
 	req.ProtoMinor = 1
 
+	auth := &proxyAuth{dialer: c}
+
+	for {
+		resp, err := exchangeHTTP1(req, conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return conn, nil
+		}
+
+		authorization, ok := "", false
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			authorization, ok = auth.authorize(resp, req.Host)
+		}
+
+		if !ok {
+			_ = conn.Close()
+			return nil, &ErrProxyStatus{Msg: resp.Status}
+		}
+
+		req.Header.Set("Proxy-Authorization", authorization)
+
+		if resp.Close {
+			_ = conn.Close()
+
+			if conn, err = redial(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// maxDiscardBody is the largest body of a response to a CONNECT request discarded to reuse the
+// connection for the next request.
+const maxDiscardBody = 1 << 20
+
+// exchangeHTTP1 writes req to conn and reads the response, aborting when the context of req
+// is done. The body of a response other than 200 is discarded so that conn can be reused;
+// resp.Close is set when it cannot be read in full, leaving conn out of sync, and conn must
+// be closed.
+func exchangeHTTP1(req *http.Request, conn net.Conn) (*http.Response, error) {
 	ctx := req.Context()
 
 	done := make(chan struct{})
@@ -159,24 +251,30 @@ func (c *proxyDialer) connectHTTP1(req *http.Request, conn net.Conn) error {
 	}()
 
 	if err := req.Write(conn); err != nil {
-		_ = conn.Close()
-		return err
+		return nil, err
 	}
 
 	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
 	if err != nil {
-		_ = conn.Close()
-		return err
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		_ = conn.Close()
-		return &ErrProxyStatus{resp.Status}
+		// Closing the body would read the rest of it.
+		n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxDiscardBody+1))
+		if err != nil || n > maxDiscardBody {
+			resp.Close = true
+			return resp, nil
+		}
+
+		_ = resp.Body.Close()
 	}
 
-	return nil
+	return resp, nil
 }
 
+// connectHTTP2 opens a CONNECT stream over the HTTP/2 connection to the proxy, answering its
+// Digest challenges. NTLM is not available over HTTP/2.
 func (c *proxyDialer) connectHTTP2(
 	req *http.Request,
 	conn net.Conn,
@@ -186,43 +284,104 @@ func (c *proxyDialer) connectHTTP2(
 	req.Proto = "HTTP/2.0"
 	req.ProtoMajor = 2
 	req.ProtoMinor = 0
-	pr, pw := io.Pipe()
-	req.Body = pr
 
-	resp, err := h2clientConn.RoundTrip(req)
-	if err != nil {
-		_ = pw.Close()
-		_ = pr.Close()
+	auth := &proxyAuth{dialer: c}
 
-		if closeOnError {
-			_ = conn.Close()
+	for {
+		pr, pw := io.Pipe()
+		req.Body = pr
+
+		resp, err := h2clientConn.RoundTrip(req)
+		if err != nil {
+			_ = pw.Close()
+			_ = pr.Close()
+
+			if closeOnError {
+				_ = conn.Close()
+			}
+
+			return nil, err
 		}
 
-		return nil, err
-	}
+		if resp.StatusCode == http.StatusOK {
+			return newHTTP2Conn(conn, pw, resp.Body), nil
+		}
 
-	if resp.StatusCode != http.StatusOK {
 		_ = pw.Close()
 		_ = pr.Close()
 		_ = resp.Body.Close()
 
-		if closeOnError {
-			_ = conn.Close()
+		authorization, ok := "", false
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			authorization, ok = auth.authorize(resp, req.Host)
 		}
 
-		return nil, &ErrProxyStatus{resp.Status}
-	}
+		if !ok || strings.HasPrefix(authorization, "NTLM ") {
+			if closeOnError {
+				_ = conn.Close()
+			}
+
+			return nil, &ErrProxyStatus{Msg: resp.Status}
+		}
 
-	return newHTTP2Conn(conn, pw, resp.Body), nil
+		req.Header.Set("Proxy-Authorization", authorization)
+	}
 }
 
+// DialContext connects to address through the proxy, within Timeout when it is set.
 func (c *proxyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.Timeout <= 0 {
+		return c.dialContext(ctx, network, address)
+	}
+
+	// The context of an HTTP/2 CONNECT stream must outlive the dial, so the timeout cancels
+	// a context detached from ctx rather than a child of it.
+	dialCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	timer := time.AfterFunc(c.Timeout, cancel)
+
+	conn, err := c.dialContext(dialCtx, network, address)
+
+	expired, canceled := !timer.Stop(), !stop()
+
+	switch {
+	case expired:
+		err = &ErrProxyTimeout{fmt.Sprintf("%s after %s", c.ProxyURL.Redacted(), c.Timeout)}
+	case canceled:
+		err = ctx.Err()
+	case err == nil:
+		return conn, nil
+	}
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+
+	cancel()
+
+	return nil, err
+}
+
+func (c *proxyDialer) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	if c.ProxyURL == nil {
 		return nil, &ErrProxyEmpty{}
 	}
 
 	// Pre-resolve DNS locally if custom resolver is configured.
-	if c.Dialer.Resolver != nil {
+	// The address of the next hop of a chain is left to the proxy.
+	if c.LookupNetIP != nil && !c.nextHop {
+		host, port, err := net.SplitHostPort(address)
+		if err == nil && net.ParseIP(host) == nil {
+			ips, err := c.LookupNetIP(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(ips) > 0 {
+				address = net.JoinHostPort(ips[0].String(), port)
+			}
+		}
+	} else if c.Dialer.Resolver != nil && !c.nextHop {
 		host, port, err := net.SplitHostPort(address)
 		if err == nil {
 			if net.ParseIP(host) == nil {
@@ -235,7 +394,7 @@ func (c *proxyDialer) DialContext(ctx context.Context, network, address string)
 	}
 
 	if strings.HasPrefix(c.ProxyURL.Scheme, "socks") {
-		forward := proxy.Dialer(&dialerProxy{dialer: &c.Dialer})
+		forward := proxy.Dialer(&dialerProxy{dialer: c})
 
 		dial, err := proxy.FromURL(c.ProxyURL, forward)
 		if err != nil {
@@ -254,6 +413,11 @@ func (c *proxyDialer) DialContext(ctx context.Context, network, address string)
 
 	maps.Copy(req.Header, c.DefaultHeader)
 
+	// Answer the Digest challenge of earlier tunnels preemptively instead of sending Basic credentials.
+	if authorization, ok := c.digestAuthorization(address); ok {
+		req.Header.Set("Proxy-Authorization", authorization)
+	}
+
 	if ctxHeader, ctxHasHeader := ctx.Value(ContextKeyHeader{}).(http.Header); ctxHasHeader {
 		maps.Copy(req.Header, ctxHeader)
 	}
@@ -291,7 +455,19 @@ func (c *proxyDialer) DialContext(ctx context.Context, network, address string)
 		return nil, err
 	}
 
-	return c.connect(req, rawConn, negotiatedProtocol)
+	return c.connect(req, rawConn, negotiatedProtocol, func() (net.Conn, error) {
+		conn, _, err := c.initProxyConn(ctx, network)
+		return conn, err
+	})
+}
+
+// dialTCP establishes a TCP connection with DialTCPContext, or Dialer when it is not set.
+func (c *proxyDialer) dialTCP(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.DialTCPContext != nil {
+		return c.DialTCPContext(ctx, network, address)
+	}
+
+	return c.Dialer.DialContext(ctx, network, address)
 }
 
 func (c *proxyDialer) initProxyConn(ctx context.Context, network string) (net.Conn, string, error) {
@@ -303,7 +479,7 @@ func (c *proxyDialer) initProxyConn(ctx context.Context, network string) (net.Co
 
 	switch c.ProxyURL.Scheme {
 	case schemeHTTP:
-		rawConn, err = c.Dialer.DialContext(ctx, network, c.ProxyURL.Host)
+		rawConn, err = c.dialTCP(ctx, network, c.ProxyURL.Host)
 		if err != nil {
 			return nil, "", err
 		}
@@ -314,7 +490,7 @@ func (c *proxyDialer) initProxyConn(ctx context.Context, network string) (net.Co
 				return nil, "", err
 			}
 		} else {
-			tcpConn, err := c.Dialer.DialContext(ctx, network, c.ProxyURL.Host)
+			tcpConn, err := c.dialTCP(ctx, network, c.ProxyURL.Host)
 			if err != nil {
 				return nil, "", err
 			}
@@ -342,7 +518,12 @@ func (c *proxyDialer) initProxyConn(ctx context.Context, network string) (net.Co
 	return rawConn, negotiatedProtocol, err
 }
 
-func (c *proxyDialer) connect(req *http.Request, conn net.Conn, negotiatedProtocol string) (net.Conn, error) {
+func (c *proxyDialer) connect(
+	req *http.Request,
+	conn net.Conn,
+	negotiatedProtocol string,
+	redial func() (net.Conn, error),
+) (net.Conn, error) {
 	if negotiatedProtocol == http2.NextProtoTLS {
 		c.tr2Once.Do(func() { c.tr2 = new(http2.Transport) })
 
@@ -371,11 +552,7 @@ func (c *proxyDialer) connect(req *http.Request, conn net.Conn, negotiatedProtoc
 		return proxyConn, nil
 	}
 
-	if err := c.connectHTTP1(req, conn); err != nil {
-		return nil, err
-	}
-
-	return conn, nil
+	return c.connectHTTP1(req, conn, redial)
 }
 
 func (c *proxyDialer) Close() error {
@@ -3,6 +3,7 @@ package connectproxy_test
 import (
 	"context"
 	"net"
+	"net/netip"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -250,6 +251,26 @@ func TestProxyErrors(t *testing.T) {
 	}
 }
 
+func TestProxyStatusCode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err  *connectproxy.ErrProxyStatus
+		want int
+	}{
+		{&connectproxy.ErrProxyStatus{"502 Bad Gateway"}, 502},
+		{&connectproxy.ErrProxyStatus{Msg: "407 Proxy Authentication Required"}, 407},
+		{&connectproxy.ErrProxyStatus{Msg: "bad gateway"}, 0},
+		{&connectproxy.ErrProxyStatus{}, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.err.StatusCode(); got != tc.want {
+			t.Errorf("expected status code %d for %q, got %d", tc.want, tc.err.Msg, got)
+		}
+	}
+}
+
 func TestDialerEdgeCases(t *testing.T) {
 	t.Parallel()
 
@@ -1505,3 +1526,71 @@ func TestDialContextWithImmediateCancellation(t *testing.T) {
 		})
 	}
 }
+
+func TestDialerLookupAndDialHooks(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	targets := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 1024)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		targets <- strings.Fields(string(buf[:n]))[1]
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	}()
+
+	dialer, err := connectproxy.NewDialer("http://hooks-proxy.test:8080")
+	if err != nil {
+		t.Fatalf("failed to create dialer: %v", err)
+	}
+
+	var dials atomic.Int32
+
+	dialer.DialTCPContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, listener.Addr().String())
+	}
+
+	dialer.LookupNetIP = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if host != "hooks.test" {
+			t.Errorf("unexpected lookup of %s", host)
+		}
+
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", "hooks.test:443")
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	conn.Close()
+
+	if target := <-targets; target != "192.0.2.1:443" {
+		t.Errorf("expected the first looked up address as CONNECT target, got %s", target)
+	}
+
+	if dials.Load() != 1 {
+		t.Errorf("expected the proxy to be dialed with DialTCPContext, got %d dials", dials.Load())
+	}
+}
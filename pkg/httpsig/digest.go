@@ -0,0 +1,114 @@
+package httpsig
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+)
+
+// Digest algorithms of the Content-Digest field (RFC 9530 section 5).
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+// digestHash returns the hash function of a digest algorithm, nil when it is not supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case DigestSHA256:
+		return sha256.New
+	case DigestSHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// ContentDigest returns the Content-Digest field value of content with the algorithm, sha-256
+// or sha-512.
+func ContentDigest(content []byte, algorithm string) (string, error) {
+	newHash := digestHash(algorithm)
+	if newHash == nil {
+		return "", &ErrSignature{"unsupported digest algorithm " + algorithm}
+	}
+
+	h := newHash()
+	h.Write(content)
+
+	return algorithm + "=" + serializeBareItem(h.Sum(nil)), nil
+}
+
+// VerifyContentDigest checks the digests of a Content-Digest field value against content. The
+// digests of unsupported algorithms are ignored, at least one digest must be supported.
+func VerifyContentDigest(value string, content []byte) error {
+	v, err := NewDigestVerifier(value)
+	if err != nil {
+		return err
+	}
+
+	v.Write(content)
+
+	return v.Verify()
+}
+
+// DigestVerifier checks the digests of a Content-Digest field value against content written to
+// it in parts, such as a body verified as it is read.
+type DigestVerifier struct {
+	names   []string
+	hashes  []hash.Hash
+	digests [][]byte
+}
+
+// NewDigestVerifier returns a verifier of the digests of a Content-Digest field value. The digests
+// of unsupported algorithms are ignored, at least one digest must be supported.
+func NewDigestVerifier(value string) (*DigestVerifier, error) {
+	members, err := parseDictionary(value)
+	if err != nil {
+		return nil, err
+	}
+
+	v := new(DigestVerifier)
+
+	for _, m := range members {
+		newHash := digestHash(m.name)
+		if newHash == nil {
+			continue
+		}
+
+		digest, ok := m.value.([]byte)
+		if !ok {
+			return nil, &ErrSignature{"invalid " + m.name + " digest"}
+		}
+
+		v.names = append(v.names, m.name)
+		v.hashes = append(v.hashes, newHash())
+		v.digests = append(v.digests, digest)
+	}
+
+	if len(v.hashes) == 0 {
+		return nil, &ErrSignature{"no supported content digest"}
+	}
+
+	return v, nil
+}
+
+// Write adds p to the content. It never returns an error.
+func (v *DigestVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Verify checks the digests against the content written so far.
+func (v *DigestVerifier) Verify() error {
+	for i, h := range v.hashes {
+		if subtle.ConstantTimeCompare(h.Sum(nil), v.digests[i]) != 1 {
+			return &ErrSignature{v.names[i] + " content digest mismatch"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,362 @@
+// Package httpsig signs and verifies HTTP messages with HTTP Message Signatures (RFC 9421) and
+// computes their Content-Digest (RFC 9530).
+//
+// Signatures cover the derived components @method, @target-uri, @authority, @scheme,
+// @request-target, @path, @query and @status, and header fields. Component parameters, such as
+// ;req, ;sf or ;key, and the @query-param component are not supported.
+package httpsig
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enetx/http"
+)
+
+// ErrSignature is returned when a message cannot be signed, or its signature or content digest
+// is invalid.
+type ErrSignature struct{ Msg string }
+
+func (e *ErrSignature) Error() string { return fmt.Sprintf("http signature: %s", e.Msg) }
+
+// Message is the HTTP message whose components a signature covers.
+type Message struct {
+	Method string      // Method of a request, empty for responses
+	URL    *url.URL    // Absolute target URI of a request
+	Status int         // Status code of a response, zero for requests
+	Header http.Header // Header fields of the message
+}
+
+// RequestMessage returns the message of a request sent by a client or received by a server,
+// whose target URI is completed with the Host header and the scheme of the connection.
+func RequestMessage(r *http.Request) *Message {
+	u := *r.URL
+
+	if r.Host != "" {
+		u.Host = r.Host
+	}
+
+	// Clients send the root path of URLs without a path.
+	if u.Path == "" && u.RawPath == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	return &Message{Method: r.Method, URL: &u, Header: withContentLength(r.Header, r.ContentLength)}
+}
+
+// ResponseMessage returns the message of a response.
+func ResponseMessage(resp *http.Response) *Message {
+	return &Message{Status: resp.StatusCode, Header: withContentLength(resp.Header, resp.ContentLength)}
+}
+
+// withContentLength returns header with the Content-Length field the message is sent with.
+func withContentLength(header http.Header, length int64) http.Header {
+	if length <= 0 || header.Get("Content-Length") != "" {
+		return header
+	}
+
+	header = header.Clone()
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	return header
+}
+
+// component returns the value of a component identifier (RFC 9421 section 2).
+func (m *Message) component(name string) (string, error) {
+	request := m.Status == 0 && m.URL != nil
+
+	switch name {
+	case "@status":
+		if m.Status != 0 {
+			return strconv.Itoa(m.Status), nil
+		}
+	case "@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path", "@query":
+		if !request {
+			break
+		}
+
+		switch name {
+		case "@method":
+			return m.Method, nil
+		case "@target-uri":
+			return m.URL.String(), nil
+		case "@authority":
+			return authority(m.URL), nil
+		case "@scheme":
+			return strings.ToLower(m.URL.Scheme), nil
+		case "@request-target":
+			return m.URL.RequestURI(), nil
+		case "@path":
+			if path := m.URL.EscapedPath(); path != "" {
+				return path, nil
+			}
+
+			return "/", nil
+		default:
+			return "?" + m.URL.RawQuery, nil
+		}
+	default:
+		if strings.HasPrefix(name, "@") || name != strings.ToLower(name) {
+			break
+		}
+
+		values := m.Header.Values(name)
+		if len(values) == 0 {
+			return "", &ErrSignature{"missing header field " + name}
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.TrimSpace(value)
+		}
+
+		return strings.Join(trimmed, ", "), nil
+	}
+
+	return "", &ErrSignature{"unsupported component " + name}
+}
+
+// authority returns the lower-case host of u, without the default port of its scheme.
+func authority(u *url.URL) string {
+	host := strings.ToLower(u.Host)
+
+	if h, port, err := net.SplitHostPort(host); err == nil &&
+		(port == "80" && u.Scheme == "http" || port == "443" && u.Scheme == "https") {
+		if strings.Contains(h, ":") {
+			return "[" + h + "]"
+		}
+
+		return h
+	}
+
+	return host
+}
+
+// signatureBase returns the signature base of the message for the covered components and the
+// serialized signature parameters (RFC 9421 section 2.5).
+func (m *Message) signatureBase(components []string, params string) ([]byte, error) {
+	var b strings.Builder
+
+	for i, name := range components {
+		if slices.Contains(components[:i], name) {
+			return nil, &ErrSignature{"duplicate component " + name}
+		}
+
+		value, err := m.component(name)
+		if err != nil {
+			return nil, err
+		}
+
+		b.WriteString(serializeBareItem(name) + ": " + value + "\n")
+	}
+
+	b.WriteString(`"@signature-params": ` + params)
+
+	return []byte(b.String()), nil
+}
+
+// Signer signs messages with a key. Its fields must not be modified during calls of Sign.
+type Signer struct {
+	Key        *Key
+	Label      string        // Label of the signature, sig1 when empty
+	Components []string      // Covered component identifiers, in order
+	Expires    time.Duration // Validity of the signatures from their creation, unlimited when zero
+	Nonce      bool          // Add a random nonce parameter
+	Tag        string        // Application-specific tag parameter, if any
+
+	// Now returns the creation time of the signatures, time.Now when nil.
+	Now func() time.Time
+}
+
+// Sign returns the Signature-Input and Signature field values of a signature of m.
+func (s *Signer) Sign(m *Message) (string, string, error) {
+	if s.Key == nil {
+		return "", "", &ErrSignature{"no signing key"}
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	params := []param{{"created", now.Unix()}}
+
+	if s.Expires > 0 {
+		params = append(params, param{"expires", now.Add(s.Expires).Unix()})
+	}
+
+	if s.Nonce {
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+
+		params = append(params, param{"nonce", base64.RawURLEncoding.EncodeToString(nonce)})
+	}
+
+	params = append(params, param{"keyid", s.Key.ID})
+
+	if s.Tag != "" {
+		params = append(params, param{"tag", s.Tag})
+	}
+
+	items := make([]item, len(s.Components))
+	for i, name := range s.Components {
+		items[i] = item{value: name}
+	}
+
+	input := serializeInnerList(items, params)
+
+	base, err := m.signatureBase(s.Components, input)
+	if err != nil {
+		return "", "", err
+	}
+
+	signature, err := s.Key.sign(base)
+	if err != nil {
+		return "", "", err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+
+	return label + "=" + input, label + "=" + serializeBareItem(signature), nil
+}
+
+// Verifier verifies the signatures of messages with a set of keys. Its fields must not be
+// modified during calls of Verify.
+type Verifier struct {
+	Keys   []*Key
+	Label  string        // Label of the verified signature, any signature when empty
+	MaxAge time.Duration // Maximum age of the signatures from their creation, unlimited when zero
+
+	// Now returns the verification time, time.Now when nil.
+	Now func() time.Time
+}
+
+// Verify verifies a signature of m made with one of the keys, selected by the keyid parameter
+// of the signature, or the only key when the signature has none. It returns the components the
+// verified signature covers.
+func (v *Verifier) Verify(m *Message) ([]string, error) {
+	inputs, err := parseDictionary(strings.Join(m.Header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := parseDictionary(strings.Join(m.Header.Values("Signature"), ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	err = &ErrSignature{"no signature to verify"}
+
+	for _, input := range inputs {
+		if v.Label != "" && input.name != v.Label {
+			continue
+		}
+
+		components, verr := v.verify(m, input, signatures, now)
+		if verr == nil {
+			return components, nil
+		}
+
+		err = verr
+	}
+
+	return nil, err
+}
+
+// verify verifies the signature of an input.
+func (v *Verifier) verify(m *Message, input member, signatures []member, now time.Time) ([]string, error) {
+	if !input.isList {
+		return nil, &ErrSignature{"invalid signature input " + input.name}
+	}
+
+	components := make([]string, len(input.list))
+
+	for i, it := range input.list {
+		name, ok := it.value.(string)
+		if !ok || len(it.params) != 0 {
+			return nil, &ErrSignature{"unsupported component in signature input " + input.name}
+		}
+
+		components[i] = name
+	}
+
+	var (
+		keyID, alg string
+		key        *Key
+	)
+
+	for _, p := range input.params {
+		switch p.name {
+		case "keyid":
+			keyID, _ = p.value.(string)
+		case "alg":
+			alg, _ = p.value.(string)
+		case "expires":
+			if expires, ok := p.value.(int64); !ok || now.Unix() > expires {
+				return nil, &ErrSignature{"signature " + input.name + " expired"}
+			}
+		case "created":
+			created, ok := p.value.(int64)
+			if !ok || v.MaxAge > 0 && now.Sub(time.Unix(created, 0)) > v.MaxAge {
+				return nil, &ErrSignature{"signature " + input.name + " is too old"}
+			}
+		}
+	}
+
+	for _, k := range v.Keys {
+		if k.ID == keyID || keyID == "" && len(v.Keys) == 1 {
+			key = k
+			break
+		}
+	}
+
+	switch {
+	case key == nil:
+		return nil, &ErrSignature{"unknown key " + strconv.Quote(keyID)}
+	case alg != "" && Algorithm(alg) != key.Algorithm:
+		return nil, &ErrSignature{"algorithm " + alg + " does not match key " + key.ID}
+	}
+
+	idx := slices.IndexFunc(signatures, func(s member) bool { return s.name == input.name })
+	if idx < 0 {
+		return nil, &ErrSignature{"missing signature " + input.name}
+	}
+
+	signature, ok := signatures[idx].value.([]byte)
+	if !ok {
+		return nil, &ErrSignature{"invalid signature " + input.name}
+	}
+
+	base, err := m.signatureBase(components, serializeInnerList(input.list, input.params))
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.verify(base, signature) {
+		return nil, &ErrSignature{"signature " + input.name + " does not match"}
+	}
+
+	return components, nil
+}
@@ -0,0 +1,144 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+// Algorithm is an HTTP signature algorithm of the registry of RFC 9421 section 6.2.
+type Algorithm string
+
+const (
+	Ed25519         Algorithm = "ed25519"
+	ECDSAP256SHA256 Algorithm = "ecdsa-p256-sha256"
+	RSAPSSSHA512    Algorithm = "rsa-pss-sha512"
+	HMACSHA256      Algorithm = "hmac-sha256"
+)
+
+// Key is a key signing or verifying signatures with an algorithm, identified by the keyid
+// parameter of the signatures.
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+	key       any
+}
+
+// NewKey returns the key identified by id for an ed25519.PrivateKey or ed25519.PublicKey, an
+// *ecdsa.PrivateKey or *ecdsa.PublicKey on the P-256 curve, an *rsa.PrivateKey or *rsa.PublicKey
+// signing with RSASSA-PSS, or a []byte HMAC shared secret. Public keys only verify signatures.
+func NewKey(id string, key any) (*Key, error) {
+	k := &Key{ID: id, key: key}
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		k.Algorithm = Ed25519
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, &ErrSignature{"only P-256 ECDSA keys are supported"}
+		}
+
+		k.Algorithm = ECDSAP256SHA256
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, &ErrSignature{"only P-256 ECDSA keys are supported"}
+		}
+
+		k.Algorithm = ECDSAP256SHA256
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		k.Algorithm = RSAPSSSHA512
+	case []byte:
+		if len(key) == 0 {
+			return nil, &ErrSignature{"empty HMAC key"}
+		}
+
+		k.Algorithm = HMACSHA256
+	default:
+		return nil, &ErrSignature{fmt.Sprintf("unsupported key type %T", key)}
+	}
+
+	return k, nil
+}
+
+// sign returns the signature of data.
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch key := k.key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data), nil
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(data)
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature is the concatenation of r and s, 32 bytes each (RFC 9421 section 3.3.4).
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		return signature, nil
+	case *rsa.PrivateKey:
+		digest := sha512.Sum512(data)
+		return rsa.SignPSS(rand.Reader, key, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+
+		return mac.Sum(nil), nil
+	default:
+		return nil, &ErrSignature{"key " + k.ID + " cannot sign"}
+	}
+}
+
+// verify checks the signature of data.
+func (k *Key) verify(data, signature []byte) bool {
+	switch key := k.key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Verify(key.Public().(ed25519.PublicKey), data, signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *ecdsa.PrivateKey:
+		return verifyECDSA(&key.PublicKey, data, signature)
+	case *ecdsa.PublicKey:
+		return verifyECDSA(key, data, signature)
+	case *rsa.PrivateKey:
+		return verifyPSS(&key.PublicKey, data, signature)
+	case *rsa.PublicKey:
+		return verifyPSS(key, data, signature)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+
+		return hmac.Equal(mac.Sum(nil), signature)
+	default:
+		return false
+	}
+}
+
+func verifyECDSA(key *ecdsa.PublicKey, data, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+
+	digest := sha256.Sum256(data)
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+
+	return ecdsa.Verify(key, digest[:], r, s)
+}
+
+func verifyPSS(key *rsa.PublicKey, data, signature []byte) bool {
+	digest := sha512.Sum512(data)
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+
+	return rsa.VerifyPSS(key, crypto.SHA512, digest[:], signature, opts) == nil
+}
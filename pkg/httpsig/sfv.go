@@ -0,0 +1,329 @@
+package httpsig
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// The subset of Structured Field Values (RFC 8941) of the Signature-Input, Signature and
+// Content-Digest fields: dictionaries of items and inner lists, without decimals.
+
+// token is a Structured Field token, serialized without quotes.
+type token string
+
+// param is a parameter of an item or an inner list.
+type param struct {
+	name  string
+	value any // string, token, int64, bool or []byte
+}
+
+// item is a bare item with its parameters.
+type item struct {
+	value  any
+	params []param
+}
+
+// member is a dictionary member, an item or an inner list.
+type member struct {
+	name   string
+	list   []item // Items of an inner list, nil for an item
+	isList bool
+	value  any
+	params []param
+}
+
+// sfParser parses a field value.
+type sfParser struct {
+	s   string
+	pos int
+}
+
+// parseDictionary parses a dictionary field value (RFC 8941 section 4.2.2).
+func parseDictionary(s string) ([]member, error) {
+	p := &sfParser{s: s}
+	p.skip(" ")
+
+	var members []member
+
+	for p.pos < len(p.s) {
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		m := member{name: name, value: true}
+
+		if p.peek() == '=' {
+			p.pos++
+
+			if p.peek() == '(' {
+				m.isList = true
+				m.list, err = p.innerList()
+			} else {
+				m.value, err = p.bareItem()
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if m.params, err = p.params(); err != nil {
+			return nil, err
+		}
+
+		members = append(members, m)
+
+		p.skip(" \t")
+
+		if p.pos == len(p.s) {
+			break
+		}
+
+		if p.peek() != ',' {
+			return nil, p.errorf("expected a comma")
+		}
+
+		p.pos++
+		p.skip(" \t")
+
+		if p.pos == len(p.s) {
+			return nil, p.errorf("trailing comma")
+		}
+	}
+
+	return members, nil
+}
+
+func (p *sfParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+
+	return 0
+}
+
+func (p *sfParser) skip(chars string) {
+	for p.pos < len(p.s) && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *sfParser) errorf(msg string) error {
+	return &ErrSignature{"invalid structured field at " + strconv.Itoa(p.pos) + ": " + msg}
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.pos
+
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if 'a' <= c && c <= 'z' || c == '*' || p.pos > start && ('0' <= c && c <= '9' || strings.IndexByte("_-.", c) >= 0) {
+			p.pos++
+			continue
+		}
+
+		break
+	}
+
+	if p.pos == start {
+		return "", p.errorf("expected a key")
+	}
+
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) innerList() ([]item, error) {
+	p.pos++ // (
+
+	items := []item{}
+
+	for {
+		p.skip(" ")
+
+		if p.peek() == ')' {
+			p.pos++
+			return items, nil
+		}
+
+		value, err := p.bareItem()
+		if err != nil {
+			return nil, err
+		}
+
+		params, err := p.params()
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item{value, params})
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, p.errorf("unterminated inner list")
+		}
+	}
+}
+
+func (p *sfParser) params() ([]param, error) {
+	var params []param
+
+	for p.peek() == ';' {
+		p.pos++
+		p.skip(" ")
+
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+
+		if p.peek() == '=' {
+			p.pos++
+
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+
+		params = append(params, param{name, value})
+	}
+
+	return params, nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || '0' <= c && c <= '9':
+		start := p.pos
+		p.pos++
+
+		for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+
+		n, err := strconv.ParseInt(p.s[start:p.pos], 10, 64)
+		if err != nil || p.pos-start > 16 {
+			return nil, p.errorf("invalid integer")
+		}
+
+		return n, nil
+	case c == '"':
+		var b strings.Builder
+
+		for p.pos++; p.pos < len(p.s); p.pos++ {
+			switch c := p.s[p.pos]; c {
+			case '\\':
+				p.pos++
+				if p.pos == len(p.s) || p.s[p.pos] != '"' && p.s[p.pos] != '\\' {
+					return nil, p.errorf("invalid escape")
+				}
+
+				b.WriteByte(p.s[p.pos])
+			case '"':
+				p.pos++
+				return b.String(), nil
+			default:
+				if c < 0x20 || c > 0x7e {
+					return nil, p.errorf("invalid string character")
+				}
+
+				b.WriteByte(c)
+			}
+		}
+
+		return nil, p.errorf("unterminated string")
+	case c == ':':
+		end := strings.IndexByte(p.s[p.pos+1:], ':')
+		if end < 0 {
+			return nil, p.errorf("unterminated byte sequence")
+		}
+
+		data, err := base64.StdEncoding.DecodeString(p.s[p.pos+1 : p.pos+1+end])
+		if err != nil {
+			return nil, p.errorf("invalid byte sequence")
+		}
+
+		p.pos += end + 2
+
+		return data, nil
+	case c == '?':
+		if p.pos+1 < len(p.s) && (p.s[p.pos+1] == '0' || p.s[p.pos+1] == '1') {
+			p.pos += 2
+			return p.s[p.pos-1] == '1', nil
+		}
+
+		return nil, p.errorf("invalid boolean")
+	case c == '*' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		start := p.pos
+
+		for p.pos < len(p.s) && (isTChar(p.s[p.pos]) || p.s[p.pos] == ':' || p.s[p.pos] == '/') {
+			p.pos++
+		}
+
+		return token(p.s[start:p.pos]), nil
+	default:
+		return nil, p.errorf("unexpected character")
+	}
+}
+
+func isTChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// serializeInnerList serializes an inner list with its parameters (RFC 8941 section 4.1.1.1).
+func serializeInnerList(items []item, params []param) string {
+	var b strings.Builder
+
+	b.WriteByte('(')
+
+	for i, it := range items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+
+		b.WriteString(serializeBareItem(it.value))
+		b.WriteString(serializeParams(it.params))
+	}
+
+	b.WriteByte(')')
+	b.WriteString(serializeParams(params))
+
+	return b.String()
+}
+
+func serializeParams(params []param) string {
+	var b strings.Builder
+
+	for _, p := range params {
+		b.WriteString(";" + p.name)
+
+		if v, ok := p.value.(bool); !ok || !v {
+			b.WriteString("=" + serializeBareItem(p.value))
+		}
+	}
+
+	return b.String()
+}
+
+func serializeBareItem(value any) string {
+	switch v := value.(type) {
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case token:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "?1"
+		}
+
+		return "?0"
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,351 @@
+package pac
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// interp holds the state of a FindProxyForURL call.
+type interp struct {
+	ctx    context.Context
+	script *Script
+	vm     *goja.Runtime
+	dns    map[string]net.IP // Addresses resolved during the call
+}
+
+// builtin is a global function of PAC scripts.
+type builtin func(in *interp, args []goja.Value) any
+
+// builtins are the global functions of PAC scripts.
+var builtins = map[string]builtin{
+	"isPlainHostName": func(_ *interp, args []goja.Value) any {
+		return !strings.Contains(arg(args, 0), ".")
+	},
+	"dnsDomainIs": func(_ *interp, args []goja.Value) any {
+		return strings.HasSuffix(strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1)))
+	},
+	"localHostOrDomainIs": func(_ *interp, args []goja.Value) any {
+		host, hostdom := strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))
+		return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")
+	},
+	"dnsDomainLevels": func(_ *interp, args []goja.Value) any {
+		return strings.Count(arg(args, 0), ".")
+	},
+	"isResolvable": func(in *interp, args []goja.Value) any {
+		return in.resolve(arg(args, 0)) != nil
+	},
+	"dnsResolve": func(in *interp, args []goja.Value) any {
+		if ip := in.resolve(arg(args, 0)); ip != nil {
+			return ip.String()
+		}
+
+		return nil
+	},
+	"isInNet": func(in *interp, args []goja.Value) any {
+		ip := in.resolve(arg(args, 0)).To4()
+		pattern := net.ParseIP(arg(args, 1)).To4()
+		mask := net.ParseIP(arg(args, 2)).To4()
+
+		if ip == nil || pattern == nil || mask == nil {
+			return false
+		}
+
+		return ip.Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask)))
+	},
+	"convert_addr": func(_ *interp, args []goja.Value) any {
+		if ip := net.ParseIP(arg(args, 0)).To4(); ip != nil {
+			return binary.BigEndian.Uint32(ip)
+		}
+
+		return 0
+	},
+	"myIpAddress": func(in *interp, _ []goja.Value) any {
+		return in.script.myIPAddress()
+	},
+	"shExpMatch": func(_ *interp, args []goja.Value) any {
+		return shExpMatch(arg(args, 0), arg(args, 1))
+	},
+	"weekdayRange": func(in *interp, args []goja.Value) any {
+		return weekdayRange(in.now(&args), args)
+	},
+	"dateRange": func(in *interp, args []goja.Value) any {
+		return dateRange(in.now(&args), args)
+	},
+	"timeRange": func(in *interp, args []goja.Value) any {
+		return timeRange(in.now(&args), args)
+	},
+	"alert": func(*interp, []goja.Value) any { return nil },
+}
+
+// define sets the builtins as globals of the runtime of the call.
+func (in *interp) define() {
+	for name, fn := range builtins {
+		in.vm.Set(name, func(call goja.FunctionCall) goja.Value {
+			return in.vm.ToValue(fn(in, call.Arguments))
+		})
+	}
+}
+
+// arg returns the i-th argument as a string, empty when missing, undefined or null.
+func arg(args []goja.Value, i int) string {
+	if i < len(args) && !goja.IsUndefined(args[i]) && !goja.IsNull(args[i]) {
+		return args[i].String()
+	}
+
+	return ""
+}
+
+// resolve returns the address of host, preferring IPv4. Lookups are cached for the call.
+func (in *interp) resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	if host == "" {
+		return nil
+	}
+
+	if ip, ok := in.dns[host]; ok {
+		return ip
+	}
+
+	resolver := in.script.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var ip net.IP
+
+	if addrs, err := resolver.LookupIPAddr(in.ctx, host); err == nil {
+		for _, addr := range addrs {
+			if ip == nil || ip.To4() == nil && addr.IP.To4() != nil {
+				ip = addr.IP
+			}
+		}
+	}
+
+	if in.dns == nil {
+		in.dns = make(map[string]net.IP)
+	}
+
+	in.dns[host] = ip
+
+	return ip
+}
+
+// now returns the time of the script clock, in UTC when the last argument is "GMT", which is
+// removed from args.
+func (in *interp) now(args *[]goja.Value) time.Time {
+	now := time.Now
+	if in.script.Now != nil {
+		now = in.script.Now
+	}
+
+	t := now()
+
+	if n := len(*args); n > 0 && strings.EqualFold(arg(*args, n-1), "GMT") {
+		*args = (*args)[:n-1]
+		return t.UTC()
+	}
+
+	return t.Local()
+}
+
+// myIPAddress returns the MyIPAddress of the script or the address of the interface routing to
+// the internet; no packets are sent.
+func (s *Script) myIPAddress() string {
+	if s.MyIPAddress != nil {
+		return s.MyIPAddress()
+	}
+
+	conn, err := net.Dial("udp4", "198.51.100.1:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// shExpMatch matches s against a shell expression where * matches any sequence of characters
+// and ? a single character.
+func shExpMatch(s, shexp string) bool {
+	var pattern strings.Builder
+
+	pattern.WriteString("^")
+
+	for _, r := range shexp {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+
+	return err == nil && re.MatchString(s)
+}
+
+var (
+	weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	months   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// inRange reports whether v is between start and end, wrapping around when start is after end.
+func inRange(v, start, end int) bool {
+	if start <= end {
+		return start <= v && v <= end
+	}
+
+	return v >= start || v <= end
+}
+
+// weekdayRange implements weekdayRange(wd1[, wd2]).
+func weekdayRange(t time.Time, args []goja.Value) bool {
+	if len(args) == 0 || len(args) > 2 {
+		return false
+	}
+
+	start := indexOf(weekdays, arg(args, 0))
+	end := start
+
+	if len(args) == 2 {
+		end = indexOf(weekdays, arg(args, 1))
+	}
+
+	if start < 0 || end < 0 {
+		return false
+	}
+
+	return inRange(int(t.Weekday()), start, end)
+}
+
+// dateRange implements dateRange with one day, month or year, or the two bounds of a range of
+// days, months, years, days and months, months and years, or full dates.
+func dateRange(t time.Time, args []goja.Value) bool {
+	type field struct{ kind, value int } // kind: 0 day, 1 month, 2 year
+
+	fields := make([]field, 0, len(args))
+
+	for _, a := range args {
+		if m := indexOf(months, a.String()); m >= 0 {
+			fields = append(fields, field{1, m + 1})
+			continue
+		}
+
+		n := a.ToFloat()
+		if math.IsNaN(n) || n < 1 {
+			return false
+		}
+
+		if n > 31 {
+			fields = append(fields, field{2, int(n)})
+		} else {
+			fields = append(fields, field{0, int(n)})
+		}
+	}
+
+	current := func(kinds []field) int {
+		var key int
+
+		for _, f := range kinds {
+			switch f.kind {
+			case 0:
+				key += t.Day()
+			case 1:
+				key += int(t.Month()) * 100
+			default:
+				key += t.Year() * 10000
+			}
+		}
+
+		return key
+	}
+
+	key := func(fields []field) int {
+		var key int
+
+		for _, f := range fields {
+			switch f.kind {
+			case 0:
+				key += f.value
+			case 1:
+				key += f.value * 100
+			default:
+				key += f.value * 10000
+			}
+		}
+
+		return key
+	}
+
+	switch len(fields) {
+	case 1:
+		return current(fields) == key(fields)
+	case 2, 4, 6:
+		start, end := fields[:len(fields)/2], fields[len(fields)/2:]
+
+		for i := range start {
+			if start[i].kind != end[i].kind {
+				return false
+			}
+		}
+
+		return inRange(current(start), key(start), key(end))
+	}
+
+	return false
+}
+
+// timeRange implements timeRange with hours, hours and minutes, or hours, minutes and seconds,
+// given once or as the two bounds of a range including its end.
+func timeRange(t time.Time, args []goja.Value) bool {
+	values := make([]int, len(args))
+
+	for i, a := range args {
+		n := a.ToFloat()
+		if math.IsNaN(n) {
+			return false
+		}
+
+		values[i] = int(n)
+	}
+
+	now := t.Hour()*3600 + t.Minute()*60 + t.Second()
+
+	switch len(values) {
+	case 1:
+		return t.Hour() == values[0]
+	case 2:
+		return inRange(now, values[0]*3600, values[1]*3600+3599)
+	case 4:
+		return inRange(now, values[0]*3600+values[1]*60, values[2]*3600+values[3]*60+59)
+	case 6:
+		return inRange(now, values[0]*3600+values[1]*60+values[2], values[3]*3600+values[4]*60+values[5])
+	}
+
+	return false
+}
@@ -0,0 +1,195 @@
+// Package pac evaluates proxy auto-config (PAC) scripts.
+//
+// Scripts are run by the goja JavaScript engine (ECMAScript 5.1 with most of ES6), with the
+// standard helper functions isPlainHostName, dnsDomainIs, localHostOrDomainIs, isResolvable,
+// isInNet, dnsResolve, convert_addr, myIpAddress, dnsDomainLevels, shExpMatch, weekdayRange,
+// dateRange, timeRange and alert.
+package pac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+)
+
+const (
+	maxRunTime   = time.Second // Evaluation time of a FindProxyForURL call
+	maxCallDepth = 1024        // Call stack size of a FindProxyForURL call
+)
+
+// ErrScript is returned when a script cannot be parsed or evaluated.
+type ErrScript struct{ Msg string }
+
+func (e *ErrScript) Error() string { return fmt.Sprintf("pac script: %s", e.Msg) }
+
+// Script is a parsed PAC script. Its fields must be set before the first call of
+// FindProxyForURL, which is safe for concurrent use.
+type Script struct {
+	// Resolver resolves the hosts of dnsResolve, isResolvable and isInNet,
+	// net.DefaultResolver when nil.
+	Resolver *net.Resolver
+
+	// MyIPAddress returns the address of myIpAddress, the address of the interface
+	// routing to the internet when nil.
+	MyIPAddress func() string
+
+	// Now returns the time of weekdayRange, dateRange and timeRange, time.Now when nil.
+	Now func() time.Time
+
+	program *goja.Program
+}
+
+// Parse parses a PAC script declaring the function FindProxyForURL(url, host).
+func Parse(src string) (*Script, error) {
+	prg, err := parser.ParseFile(nil, "pac", src, 0)
+	if err != nil {
+		return nil, &ErrScript{err.Error()}
+	}
+
+	if !declares(prg, "FindProxyForURL") {
+		return nil, &ErrScript{"FindProxyForURL is not defined"}
+	}
+
+	program, err := goja.CompileAST(prg, false)
+	if err != nil {
+		return nil, &ErrScript{err.Error()}
+	}
+
+	return &Script{program: program}, nil
+}
+
+// declares reports whether the top-level statements of prg declare name as a function or a
+// variable.
+func declares(prg *ast.Program, name string) bool {
+	for _, s := range prg.Body {
+		switch s := s.(type) {
+		case *ast.FunctionDeclaration:
+			if s.Function.Name != nil && s.Function.Name.Name.String() == name {
+				return true
+			}
+		case *ast.VariableStatement:
+			for _, binding := range s.List {
+				if id, ok := binding.Target.(*ast.Identifier); ok && id.Name.String() == name {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// FindProxyForURL evaluates the script for the URL and its host, returning the result string
+// such as "PROXY proxy.example.com:8080; DIRECT". Every call runs the top-level statements of
+// the script on fresh globals. The evaluation is stopped when ctx is done or after one second.
+func (s *Script) FindProxyForURL(ctx context.Context, url, host string) (string, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallDepth)
+
+	in := &interp{ctx: ctx, script: s, vm: vm}
+	in.define()
+
+	stop := context.AfterFunc(ctx, func() { vm.Interrupt(ctx.Err()) })
+	defer stop()
+
+	timer := time.AfterFunc(maxRunTime, func() {
+		vm.Interrupt(fmt.Errorf("script exceeded %s of evaluation", maxRunTime))
+	})
+	defer timer.Stop()
+
+	if _, err := vm.RunProgram(s.program); err != nil {
+		return "", scriptError(err)
+	}
+
+	fn, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return "", &ErrScript{"FindProxyForURL is not a function"}
+	}
+
+	result, err := fn(goja.Undefined(), vm.ToValue(url), vm.ToValue(host))
+	if err != nil {
+		return "", scriptError(err)
+	}
+
+	if goja.IsUndefined(result) || goja.IsNull(result) {
+		return "", &ErrScript{"FindProxyForURL returned " + result.String()}
+	}
+
+	return result.String(), nil
+}
+
+// scriptError converts an error of the engine, returning the cause of interruptions by the context.
+func scriptError(err error) error {
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		if cause, ok := interrupted.Value().(error); ok {
+			err = cause
+		}
+	}
+
+	return &ErrScript{err.Error()}
+}
+
+// Proxy is an entry of the result of FindProxyForURL.
+type Proxy struct {
+	// Type is DIRECT, PROXY, HTTP, HTTPS, SOCKS, SOCKS4 or SOCKS5.
+	Type string
+
+	// Host is the host:port of the proxy, empty for DIRECT.
+	Host string
+}
+
+// URL returns the proxy URL of the entry: http:// for PROXY and HTTP, https:// for HTTPS,
+// socks5:// for SOCKS and SOCKS5, socks4:// for SOCKS4, and an empty string for DIRECT.
+func (p Proxy) URL() string {
+	switch p.Type {
+	case "PROXY", "HTTP":
+		return "http://" + p.Host
+	case "HTTPS":
+		return "https://" + p.Host
+	case "SOCKS", "SOCKS5":
+		return "socks5://" + p.Host
+	case "SOCKS4":
+		return "socks4://" + p.Host
+	}
+
+	return ""
+}
+
+// ParseResult parses the semicolon-separated entries of a FindProxyForURL result in order,
+// skipping entries of unknown types and entries without a host.
+func ParseResult(result string) []Proxy {
+	var proxies []Proxy
+
+	for entry := range strings.SplitSeq(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		p := Proxy{Type: strings.ToUpper(fields[0])}
+
+		switch p.Type {
+		case "DIRECT":
+		case "PROXY", "HTTP", "HTTPS", "SOCKS", "SOCKS4", "SOCKS5":
+			if len(fields) < 2 {
+				continue
+			}
+
+			p.Host = fields[1]
+		default:
+			continue
+		}
+
+		proxies = append(proxies, p)
+	}
+
+	return proxies
+}
@@ -0,0 +1,327 @@
+package pac_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enetx/surf/pkg/pac"
+)
+
+const corporate = `
+// Typical corporate PAC file.
+var internal = ["intranet.example.com", "wiki.example.com"];
+
+function isInternal(host) {
+	for (var i = 0; i < internal.length; i++) {
+		if (dnsDomainIs(host, internal[i])) return true;
+	}
+	return false;
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+
+	if (isPlainHostName(host) || localHostOrDomainIs(host, "localhost.localdomain"))
+		return "DIRECT";
+
+	if (isInternal(host) || isInNet(host, "10.0.0.0", "255.0.0.0"))
+		return "DIRECT";
+
+	/* Downloads go through the SOCKS proxy. */
+	if (shExpMatch(url, "*://downloads.*/*.iso") || url.substring(0, 4) == "ftp:")
+		return "SOCKS5 socks.example.com:1080; DIRECT";
+
+	var level = dnsDomainLevels(host) > 2 ? "deep" : 'shallow';
+	if (level === "deep" && host.indexOf("cdn") !== -1)
+		return "HTTPS secure.example.com:443";
+
+	return "PROXY proxy.example.com:8080; PROXY backup.example.com:8080";
+}
+`
+
+func TestFindProxyForURL(t *testing.T) {
+	t.Parallel()
+
+	script, err := pac.Parse(corporate)
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+
+	testCases := []struct {
+		url, host, expected string
+	}{
+		{"http://printer/", "printer", "DIRECT"},
+		{"http://localhost/", "localhost", "DIRECT"},
+		{"https://WIKI.example.com/page", "WIKI.example.com", "DIRECT"},
+		{"http://10.1.2.3/", "10.1.2.3", "DIRECT"},
+		{"https://downloads.example.org/linux.iso", "downloads.example.org", "SOCKS5 socks.example.com:1080; DIRECT"},
+		{"ftp://files.example.org/", "files.example.org", "SOCKS5 socks.example.com:1080; DIRECT"},
+		{"https://a.cdn.example.org/", "a.cdn.example.org", "HTTPS secure.example.com:443"},
+		{"https://example.org/", "example.org", "PROXY proxy.example.com:8080; PROXY backup.example.com:8080"},
+	}
+
+	for _, tc := range testCases {
+		result, err := script.FindProxyForURL(context.Background(), tc.url, tc.host)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.url, err)
+			continue
+		}
+
+		if result != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.url, tc.expected, result)
+		}
+	}
+}
+
+func TestDateTimeFunctions(t *testing.T) {
+	t.Parallel()
+
+	// Wednesday, 2024-03-13 14:30:15 UTC.
+	now := time.Date(2024, time.March, 13, 14, 30, 15, 0, time.UTC)
+
+	testCases := []struct {
+		call     string
+		expected bool
+	}{
+		{`weekdayRange("MON", "FRI", "GMT")`, true},
+		{`weekdayRange("SAT", "SUN", "GMT")`, false},
+		{`weekdayRange("FRI", "THU", "GMT")`, true},
+		{`weekdayRange("WED", "GMT")`, true},
+		{`dateRange(13, "GMT")`, true},
+		{`dateRange("MAR", "GMT")`, true},
+		{`dateRange(2023, 2024, "GMT")`, true},
+		{`dateRange(1, "JAN", 28, "FEB", "GMT")`, false},
+		{`dateRange("NOV", "APR", "GMT")`, true},
+		{`dateRange(1, "MAR", 2024, 31, "MAR", 2024, "GMT")`, true},
+		{`timeRange(14, "GMT")`, true},
+		{`timeRange(9, 17, "GMT")`, true},
+		{`timeRange(22, 6, "GMT")`, false},
+		{`timeRange(14, 0, 14, 30, "GMT")`, true},
+		{`timeRange(14, 30, 16, 14, 30, 14, "GMT")`, false},
+	}
+
+	for _, tc := range testCases {
+		script, err := pac.Parse("function FindProxyForURL(url, host) { return " + tc.call + " ? 'PROXY yes:1' : 'DIRECT'; }")
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %v", tc.call, err)
+		}
+
+		script.Now = func() time.Time { return now }
+
+		result, err := script.FindProxyForURL(context.Background(), "http://example.com/", "example.com")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.call, err)
+		}
+
+		if got := result == "PROXY yes:1"; got != tc.expected {
+			t.Errorf("%s: expected %t, got %t", tc.call, tc.expected, got)
+		}
+	}
+}
+
+func TestDNSFunctions(t *testing.T) {
+	t.Parallel()
+
+	script, err := pac.Parse(`
+		function FindProxyForURL(url, host) {
+			var ip = dnsResolve(host);
+			return [ip, isResolvable(host), convert_addr("10.0.1.2"), myIpAddress(),
+				isInNet(host, "127.0.0.0", "255.0.0.0"), dnsResolve("invalid.invalid")].join(" ");
+		}
+	`)
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+
+	script.MyIPAddress = func() string { return "192.0.2.7" }
+
+	result, err := script.FindProxyForURL(context.Background(), "http://127.0.0.1/", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "127.0.0.1 true 167772418 192.0.2.7 true "; result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, src := range []string{
+		"",
+		"function other() { return 'DIRECT'; }",
+		"function FindProxyForURL(url, host) { return 'DIRECT' ",
+		"function FindProxyForURL(url, host) { return 'DIRECT' +; }",
+		`var s = "unterminated;`,
+	} {
+		if _, err := pac.Parse(src); err == nil {
+			t.Errorf("expected a parse error for %q", src)
+		}
+	}
+
+	for _, src := range []string{
+		"function FindProxyForURL(url, host) { return undefinedFunction(host); }",
+		"function FindProxyForURL(url, host) { while (true) {} }",
+		"function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }",
+		"function FindProxyForURL(url, host) { }",
+	} {
+		script, err := pac.Parse(src)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", src, err)
+		}
+
+		_, err = script.FindProxyForURL(context.Background(), "http://example.com/", "example.com")
+
+		var scriptErr *pac.ErrScript
+		if !errors.As(err, &scriptErr) {
+			t.Errorf("expected ErrScript for %q, got %v", src, err)
+		}
+	}
+}
+
+func TestStringMethods(t *testing.T) {
+	t.Parallel()
+
+	script, err := pac.Parse(`
+		function FindProxyForURL(url, host) {
+			var parts = host.split(".");
+			var out = parts.length + "|" + parts[0].toUpperCase() + "|" + host.slice(-3) + "|" +
+				host.substr(4, 3) + "|" + host.charAt(0) + "|" + host.lastIndexOf(".") + "|" +
+				url.replace("http", "ws") + "|" + (host.endsWith(".com") && host.startsWith("www")) + "|" +
+				parts.indexOf("example") + "|" + (1 == "1") + (null == undefined) + (0 === "0");
+			var n = 0;
+			n += 2;
+			n++;
+			return out + "|" + n + "|" + typeof missing + "|" + 7 % 4 * 2;
+		}
+	`)
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+
+	result, err := script.FindProxyForURL(context.Background(), "http://www.example.com/", "www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "3|WWW|com|exa|w|11|ws://www.example.com/|true|1|truetruefalse|3|undefined|6"
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestScriptLanguage(t *testing.T) {
+	t.Parallel()
+
+	// Constructs found in PAC files generated by proxy managers and browser extensions.
+	script, err := pac.Parse(`
+		var rules = {
+			"(^|\\.)example\\.org$": "PROXY a.example.com:3128",
+			"^10\\.": "DIRECT"
+		};
+
+		var FindProxyForURL = (function () {
+			var compiled = Object.keys(rules).map(function (pattern) {
+				return { re: new RegExp(pattern, "i"), result: rules[pattern] };
+			});
+
+			return function (url, host) {
+				switch (url.split(":")[0]) {
+				case "ws":
+				case "wss":
+					return "DIRECT";
+				}
+
+				try {
+					for (var i = 0; i < compiled.length; i++) {
+						if (compiled[i].re.test(host)) return compiled[i].result;
+					}
+
+					if (/^internal-/.test(host)) throw new Error("internal");
+				} catch (e) {
+					return "DIRECT";
+				}
+
+				return ["PROXY", "b.example.com:8080"].join(" ");
+			};
+		})();
+	`)
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+
+	testCases := []struct {
+		url, host, expected string
+	}{
+		{"https://www.EXAMPLE.org/", "www.EXAMPLE.org", "PROXY a.example.com:3128"},
+		{"http://10.0.0.1/", "10.0.0.1", "DIRECT"},
+		{"wss://example.org/", "example.org", "DIRECT"},
+		{"https://internal-wiki/", "internal-wiki", "DIRECT"},
+		{"https://example.net/", "example.net", "PROXY b.example.com:8080"},
+	}
+
+	for _, tc := range testCases {
+		result, err := script.FindProxyForURL(context.Background(), tc.url, tc.host)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.url, err)
+			continue
+		}
+
+		if result != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.url, tc.expected, result)
+		}
+	}
+}
+
+func TestScriptContext(t *testing.T) {
+	t.Parallel()
+
+	script, err := pac.Parse("function FindProxyForURL(url, host) { while (true) {} }")
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = script.FindProxyForURL(ctx, "http://example.com/", "example.com")
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected the deadline of the context, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("evaluation stopped after %s", elapsed)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	t.Parallel()
+
+	proxies := pac.ParseResult("PROXY a:8080;  socks b:1080 ;HTTPS c:443; SOCKS4 d:1080; BOGUS e:1; PROXY; DIRECT")
+
+	expected := []pac.Proxy{
+		{Type: "PROXY", Host: "a:8080"},
+		{Type: "SOCKS", Host: "b:1080"},
+		{Type: "HTTPS", Host: "c:443"},
+		{Type: "SOCKS4", Host: "d:1080"},
+		{Type: "DIRECT"},
+	}
+
+	if !reflect.DeepEqual(proxies, expected) {
+		t.Fatalf("expected %v, got %v", expected, proxies)
+	}
+
+	urls := []string{"http://a:8080", "socks5://b:1080", "https://c:443", "socks4://d:1080", ""}
+	for i, p := range proxies {
+		if p.URL() != urls[i] {
+			t.Errorf("expected %q for %v, got %q", urls[i], p, p.URL())
+		}
+	}
+}
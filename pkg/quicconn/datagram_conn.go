@@ -0,0 +1,102 @@
+package quicconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// DatagramStream exchanges datagrams, such as the HTTP datagrams (RFC 9297) of an
+// http3.RequestStream or the QUIC datagrams (RFC 9221) of a quic.Conn.
+type DatagramStream interface {
+	SendDatagram(b []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+	Context() context.Context
+}
+
+// DatagramConn exposes the datagrams of an RFC 9298 CONNECT-UDP tunnel as a net.Conn,
+// each Read and Write carrying a single UDP payload with context ID 0. Wrap it with
+// New in EncapRaw mode to feed a QUIC stack.
+//
+// Like on a UDP path, payloads larger than the datagram limit of the underlying
+// connection are dropped by Write, leaving it to QUIC path MTU discovery to adapt.
+type DatagramConn struct {
+	str           DatagramStream
+	ctx           context.Context
+	cancel        context.CancelFunc
+	close         func() error
+	local, remote net.Addr
+	once          sync.Once
+	err           error
+}
+
+// NewDatagram returns a DatagramConn over str. The close function, when not nil, is
+// called once by Close to end the tunnel, for example by closing the request stream.
+func NewDatagram(str DatagramStream, local, remote net.Addr, close func() error) *DatagramConn {
+	ctx, cancel := context.WithCancel(str.Context())
+
+	return &DatagramConn{str: str, ctx: ctx, cancel: cancel, close: close, local: local, remote: remote}
+}
+
+// Read receives the next UDP payload into p, skipping datagrams of other context IDs.
+// It returns io.ErrShortBuffer if the payload does not fit.
+func (c *DatagramConn) Read(p []byte) (int, error) {
+	for {
+		data, err := c.str.ReceiveDatagram(c.ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		// UDP payloads use context ID 0 (RFC 9298, section 4), other contexts are ignored.
+		if len(data) == 0 || data[0] != 0 {
+			continue
+		}
+
+		if len(data)-1 > len(p) {
+			return 0, io.ErrShortBuffer
+		}
+
+		return copy(p, data[1:]), nil
+	}
+}
+
+// Write sends p as a single datagram. Payloads over the datagram limit are dropped
+// and reported as written.
+func (c *DatagramConn) Write(p []byte) (int, error) {
+	datagram := make([]byte, 0, len(p)+1)
+	datagram = append(datagram, 0)
+	datagram = append(datagram, p...)
+
+	if err := c.str.SendDatagram(datagram); err != nil {
+		var tooLarge *quic.DatagramTooLargeError
+		if !errors.As(err, &tooLarge) {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close stops pending reads and calls the close function given to NewDatagram.
+func (c *DatagramConn) Close() error {
+	c.once.Do(func() {
+		c.cancel()
+
+		if c.close != nil {
+			c.err = c.close()
+		}
+	})
+
+	return c.err
+}
+
+func (c *DatagramConn) LocalAddr() net.Addr              { return c.local }
+func (c *DatagramConn) RemoteAddr() net.Addr             { return c.remote }
+func (c *DatagramConn) SetDeadline(time.Time) error      { return nil }
+func (c *DatagramConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *DatagramConn) SetWriteDeadline(time.Time) error { return nil }
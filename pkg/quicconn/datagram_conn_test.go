@@ -0,0 +1,151 @@
+package quicconn_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/enetx/surf/pkg/quicconn"
+	"github.com/quic-go/quic-go"
+)
+
+// quicPair returns both ends of a loopback QUIC connection with datagrams enabled.
+func quicPair(t *testing.T) (client, server *quic.Conn) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &quic.Config{EnableDatagrams: true}
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{"test"},
+	}, cfg)
+	if err != nil {
+		t.Skip("Failed to create QUIC listener:", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err = quic.DialAddr(ctx, ln.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"test"},
+	}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.CloseWithError(0, "") })
+
+	server, err = ln.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, server
+}
+
+func TestDatagramConn_WriteDropsOversizedPayload(t *testing.T) {
+	client, server := quicPair(t)
+
+	conn := quicconn.NewDatagram(client, client.LocalAddr(), client.RemoteAddr(), nil)
+	defer conn.Close()
+
+	// A payload larger than the datagram limit of the path is dropped like on UDP.
+	if n, err := conn.Write(make([]byte, 4000)); err != nil || n != 4000 {
+		t.Fatalf("expected oversized payload to be dropped, got n=%d err=%v", n, err)
+	}
+
+	if _, err := conn.Write([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := server.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "\x00small" {
+		t.Fatalf("expected small payload with context ID 0, got %q", data)
+	}
+}
+
+func TestDatagramConn_ReadSkipsOtherContexts(t *testing.T) {
+	client, server := quicPair(t)
+
+	conn := quicconn.NewDatagram(client, client.LocalAddr(), client.RemoteAddr(), nil)
+	defer conn.Close()
+
+	if err := server.SendDatagram([]byte("\x01other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.SendDatagram([]byte("\x00payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "payload" {
+		t.Fatalf("expected payload of context ID 0, got %q", buf[:n])
+	}
+}
+
+func TestDatagramConn_CloseStopsRead(t *testing.T) {
+	client, _ := quicPair(t)
+
+	closed := 0
+	conn := quicconn.NewDatagram(client, client.LocalAddr(), client.RemoteAddr(), func() error {
+		closed++
+		return nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 64))
+		done <- err
+	}()
+
+	conn.Close()
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected Read to fail after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read not unblocked by Close")
+	}
+
+	if closed != 1 {
+		t.Fatalf("expected close function to be called once, got %d", closed)
+	}
+}
@@ -6,11 +6,16 @@
 // net.Conn. QUIC, however, requires a net.PacketConn. This package bridges the
 // gap by wrapping that net.Conn and implementing net.PacketConn on top.
 //
-// Two encapsulation modes are supported:
-//  1. EncapRaw    — pass raw UDP payloads through; the dialer / proxy stack
+// Three encapsulation modes are supported:
+//  1. EncapRaw        — pass raw UDP payloads through; the dialer / proxy stack
 //     is assumed to take care of any required encapsulation.
-//  2. EncapSocks5 — add / remove RFC 1928 UDP ASSOCIATE headers on the fly,
+//  2. EncapSocks5     — add / remove RFC 1928 UDP ASSOCIATE headers on the fly,
 //     so you can drive a UDP relay directly using a plain net.Conn.
+//  3. EncapConnectUDP — frame datagrams as RFC 9297 DATAGRAM capsules on the
+//     byte stream of an RFC 9298 CONNECT-UDP (MASQUE) request.
+//
+// When the CONNECT-UDP tunnel carries HTTP datagrams instead, DatagramConn exposes
+// them as a net.Conn to wrap in EncapRaw mode.
 //
 // The adapter also exposes optional SetReadBuffer / SetWriteBuffer methods.
 // quic-go detects these via type assertions and will attempt to tune socket
@@ -20,6 +25,7 @@
 package quicconn
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -46,8 +52,21 @@ const (
 	// strips those headers on receive. Use this when you talk to a SOCKS5 UDP
 	// relay over a plain net.Conn and need to perform the encapsulation yourself.
 	EncapSocks5
+
+	// EncapConnectUDP frames datagrams as RFC 9297 DATAGRAM capsules with context
+	// ID 0 on the request stream of an RFC 9298 CONNECT-UDP tunnel. Use this when
+	// the proxy connection is a byte stream, such as an HTTP/2 extended CONNECT
+	// stream or an HTTP/3 stream without HTTP datagram support. Other capsule
+	// types and datagrams for other context IDs are skipped on receive.
+	EncapConnectUDP
 )
 
+// capsuleDatagram is the RFC 9297 DATAGRAM capsule type.
+const capsuleDatagram = 0x00
+
+// maxCapsuleDatagram bounds the size of a received DATAGRAM capsule.
+const maxCapsuleDatagram = 64 * 1024
+
 // QuicPacketConn adapts a stream-like net.Conn into a packet-oriented
 // net.PacketConn suitable for QUIC. When mode is EncapSocks5, it performs
 // RFC 1928 UDP encapsulation/decapsulation on the fly.
@@ -61,6 +80,7 @@ type QuicPacketConn struct {
 	defaultTarget *net.UDPAddr
 	readBuf       []byte
 	writeBuf      []byte
+	stream        *bufio.Reader // capsule stream reader in EncapConnectUDP mode
 
 	rmu sync.Mutex // guards readBuf and ReadFrom
 	wmu sync.Mutex // guards writeBuf and WriteTo
@@ -75,11 +95,12 @@ var _ net.PacketConn = (*QuicPacketConn)(nil)
 //   - conn: a connected transport (e.g., a UDP-capable proxy connection).
 //   - defaultTarget: default peer address used when WriteTo is called with addr == nil,
 //     and used as a fallback source address in ReadFrom when the relay does not supply one.
-//   - mode: encapsulation mode (EncapRaw or EncapSocks5).
+//   - mode: encapsulation mode (EncapRaw, EncapSocks5 or EncapConnectUDP).
 //
 // Default target requirements:
-//   - EncapRaw: defaultTarget MUST be non-nil. If it is nil, New panics. In raw mode the
-//     adapter cannot infer a UDP peer address from a generic net.Conn.
+//   - EncapRaw, EncapConnectUDP: defaultTarget MUST be non-nil. If it is nil, New panics.
+//     The adapter cannot infer a UDP peer address from a generic net.Conn, and a
+//     CONNECT-UDP tunnel is bound to a single target.
 //   - EncapSocks5: defaultTarget may be nil because the SOCKS5 UDP header carries the peer
 //     address. Providing a non-nil defaultTarget is still recommended as a fallback for
 //     relays that might strip the header.
@@ -87,17 +108,23 @@ var _ net.PacketConn = (*QuicPacketConn)(nil)
 // The returned value implements net.PacketConn and can be passed to QUIC dialers
 // (e.g., quic-go's quic.Dial).
 func New(conn net.Conn, defaultTarget *net.UDPAddr, mode EncapMode) *QuicPacketConn {
-	if defaultTarget == nil && mode == EncapRaw {
-		panic("quicconn: defaultTarget is required for QUIC/UDP in EncapRaw mode")
+	if defaultTarget == nil && mode != EncapSocks5 {
+		panic("quicconn: defaultTarget is required for QUIC/UDP in EncapRaw and EncapConnectUDP modes")
 	}
 
-	return &QuicPacketConn{
+	q := &QuicPacketConn{
 		conn:          conn,
 		mode:          mode,
 		defaultTarget: defaultTarget,
 		readBuf:       make([]byte, 64*1024),
 		writeBuf:      make([]byte, 0, 1500),
 	}
+
+	if mode == EncapConnectUDP {
+		q.stream = bufio.NewReader(conn)
+	}
+
+	return q
 }
 
 // SetReadBuffer optionally sets the receive buffer size if the underlying
@@ -138,10 +165,15 @@ func (q *QuicPacketConn) SetWriteBuffer(n int) error {
 // the source address, and any error encountered. In EncapSocks5 mode, it
 // removes the RFC 1928 header and returns the payload with decoded source
 // address. If no header is present, the packet is treated as raw.
+// In EncapConnectUDP mode, it reads the next DATAGRAM capsule from the stream.
 func (q *QuicPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
 	q.rmu.Lock()
 	defer q.rmu.Unlock()
 
+	if q.mode == EncapConnectUDP {
+		return q.readCapsule(p)
+	}
+
 	// Calculate required readBuf size
 	need := len(p)
 	if q.mode == EncapSocks5 {
@@ -206,6 +238,8 @@ func (q *QuicPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
 
 // WriteTo writes datagram p to addr. If addr is nil, defaultTarget is used.
 // In EncapSocks5 mode, the datagram is wrapped in an RFC 1928 UDP header.
+// In EncapConnectUDP mode, it is sent as a DATAGRAM capsule; the tunnel is
+// bound to its target, so addr is only validated.
 // In EncapRaw mode, p is forwarded as-is.
 func (q *QuicPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
 	q.wmu.Lock()
@@ -225,8 +259,18 @@ func (q *QuicPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
 		return 0, ErrDefaultTargetRequired
 	}
 
-	if q.mode == EncapSocks5 {
-		hdr, err := buildSocks5UDP(dst)
+	if q.mode == EncapSocks5 || q.mode == EncapConnectUDP {
+		var (
+			hdr []byte
+			err error
+		)
+
+		if q.mode == EncapSocks5 {
+			hdr, err = buildSocks5UDP(dst)
+		} else {
+			hdr = buildCapsuleHeader(len(p))
+		}
+
 		if err != nil {
 			return 0, err
 		}
@@ -294,6 +338,133 @@ func (q *QuicPacketConn) SetWriteDeadline(t time.Time) error {
 	return q.conn.SetWriteDeadline(t)
 }
 
+// readCapsule reads capsules from the stream until a DATAGRAM capsule for
+// context ID 0 arrives and copies its payload into p.
+func (q *QuicPacketConn) readCapsule(p []byte) (int, net.Addr, error) {
+	for {
+		typ, err := readVarint(q.stream)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length, err := readVarint(q.stream)
+		if err != nil {
+			return 0, nil, noEOF(err)
+		}
+
+		if typ != capsuleDatagram {
+			if _, err := io.CopyN(io.Discard, q.stream, int64(length)); err != nil {
+				return 0, nil, noEOF(err)
+			}
+
+			continue
+		}
+
+		if length > maxCapsuleDatagram {
+			return 0, nil, errors.New("DATAGRAM capsule too large")
+		}
+
+		data := q.readBuf[:length]
+		if _, err := io.ReadFull(q.stream, data); err != nil {
+			return 0, nil, noEOF(err)
+		}
+
+		contextID, n, ok := parseVarint(data)
+		if !ok {
+			return 0, nil, errors.New("malformed DATAGRAM capsule")
+		}
+
+		// Only context ID 0 carries UDP payloads (RFC 9298, section 4).
+		if contextID != 0 {
+			continue
+		}
+
+		payload := data[n:]
+		if len(payload) > len(p) {
+			return 0, nil, errors.New("buffer too small for DATAGRAM capsule payload")
+		}
+
+		copy(p, payload)
+
+		return len(payload), q.defaultTarget, nil
+	}
+}
+
+// buildCapsuleHeader returns the DATAGRAM capsule header, including the
+// zero context ID, for a UDP payload of size n.
+func buildCapsuleHeader(n int) []byte {
+	h := make([]byte, 0, 10)
+	h = appendVarint(h, capsuleDatagram)
+	h = appendVarint(h, uint64(n)+1) // context ID + payload
+	h = appendVarint(h, 0)
+
+	return h
+}
+
+// appendVarint appends v as a QUIC variable-length integer (RFC 9000, section 16).
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, uint16(v)|0x4000)
+	case v < 1<<30:
+		return binary.BigEndian.AppendUint32(b, uint32(v)|0x80000000)
+	default:
+		return binary.BigEndian.AppendUint64(b, v|0xc000000000000000)
+	}
+}
+
+// parseVarint decodes a QUIC variable-length integer from the start of b and
+// returns its value and encoded length.
+func parseVarint(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0, false
+	}
+
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, n, true
+}
+
+// readVarint reads a QUIC variable-length integer from r.
+func readVarint(r *bufio.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	v := uint64(first & 0x3f)
+
+	for range 1<<(first>>6) - 1 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+
+		v = v<<8 | uint64(c)
+	}
+
+	return v, nil
+}
+
+// noEOF reports a stream ending inside a capsule as io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
 // buildSocks5UDP constructs an RFC 1928 UDP ASSOCIATE header for the given
 // destination address. Only literal IP destinations are supported; domain
 // names must be resolved by the caller.
@@ -462,3 +462,92 @@ func TestSetReadBufferAndSetWriteBufferWithUnsupportedConn(t *testing.T) {
 		t.Errorf("SetWriteBuffer with unsupported connection should not fail: %v", err)
 	}
 }
+
+func TestNew_EncapConnectUDP_PanicsOnNilDefaultTarget(t *testing.T) {
+	t.Parallel()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("expected panic when defaultTarget is nil in EncapConnectUDP mode")
+		}
+	}()
+	_ = quicconn.New(&mockConn{}, nil, quicconn.EncapConnectUDP)
+}
+
+func TestWriteTo_ConnectUDP_WrapsCapsule(t *testing.T) {
+	t.Parallel()
+	mc := &mockConn{}
+	dst := &net.UDPAddr{IP: net.IPv4(9, 9, 9, 9), Port: 443}
+	pc := quicconn.New(mc, dst, quicconn.EncapConnectUDP)
+
+	payload := bytes.Repeat([]byte{0xab}, 100)
+	n, err := pc.WriteTo(payload, nil)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if n != len(payload) {
+		t.Fatalf("unexpected n: got %d want %d", n, len(payload))
+	}
+
+	// Type 0x00, two-byte varint length 101, context ID 0x00.
+	want := append([]byte{0x00, 0x40, 101, 0x00}, payload...)
+	if !bytes.Equal(mc.writeData.Bytes(), want) {
+		t.Fatalf("capsule mismatch: got % x", mc.writeData.Bytes()[:8])
+	}
+}
+
+func TestReadFrom_ConnectUDP_SkipsUnknownCapsules(t *testing.T) {
+	t.Parallel()
+	var stream []byte
+	stream = append(stream, 0x40, 0x29, 0x03, 'x', 'y', 'z') // unknown capsule type 0x29
+	stream = append(stream, 0x00, 0x03, 0x02, 'n', 'o')      // DATAGRAM for context ID 2
+	stream = append(stream, 0x00, 0x06, 0x00, 'h', 'e', 'l', 'l', 'o')
+	stream = append(stream, 0x00, 0x03) // truncated capsule
+
+	dst := &net.UDPAddr{IP: net.IPv4(9, 9, 9, 9), Port: 443}
+	pc := quicconn.New(&mockConn{readData: stream}, dst, quicconn.EncapConnectUDP)
+
+	buf := make([]byte, 64)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v", err)
+	}
+	if string(buf[:n]) != "hello" || addr.String() != dst.String() {
+		t.Fatalf("unexpected datagram %q from %s", buf[:n], addr)
+	}
+
+	if _, _, err := pc.ReadFrom(buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for truncated capsule, got %v", err)
+	}
+}
+
+func TestConnectUDP_RoundTrip(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}
+	cpc := quicconn.New(client, dst, quicconn.EncapConnectUDP)
+	spc := quicconn.New(server, dst, quicconn.EncapConnectUDP)
+
+	sizes := []int{0, 1, 63, 64, 1200, 16383, 16384}
+
+	go func() {
+		for _, size := range sizes {
+			if _, err := cpc.WriteTo(bytes.Repeat([]byte{byte(size)}, size), nil); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for _, size := range sizes {
+		n, _, err := spc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom error: %v", err)
+		}
+		if !bytes.Equal(buf[:n], bytes.Repeat([]byte{byte(size)}, size)) {
+			t.Fatalf("payload mismatch for size %d: got %d bytes", size, n)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxWindow is the size of the LZ77 sliding window used by compress/flate.
+	maxWindow = 1 << 15
+
+	// deflateTail is the empty stored block every compressed message ends with (RFC 7692, section 7.2.1).
+	deflateTail = "\x00\x00\xff\xff"
+
+	// deflateFinal terminates the stream so the decompressor reports io.EOF at the message end.
+	deflateFinal = deflateTail + "\x01\x00\x00\xff\xff"
+)
+
+// String renders the parameters in Sec-WebSocket-Extensions format.
+func (d Deflate) String() string {
+	var b strings.Builder
+
+	b.WriteString("permessage-deflate")
+
+	if d.ServerNoContextTakeover {
+		b.WriteString("; server_no_context_takeover")
+	}
+
+	if d.ClientNoContextTakeover {
+		b.WriteString("; client_no_context_takeover")
+	}
+
+	if d.ServerMaxWindowBits != 0 {
+		b.WriteString("; server_max_window_bits=" + strconv.Itoa(d.ServerMaxWindowBits))
+	}
+
+	if d.ClientMaxWindowBits != 0 {
+		b.WriteString("; client_max_window_bits=" + strconv.Itoa(d.ClientMaxWindowBits))
+	}
+
+	return b.String()
+}
+
+// compressor implements the sending side of permessage-deflate.
+// With context takeover the flate.Writer keeps its history between messages.
+type compressor struct {
+	fw       *flate.Writer
+	buf      bytes.Buffer
+	takeover bool
+	limit    int // messages larger than limit are sent uncompressed, 0 means no limit
+}
+
+// newCompressor creates a compressor. maxBits is the negotiated window size for our side.
+// compress/flate always uses a 32 KiB window, so a smaller negotiated window disables
+// context takeover and compression of messages that do not fit into that window.
+func newCompressor(takeover bool, maxBits int) *compressor {
+	c := &compressor{takeover: takeover}
+
+	if maxBits != 0 && maxBits < 15 {
+		c.takeover = false
+		c.limit = 1 << maxBits
+	}
+
+	c.fw, _ = flate.NewWriter(&c.buf, flate.BestSpeed)
+
+	return c
+}
+
+// compress returns the compressed payload of p. The boolean result is false when the
+// message must be sent uncompressed.
+func (c *compressor) compress(p []byte) ([]byte, bool, error) {
+	if c.limit != 0 && len(p) > c.limit {
+		return p, false, nil
+	}
+
+	c.buf.Reset()
+
+	if _, err := c.fw.Write(p); err != nil {
+		return nil, false, err
+	}
+
+	if err := c.fw.Flush(); err != nil {
+		return nil, false, err
+	}
+
+	out := bytes.TrimSuffix(c.buf.Bytes(), []byte(deflateTail))
+
+	if !c.takeover {
+		c.fw.Reset(&c.buf)
+	}
+
+	return out, true, nil
+}
+
+// decompressor implements the receiving side of permessage-deflate.
+// Context takeover is supported by priming each message with the last 32 KiB of output.
+type decompressor struct {
+	dict     []byte
+	takeover bool
+}
+
+// decompress inflates a complete message payload, reading at most limit bytes when limit >= 0.
+func (d *decompressor) decompress(p []byte, limit int64) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(p), strings.NewReader(deflateFinal))
+	fr := flate.NewReaderDict(src, d.dict)
+	defer fr.Close()
+
+	var r io.Reader = fr
+	if limit >= 0 {
+		r = io.LimitReader(fr, limit+1)
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit >= 0 && int64(len(out)) > limit {
+		return nil, ErrReadLimit
+	}
+
+	if d.takeover {
+		d.dict = append(d.dict, out...)
+		if len(d.dict) > maxWindow {
+			d.dict = append(d.dict[:0], d.dict[len(d.dict)-maxWindow:]...)
+		}
+	}
+
+	return out, nil
+}
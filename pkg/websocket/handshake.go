@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// acceptGUID is the fixed GUID appended to Sec-WebSocket-Key (RFC 6455, section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Version is the only protocol version accepted by RFC 6455 servers.
+const Version = "13"
+
+// Browser extension offers as sent in the Sec-WebSocket-Extensions request header.
+const (
+	// ChromeExtensions is the permessage-deflate offer sent by Chromium based browsers.
+	ChromeExtensions = "permessage-deflate; client_max_window_bits"
+
+	// FirefoxExtensions is the permessage-deflate offer sent by Firefox.
+	FirefoxExtensions = "permessage-deflate"
+)
+
+// NewKey returns a random, base64 encoded 16-byte nonce suitable for the
+// Sec-WebSocket-Key request header.
+func NewKey() string {
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+
+	return base64.StdEncoding.EncodeToString(nonce[:])
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value the server must return
+// for the given Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Deflate holds the negotiated permessage-deflate parameters (RFC 7692).
+type Deflate struct {
+	ServerNoContextTakeover bool // Server resets its compression context after each message
+	ClientNoContextTakeover bool // Client must reset its compression context after each message
+	ServerMaxWindowBits     int  // LZ77 window used by the server (8-15, 0 means 15)
+	ClientMaxWindowBits     int  // LZ77 window the client may use (8-15, 0 means 15)
+}
+
+// ParseDeflate parses a Sec-WebSocket-Extensions response header and returns the accepted
+// permessage-deflate parameters. The boolean result reports whether the extension was accepted.
+// Any other extension in the response is treated as an error, since the client never offered it.
+func ParseDeflate(header string) (Deflate, bool, error) {
+	var (
+		params   Deflate
+		accepted bool
+	)
+
+	if strings.TrimSpace(header) == "" {
+		return params, false, nil
+	}
+
+	for ext := range strings.SplitSeq(header, ",") {
+		parts := strings.Split(ext, ";")
+
+		name := strings.TrimSpace(parts[0])
+		if name != "permessage-deflate" {
+			return params, false, fmt.Errorf("websocket: server accepted unsupported extension %q", name)
+		}
+
+		if accepted {
+			return params, false, fmt.Errorf("websocket: duplicate permessage-deflate extension")
+		}
+
+		accepted = true
+
+		for _, param := range parts[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			switch strings.TrimSpace(key) {
+			case "server_no_context_takeover":
+				params.ServerNoContextTakeover = true
+			case "client_no_context_takeover":
+				params.ClientNoContextTakeover = true
+			case "server_max_window_bits":
+				bits, err := parseWindowBits(value)
+				if err != nil {
+					return params, false, err
+				}
+				params.ServerMaxWindowBits = bits
+			case "client_max_window_bits":
+				bits, err := parseWindowBits(value)
+				if err != nil {
+					return params, false, err
+				}
+				params.ClientMaxWindowBits = bits
+			default:
+				return params, false, fmt.Errorf("websocket: unknown permessage-deflate parameter %q", key)
+			}
+		}
+	}
+
+	return params, accepted, nil
+}
+
+// parseWindowBits validates an LZ77 window size parameter.
+func parseWindowBits(value string) (int, error) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 8 || bits > 15 {
+		return 0, fmt.Errorf("websocket: invalid window bits %q", value)
+	}
+
+	return bits, nil
+}
@@ -0,0 +1,590 @@
+// Package websocket implements a message-oriented WebSocket connection (RFC 6455)
+// with permessage-deflate compression (RFC 7692).
+//
+// The package only deals with framing; the opening handshake is performed by the
+// caller (for example surf.Request.Upgrade), which hands over the upgraded stream.
+// The same framing is used for HTTP/1.1 upgrades and for WebSockets bootstrapped
+// with extended CONNECT over HTTP/2 (RFC 8441) and HTTP/3 (RFC 9220).
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType identifies the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1 // UTF-8 encoded text message
+	BinaryMessage MessageType = 2 // Binary message
+)
+
+// Frame opcodes (RFC 6455, section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Frame header bits.
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+)
+
+// Close status codes (RFC 6455, section 7.4.1).
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+// maxControlPayload is the largest payload allowed in a control frame.
+const maxControlPayload = 125
+
+// DefaultReadLimit is the maximum size in bytes of a message read from the peer, after
+// decompression, until SetReadLimit is called.
+const DefaultReadLimit = 32 << 20
+
+// payloadChunk is the largest buffer allocated for a frame payload before its bytes arrive.
+const payloadChunk = 64 << 10
+
+var (
+	// ErrReadLimit is returned when a message exceeds the configured read limit.
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+
+	// ErrCloseSent is returned when writing after a close frame has been sent.
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// CloseError is returned by ReadMessage when the peer sends a close frame.
+type CloseError struct {
+	Code int    // Close status code, CloseNoStatusReceived if the frame had no payload
+	Text string // Optional close reason
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// ProtocolError reports a framing violation by the peer.
+type ProtocolError struct{ Msg string }
+
+func (e *ProtocolError) Error() string { return "websocket: protocol error: " + e.Msg }
+
+// Options configures a Conn.
+type Options struct {
+	// Server selects the server role: incoming frames must be masked and outgoing frames are not.
+	Server bool
+
+	// Deflate holds the negotiated permessage-deflate parameters, nil when the extension is not used.
+	Deflate *Deflate
+
+	// Subprotocol is the negotiated Sec-WebSocket-Protocol value.
+	Subprotocol string
+
+	// Reader optionally replaces the buffered reader created over the stream, allowing
+	// bytes already buffered during the handshake to be consumed.
+	Reader *bufio.Reader
+}
+
+// Conn is a message-oriented WebSocket connection.
+//
+// ReadMessage must be called from a single goroutine. Write methods are safe for
+// concurrent use; control frames may be interleaved between the fragments of a data message.
+type Conn struct {
+	rwc         io.ReadWriteCloser
+	br          *bufio.Reader
+	compressor  *compressor
+	decompress  *decompressor
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+	subprotocol string
+	readLimit   int64
+	fragment    int
+	server      bool
+
+	msgMu     sync.Mutex // serializes data messages
+	wmu       sync.Mutex // serializes frames
+	closeOnce sync.Once
+	closeSent bool
+	closeErr  error
+}
+
+// NewConn wraps an upgraded stream into a Conn.
+func NewConn(rwc io.ReadWriteCloser, opts Options) *Conn {
+	c := &Conn{
+		rwc:         rwc,
+		br:          opts.Reader,
+		subprotocol: opts.Subprotocol,
+		server:      opts.Server,
+		readLimit:   DefaultReadLimit,
+	}
+
+	if c.br == nil {
+		c.br = bufio.NewReader(rwc)
+	}
+
+	if opts.Deflate != nil {
+		d := opts.Deflate
+
+		if c.server {
+			c.compressor = newCompressor(!d.ServerNoContextTakeover, d.ServerMaxWindowBits)
+			c.decompress = &decompressor{takeover: !d.ClientNoContextTakeover}
+		} else {
+			c.compressor = newCompressor(!d.ClientNoContextTakeover, d.ClientMaxWindowBits)
+			c.decompress = &decompressor{takeover: !d.ServerNoContextTakeover}
+		}
+	}
+
+	c.pingHandler = func(data []byte) error { return c.writeControl(opPong, data) }
+	c.pongHandler = func([]byte) error { return nil }
+
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool { return c.compressor != nil }
+
+// SetReadLimit sets the maximum size in bytes of a message read from the peer, after
+// decompression. DefaultReadLimit applies until it is called; -1 means no limit.
+func (c *Conn) SetReadLimit(limit int64) { c.readLimit = limit }
+
+// SetFragmentSize sets the maximum payload size of outgoing data frames.
+// Larger messages are split into continuation frames. Zero (the default) disables fragmentation.
+func (c *Conn) SetFragmentSize(size int) { c.fragment = size }
+
+// SetPingHandler sets the handler for ping frames. The default handler replies with a pong.
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	if h == nil {
+		h = func(data []byte) error { return c.writeControl(opPong, data) }
+	}
+
+	c.pingHandler = h
+}
+
+// SetPongHandler sets the handler for pong frames. The default handler does nothing.
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	if h == nil {
+		h = func([]byte) error { return nil }
+	}
+
+	c.pongHandler = h
+}
+
+// SetDeadline sets read and write deadlines if the underlying stream supports them.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+
+	return nil
+}
+
+// SetReadDeadline sets the read deadline if the underlying stream supports it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline if the underlying stream supports it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.rwc.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+
+	return nil
+}
+
+// Ping sends a ping frame with optional application data (at most 125 bytes).
+func (c *Conn) Ping(data []byte) error { return c.writeControl(opPing, data) }
+
+// Pong sends an unsolicited pong frame.
+func (c *Conn) Pong(data []byte) error { return c.writeControl(opPong, data) }
+
+// WriteText sends a text message.
+func (c *Conn) WriteText(text string) error { return c.WriteMessage(TextMessage, []byte(text)) }
+
+// WriteBinary sends a binary message.
+func (c *Conn) WriteBinary(data []byte) error { return c.WriteMessage(BinaryMessage, data) }
+
+// WriteMessage sends a data message, compressing and fragmenting it as configured.
+func (c *Conn) WriteMessage(mt MessageType, data []byte) error {
+	if mt != TextMessage && mt != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", mt)
+	}
+
+	c.msgMu.Lock()
+	defer c.msgMu.Unlock()
+
+	var rsv byte
+
+	if c.compressor != nil {
+		compressed, ok, err := c.compressor.compress(data)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			data, rsv = compressed, rsv1Bit
+		}
+	}
+
+	opcode := byte(mt)
+
+	for {
+		chunk := data
+		if c.fragment > 0 && len(chunk) > c.fragment {
+			chunk = chunk[:c.fragment]
+		}
+
+		data = data[len(chunk):]
+		fin := len(data) == 0
+
+		if err := c.writeFrame(fin, rsv, opcode, chunk); err != nil {
+			return err
+		}
+
+		if fin {
+			return nil
+		}
+
+		opcode, rsv = opContinuation, 0
+	}
+}
+
+// Close sends a normal closure frame and closes the underlying stream.
+func (c *Conn) Close() error { return c.CloseWithCode(CloseNormalClosure, "") }
+
+// CloseWithCode sends a close frame with the given status code and reason, then closes
+// the underlying stream. Calling it more than once returns the result of the first call.
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	c.closeOnce.Do(func() {
+		err := c.sendClose(code, reason)
+		if cerr := c.rwc.Close(); err == nil || errors.Is(err, ErrCloseSent) {
+			err = cerr
+		}
+
+		c.closeErr = err
+	})
+
+	return c.closeErr
+}
+
+// sendClose writes a close frame unless one was already sent.
+func (c *Conn) sendClose(code int, reason string) error {
+	var payload []byte
+
+	if code != CloseNoStatusReceived {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+
+	return c.writeControl(opClose, payload)
+}
+
+// writeControl writes a single control frame.
+func (c *Conn) writeControl(opcode byte, data []byte) error {
+	if len(data) > maxControlPayload {
+		return &ProtocolError{"control frame payload too large"}
+	}
+
+	return c.writeFrame(true, 0, opcode, data)
+}
+
+// writeFrame encodes and writes one frame, masking the payload in the client role.
+func (c *Conn) writeFrame(fin bool, rsv, opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	b0 := rsv | opcode
+	if fin {
+		b0 |= finBit
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, b0)
+
+	var mask byte
+	if !c.server {
+		mask = maskBit
+	}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, mask|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, mask|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, mask|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.server {
+		frame = append(frame, payload...)
+	} else {
+		var key [4]byte
+		_, _ = rand.Read(key[:])
+
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[start:])
+	}
+
+	_, err := c.rwc.Write(frame)
+
+	return err
+}
+
+// frame is a decoded frame header with its payload.
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads one frame from the peer.
+func (c *Conn) readFrame() (*frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    hdr[0]&finBit != 0,
+		rsv1:   hdr[0]&rsv1Bit != 0,
+		opcode: hdr[0] & 0x0F,
+	}
+
+	if hdr[0]&(rsv2Bit|rsv3Bit) != 0 || (f.rsv1 && c.decompress == nil) {
+		return nil, c.fail(CloseProtocolError, "unexpected reserved bits")
+	}
+
+	masked := hdr[1]&maskBit != 0
+	if masked != c.server {
+		return nil, c.fail(CloseProtocolError, "invalid frame masking")
+	}
+
+	length := uint64(hdr[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+
+		if length>>63 != 0 {
+			return nil, c.fail(CloseProtocolError, "invalid payload length")
+		}
+	}
+
+	if f.opcode >= opClose {
+		if !f.fin || length > maxControlPayload {
+			return nil, c.fail(CloseProtocolError, "invalid control frame")
+		}
+
+		if f.rsv1 {
+			return nil, c.fail(CloseProtocolError, "compressed control frame")
+		}
+	}
+
+	if c.readLimit >= 0 && length > uint64(c.readLimit) {
+		return nil, c.fail(CloseMessageTooBig, ErrReadLimit.Error())
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// The payload grows as its bytes arrive, a length announced by the peer is not allocated up front.
+	var payload bytes.Buffer
+	payload.Grow(int(min(length, payloadChunk)))
+
+	if _, err := io.CopyN(&payload, c.br, int64(length)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	f.payload = payload.Bytes()
+
+	if masked {
+		maskBytes(key, f.payload)
+	}
+
+	return f, nil
+}
+
+// ReadMessage reads the next data message. Control frames received in between are
+// dispatched to the ping and pong handlers. When the peer closes the connection,
+// the close frame is echoed and a *CloseError is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		mt         MessageType
+		message    []byte
+		compressed bool
+	)
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.pingHandler(f.payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if err := c.pongHandler(f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if mt != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+
+			mt = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if mt == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+
+			if f.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "RSV1 set on continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+		}
+
+		message = append(message, f.payload...)
+
+		if c.readLimit >= 0 && int64(len(message)) > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, ErrReadLimit.Error())
+		}
+
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = c.decompress.decompress(message, c.readLimit)
+			if errors.Is(err, ErrReadLimit) {
+				return 0, nil, c.fail(CloseMessageTooBig, err.Error())
+			}
+
+			if err != nil {
+				return 0, nil, c.fail(CloseInvalidFramePayloadData, err.Error())
+			}
+		}
+
+		if mt == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in text message")
+		}
+
+		return mt, message, nil
+	}
+}
+
+// handleClose processes a close frame from the peer and echoes it back.
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatusReceived}
+
+	switch {
+	case len(payload) == 1:
+		_ = c.fail(CloseProtocolError, "invalid close payload")
+		return &ProtocolError{"invalid close payload"}
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+
+		if !utf8.ValidString(ce.Text) {
+			_ = c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in close reason")
+			return &ProtocolError{"invalid UTF-8 in close reason"}
+		}
+	}
+
+	_ = c.sendClose(ce.Code, "")
+
+	return ce
+}
+
+// fail sends a close frame with the given code and returns a ProtocolError.
+func (c *Conn) fail(code int, msg string) error {
+	_ = c.sendClose(code, "")
+	return &ProtocolError{msg}
+}
+
+// maskBytes applies the RFC 6455 masking algorithm in place.
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
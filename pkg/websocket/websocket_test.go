@@ -0,0 +1,345 @@
+package websocket_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/enetx/surf/pkg/websocket"
+)
+
+// pair returns a connected client and server over a loopback TCP connection.
+func pair(t *testing.T, deflate *websocket.Deflate) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+
+	client := websocket.NewConn(c, websocket.Options{Deflate: deflate})
+	server := websocket.NewConn(s, websocket.Options{Server: true, Deflate: deflate})
+
+	return client, server
+}
+
+// echo reads messages from conn and writes them back until an error occurs.
+func echo(conn *websocket.Conn) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+
+			if err := conn.WriteMessage(mt, data); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+func TestAcceptKey(t *testing.T) {
+	t.Parallel()
+
+	// Example from RFC 6455, section 1.3.
+	if got := websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key: %s", got)
+	}
+
+	if a, b := websocket.NewKey(), websocket.NewKey(); a == b || len(a) != 24 {
+		t.Fatalf("expected random 24 byte keys, got %q and %q", a, b)
+	}
+}
+
+func TestParseDeflate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header   string
+		want     websocket.Deflate
+		accepted bool
+		err      bool
+	}{
+		{"", websocket.Deflate{}, false, false},
+		{"permessage-deflate", websocket.Deflate{}, true, false},
+		{
+			"permessage-deflate; server_no_context_takeover; client_max_window_bits=10",
+			websocket.Deflate{ServerNoContextTakeover: true, ClientMaxWindowBits: 10},
+			true,
+			false,
+		},
+		{`permessage-deflate; server_max_window_bits="12"`, websocket.Deflate{ServerMaxWindowBits: 12}, true, false},
+		{"permessage-deflate; server_max_window_bits=7", websocket.Deflate{}, false, true},
+		{"permessage-deflate; unknown", websocket.Deflate{}, false, true},
+		{"x-webkit-deflate-frame", websocket.Deflate{}, false, true},
+		{"permessage-deflate, permessage-deflate", websocket.Deflate{}, false, true},
+	}
+
+	for _, tt := range tests {
+		got, accepted, err := websocket.ParseDeflate(tt.header)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseDeflate(%q) error = %v, want error %v", tt.header, err, tt.err)
+			continue
+		}
+
+		if tt.err {
+			continue
+		}
+
+		if got != tt.want || accepted != tt.accepted {
+			t.Errorf("ParseDeflate(%q) = %+v, %v, want %+v, %v", tt.header, got, accepted, tt.want, tt.accepted)
+		}
+	}
+}
+
+func TestDeflateString(t *testing.T) {
+	t.Parallel()
+
+	d := websocket.Deflate{ClientNoContextTakeover: true, ServerMaxWindowBits: 10}
+	if got := d.String(); got != "permessage-deflate; client_no_context_takeover; server_max_window_bits=10" {
+		t.Fatalf("unexpected extension string: %s", got)
+	}
+}
+
+func TestConnEcho(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		deflate *websocket.Deflate
+	}{
+		{"plain", nil},
+		{"deflate", &websocket.Deflate{}},
+		{"deflate no context takeover", &websocket.Deflate{ServerNoContextTakeover: true, ClientNoContextTakeover: true}},
+		{"deflate small window", &websocket.Deflate{ClientMaxWindowBits: 9, ServerMaxWindowBits: 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, server := pair(t, tt.deflate)
+			done := echo(server)
+
+			if client.Compressed() != (tt.deflate != nil) {
+				t.Fatalf("Compressed() = %v", client.Compressed())
+			}
+
+			messages := [][]byte{
+				[]byte("hello"),
+				[]byte(strings.Repeat("surf ", 1000)),
+				[]byte(strings.Repeat("surf ", 1000)),
+				{},
+			}
+
+			for _, msg := range messages {
+				if err := client.WriteBinary(msg); err != nil {
+					t.Fatal(err)
+				}
+
+				mt, data, err := client.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if mt != websocket.BinaryMessage || !bytes.Equal(data, msg) {
+					t.Fatalf("unexpected echo: type %d, %d bytes", mt, len(data))
+				}
+			}
+
+			client.Close()
+
+			var ce *websocket.CloseError
+			if err := <-done; !errors.As(err, &ce) || ce.Code != websocket.CloseNormalClosure {
+				t.Fatalf("expected normal closure, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConnFragmentation(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, nil)
+	done := echo(server)
+
+	client.SetFragmentSize(7)
+	server.SetFragmentSize(3)
+
+	text := "fragmented message with ünïcödé"
+
+	if err := client.WriteText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	mt, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mt != websocket.TextMessage || string(data) != text {
+		t.Fatalf("unexpected echo: type %d, %q", mt, data)
+	}
+
+	client.CloseWithCode(websocket.CloseGoingAway, "bye")
+
+	var ce *websocket.CloseError
+	if err := <-done; !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("expected going away closure, got %v", err)
+	}
+}
+
+func TestConnPingPong(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, nil)
+	done := echo(server)
+
+	pong := make(chan string, 1)
+	client.SetPongHandler(func(data []byte) error {
+		pong <- string(data)
+		return nil
+	})
+
+	if err := client.Ping([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.WriteText("after ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "after ping" {
+		t.Fatalf("unexpected message %q: %v", data, err)
+	}
+
+	if got := <-pong; got != "ping" {
+		t.Fatalf("unexpected pong payload %q", got)
+	}
+
+	if err := client.Ping(make([]byte, 126)); err == nil {
+		t.Fatal("expected error for oversized control frame")
+	}
+
+	client.Close()
+	<-done
+}
+
+func TestConnReadLimit(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, nil)
+	server.SetReadLimit(16)
+
+	go client.WriteText(strings.Repeat("x", 32))
+
+	_, _, err := server.ReadMessage()
+
+	var pe *websocket.ProtocolError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+
+	_, _, err = client.ReadMessage()
+
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseMessageTooBig {
+		t.Fatalf("expected message too big closure, got %v", err)
+	}
+}
+
+func TestConnFrameLength(t *testing.T) {
+	t.Parallel()
+
+	for _, length := range [][]byte{
+		{0x80, 0, 0, 0, 0, 0, 0, 0}, // Most significant bit set
+		{0, 0, 0x01, 0, 0, 0, 0, 0}, // Beyond the default read limit
+	} {
+		c, s := net.Pipe()
+		t.Cleanup(func() { c.Close(); s.Close() })
+
+		client := websocket.NewConn(c, websocket.Options{})
+
+		// A server announcing a huge payload neither crashes the client nor makes it allocate the payload.
+		go func() {
+			s.Write(append([]byte{0x82, 127}, length...))
+			io.Copy(io.Discard, s)
+		}()
+
+		var pe *websocket.ProtocolError
+		if _, _, err := client.ReadMessage(); !errors.As(err, &pe) {
+			t.Fatalf("length %x: expected protocol error, got %v", length, err)
+		}
+	}
+}
+
+func TestConnDefaultReadLimit(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, &websocket.Deflate{})
+
+	go server.WriteBinary(make([]byte, websocket.DefaultReadLimit+1))
+
+	var pe *websocket.ProtocolError
+	if _, _, err := client.ReadMessage(); !errors.As(err, &pe) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+}
+
+func TestConnInvalidUTF8(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, nil)
+
+	go client.WriteMessage(websocket.TextMessage, []byte{0xff, 0xfe})
+
+	var pe *websocket.ProtocolError
+	if _, _, err := server.ReadMessage(); !errors.As(err, &pe) {
+		t.Fatalf("expected protocol error, got %v", err)
+	}
+
+	var ce *websocket.CloseError
+	if _, _, err := client.ReadMessage(); !errors.As(err, &ce) || ce.Code != websocket.CloseInvalidFramePayloadData {
+		t.Fatalf("expected invalid payload closure, got %v", err)
+	}
+}
+
+func TestConnWriteAfterClose(t *testing.T) {
+	t.Parallel()
+
+	client, server := pair(t, nil)
+	done := echo(server)
+
+	client.Close()
+	<-done
+
+	if err := client.WriteText("late"); !errors.Is(err, websocket.ErrCloseSent) {
+		t.Fatalf("expected ErrCloseSent, got %v", err)
+	}
+}
@@ -182,6 +182,24 @@ var headerOrder = g.Map[string, g.Slice[string]]{
 		header.COOKIE,
 		header.PRIORITY,
 	},
+
+	"websocket": {
+		header.HOST,
+		header.CONNECTION,
+		header.PRAGMA,
+		header.CACHE_CONTROL,
+		header.AUTHORIZATION,
+		header.USER_AGENT,
+		header.UPGRADE,
+		header.ORIGIN,
+		header.SEC_WEBSOCKET_VERSION,
+		header.ACCEPT_ENCODING,
+		header.ACCEPT_LANGUAGE,
+		header.COOKIE,
+		header.SEC_WEBSOCKET_KEY,
+		header.SEC_WEBSOCKET_EXTENSIONS,
+		header.SEC_WEBSOCKET_PROTOCOL,
+	},
 }
 
 var (
@@ -217,6 +235,19 @@ func Headers[T ~string](headers *g.MapOrd[T, T], method string) {
 		headers.Insert(header.SEC_FETCH_DEST, "empty")
 		headers.Insert(header.SEC_FETCH_MODE, "cors")
 		headers.Insert(header.SEC_FETCH_SITE, "same-origin")
+	case "websocket":
+		// Chrome sends neither client hints nor fetch metadata on the WebSocket handshake.
+		headers.Insert(header.SEC_CH_UA, "")
+		headers.Insert(header.SEC_CH_UA_MOBILE, "")
+		headers.Insert(header.SEC_CH_UA_PLATFORM, "")
+		headers.Insert(header.CACHE_CONTROL, "no-cache")
+		headers.Insert(header.CONNECTION, "Upgrade")
+		headers.Insert(header.PRAGMA, "no-cache")
+		headers.Insert(header.SEC_WEBSOCKET_EXTENSIONS, "permessage-deflate; client_max_window_bits")
+		headers.Insert(header.SEC_WEBSOCKET_KEY, "")
+		headers.Insert(header.SEC_WEBSOCKET_PROTOCOL, "")
+		headers.Insert(header.SEC_WEBSOCKET_VERSION, "")
+		headers.Insert(header.UPGRADE, "websocket")
 	default:
 		headers.Insert(
 			header.ACCEPT,
@@ -132,6 +132,28 @@ var headerOrder = g.Map[string, g.Slice[string]]{
 		header.PRAGMA,
 		header.CACHE_CONTROL,
 	},
+
+	"websocket": {
+		header.HOST,
+		header.USER_AGENT,
+		header.ACCEPT,
+		header.ACCEPT_LANGUAGE,
+		header.ACCEPT_ENCODING,
+		header.SEC_WEBSOCKET_VERSION,
+		header.ORIGIN,
+		header.SEC_WEBSOCKET_PROTOCOL,
+		header.SEC_WEBSOCKET_EXTENSIONS,
+		header.SEC_WEBSOCKET_KEY,
+		header.AUTHORIZATION,
+		header.CONNECTION,
+		header.COOKIE,
+		header.SEC_FETCH_DEST,
+		header.SEC_FETCH_MODE,
+		header.SEC_FETCH_SITE,
+		header.PRAGMA,
+		header.CACHE_CONTROL,
+		header.UPGRADE,
+	},
 }
 
 var (
@@ -167,6 +189,19 @@ func Headers[T ~string](headers *g.MapOrd[T, T], method string) {
 		headers.Insert(header.SEC_FETCH_DEST, "empty")
 		headers.Insert(header.SEC_FETCH_MODE, "cors")
 		headers.Insert(header.SEC_FETCH_SITE, "same-origin")
+	case "websocket":
+		headers.Insert(header.ACCEPT, "*/*")
+		headers.Insert(header.CACHE_CONTROL, "no-cache")
+		headers.Insert(header.CONNECTION, "keep-alive, Upgrade")
+		headers.Insert(header.PRAGMA, "no-cache")
+		headers.Insert(header.SEC_FETCH_DEST, "empty")
+		headers.Insert(header.SEC_FETCH_MODE, "websocket")
+		headers.Insert(header.SEC_FETCH_SITE, "same-origin")
+		headers.Insert(header.SEC_WEBSOCKET_EXTENSIONS, "permessage-deflate")
+		headers.Insert(header.SEC_WEBSOCKET_KEY, "")
+		headers.Insert(header.SEC_WEBSOCKET_PROTOCOL, "")
+		headers.Insert(header.SEC_WEBSOCKET_VERSION, "")
+		headers.Insert(header.UPGRADE, "websocket")
 	default:
 		headers.Insert(header.ACCEPT, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 		headers.Insert(header.PRIORITY, "u=0, i")
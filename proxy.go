@@ -0,0 +1,208 @@
+package surf
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+)
+
+// proxyTransports holds the transports of the proxies selected per request, keyed by proxy URL,
+// so that connections, TLS sessions and HTTP/2 streams are reused for every proxy. Beyond
+// _proxyMaxTransports, the transports of the least recently used proxies are dropped.
+type proxyTransports struct {
+	mu         sync.Mutex
+	transports map[g.String]*list.Element
+	lru        *list.List // Front is the most recently used transport
+}
+
+// proxyTransportEntry is the transport of a proxy.
+type proxyTransportEntry struct {
+	proxy     g.String
+	transport http.RoundTripper
+}
+
+// proxyClient returns the http.Client sending requests through proxy: the client itself for an
+// empty proxy or the proxy of the client, otherwise a copy sharing the cookie jar, redirect policy
+// and timeout of the client, with the transport of the proxy. ProxyDirect has a transport without
+// any proxy.
+func (c *Client) proxyClient(proxy g.String) (*http.Client, error) {
+	if proxy.IsEmpty() || c.builder != nil && proxy == c.builder.proxy {
+		return c.cli, nil
+	}
+
+	transport, err := c.proxyTransport(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := *c.cli
+	cli.Transport = transport
+
+	return &cli, nil
+}
+
+// proxyTransport returns the transport of proxy, creating it on first use.
+func (c *Client) proxyTransport(proxy g.String) (http.RoundTripper, error) {
+	p := &c.proxies
+
+	p.mu.Lock()
+
+	if e, ok := p.transports[proxy]; ok {
+		p.lru.MoveToFront(e)
+		p.mu.Unlock()
+
+		return e.Value.(*proxyTransportEntry).transport, nil
+	}
+
+	transport, err := c.newProxyTransport(proxy)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	if p.transports == nil {
+		p.transports = make(map[g.String]*list.Element)
+		p.lru = list.New()
+	}
+
+	p.transports[proxy] = p.lru.PushFront(&proxyTransportEntry{proxy: proxy, transport: transport})
+
+	var evicted []http.RoundTripper
+
+	for p.lru.Len() > _proxyMaxTransports {
+		entry := p.lru.Remove(p.lru.Back()).(*proxyTransportEntry)
+		delete(p.transports, entry.proxy)
+		evicted = append(evicted, entry.transport)
+	}
+
+	p.mu.Unlock()
+
+	for _, transport := range evicted {
+		closeIdleConnections(transport)
+	}
+
+	return transport, nil
+}
+
+// newProxyTransport creates a transport with the configuration of the client transport, including
+// the JA fingerprint and HTTP/3, that sends requests through proxy.
+func (c *Client) newProxyTransport(proxy g.String) (http.RoundTripper, error) {
+	var (
+		base = c.cli.Transport
+		ut   *uquicTransport
+		ja   *JA
+		tcp  *http.Transport
+	)
+
+	if t, ok := base.(*uquicTransport); ok {
+		ut, base = t, t.fallbackTransport
+	}
+
+	switch t := base.(type) {
+	case *roundtripper:
+		tcp, ja = t.http1tr, t.ja
+	case *http.Transport:
+		tcp = t
+	case nil:
+	default:
+		return nil, errors.New("per-request proxy requires *http.Transport")
+	}
+
+	var transport http.RoundTripper
+
+	if tcp != nil {
+		clone := tcp.Clone()
+
+		if proxy == ProxyDirect {
+			// Neither the proxy of the client nor the proxies of the environment.
+			clone.Proxy = nil
+			if c.builder != nil && (!c.builder.proxy.IsEmpty() || c.builder.proxyChain != nil) {
+				clone.DialContext = c.dialContext
+			}
+		} else if err := setProxy(c, clone, proxy); err != nil {
+			return nil, err
+		}
+
+		transport = clone
+
+		if ja != nil {
+			rt := newRoundTripper(ja, clone)
+			rt.proxied = proxy != ProxyDirect
+			transport = rt
+		}
+	}
+
+	if ut != nil {
+		if proxy == ProxyDirect {
+			proxy = ""
+		}
+
+		clone, err := ut.withProxy(proxy.Std(), transport)
+		if err != nil {
+			return nil, err
+		}
+
+		transport = clone
+	}
+
+	return transport, nil
+}
+
+// closeIdleConnections closes the idle connections of every proxy transport.
+func (p *proxyTransports) closeIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.transports {
+		closeIdleConnections(e.Value.(*proxyTransportEntry).transport)
+	}
+}
+
+// drop removes the transport of proxy and closes its idle connections.
+func (p *proxyTransports) drop(proxy g.String) {
+	p.mu.Lock()
+
+	e, ok := p.transports[proxy]
+	if ok {
+		p.lru.Remove(e)
+		delete(p.transports, proxy)
+	}
+
+	p.mu.Unlock()
+
+	if ok {
+		closeIdleConnections(e.Value.(*proxyTransportEntry).transport)
+	}
+}
+
+// close shuts down every proxy transport and removes them from the pool.
+func (p *proxyTransports) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.transports {
+		transport := e.Value.(*proxyTransportEntry).transport
+		if closer, ok := transport.(interface{ Close() error }); ok {
+			closer.Close()
+		} else {
+			closeIdleConnections(transport)
+		}
+	}
+
+	clear(p.transports)
+
+	if p.lru != nil {
+		p.lru.Init()
+	}
+}
+
+// closeIdleConnections closes the idle connections of transport. Connections still in use are
+// closed by the idle timeout of the transport once their response is read.
+func closeIdleConnections(transport http.RoundTripper) {
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
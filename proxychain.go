@@ -0,0 +1,112 @@
+package surf
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/pkg/connectproxy"
+)
+
+// ProxyChainSettings provides a fluent interface for configuring a chain of proxies.
+type ProxyChainSettings struct {
+	builder *Builder
+	chain   *proxyChain
+}
+
+// Timeout limits the time to tunnel through the hop of the chain numbered from 1, including the
+// connection to the proxy server. Tunnels through the hop fail with connectproxy.ErrProxyTimeout.
+func (pcs *ProxyChainSettings) Timeout(hop int, timeout time.Duration) *ProxyChainSettings {
+	if hop >= 1 && hop <= len(pcs.chain.timeouts) {
+		pcs.chain.timeouts[hop-1] = timeout
+	}
+
+	return pcs
+}
+
+// ResolveHops resolves the addresses of the hops after the first one with the resolver of the
+// client, instead of letting the hop before them resolve them. By default only the first hop and,
+// with a custom resolver, the targets are resolved locally, so that hops only known on the network
+// of a jump host are reachable.
+func (pcs *ProxyChainSettings) ResolveHops() *ProxyChainSettings {
+	pcs.chain.resolveHops = true
+	return pcs
+}
+
+// Set applies the proxy chain to the client.
+func (pcs *ProxyChainSettings) Set() *Builder {
+	chain := pcs.chain
+	pcs.builder.proxyChain = chain
+
+	return pcs.builder.addCliMW(func(client *Client) error {
+		transport, ok := client.GetTransport().(*http.Transport)
+		if !ok {
+			// HTTP/3 transports fall back to their TCP transport through the chain.
+			return nil
+		}
+
+		return setProxyChain(client, transport, chain)
+	}, 0)
+}
+
+// proxyChain is the chain of proxies of a client.
+type proxyChain struct {
+	proxies     g.Slice[g.String] // Proxy URLs, from the first hop to the one reaching the target
+	timeouts    []time.Duration   // Tunnel timeouts of the hops, unlimited when zero
+	resolveHops bool              // Hop addresses are resolved locally
+}
+
+// setProxyChain routes the connections of transport through every proxy of chain in turn.
+func setProxyChain(client *Client, transport *http.Transport, chain *proxyChain) error {
+	transport.Proxy = nil
+
+	proxies := make([]string, len(chain.proxies))
+	for i, proxy := range chain.proxies {
+		proxies[i] = proxy.Std()
+	}
+
+	dialer, err := connectproxy.NewChain(proxies...)
+	if err != nil {
+		return fmt.Errorf("create proxy dialer: %w", err)
+	}
+
+	for i, timeout := range chain.timeouts {
+		dialer.Hops[i].Timeout = timeout
+	}
+
+	if chain.resolveHops {
+		dialer.ResolveHops()
+	}
+
+	first, last := dialer.Hops[0], dialer.Hops[len(dialer.Hops)-1]
+
+	// Pass custom DNS resolver to proxy dialer if configured.
+	// This ensures DNS queries go through the custom DNS server, not through the proxy.
+	// Target hostnames are pre-resolved locally before being sent to the proxy.
+	if client.dialer != nil && client.dialer.Resolver != nil {
+		dialer.SetResolver(client.dialer.Resolver)
+	}
+
+	// Apply the address family and Happy Eyeballs options to the proxy server connections, and
+	// the address family to the target hosts when they are already resolved locally. Otherwise
+	// the targets are left to the proxy.
+	if b := client.builder; b != nil && (b.family != anyFamily || b.fallbackDelay != 0) {
+		first.DialTCPContext = client.dial
+
+		if b.family != anyFamily && client.dialer != nil && client.dialer.Resolver != nil {
+			last.LookupNetIP = func(ctx context.Context, host string) ([]netip.Addr, error) {
+				return lookupIPs(ctx, client.dialer.Resolver, b.family, host)
+			}
+		}
+	}
+
+	transport.DialContext = dialer.DialContext
+	if len(dialer.Hops) == 1 {
+		transport.DialContext = first.DialContext
+	}
+
+	return nil
+}
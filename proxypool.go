@@ -0,0 +1,773 @@
+package surf
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"weak"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+)
+
+// ProxyStrategy selects the proxy of a ProxyPool a request is sent through.
+type ProxyStrategy int
+
+const (
+	ProxyRoundRobin    ProxyStrategy = iota // Every proxy in turn
+	ProxyRandom                             // A random proxy
+	ProxyLeastUsed                          // The proxy that sent the fewest requests
+	ProxyFastest                            // The proxy with the lowest latency, unmeasured proxies first
+	ProxyStickyHost                         // The same proxy for every request to a host
+	ProxyStickySession                      // The same proxy for every request of a session
+)
+
+// ProxyPool rotates requests over a set of proxies. Proxies that fail to connect a number of
+// times in a row, or whose responses match a banned status code or body pattern, are quarantined
+// and skipped for a while. Optional health checks send a request through every proxy at an
+// interval, measuring latency and releasing proxies that work again from quarantine.
+//
+// A pool is attached to clients with Builder.ProxyPool and may be shared by several clients.
+// The transports of the clients through removed and quarantined proxies are closed. It is safe
+// for concurrent use.
+type ProxyPool struct {
+	mu          sync.Mutex
+	proxies     []*pooledProxy
+	strategy    ProxyStrategy
+	next        int                           // Round-robin position
+	hosts       map[string]*list.Element      // Proxies bound to hosts by ProxyStickyHost
+	hostsLRU    *list.List                    // Host bindings, the most recently used at the front
+	sessions    map[proxySession]*pooledProxy // Proxies bound to sessions by ProxyStickySession
+	banCodes    g.Slice[int]                  // Status codes that quarantine the proxy
+	banPatterns []any                         // Body patterns that quarantine the proxy
+	maxFailures int                           // Consecutive failures that quarantine the proxy
+	quarantine  time.Duration                 // How long a quarantined proxy is skipped
+	checkURL    g.String                      // URL requested by health checks
+	checker     *Client                       // Client sending the health checks without attached clients
+	clients     []weak.Pointer[Client]        // Clients the pool is attached to
+	stop        chan g.Unit                   // Stops the health check loop
+}
+
+// proxySession identifies a session of ProxyStickySession: the ID set with Request.ProxySession,
+// or the client of requests without one.
+type proxySession struct {
+	client weak.Pointer[Client]
+	id     g.String
+}
+
+// hostBinding is the proxy bound to a host by ProxyStickyHost.
+type hostBinding struct {
+	host  string
+	proxy *pooledProxy
+}
+
+// pooledProxy is a proxy of a pool and its state.
+type pooledProxy struct {
+	url         g.String
+	requests    int64
+	failures    int64
+	bans        int64
+	consecutive int           // Failures since the last success
+	latency     time.Duration // Moving average of response times, 0 until measured
+	until       time.Time     // End of the quarantine
+}
+
+// ProxyStats reports the usage and health of a proxy of a pool.
+type ProxyStats struct {
+	Proxy            g.String      // Proxy URL
+	Requests         int64         // Requests sent through the proxy
+	Failures         int64         // Requests and health checks that failed
+	Bans             int64         // Responses matching a banned status code or body pattern
+	Latency          time.Duration // Moving average of response times, 0 until measured
+	QuarantinedUntil time.Time     // End of the quarantine, zero when the proxy is not quarantined
+}
+
+// NewProxyPool creates a pool of proxies rotated round-robin.
+func NewProxyPool(proxies ...g.String) *ProxyPool {
+	pool := &ProxyPool{
+		hosts:       make(map[string]*list.Element),
+		hostsLRU:    list.New(),
+		sessions:    make(map[proxySession]*pooledProxy),
+		maxFailures: _proxyPoolMaxFailures,
+		quarantine:  _proxyPoolQuarantine,
+	}
+
+	return pool.Add(proxies...)
+}
+
+// Add adds proxies to the pool. Empty entries, comments starting with # and proxies already
+// in the pool are skipped.
+func (p *ProxyPool) Add(proxies ...g.String) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, proxy := range proxies {
+		proxy = proxy.Trim()
+		if proxy.IsEmpty() || proxy.StartsWith("#") || p.find(proxy) != nil {
+			continue
+		}
+
+		p.proxies = append(p.proxies, &pooledProxy{url: proxy})
+	}
+
+	return p
+}
+
+// Load adds the proxies listed in r, one per line.
+func (p *ProxyPool) Load(r io.Reader) error {
+	var proxies []g.String
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		proxies = append(proxies, g.String(scanner.Text()))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.Add(proxies...)
+
+	return nil
+}
+
+// Remove removes proxies from the pool and closes their transports in the clients the pool is
+// attached to.
+func (p *ProxyPool) Remove(proxies ...g.String) *ProxyPool {
+	p.mu.Lock()
+
+	var removed []g.String
+
+	p.proxies = slices.DeleteFunc(p.proxies, func(proxy *pooledProxy) bool {
+		for _, url := range proxies {
+			if proxy.url == url.Trim() {
+				removed = append(removed, proxy.url)
+				return true
+			}
+		}
+
+		return false
+	})
+
+	bound := func(proxy *pooledProxy) bool { return slices.Contains(removed, proxy.url) }
+	maps.DeleteFunc(p.hosts, func(_ string, e *list.Element) bool {
+		if bound(e.Value.(*hostBinding).proxy) {
+			p.hostsLRU.Remove(e)
+			return true
+		}
+
+		return false
+	})
+	maps.DeleteFunc(p.sessions, func(_ proxySession, proxy *pooledProxy) bool { return bound(proxy) })
+
+	p.mu.Unlock()
+
+	for _, url := range removed {
+		p.evict(url)
+	}
+
+	return p
+}
+
+// LoadFile adds the proxies listed in the file at path, one per line.
+func (p *ProxyPool) LoadFile(path g.String) error {
+	file, err := os.Open(path.Std())
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return p.Load(file)
+}
+
+// Strategy sets how the proxy of every request is chosen.
+func (p *ProxyPool) Strategy(strategy ProxyStrategy) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.strategy = strategy
+
+	return p
+}
+
+// BanStatus quarantines proxies receiving a response with one of the status codes,
+// such as 403 or 429 from sites blocking the proxy.
+func (p *ProxyPool) BanStatus(codes ...int) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.banCodes.Push(codes...)
+
+	return p
+}
+
+// BanBody quarantines proxies receiving a response whose body contains one of the patterns,
+// such as a captcha page. Patterns are matched like Body.Contains against the beginning of the body
+// as it is read, so the proxy is quarantined once the body is read or closed.
+func (p *ProxyPool) BanBody(patterns ...any) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.banPatterns = append(p.banPatterns, patterns...)
+
+	return p
+}
+
+// MaxFailures sets the number of consecutive connection failures that quarantine a proxy.
+func (p *ProxyPool) MaxFailures(failures int) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxFailures = max(failures, 1)
+
+	return p
+}
+
+// Quarantine sets how long failing and banned proxies are skipped.
+func (p *ProxyPool) Quarantine(duration time.Duration) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.quarantine = duration
+
+	return p
+}
+
+// HealthCheck sets the URL requested through every proxy by Check and, with a positive interval,
+// starts checking the proxies in the background at that interval until Close is called.
+//
+// Health checks are sent as the first client the pool is attached to sends its requests, with its
+// request middlewares, headers and transport, including the JA fingerprint and resolver, but
+// without its rate limits, circuit breaker, retries and cache. A pool attached to no client sends
+// them with a default client.
+func (p *ProxyPool) HealthCheck(url g.String, interval time.Duration) *ProxyPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checkURL = url
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+
+	if interval > 0 {
+		p.stop = make(chan g.Unit)
+		go p.healthCheckLoop(interval, p.stop)
+	}
+
+	return p
+}
+
+// healthCheckLoop checks the proxies right away and then at every interval until stop is closed.
+func (p *ProxyPool) healthCheckLoop(interval time.Duration, stop chan g.Unit) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.Check(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check requests the health check URL through every proxy, including quarantined ones, and
+// waits for the results. Proxies that answer are released from quarantine and their latency is
+// updated, the others and those answering with a 5xx status count a failure. Does nothing without
+// a URL set with HealthCheck.
+func (p *ProxyPool) Check(ctx context.Context) {
+	checker := p.owner()
+
+	p.mu.Lock()
+
+	url, proxies := p.checkURL, slices.Clone(p.proxies)
+
+	if checker == nil && !url.IsEmpty() {
+		if p.checker == nil {
+			p.checker = NewClient().Builder().Timeout(_proxyPoolCheckTimeout).Build().Unwrap()
+		}
+
+		checker = p.checker
+	}
+
+	p.mu.Unlock()
+
+	if url.IsEmpty() {
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, proxy := range proxies {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+
+			status, err := probe(ctx, checker, url, proxy.url)
+			if err != nil {
+				if ctx.Err() == nil {
+					p.report(proxy.url, 0, err)
+				}
+
+				return
+			}
+
+			switch {
+			case p.bannedStatus(status):
+				p.ban(proxy.url)
+				return
+			case status >= http.StatusInternalServerError:
+				p.report(proxy.url, 0, fmt.Errorf("health check status %d", status))
+				return
+			}
+
+			p.report(proxy.url, time.Since(start), nil)
+			p.release(proxy.url)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// probe requests url through proxy as client sends its requests, with its request middlewares and
+// transport but without its rate limits, circuit breaker, retries and cache, and returns the status
+// code of the response.
+func probe(ctx context.Context, client *Client, url, proxy g.String) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, _proxyPoolCheckTimeout)
+	defer cancel()
+
+	req := client.Get(url).Proxy(proxy).WithContext(ctx)
+	if req.err != nil {
+		return 0, req.err
+	}
+
+	if err := client.applyReqMW(req); err != nil {
+		return 0, err
+	}
+
+	cli, err := client.proxyClient(req.proxy)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := cli.Do(req.request)
+	if err != nil {
+		return 0, err
+	}
+
+	// Drained so that the connection through the proxy is reused by the next check.
+	io.CopyN(io.Discard, resp.Body, _proxyPoolCheckDrainSize)
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// attach records a client the pool is attached to.
+func (p *ProxyPool) attach(client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pointer := weak.Make(client); !slices.Contains(p.clients, pointer) {
+		p.clients = append(p.clients, pointer)
+	}
+}
+
+// attached returns the clients the pool is attached to, forgetting the collected ones.
+func (p *ProxyPool) attached() []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make([]*Client, 0, len(p.clients))
+
+	p.clients = slices.DeleteFunc(p.clients, func(pointer weak.Pointer[Client]) bool {
+		client := pointer.Value()
+		if client != nil {
+			clients = append(clients, client)
+		}
+
+		return client == nil
+	})
+
+	return clients
+}
+
+// owner returns the first client the pool is attached to, or nil.
+func (p *ProxyPool) owner() *Client {
+	if clients := p.attached(); len(clients) != 0 {
+		return clients[0]
+	}
+
+	return nil
+}
+
+// evict closes the transports of the clients the pool is attached to through url.
+func (p *ProxyPool) evict(url g.String) {
+	for _, client := range p.attached() {
+		client.proxies.drop(url)
+	}
+}
+
+// Close stops the background health checks.
+func (p *ProxyPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+
+	if p.checker == nil {
+		return nil
+	}
+
+	checker := p.checker
+	p.checker = nil
+
+	return checker.Close()
+}
+
+// Len returns the number of proxies in the pool.
+func (p *ProxyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.proxies)
+}
+
+// Stats returns the usage and health of every proxy, in the order they were added.
+func (p *ProxyPool) Stats() g.Slice[ProxyStats] {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make(g.Slice[ProxyStats], 0, len(p.proxies))
+
+	for _, proxy := range p.proxies {
+		s := ProxyStats{
+			Proxy:    proxy.url,
+			Requests: proxy.requests,
+			Failures: proxy.failures,
+			Bans:     proxy.bans,
+			Latency:  proxy.latency,
+		}
+
+		if proxy.quarantined(now) {
+			s.QuarantinedUntil = proxy.until
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// quarantined reports whether the proxy is skipped at now.
+func (pp *pooledProxy) quarantined(now time.Time) bool { return now.Before(pp.until) }
+
+// find returns the proxy of the pool with the URL, or nil.
+func (p *ProxyPool) find(url g.String) *pooledProxy {
+	for _, proxy := range p.proxies {
+		if proxy.url == url {
+			return proxy
+		}
+	}
+
+	return nil
+}
+
+// pick chooses the proxy of req with the strategy of the pool.
+func (p *ProxyPool) pick(req *Request) (g.String, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	var proxy *pooledProxy
+
+	switch p.strategy {
+	case ProxyStickyHost:
+		host := req.request.URL.Hostname()
+		if e := p.hosts[host]; e != nil && !e.Value.(*hostBinding).proxy.quarantined(now) {
+			proxy = e.Value.(*hostBinding).proxy
+			p.hostsLRU.MoveToFront(e)
+		} else if proxy = p.choose(ProxyRoundRobin, now); proxy != nil {
+			p.bindHost(host, proxy)
+		}
+	case ProxyStickySession:
+		session := proxySession{id: req.session}
+		if session.id.IsEmpty() {
+			session.client = weak.Make(req.cli)
+		}
+
+		if proxy = p.sessions[session]; proxy == nil || proxy.quarantined(now) {
+			if proxy = p.choose(ProxyRoundRobin, now); proxy != nil {
+				p.bindSession(session, proxy)
+			}
+		}
+	default:
+		proxy = p.choose(p.strategy, now)
+	}
+
+	if proxy == nil {
+		if len(p.proxies) == 0 {
+			return "", &ErrNoProxyAvailable{Msg: "the proxy pool is empty"}
+		}
+
+		return "", &ErrNoProxyAvailable{Msg: fmt.Sprintf("all %d proxies are quarantined", len(p.proxies))}
+	}
+
+	proxy.requests++
+
+	return proxy.url, nil
+}
+
+// refund takes back the request counted by pick for a request that was not sent through url.
+func (p *ProxyPool) refund(url g.String) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if proxy := p.find(url); proxy != nil && proxy.requests > 0 {
+		proxy.requests--
+	}
+}
+
+// choose returns a proxy that is not quarantined with strategy, or nil.
+func (p *ProxyPool) choose(strategy ProxyStrategy, now time.Time) *pooledProxy {
+	var chosen *pooledProxy
+
+	switch strategy {
+	case ProxyRandom:
+		available := make([]*pooledProxy, 0, len(p.proxies))
+		for _, proxy := range p.proxies {
+			if !proxy.quarantined(now) {
+				available = append(available, proxy)
+			}
+		}
+
+		if len(available) != 0 {
+			chosen = available[rand.IntN(len(available))]
+		}
+	case ProxyLeastUsed:
+		for _, proxy := range p.proxies {
+			if !proxy.quarantined(now) && (chosen == nil || proxy.requests < chosen.requests) {
+				chosen = proxy
+			}
+		}
+	case ProxyFastest:
+		for _, proxy := range p.proxies {
+			if !proxy.quarantined(now) && (chosen == nil || proxy.latency < chosen.latency) {
+				chosen = proxy
+			}
+		}
+	default:
+		for i := range p.proxies {
+			index := (p.next + i) % len(p.proxies)
+			if proxy := p.proxies[index]; !proxy.quarantined(now) {
+				p.next = index + 1
+				chosen = proxy
+
+				break
+			}
+		}
+	}
+
+	return chosen
+}
+
+// bindHost binds host to proxy, forgetting the least recently used hosts beyond _proxyPoolMaxHosts.
+func (p *ProxyPool) bindHost(host string, proxy *pooledProxy) {
+	if e := p.hosts[host]; e != nil {
+		e.Value.(*hostBinding).proxy = proxy
+		p.hostsLRU.MoveToFront(e)
+
+		return
+	}
+
+	p.hosts[host] = p.hostsLRU.PushFront(&hostBinding{host: host, proxy: proxy})
+
+	for p.hostsLRU.Len() > _proxyPoolMaxHosts {
+		delete(p.hosts, p.hostsLRU.Remove(p.hostsLRU.Back()).(*hostBinding).host)
+	}
+}
+
+// bindSession binds the session to proxy, forgetting the sessions of collected clients and
+// arbitrary sessions beyond _proxyPoolMaxSessions.
+func (p *ProxyPool) bindSession(session proxySession, proxy *pooledProxy) {
+	for s := range p.sessions {
+		if s.id.IsEmpty() && s.client.Value() == nil {
+			delete(p.sessions, s)
+		}
+	}
+
+	if _, ok := p.sessions[session]; !ok {
+		for s := range p.sessions {
+			if len(p.sessions) < _proxyPoolMaxSessions {
+				break
+			}
+
+			delete(p.sessions, s)
+		}
+	}
+
+	p.sessions[session] = proxy
+}
+
+// report records the outcome of a request through proxy. A success resets the failure count and,
+// with a positive latency, updates its latency; a failure quarantines the proxy once MaxFailures
+// is reached. A success does not release the proxy from quarantine, as a ban of a response of
+// another request may have set it. Proxies not in the pool are ignored.
+func (p *ProxyPool) report(url g.String, latency time.Duration, err error) {
+	if err != nil {
+		if p.fail(url) {
+			p.evict(url)
+		}
+
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxy := p.find(url)
+	if proxy == nil {
+		return
+	}
+
+	proxy.consecutive = 0
+
+	switch {
+	case latency <= 0:
+	case proxy.latency == 0:
+		proxy.latency = latency
+	default:
+		proxy.latency = (7*proxy.latency + 3*latency) / 10
+	}
+}
+
+// release releases proxy from quarantine after a successful health check.
+func (p *ProxyPool) release(url g.String) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if proxy := p.find(url); proxy != nil {
+		proxy.until = time.Time{}
+	}
+}
+
+// fail counts a failure of proxy and reports whether it quarantined the proxy.
+func (p *ProxyPool) fail(url g.String) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxy := p.find(url)
+	if proxy == nil {
+		return false
+	}
+
+	proxy.failures++
+	proxy.consecutive++
+
+	if proxy.consecutive < p.maxFailures {
+		return false
+	}
+
+	proxy.until = time.Now().Add(p.quarantine)
+
+	return true
+}
+
+// ban quarantines proxy after a banned response.
+func (p *ProxyPool) ban(url g.String) {
+	p.mu.Lock()
+
+	proxy := p.find(url)
+	if proxy != nil {
+		proxy.bans++
+		proxy.until = time.Now().Add(p.quarantine)
+	}
+
+	p.mu.Unlock()
+
+	if proxy != nil {
+		p.evict(url)
+	}
+}
+
+// bannedStatus reports whether code is a banned status code.
+func (p *ProxyPool) bannedStatus(code int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.banCodes.Contains(code)
+}
+
+// inspect quarantines the proxy of a response with a banned status code or body pattern.
+// The patterns are matched against the beginning of the body as it is read, outside the response
+// middlewares. Responses served by the HTTP cache are not inspected.
+func (p *ProxyPool) inspect(r *Response) error {
+	proxy := r.request.proxy
+	if proxy.IsEmpty() || r.request.cached {
+		return nil
+	}
+
+	if r.Body == nil {
+		p.watch(proxy, int(r.StatusCode), nil)
+		return nil
+	}
+
+	r.Body.Reader = p.watch(proxy, int(r.StatusCode), r.Body.Reader)
+
+	return nil
+}
+
+// watch quarantines proxy when status is banned, or returns body quarantining proxy once its
+// beginning is read and contains a ban pattern. It also checks the responses of retried attempts,
+// which are discarded instead of going through inspect.
+func (p *ProxyPool) watch(proxy g.String, status int, body io.ReadCloser) io.ReadCloser {
+	if p.bannedStatus(status) {
+		p.ban(proxy)
+		return body
+	}
+
+	p.mu.Lock()
+	patterns := slices.Clone(p.banPatterns)
+	p.mu.Unlock()
+
+	if len(patterns) == 0 || body == nil {
+		return body
+	}
+
+	return newPeekReader(body, _proxyPoolBanPeekSize, func(peek []byte) {
+		for _, pattern := range patterns {
+			if containsPattern(peek, pattern) {
+				p.ban(proxy)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package surf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/enetx/g"
+	"github.com/enetx/surf/pkg/pac"
+)
+
+// ProxyDirect is the proxy of requests routed without a proxy, bypassing the proxy of the client.
+const ProxyDirect g.String = "DIRECT"
+
+// ProxyRouterSettings provides a fluent interface for choosing the proxy of every request from
+// its destination: NO_PROXY entries first, then the rules in the order they were added, the PAC
+// script and finally the default proxy. Requests matching nothing use the proxy of the client.
+type ProxyRouterSettings struct {
+	builder  *Builder
+	rules    []proxyRule
+	noProxy  []g.String
+	script   g.String
+	file     g.String
+	fallback g.String
+}
+
+// proxyRule routes the requests matching one of domain, prefix or re through proxy.
+type proxyRule struct {
+	domain string
+	prefix netip.Prefix
+	re     *regexp.Regexp
+	proxy  g.String
+}
+
+// Domain routes the requests to a domain through proxy, or directly with ProxyDirect.
+// "example.com" matches the domain and its subdomains, ".example.com" only its subdomains and
+// "*" every host.
+func (prs *ProxyRouterSettings) Domain(suffix, proxy g.String) *ProxyRouterSettings {
+	prs.rules = append(prs.rules, proxyRule{domain: suffix.Lower().Std(), proxy: proxy})
+	return prs
+}
+
+// CIDR routes the requests to addresses within prefix, such as "10.0.0.0/8", through proxy.
+// Host names are resolved with the resolver of the client to be matched. An invalid prefix
+// fails Build.
+func (prs *ProxyRouterSettings) CIDR(prefix, proxy g.String) *ProxyRouterSettings {
+	rule := proxyRule{proxy: proxy}
+
+	p, err := netip.ParsePrefix(prefix.Std())
+	if err != nil {
+		prs.builder.addCliMW(func(*Client) error { return err }, 999)
+	} else {
+		rule.prefix = p.Masked()
+	}
+
+	prs.rules = append(prs.rules, rule)
+
+	return prs
+}
+
+// Regexp routes the requests whose full URL matches re through proxy.
+func (prs *ProxyRouterSettings) Regexp(re *regexp.Regexp, proxy g.String) *ProxyRouterSettings {
+	prs.rules = append(prs.rules, proxyRule{re: re, proxy: proxy})
+	return prs
+}
+
+// NoProxy adds comma-separated entries in the NO_PROXY format whose requests are sent directly:
+// host names matching like Domain, IP addresses, CIDR prefixes and "*", optionally with a port.
+// The NO_PROXY and no_proxy environment variables are always honored.
+func (prs *ProxyRouterSettings) NoProxy(list g.String) *ProxyRouterSettings {
+	prs.noProxy = append(prs.noProxy, list)
+	return prs
+}
+
+// PAC evaluates a proxy auto-config script for the requests matching no rule. The first entry of
+// the result of FindProxyForURL is used: PROXY and HTTP entries as HTTP proxies, HTTPS entries
+// as HTTPS proxies, SOCKS and SOCKS5 entries as SOCKS5 proxies, SOCKS4 entries as SOCKS4 proxies,
+// DIRECT entries as ProxyDirect. The other entries of a failover list such as
+// "PROXY a:8080; PROXY b:8080; DIRECT" are not tried when the first proxy fails, the request
+// fails with the error of the proxy.
+// DNS helpers of the script use the resolver of the client.
+// A script that cannot be parsed fails Build, evaluation errors are returned by Request.Do.
+func (prs *ProxyRouterSettings) PAC(script g.String) *ProxyRouterSettings {
+	prs.script = script
+	return prs
+}
+
+// PACFile reads the proxy auto-config script of PAC from path when the client is built.
+func (prs *ProxyRouterSettings) PACFile(path g.String) *ProxyRouterSettings {
+	prs.file = path
+	return prs
+}
+
+// Default routes the requests matching no rule and no PAC result through proxy, or directly with
+// ProxyDirect.
+func (prs *ProxyRouterSettings) Default(proxy g.String) *ProxyRouterSettings {
+	prs.fallback = proxy
+	return prs
+}
+
+// Set applies the proxy router settings to the client. A proxy set with Request.Proxy, ProxySelector
+// or ProxyPool takes precedence over the router.
+func (prs *ProxyRouterSettings) Set() *Builder {
+	router := &proxyRouter{client: prs.builder.cli, rules: prs.rules, fallback: prs.fallback}
+	prs.builder.proxyRouter = router
+
+	// Runs after the middlewares configuring the resolver used by CIDR rules and the PAC script.
+	return prs.builder.addCliMW(func(client *Client) error { return router.compile(client, prs) }, 999)
+}
+
+// compile reads the NO_PROXY entries and parses the PAC script of the router settings.
+func (r *proxyRouter) compile(client *Client, prs *ProxyRouterSettings) error {
+	lists := append([]g.String{g.String(os.Getenv("NO_PROXY")), g.String(os.Getenv("no_proxy"))}, prs.noProxy...)
+	for _, list := range lists {
+		for entry := range strings.SplitSeq(list.Std(), ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				r.noProxy = append(r.noProxy, parseNoProxy(entry))
+			}
+		}
+	}
+
+	script := prs.script
+
+	if !prs.file.IsEmpty() {
+		content, err := os.ReadFile(prs.file.Std())
+		if err != nil {
+			return err
+		}
+
+		script = g.String(content)
+	}
+
+	if !script.IsEmpty() {
+		parsed, err := pac.Parse(script.Std())
+		if err != nil {
+			return err
+		}
+
+		if client.dialer != nil {
+			parsed.Resolver = client.dialer.Resolver
+		}
+
+		r.script = parsed
+	}
+
+	return nil
+}
+
+// routeProxy sets the proxy of req chosen by the proxy router of the client, unless a proxy is
+// already set. Routing runs outside the request middlewares, which are serialized per client,
+// as it may resolve hosts and evaluate the PAC script.
+func (c *Client) routeProxy(req *Request) error {
+	if c.builder == nil || c.builder.proxyRouter == nil || !req.proxy.IsEmpty() {
+		return nil
+	}
+
+	proxy, err := c.builder.proxyRouter.route(req)
+	req.proxy = proxy
+
+	return err
+}
+
+// proxyRouter chooses the proxy of every request of a client.
+type proxyRouter struct {
+	client   *Client
+	noProxy  []proxyRule
+	rules    []proxyRule
+	script   *pac.Script
+	fallback g.String
+}
+
+// parseNoProxy parses a NO_PROXY entry into a rule; a port restricts the entry to the port.
+func parseNoProxy(entry string) proxyRule {
+	rule := proxyRule{proxy: ProxyDirect}
+
+	if entry == "*" {
+		rule.domain = entry
+		return rule
+	}
+
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		rule.prefix = prefix.Masked()
+		return rule
+	}
+
+	host, port := entry, ""
+	if h, p, err := net.SplitHostPort(entry); err == nil {
+		host, port = h, p
+	}
+
+	host = strings.Trim(host, "[]")
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		rule.prefix = netip.PrefixFrom(ip, ip.BitLen())
+	} else {
+		rule.domain = strings.ToLower(strings.TrimPrefix(host, "*"))
+	}
+
+	if port != "" {
+		rule.re = regexp.MustCompile(":" + regexp.QuoteMeta(port) + "$")
+	}
+
+	return rule
+}
+
+// route returns the proxy of req, empty when the proxy of the client is used.
+func (r *proxyRouter) route(req *Request) (g.String, error) {
+	u := req.request.URL
+	ctx := req.request.Context()
+	host := strings.ToLower(u.Hostname())
+
+	var resolved []netip.Addr
+
+	match := func(rule proxyRule, target string) bool {
+		switch {
+		case rule.domain != "":
+			return matchDomain(host, rule.domain) && (rule.re == nil || rule.re.MatchString(target))
+		case rule.prefix.IsValid():
+			if resolved == nil {
+				resolved = r.resolve(ctx, host)
+			}
+
+			for _, ip := range resolved {
+				if rule.prefix.Contains(ip.Unmap()) {
+					return rule.re == nil || rule.re.MatchString(target)
+				}
+			}
+
+			return false
+		case rule.re != nil:
+			return rule.re.MatchString(target)
+		}
+
+		return false
+	}
+
+	// NO_PROXY ports are matched against the host:port of the request.
+	hostport := net.JoinHostPort(u.Hostname(), u.Port())
+	if u.Port() == "" {
+		hostport = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+
+	for _, rule := range r.noProxy {
+		if match(rule, hostport) {
+			return ProxyDirect, nil
+		}
+	}
+
+	for _, rule := range r.rules {
+		if match(rule, u.String()) {
+			return rule.proxy, nil
+		}
+	}
+
+	if r.script != nil {
+		result, err := r.script.FindProxyForURL(ctx, u.String(), host)
+		if err != nil {
+			return "", err
+		}
+
+		if proxies := pac.ParseResult(result); len(proxies) != 0 {
+			if proxies[0].Type == "DIRECT" {
+				return ProxyDirect, nil
+			}
+
+			return g.String(proxies[0].URL()), nil
+		}
+	}
+
+	return r.fallback, nil
+}
+
+// resolve returns the addresses of host, the address itself for IP literals.
+func (r *proxyRouter) resolve(ctx context.Context, host string) []netip.Addr {
+	var resolver *net.Resolver
+	if r.client.dialer != nil {
+		resolver = r.client.dialer.Resolver
+	}
+
+	ips, err := lookupIPs(ctx, resolver, anyFamily, host)
+	if err != nil {
+		return []netip.Addr{}
+	}
+
+	return ips
+}
+
+// matchDomain reports whether host matches domain: "*" matches every host, ".example.com" the
+// subdomains of example.com and "example.com" the domain and its subdomains.
+func matchDomain(host, domain string) bool {
+	switch {
+	case domain == "*":
+		return true
+	case strings.HasPrefix(domain, "."):
+		return strings.HasSuffix(host, domain)
+	}
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
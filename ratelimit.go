@@ -0,0 +1,428 @@
+package surf
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+)
+
+// RateLimitStats reports the state of a rate limit of the client.
+type RateLimitStats struct {
+	Scope    g.String // global, host or proxy
+	Key      g.String // Host or proxy of the limit, empty for the global limit
+	Tokens   float64  // Requests that can be sent at once, negative when requests are waiting
+	Waiting  int      // Requests waiting for the limit
+	InFlight int      // Requests in flight to the host, with MaxInFlight only
+}
+
+// RateLimitSettings provides a fluent interface for limiting the rate and concurrency of the
+// requests of the client.
+type RateLimitSettings struct {
+	builder *Builder
+	limiter *rateLimiter
+}
+
+// Global limits the requests of the client to limit requests per period, sent at once at most.
+func (rls *RateLimitSettings) Global(limit int, per time.Duration) *RateLimitSettings {
+	rls.limiter.global = newBucketLimit(limit, per)
+	return rls
+}
+
+// PerHost limits the requests to every host to limit requests per period, sent at once at most.
+func (rls *RateLimitSettings) PerHost(limit int, per time.Duration) *RateLimitSettings {
+	rls.limiter.host = newBucketLimit(limit, per)
+	return rls
+}
+
+// PerProxy limits the requests through every proxy selected per request, with Request.Proxy, a
+// ProxyPool or a ProxyRouter, to limit requests per period, sent at once at most.
+func (rls *RateLimitSettings) PerProxy(limit int, per time.Duration) *RateLimitSettings {
+	rls.limiter.proxy = newBucketLimit(limit, per)
+	return rls
+}
+
+// MaxInFlight limits the requests in flight to every host, from sending a request until its
+// response body is closed. It is independent of the connection limits of the transport. A body
+// that is never closed holds its slot until it is garbage collected, so close every body.
+func (rls *RateLimitSettings) MaxInFlight(requests int) *RateLimitSettings {
+	rls.limiter.inFlight = requests
+	return rls
+}
+
+// Set applies the rate limits to the client. Every attempt of a request, including retries and
+// requests of the client returned by Client.Std, waits for the limits before it is sent, or until
+// its context is done.
+func (rls *RateLimitSettings) Set() *Builder {
+	rls.builder.limiter = rls.limiter
+	return rls.builder
+}
+
+// bucketLimit is the rate and size of token buckets.
+type bucketLimit struct {
+	rate  float64 // Tokens per second
+	burst float64
+}
+
+func newBucketLimit(limit int, per time.Duration) *bucketLimit {
+	if limit <= 0 || per <= 0 {
+		return nil
+	}
+
+	return &bucketLimit{rate: float64(limit) / per.Seconds(), burst: float64(limit)}
+}
+
+// bucket is a token bucket. Waiting requests reserve tokens in advance, so tokens are negative
+// while requests are waiting.
+type bucket struct {
+	key     limitKey
+	tokens  float64
+	last    time.Time
+	waiting int
+}
+
+// hostSlots are the in-flight requests to a host.
+type hostSlots struct {
+	sem      chan g.Unit // Holds a value per request in flight
+	inFlight int
+	waiting  int
+}
+
+// limitKey identifies a bucket.
+type limitKey struct{ scope, key g.String }
+
+// rateLimiter limits the rate of the requests with token buckets, and the in-flight requests
+// per host.
+type rateLimiter struct {
+	mu       sync.Mutex
+	global   *bucketLimit
+	host     *bucketLimit
+	proxy    *bucketLimit
+	inFlight int
+	buckets  map[limitKey]*list.Element
+	lru      *list.List // Buckets, the most recently used at the front
+	slots    map[g.String]*hostSlots
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[limitKey]*list.Element),
+		lru:     list.New(),
+		slots:   make(map[g.String]*hostSlots),
+	}
+}
+
+// wait waits until req may be sent under every limit, or until its context is done. The returned
+// function releases the in-flight slot of the request. The tokens taken before a wait that fails
+// are given back.
+func (rl *rateLimiter) wait(req *Request) (func(), error) {
+	ctx := req.request.Context()
+	host := g.String(req.request.URL.Host)
+
+	keys := []limitKey{{"global", ""}, {"host", host}}
+	if !req.proxy.IsEmpty() {
+		keys = append(keys, limitKey{"proxy", req.proxy})
+	}
+
+	taken := make([]limitKey, 0, len(keys))
+
+	for _, key := range keys {
+		if limit := rl.limit(key.scope); limit != nil {
+			if err := rl.take(ctx, limit, key); err != nil {
+				rl.refund(taken)
+				return nil, err
+			}
+
+			taken = append(taken, key)
+		}
+	}
+
+	if rl.inFlight <= 0 {
+		return func() {}, nil
+	}
+
+	release, err := rl.acquire(ctx, host)
+	if err != nil {
+		rl.refund(taken)
+	}
+
+	return release, err
+}
+
+// refund gives a token back to the buckets of keys, for a request that is not sent.
+func (rl *rateLimiter) refund(keys []limitKey) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+
+	for _, key := range keys {
+		if e, limit := rl.buckets[key], rl.limit(key.scope); e != nil && limit != nil {
+			b := e.Value.(*bucket)
+			b.refill(limit, now)
+			b.tokens = min(b.tokens+1, limit.burst)
+		}
+	}
+}
+
+// take takes a token of the bucket of key, waiting for it to be refilled.
+func (rl *rateLimiter) take(ctx context.Context, limit *bucketLimit, key limitKey) error {
+	rl.mu.Lock()
+
+	now := time.Now()
+
+	var b *bucket
+
+	if e := rl.buckets[key]; e != nil {
+		rl.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		if len(rl.buckets) >= _rateLimitMaxBuckets {
+			rl.prune(now)
+		}
+
+		b = &bucket{key: key, tokens: limit.burst, last: now}
+		rl.buckets[key] = rl.lru.PushFront(b)
+	}
+
+	b.refill(limit, now)
+	b.tokens--
+
+	if b.tokens >= 0 {
+		rl.mu.Unlock()
+		return nil
+	}
+
+	delay := time.Duration(-b.tokens / limit.rate * float64(time.Second))
+	b.waiting++
+
+	rl.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		rl.mu.Lock()
+		b.waiting--
+		rl.mu.Unlock()
+
+		return nil
+	case <-ctx.Done():
+		// Give the reserved token back to the requests waiting after this one.
+		rl.mu.Lock()
+		b.waiting--
+		b.tokens++
+		rl.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// refill adds the tokens accumulated since the last update, up to the burst.
+func (b *bucket) refill(limit *bucketLimit, now time.Time) {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*limit.rate, limit.burst)
+	b.last = now
+}
+
+// prune removes the full buckets without waiting requests, then the least recently used buckets
+// without waiting requests until fewer than _rateLimitMaxBuckets remain. Called with rl.mu held.
+func (rl *rateLimiter) prune(now time.Time) {
+	for _, e := range rl.buckets {
+		b := e.Value.(*bucket)
+		if limit := rl.limit(b.key.scope); limit != nil && b.waiting == 0 {
+			if b.refill(limit, now); b.tokens >= limit.burst {
+				rl.remove(e)
+			}
+		}
+	}
+
+	for e := rl.lru.Back(); e != nil && len(rl.buckets) >= _rateLimitMaxBuckets; {
+		prev := e.Prev()
+		if e.Value.(*bucket).waiting == 0 {
+			rl.remove(e)
+		}
+
+		e = prev
+	}
+}
+
+// remove removes the bucket of e. Called with rl.mu held.
+func (rl *rateLimiter) remove(e *list.Element) {
+	delete(rl.buckets, rl.lru.Remove(e).(*bucket).key)
+}
+
+// limit returns the limit of a scope.
+func (rl *rateLimiter) limit(scope g.String) *bucketLimit {
+	switch scope {
+	case "global":
+		return rl.global
+	case "host":
+		return rl.host
+	default:
+		return rl.proxy
+	}
+}
+
+// acquire takes an in-flight slot of host, waiting for a request to complete.
+func (rl *rateLimiter) acquire(ctx context.Context, host g.String) (func(), error) {
+	rl.mu.Lock()
+
+	s := rl.slots[host]
+	if s == nil {
+		s = &hostSlots{sem: make(chan g.Unit, rl.inFlight)}
+		rl.slots[host] = s
+	}
+
+	s.waiting++
+	rl.mu.Unlock()
+
+	var err error
+
+	select {
+	case s.sem <- g.Unit{}:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	s.waiting--
+
+	if err != nil {
+		rl.forget(host, s)
+		return nil, err
+	}
+
+	s.inFlight++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+
+			<-s.sem
+			s.inFlight--
+			rl.forget(host, s)
+		})
+	}, nil
+}
+
+// forget removes the slots of host when no request uses them. Called with rl.mu held.
+func (rl *rateLimiter) forget(host g.String, s *hostSlots) {
+	if s.inFlight == 0 && s.waiting == 0 && rl.slots[host] == s {
+		delete(rl.slots, host)
+	}
+}
+
+// admit waits until req may be sent under the rate limits and the throttling of the client.
+// The returned function releases the in-flight slot of the request under MaxInFlight.
+func (c *Client) admit(req *Request) (func(), error) {
+	release := func() {}
+
+	builder := c.builder
+	if builder == nil {
+		return release, nil
+	}
+
+	if builder.limiter != nil {
+		var err error
+		if release, err = builder.limiter.wait(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if builder.throttle != nil {
+		if err := builder.throttle.wait(req); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// settle records the response or error of a request sent at sent for the throttling of the
+// client, and keeps the in-flight slot of the request under MaxInFlight until the body of the
+// response is closed, or garbage collected when it is never closed. It is released at once for
+// failed requests, responses without a body and WebSocket handshakes, whose body is the upgraded
+// connection.
+func (c *Client) settle(req *Request, sent time.Time, resp *http.Response, err error, release func()) {
+	if c.builder != nil && c.builder.throttle != nil {
+		c.builder.throttle.observe(req, sent, resp)
+	}
+
+	if err != nil || resp == nil || resp.Body == nil || resp.Body == http.NoBody || req.upgrade {
+		release()
+		return
+	}
+
+	rb := &releaseBody{ReadCloser: resp.Body, release: release}
+	runtime.AddCleanup(rb, func(release func()) { release() }, release)
+
+	resp.Body = rb
+}
+
+// releaseBody releases the in-flight slot of a request when its response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rb *releaseBody) Close() error {
+	defer rb.release()
+	return rb.ReadCloser.Close()
+}
+
+// stats returns the state of the buckets and in-flight slots.
+func (rl *rateLimiter) stats() g.Slice[RateLimitStats] {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	stats := make(g.Slice[RateLimitStats], 0, len(rl.buckets)+len(rl.slots))
+
+	for key, e := range rl.buckets {
+		b := e.Value.(*bucket)
+		if limit := rl.limit(key.scope); limit != nil {
+			b.refill(limit, now)
+		}
+
+		s := RateLimitStats{Scope: key.scope, Key: key.key, Tokens: b.tokens, Waiting: b.waiting}
+
+		if key.scope == "host" {
+			if slots := rl.slots[key.key]; slots != nil {
+				s.Waiting += slots.waiting
+				s.InFlight = slots.inFlight
+			}
+		}
+
+		stats = append(stats, s)
+	}
+
+	if rl.host == nil {
+		for host, slots := range rl.slots {
+			stats = append(stats, RateLimitStats{Scope: "host", Key: host, Waiting: slots.waiting, InFlight: slots.inFlight})
+		}
+	}
+
+	return stats
+}
+
+// RateLimits returns the state of the rate limits of the client: the buckets of the global,
+// host and proxy limits, and the in-flight requests per host.
+func (c *Client) RateLimits() g.Slice[RateLimitStats] {
+	if c.builder == nil || c.builder.limiter == nil {
+		return nil
+	}
+
+	return c.builder.limiter.stats()
+}
@@ -20,12 +20,20 @@ import (
 // It wraps the standard http.Request and provides enhanced features like middleware support,
 // retry capabilities, remote address tracking, and structured error handling.
 type Request struct {
-	err        error         // General error associated with the request (validation, setup, etc.)
-	remoteAddr net.Addr      // Remote server address captured during connection
-	bodyBytes  []byte        // Cached body bytes for retry support
-	request    *http.Request // The underlying standard HTTP request
-	cli        *Client       // The associated surf client for this request
-	multipart  *Multipart    // Multipart form data for file uploads and form submissions
+	err         error         // General error associated with the request (validation, setup, etc.)
+	remoteAddr  net.Addr      // Remote server address captured during connection
+	bodyBytes   []byte        // Cached body bytes for retry support
+	request     *http.Request // The underlying standard HTTP request
+	cli         *Client       // The associated surf client for this request
+	multipart   *Multipart    // Multipart form data for file uploads and form submissions
+	proxy       g.String      // Proxy URL selected for this request, empty for the client proxy
+	pooled      bool          // Proxy was picked from the proxy pool of the client
+	session     g.String      // Proxy pool session set with ProxySession
+	bearer      string        // OAuth2 access token set on the request, if any
+	upgrade     bool          // Request is a WebSocket opening handshake
+	revalidate  bool          // Request revalidates a stored response in the background
+	cached      bool          // Request answered by the HTTP cache with a stored response
+	echAccepted bool          // Server accepted Encrypted Client Hello on the connection
 }
 
 // GetRequest returns the underlying standard http.Request.
@@ -49,6 +57,45 @@ func (req *Request) Multipart(m *Multipart) *Request {
 	return req
 }
 
+// Proxy sends the request through proxy instead of the proxy of the client. The client keeps
+// a transport per proxy, so connections to the same proxy are reused across requests while the
+// cookie jar, middlewares and fingerprint of the client are shared.
+func (req *Request) Proxy(proxy g.String) *Request {
+	req.proxy = proxy
+	return req
+}
+
+// ProxySession sets the session of the request for a proxy pool with ProxyStickySession: requests
+// of the same session, from any client the pool is attached to, are sent through the same proxy
+// until it is quarantined. Requests without a session are bound to their client.
+func (req *Request) ProxySession(session g.String) *Request {
+	req.session = session
+	return req
+}
+
+// Presign returns the URL of the request presigned with the AWS Signature Version 4 settings of
+// the client, valid for expires, at most 7 days. Only the host is signed, so the URL can be
+// requested by any HTTP client.
+func (req *Request) Presign(expires time.Duration) g.Result[g.String] {
+	if req.err != nil {
+		return g.Err[g.String](req.err)
+	}
+
+	if req.cli.builder == nil || req.cli.builder.sigv4 == nil {
+		return g.Err[g.String](&ErrSigV4{"SigV4 is not configured"})
+	}
+
+	presigned, err := req.cli.builder.sigv4.presign(req, expires)
+	if err != nil {
+		return g.Err[g.String](err)
+	}
+
+	return g.Ok(g.String(presigned))
+}
+
+// replayable reports whether the body of the request can be sent again, from bodyBytes.
+func (req *Request) replayable() bool { return req.multipart == nil || req.multipart.retry }
+
 // prepareMultipart prepares the multipart body for the request.
 // It sets up the request body with a pipe reader and configures the Content-Type header.
 // Returns an error if both Body() and Multipart() were called, as they are mutually exclusive.
@@ -90,14 +137,34 @@ func (req *Request) Do() g.Result[*Response] {
 		return g.Err[*Response](req.err)
 	}
 
-	if err := req.cli.applyReqMW(req); err != nil {
+	err := req.cli.applyReqMW(req)
+
+	// The proxy picked from the pool for an attempt is refunded when the attempt is not sent
+	picked := req.pooled
+	unsent := func() {
+		if picked {
+			req.unsent()
+			picked = false
+		}
+	}
+
+	if err != nil {
+		unsent()
 		return g.Err[*Response](err)
 	}
 
+	if builder := req.cli.builder; builder != nil && builder.oauth2 != nil {
+		if err := builder.oauth2.authorize(req); err != nil {
+			unsent()
+			return g.Err[*Response](err)
+		}
+	}
+
 	if req.request.Method != http.MethodHead {
 		if req.multipart == nil || req.multipart.retry {
 			req.bodyBytes, req.request.Body, req.err = drainbody.DrainBody(req.request.Body)
 			if req.err != nil {
+				unsent()
 				return g.Err[*Response](req.err)
 			}
 		}
@@ -105,50 +172,224 @@ func (req *Request) Do() g.Result[*Response] {
 
 	var (
 		resp     *http.Response
-		attempts int
-		err      error
+		attempts g.Slice[Attempt]
+		rounds   int  // Requests answering authentication challenges
+		renewed  bool // Request resent with a new OAuth2 token
 	)
 
+	if err := req.cli.routeProxy(req); err != nil {
+		unsent()
+		return g.Err[*Response](err)
+	}
+
+	cli, err := req.cli.proxyClient(req.proxy)
+	if err != nil {
+		unsent()
+		return g.Err[*Response](err)
+	}
+
 	start := time.Now()
-	cli := req.cli.cli
 
 	builder := req.cli.builder
 
+	// Serve the request from the HTTP cache, or validate the stored response
+	var lookup cacheLookup
+	if builder != nil && builder.cache != nil {
+		if lookup = builder.cache.lookup(req); lookup.resp != nil {
+			unsent()
+
+			req.cached = true
+
+			return req.respond(lookup.resp, attempts, start, lookup.status)
+		}
+	}
+
+	if builder != nil && builder.contentDigest && len(req.bodyBytes) != 0 {
+		setContentDigest(req)
+	}
+
 retry:
 	// Restore body from saved bytes for retry attempts
-	if attempts > 0 && req.bodyBytes != nil {
+	if (attempts.Len() > 0 || rounds > 0) && req.bodyBytes != nil {
 		req.request.Body = io.NopCloser(bytes.NewReader(req.bodyBytes))
 	}
 
-	resp, err = cli.Do(req.request)
+	if builder != nil && builder.digestAuth != nil {
+		builder.digestAuth.authorize(req)
+	}
+
+	if builder != nil && builder.httpSigner != nil {
+		if err := signHTTPMessage(builder.httpSigner, req); err != nil {
+			unsent()
+			return failed(attempts, err)
+		}
+	}
+
+	if builder != nil && builder.sigv4 != nil {
+		builder.sigv4.sign(req)
+	}
+
+	if builder != nil && builder.breaker != nil {
+		if err := builder.breaker.allow(req); err != nil {
+			unsent()
+			return failed(attempts, err)
+		}
+	}
+
+	release, err := req.cli.admit(req)
 	if err != nil {
-		return g.Err[*Response](err)
+		if builder != nil && builder.breaker != nil {
+			builder.breaker.record(req, circuitIgnored)
+		}
+
+		unsent()
+
+		return failed(attempts, err)
+	}
+
+	sent := time.Now()
+
+	resp, err = cli.Do(req.request)
+	picked = false
+
+	req.cli.settle(req, sent, resp, err, release)
+
+	if builder != nil && builder.proxyPool != nil && req.request.Context().Err() == nil {
+		builder.proxyPool.report(req.proxy, time.Since(sent), err)
+	}
+
+	if builder != nil && builder.breaker != nil {
+		builder.breaker.observe(req, resp, err)
 	}
 
-	// Check if retry is needed based on status code and retry configuration
-	if builder != nil && builder.retryMax != 0 && attempts < builder.retryMax && !builder.retryCodes.IsEmpty() &&
-		builder.retryCodes.Contains(resp.StatusCode) {
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-		attempts++
-
-		if builder.retryWait > 0 {
-			timer := time.NewTimer(builder.retryWait)
-			select {
-			case <-timer.C:
-			case <-req.request.Context().Done():
-				timer.Stop()
-				return g.Err[*Response](req.request.Context().Err())
+	if err != nil {
+		if wait, ok := req.retry(&attempts, start, sent, nil, err); ok {
+			if err := req.wait(wait); err != nil {
+				return failed(attempts, err)
+			}
+
+			if cli, picked, err = req.repick(cli); err != nil {
+				return failed(attempts, err)
 			}
-		} else if ctx := req.request.Context(); ctx.Err() != nil {
-			return g.Err[*Response](ctx.Err())
+
+			goto retry
+		}
+
+		if builder != nil && builder.cache != nil {
+			if stale := builder.cache.staleIfError(req, lookup.entry); stale != nil {
+				req.cached = true
+				return req.respond(stale, attempts, start, CacheStale)
+			}
+		}
+
+		return failed(attempts, err)
+	}
+
+	// Answer a Digest challenge, or a stale nonce, with the request body replayed
+	if builder != nil && builder.digestAuth != nil && rounds < _digestAuthRounds && req.replayable() &&
+		builder.digestAuth.challenge(req, resp) {
+		req.discard(resp)
+		rounds++
+
+		goto retry
+	}
+
+	// Send the request once more with a new OAuth2 token when the server rejected the token as invalid
+	if builder != nil && builder.oauth2 != nil && !renewed && req.replayable() {
+		ok, err := builder.oauth2.reauthorize(req, resp)
+		if err != nil {
+			resp.Body.Close()
+			return failed(attempts, err)
+		}
+
+		if ok {
+			req.discard(resp)
+			renewed = true
+			rounds++
+
+			goto retry
+		}
+	}
+
+	// Check if retry is needed according to the retry policy
+	if wait, ok := req.retry(&attempts, start, sent, resp, nil); ok {
+		// The proxy of a retried response is checked for bans before the next pick
+		if builder != nil && builder.proxyPool != nil && !req.proxy.IsEmpty() {
+			resp.Body = builder.proxyPool.watch(req.proxy, resp.StatusCode, resp.Body)
+		}
+
+		req.discard(resp)
+
+		if err := req.wait(wait); err != nil {
+			return failed(attempts, err)
+		}
+
+		if cli, picked, err = req.repick(cli); err != nil {
+			return failed(attempts, err)
 		}
 
 		goto retry
 	}
 
+	status := CacheNone
+	if builder != nil && builder.cache != nil && !lookup.bypass {
+		resp, status = builder.cache.handle(req, sent, resp, lookup.entry)
+	}
+
+	return req.respond(resp, attempts, start, status)
+}
+
+// unsent refunds the proxy picked from the proxy pool for an attempt of req that is not sent.
+func (req *Request) unsent() {
+	if builder := req.cli.builder; builder != nil && builder.proxyPool != nil && req.pooled {
+		builder.proxyPool.refund(req.proxy)
+	}
+}
+
+// repick picks a proxy of the pool for the retry of a pooled request, whose previous attempt was
+// reported to the pool, and returns its client and whether a proxy was picked. Other requests
+// are retried with cli.
+func (req *Request) repick(cli *http.Client) (*http.Client, bool, error) {
+	if !req.pooled {
+		return cli, false, nil
+	}
+
+	pool := req.cli.builder.proxyPool
+
+	proxy, err := pool.pick(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cli, err = req.cli.proxyClient(proxy); err != nil {
+		pool.refund(proxy)
+		return nil, false, err
+	}
+
+	req.proxy = proxy
+
+	return cli, true, nil
+}
+
+// discard drains and closes resp, the response of an attempt that is not returned, and releases
+// the probe of a half-open circuit the attempt was waiting for its response middlewares to record.
+func (req *Request) discard(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if builder := req.cli.builder; builder != nil && builder.breaker != nil {
+		builder.breaker.release(req, resp)
+	}
+}
+
+// respond returns the Response of resp after the response middlewares, with the attempts of the
+// request started at start and how the HTTP cache of the client answered it.
+func (req *Request) respond(resp *http.Response, attempts g.Slice[Attempt], start time.Time, status CacheStatus) g.Result[*Response] {
+	builder := req.cli.builder
+
 	response := &Response{
 		Attempts:      attempts,
+		CacheStatus:   status,
 		Time:          time.Since(start),
 		Client:        req.cli,
 		ContentLength: resp.ContentLength,
@@ -159,6 +400,7 @@ retry:
 		URL:           resp.Request.URL,
 		UserAgent:     g.String(req.request.UserAgent()),
 		remoteAddr:    req.remoteAddr,
+		echAccepted:   req.echAccepted,
 		request:       req,
 		response:      resp,
 	}
@@ -181,6 +423,15 @@ retry:
 	return g.Ok(response)
 }
 
+// failed returns the error of a request, with its attempts when it was sent.
+func failed(attempts g.Slice[Attempt], err error) g.Result[*Response] {
+	if attempts.Len() == 0 {
+		return g.Err[*Response](err)
+	}
+
+	return g.Err[*Response](&ErrAttempts{Attempts: attempts, Err: err})
+}
+
 // WithContext associates a context with the request for cancellation and deadlines.
 // The context can be used to cancel the request, set timeouts, or pass request-scoped values.
 // Returns the request for method chaining. If ctx is nil, the request is unchanged.
@@ -313,8 +564,13 @@ func (req *Request) applyHeaders(
 // non-pseudo headers with non-empty values.
 func updateRequestHeaderOrder[T ~string](r *Request, h g.MapOrd[T, T]) g.MapOrd[T, T] {
 	if r.cli.builder != nil {
+		h = h.Clone()
+
 		method := r.request.Method
-		if r.cli.builder.http3 {
+		switch {
+		case r.upgrade:
+			method = "websocket"
+		case r.cli.builder.http3:
 			method += "http3"
 		}
 
@@ -0,0 +1,696 @@
+package surf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/enetx/g"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ResolverSettings provides a fluent interface for configuring the caching resolver of the client.
+type ResolverSettings struct {
+	builder     *Builder
+	upstreams   []g.String
+	overrides   []g.String
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+}
+
+// Upstream adds plain DNS servers (ip:port) the queries are sent to. Resolvers configured with
+// DNS, DNSOverTLS or DNSOverHTTPS are upstreams as well; without any upstream the queries go to
+// the name servers of the system configuration.
+func (rs *ResolverSettings) Upstream(addresses ...g.String) *ResolverSettings {
+	rs.upstreams = append(rs.upstreams, addresses...)
+	return rs
+}
+
+// Resolve adds static overrides in the curl --resolve format host:port:address[,address]...
+// IPv6 addresses may be enclosed in brackets. Lookups happen before the port is known, so an
+// override applies to every port of the host; the port is validated but otherwise ignored.
+func (rs *ResolverSettings) Resolve(entries ...g.String) *ResolverSettings {
+	rs.overrides = append(rs.overrides, entries...)
+	return rs
+}
+
+// MinTTL sets the minimum time positive answers are cached, regardless of their TTL.
+func (rs *ResolverSettings) MinTTL(ttl time.Duration) *ResolverSettings {
+	rs.minTTL = ttl
+	return rs
+}
+
+// MaxTTL sets the maximum time positive answers are cached, one hour by default. Zero removes the
+// cap, answers are then cached for their TTL.
+func (rs *ResolverSettings) MaxTTL(ttl time.Duration) *ResolverSettings {
+	rs.maxTTL = ttl
+	return rs
+}
+
+// NegativeTTL sets the maximum time NXDOMAIN and empty answers are cached,
+// used as is when the answer has no SOA record. Zero disables negative caching.
+func (rs *ResolverSettings) NegativeTTL(ttl time.Duration) *ResolverSettings {
+	rs.negativeTTL = ttl
+	return rs
+}
+
+// Set applies the resolver settings to the client.
+func (rs *ResolverSettings) Set() *Builder {
+	return rs.builder.addCliMW(func(client *Client) error { return resolverMW(client, rs) }, -1)
+}
+
+// Resolver is a caching DNS resolver shared by every connection of a client: TCP dials, proxy
+// target pre-resolution and HTTP/3 dials all look host names up through it.
+//
+// Answers are cached for their TTL, NXDOMAIN and empty answers for the negative TTL of their
+// SOA record (RFC 2308). Each query is sent to all upstreams at once and the first answer wins,
+// identical concurrent queries share a single exchange. Static overrides answer A and AAAA
+// queries without asking any upstream. The resolver is safe for concurrent use.
+type Resolver struct {
+	mu          sync.Mutex
+	entries     map[dnsKey]*dnsEntry
+	inflight    map[dnsKey]*dnsCall
+	overrides   map[string][]netip.Addr
+	upstreams   []dnsDialFunc
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+
+	hits       atomic.Uint64
+	negative   atomic.Uint64
+	misses     atomic.Uint64
+	coalesced  atomic.Uint64
+	overridden atomic.Uint64
+	failures   atomic.Uint64
+}
+
+// ResolverStats is a snapshot of the counters of a Resolver.
+type ResolverStats struct {
+	Hits      uint64 // Queries answered from the cache
+	Negative  uint64 // Cache hits of NXDOMAIN and empty answers, included in Hits
+	Misses    uint64 // Queries sent to the upstreams
+	Coalesced uint64 // Queries that waited for an identical query in flight
+	Overrides uint64 // Queries answered from static overrides
+	Failures  uint64 // Queries no upstream answered
+	Entries   int    // Cached answers, including expired ones not evicted yet
+}
+
+// dnsDialFunc connects to a DNS server, as the Dial field of net.Resolver.
+type dnsDialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dnsKey identifies the question of a cached answer.
+type dnsKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+// dnsEntry is a cached answer.
+type dnsEntry struct {
+	msg      []byte
+	expires  time.Time
+	negative bool
+}
+
+// dnsCall is an upstream exchange in flight.
+type dnsCall struct {
+	done chan struct{}
+	msg  []byte
+	err  error
+}
+
+// newResolver creates a resolver from the settings.
+func newResolver(rs *ResolverSettings) (*Resolver, error) {
+	r := &Resolver{
+		entries:     make(map[dnsKey]*dnsEntry),
+		inflight:    make(map[dnsKey]*dnsCall),
+		overrides:   make(map[string][]netip.Addr),
+		minTTL:      rs.minTTL,
+		maxTTL:      rs.maxTTL,
+		negativeTTL: rs.negativeTTL,
+	}
+
+	for _, address := range rs.upstreams {
+		if _, err := netip.ParseAddrPort(address.Std()); err != nil {
+			return nil, fmt.Errorf("invalid DNS upstream %q: %w", address, err)
+		}
+
+		r.addUpstream(func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address.Std())
+		})
+	}
+
+	for _, entry := range rs.overrides {
+		host, addrs, err := parseResolveEntry(entry.Std())
+		if err != nil {
+			return nil, err
+		}
+
+		r.overrides[host] = append(r.overrides[host], addrs...)
+	}
+
+	return r, nil
+}
+
+// parseResolveEntry parses a static override in the curl --resolve format.
+func parseResolveEntry(entry string) (string, []netip.Addr, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", nil, fmt.Errorf("invalid resolve entry %q: want host:port:address", entry)
+	}
+
+	if parts[1] != "*" {
+		if port, err := strconv.Atoi(parts[1]); err != nil || port < 1 || port > 65535 {
+			return "", nil, fmt.Errorf("invalid resolve entry %q: invalid port", entry)
+		}
+	}
+
+	var addrs []netip.Addr
+
+	for field := range strings.SplitSeq(parts[2], ",") {
+		addr, err := netip.ParseAddr(strings.Trim(strings.TrimSpace(field), "[]"))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid resolve entry %q: %w", entry, err)
+		}
+
+		addrs = append(addrs, addr.Unmap())
+	}
+
+	return strings.ToLower(strings.TrimSuffix(parts[0], ".")), addrs, nil
+}
+
+// Stats returns a snapshot of the resolver counters.
+func (r *Resolver) Stats() ResolverStats {
+	return ResolverStats{
+		Hits:      r.hits.Load(),
+		Negative:  r.negative.Load(),
+		Misses:    r.misses.Load(),
+		Coalesced: r.coalesced.Load(),
+		Overrides: r.overridden.Load(),
+		Failures:  r.failures.Load(),
+		Entries:   r.Len(),
+	}
+}
+
+// Len returns the number of cached answers.
+func (r *Resolver) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.entries)
+}
+
+// Clear removes all cached answers.
+func (r *Resolver) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clear(r.entries)
+}
+
+// addUpstream adds a DNS server the queries are raced against.
+func (r *Resolver) addUpstream(dial dnsDialFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.upstreams = append(r.upstreams, dial)
+}
+
+// netResolver returns a net.Resolver passing the queries of the Go resolver to r.
+func (r *Resolver) netResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			exchange := func(ctx context.Context, query []byte) ([]byte, error) {
+				return r.exchange(ctx, network, address, query)
+			}
+
+			return &dnsConn{ctx: ctx, exchange: exchange}, nil
+		},
+	}
+}
+
+// exchange answers the query from the overrides or the cache, or forwards it to the upstreams.
+// The Go resolver asks the name server at network and address, used without upstreams.
+func (r *Resolver) exchange(ctx context.Context, network, address string, query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	if answer, ok := r.override(h, q); ok {
+		r.overridden.Add(1)
+		return answer, nil
+	}
+
+	key := dnsKey{strings.ToLower(q.Name.String()), q.Type, q.Class}
+
+	r.mu.Lock()
+
+	if e, ok := r.entries[key]; ok {
+		if time.Now().Before(e.expires) {
+			r.mu.Unlock()
+
+			r.hits.Add(1)
+			if e.negative {
+				r.negative.Add(1)
+			}
+
+			return withDNSID(e.msg, h.ID), nil
+		}
+
+		delete(r.entries, key)
+	}
+
+	call, ok := r.inflight[key]
+	if ok {
+		r.mu.Unlock()
+		r.coalesced.Add(1)
+	} else {
+		call = &dnsCall{done: make(chan struct{})}
+		r.inflight[key] = call
+		upstreams := r.upstreams
+
+		r.mu.Unlock()
+		r.misses.Add(1)
+
+		// The exchange is shared, so it is not bound to the context of the first query.
+		go r.resolve(context.WithoutCancel(ctx), key, call, upstreams, network, address, query)
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return withDNSID(call.msg, h.ID), nil
+}
+
+// resolve sends the query of call to the upstreams and caches the answer.
+func (r *Resolver) resolve(
+	ctx context.Context,
+	key dnsKey,
+	call *dnsCall,
+	upstreams []dnsDialFunc,
+	network, address string,
+	query []byte,
+) {
+	ctx, cancel := context.WithTimeout(ctx, _resolverQueryTimeout)
+	defer cancel()
+
+	if len(upstreams) == 0 {
+		var dialer net.Dialer
+		upstreams = []dnsDialFunc{dialer.DialContext}
+	}
+
+	call.msg, call.err = raceDNS(ctx, upstreams, network, address, query)
+
+	r.mu.Lock()
+
+	delete(r.inflight, key)
+
+	if call.err == nil {
+		r.store(key, call.msg)
+	}
+
+	r.mu.Unlock()
+
+	if call.err != nil {
+		r.failures.Add(1)
+	}
+
+	close(call.done)
+}
+
+// override answers A and AAAA queries for hosts with static overrides.
+func (r *Resolver) override(h dnsmessage.Header, q dnsmessage.Question) ([]byte, bool) {
+	if q.Type != dnsmessage.TypeA && q.Type != dnsmessage.TypeAAAA {
+		return nil, false
+	}
+
+	addrs, ok := r.overrides[strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))]
+	if !ok {
+		return nil, false
+	}
+
+	answer, err := addressAnswer(h, q, addrs, 0)
+
+	return answer, err == nil
+}
+
+// store caches a response for the TTL derived from its records, r.mu must be held.
+func (r *Resolver) store(key dnsKey, msg []byte) {
+	ttl, negative, ok := r.ttl(msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	if len(r.entries) >= _resolverMaxEntries {
+		r.evict()
+	}
+
+	r.entries[key] = &dnsEntry{msg: msg, expires: time.Now().Add(ttl), negative: negative}
+}
+
+// evict removes the expired entries or, when none has expired, an arbitrary one.
+func (r *Resolver) evict() {
+	now := time.Now()
+
+	for key, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, key)
+		}
+	}
+
+	for key := range r.entries {
+		if len(r.entries) < _resolverMaxEntries {
+			break
+		}
+
+		delete(r.entries, key)
+	}
+}
+
+// ttl returns how long a response may be cached and whether it is a negative answer.
+// Positive answers live for the lowest TTL of their records, negative answers for the
+// SOA minimum of RFC 2308 section 5. Truncated and error responses are not cached.
+func (r *Resolver) ttl(msg []byte) (time.Duration, bool, bool) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(msg)
+	if err != nil || h.Truncated {
+		return 0, false, false
+	}
+
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return 0, false, false
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false, false
+	}
+
+	var (
+		answers int
+		minTTL  uint32
+	)
+
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+
+		if err != nil {
+			return 0, false, false
+		}
+
+		if answers == 0 || rh.TTL < minTTL {
+			minTTL = rh.TTL
+		}
+
+		answers++
+
+		if err := p.SkipAnswer(); err != nil {
+			return 0, false, false
+		}
+	}
+
+	if h.RCode == dnsmessage.RCodeSuccess && answers > 0 {
+		ttl := time.Duration(minTTL) * time.Second
+		ttl = max(ttl, r.minTTL)
+		if r.maxTTL > 0 {
+			ttl = min(ttl, r.maxTTL)
+		}
+
+		return ttl, false, true
+	}
+
+	ttl := r.negativeTTL
+
+	for {
+		rh, err := p.AuthorityHeader()
+		if err != nil {
+			break
+		}
+
+		if rh.Type != dnsmessage.TypeSOA {
+			if p.SkipAuthority() != nil {
+				break
+			}
+
+			continue
+		}
+
+		soa, err := p.SOAResource()
+		if err != nil {
+			break
+		}
+
+		ttl = min(ttl, time.Duration(min(rh.TTL, soa.MinTTL))*time.Second)
+
+		break
+	}
+
+	return ttl, true, true
+}
+
+// raceDNS sends the query to all upstreams at once and returns the first answer with
+// a NOERROR or NXDOMAIN code, or else any answer received or the last error.
+func raceDNS(ctx context.Context, upstreams []dnsDialFunc, network, address string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		msg []byte
+		err error
+	}
+
+	results := make(chan result, len(upstreams))
+
+	for _, dial := range upstreams {
+		go func() {
+			msg, err := queryDNS(ctx, dial, network, address, query)
+			results <- result{msg, err}
+		}()
+	}
+
+	var fallback result
+
+	for range upstreams {
+		res := <-results
+
+		if res.err != nil {
+			if fallback.msg == nil {
+				fallback.err = res.err
+			}
+
+			continue
+		}
+
+		var p dnsmessage.Parser
+
+		h, err := p.Start(res.msg)
+		if err == nil && (h.RCode == dnsmessage.RCodeSuccess || h.RCode == dnsmessage.RCodeNameError) {
+			return res.msg, nil
+		}
+
+		fallback = res
+	}
+
+	return fallback.msg, fallback.err
+}
+
+// queryDNS exchanges the query with the server, repeating it over TCP when
+// the UDP response is truncated.
+func queryDNS(ctx context.Context, dial dnsDialFunc, network, address string, query []byte) ([]byte, error) {
+	msg, err := exchangeDNS(ctx, dial, network, address, query)
+	if err == nil && network == "udp" && len(msg) > 2 && msg[2]&0x02 != 0 {
+		return exchangeDNS(ctx, dial, "tcp", address, query)
+	}
+
+	return msg, err
+}
+
+// exchangeDNS sends the query over a new connection, as a datagram over packet connections
+// and length-prefixed over stream connections, and returns the response with the same ID.
+func exchangeDNS(ctx context.Context, dial dnsDialFunc, network, address string, query []byte) ([]byte, error) {
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 64<<10)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+
+			if n >= 12 && bytes.Equal(buf[:2], query[:2]) {
+				return bytes.Clone(buf[:n]), nil
+			}
+		}
+	}
+
+	msg := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp) < 12 || !bytes.Equal(resp[:2], query[:2]) {
+		return nil, errors.New("DNS response does not match the query")
+	}
+
+	return resp, nil
+}
+
+// withDNSID returns a copy of the DNS message with the given ID.
+func withDNSID(msg []byte, id uint16) []byte {
+	msg = bytes.Clone(msg)
+	binary.BigEndian.PutUint16(msg, id)
+
+	return msg
+}
+
+// addressAnswer builds the response to an A or AAAA query with the addresses of its family.
+func addressAnswer(h dnsmessage.Header, q dnsmessage.Question, addrs []netip.Addr, ttl uint32) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+	})
+
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+
+		switch {
+		case q.Type == dnsmessage.TypeA && addr.Is4():
+			b.AResource(rh, dnsmessage.AResource{A: addr.As4()})
+		case q.Type == dnsmessage.TypeAAAA && addr.Is6():
+			b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: addr.As16()})
+		}
+	}
+
+	return b.Finish()
+}
+
+// dnsConn adapts the DNS over TCP exchange of the Go resolver to an exchange function.
+// Every complete length-prefixed query written is passed to exchange and its
+// length-prefixed response is returned by Read.
+type dnsConn struct {
+	ctx      context.Context
+	exchange func(context.Context, []byte) ([]byte, error)
+	deadline atomic.Int64
+	wbuf     bytes.Buffer
+	rbuf     bytes.Buffer
+}
+
+func (c *dnsConn) Write(p []byte) (int, error) {
+	c.wbuf.Write(p)
+
+	ctx := c.ctx
+	if deadline := c.deadline.Load(); deadline != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, deadline))
+		defer cancel()
+	}
+
+	for c.wbuf.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+n {
+			break
+		}
+
+		query := c.wbuf.Next(2 + n)[2:]
+
+		answer, err := c.exchange(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+
+		c.rbuf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+		c.rbuf.Write(answer)
+	}
+
+	return len(p), nil
+}
+
+func (c *dnsConn) Read(p []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return c.rbuf.Read(p)
+}
+
+func (c *dnsConn) Close() error                       { return nil }
+func (c *dnsConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *dnsConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *dnsConn) SetDeadline(t time.Time) error      { c.setDeadline(t); return nil }
+func (c *dnsConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *dnsConn) SetWriteDeadline(t time.Time) error { c.setDeadline(t); return nil }
+
+func (c *dnsConn) setDeadline(t time.Time) {
+	if t.IsZero() {
+		c.deadline.Store(0)
+	} else {
+		c.deadline.Store(t.UnixNano())
+	}
+}
@@ -14,20 +14,23 @@ import (
 // It wraps the standard http.Response and provides additional features like timing information,
 // retry attempts tracking, enhanced cookie management, and convenient access methods.
 type Response struct {
-	Headers       Headers        // Response headers with convenience methods
-	Cookies       Cookies        // Response cookies with enhanced functionality
-	UserAgent     g.String       // User agent that was used for the request
-	Proto         g.String       // HTTP protocol version (HTTP/1.1, HTTP/2, HTTP/3)
-	remoteAddr    net.Addr       // Remote server address captured during connection
-	*Client                      // Embedded client provides access to all client functionality
-	URL           *url.URL       // Final URL after following redirects
-	response      *http.Response // Underlying standard HTTP response
-	Body          *Body          // Enhanced response body with compression support and caching
-	request       *Request       // The original request that generated this response
-	Time          time.Duration  // Total request duration including retries
-	ContentLength int64          // Content-Length header value (-1 if not specified)
-	StatusCode    StatusCode     // HTTP status code with convenience methods
-	Attempts      int            // Number of retry attempts made for this request
+	Headers       Headers          // Response headers with convenience methods
+	Cookies       Cookies          // Response cookies with enhanced functionality
+	UserAgent     g.String         // User agent that was used for the request
+	Proto         g.String         // HTTP protocol version (HTTP/1.1, HTTP/2, HTTP/3)
+	remoteAddr    net.Addr         // Remote server address captured during connection
+	*Client                        // Embedded client provides access to all client functionality
+	URL           *url.URL         // Final URL after following redirects
+	response      *http.Response   // Underlying standard HTTP response
+	Body          *Body            // Enhanced response body with compression support and caching
+	request       *Request         // The original request that generated this response
+	Time          time.Duration    // Total request duration including retries
+	ContentLength int64            // Content-Length header value (-1 if not specified)
+	StatusCode    StatusCode       // HTTP status code with convenience methods
+	Attempts      g.Slice[Attempt] // Attempts of the request, the last one answered with the response
+	CacheStatus   CacheStatus      // How the HTTP cache of the client answered the request
+	echAccepted   bool             // Server accepted Encrypted Client Hello on the connection
+	digest        *digestReader    // Content-Digest of the body verified as it is read
 }
 
 // GetResponse returns the underlying standard http.Response.
@@ -50,6 +53,10 @@ func (resp Response) GetCookies(rawURL g.String) []*http.Cookie { return resp.ge
 // Useful for logging, debugging, or connection analysis.
 func (resp Response) RemoteAddress() net.Addr { return resp.remoteAddr }
 
+// ECHAccepted reports whether the server accepted Encrypted Client Hello on the connection
+// the response was received on.
+func (resp Response) ECHAccepted() bool { return resp.echAccepted }
+
 // SetCookies stores cookies in the client's cookie jar for the specified URL.
 // This allows the cookies to be automatically sent with future requests to matching URLs.
 func (resp *Response) SetCookies(rawURL g.String, cookies []*http.Cookie) error {
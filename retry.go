@@ -0,0 +1,279 @@
+package surf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http2"
+	"github.com/enetx/surf/header"
+	"github.com/enetx/surf/pkg/connectproxy"
+)
+
+// RetryPolicy decides whether a request is sent again after an attempt, and how long to wait
+// before the next attempt. Policies are attached to clients with Builder.RetryPolicy and must be
+// safe for concurrent use.
+//
+// Policies are consulted after the authentication challenges of an attempt are answered, only
+// for requests whose body can be sent again and whose context is not done.
+type RetryPolicy interface {
+	// Retry reports the wait before the next attempt and whether the request is retried.
+	Retry(req *Request, attempt Attempt) (time.Duration, bool)
+}
+
+// Attempt records an attempt of a request.
+type Attempt struct {
+	Number     int           // Number of the attempt, starting at 1
+	StatusCode StatusCode    // Status code of the response, zero when the attempt failed
+	Headers    Headers       // Headers of the response, nil when the attempt failed
+	Err        error         // Error of the attempt, nil when a response was received
+	Duration   time.Duration // Time from sending the request to the response or the error
+	Elapsed    time.Duration // Time from sending the first attempt to the end of this one
+	Wait       time.Duration // Wait before the next attempt
+}
+
+// statusRetry is the retry policy of Builder.Retry: a fixed number of retries of responses
+// with a status code of a set, after a fixed wait.
+type statusRetry struct {
+	max   int
+	wait  time.Duration
+	codes g.Slice[int]
+}
+
+func (sr *statusRetry) Retry(_ *Request, attempt Attempt) (time.Duration, bool) {
+	return sr.wait, attempt.Err == nil && attempt.Number <= sr.max &&
+		sr.codes.Contains(int(attempt.StatusCode))
+}
+
+// Backoff is a retry policy with exponential backoff and full jitter. The wait before the n-th
+// retry is random, between zero and min(cap, base * 2^(n-1)), or the delay of the Retry-After
+// header of the response when there is one.
+//
+// Responses with a retryable status code and errors classified by IsRetryableError are retried,
+// for idempotent requests only by default: GET, HEAD, OPTIONS, TRACE, PUT and DELETE requests,
+// and requests with an Idempotency-Key header.
+type Backoff struct {
+	maxRetries    int
+	base          time.Duration
+	cap           time.Duration
+	maxElapsed    time.Duration
+	codes         g.Slice[int]
+	nonIdempotent bool
+}
+
+// NewBackoff creates a backoff policy retrying a request at most maxRetries times, the
+// responses with the status codes 429, 500, 502, 503 and 504.
+func NewBackoff(maxRetries int) *Backoff {
+	return &Backoff{
+		maxRetries: maxRetries,
+		base:       _backoffBase,
+		cap:        _backoffCap,
+		codes: g.SliceOf(
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		),
+	}
+}
+
+// Base sets the maximum wait before the first retry, 200ms by default.
+func (b *Backoff) Base(base time.Duration) *Backoff {
+	b.base = base
+	return b
+}
+
+// Cap sets the maximum wait before a retry, 30s by default. Retry-After delays are not capped.
+func (b *Backoff) Cap(maxWait time.Duration) *Backoff {
+	b.cap = maxWait
+	return b
+}
+
+// MaxElapsed stops the retries of a request once the next attempt would be sent more than
+// maxElapsed after the first one.
+func (b *Backoff) MaxElapsed(maxElapsed time.Duration) *Backoff {
+	b.maxElapsed = maxElapsed
+	return b
+}
+
+// StatusCodes sets the status codes of the retried responses.
+func (b *Backoff) StatusCodes(codes ...int) *Backoff {
+	b.codes = g.SliceOf(codes...)
+	return b
+}
+
+// NonIdempotent retries non-idempotent requests as well, such as POST requests.
+func (b *Backoff) NonIdempotent() *Backoff {
+	b.nonIdempotent = true
+	return b
+}
+
+// Retry implements RetryPolicy.
+func (b *Backoff) Retry(req *Request, attempt Attempt) (time.Duration, bool) {
+	if attempt.Number > b.maxRetries || !b.nonIdempotent && !idempotent(req.request) {
+		return 0, false
+	}
+
+	if attempt.Err != nil {
+		if !IsRetryableError(attempt.Err) {
+			return 0, false
+		}
+	} else if !b.codes.Contains(int(attempt.StatusCode)) {
+		return 0, false
+	}
+
+	wait, ok := retryAfter(attempt.Headers, time.Now())
+	if !ok {
+		wait = b.cap
+		if shift := attempt.Number - 1; shift < 62 && b.base < b.cap>>shift {
+			wait = b.base << shift
+		}
+
+		if wait > 0 {
+			wait = rand.N(wait + 1)
+		}
+	}
+
+	if b.maxElapsed > 0 && attempt.Elapsed+wait > b.maxElapsed {
+		return 0, false
+	}
+
+	return wait, true
+}
+
+// idempotent reports whether a request can be sent more than once with the same effect.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut,
+		http.MethodDelete:
+		return true
+	default:
+		return r.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+// retryAfter returns the delay of the Retry-After header, in seconds or an HTTP-date.
+func retryAfter(headers Headers, now time.Time) (time.Duration, bool) {
+	value := headers.Get(header.RETRY_AFTER).Trim()
+	if value.IsEmpty() {
+		return 0, false
+	}
+
+	if seconds := value.TryInt(); seconds.IsOk() {
+		if seconds.Ok() < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds.Ok()) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value.Std())
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+// IsRetryableError reports whether a request failing with err may succeed when sent again:
+// timeouts, reset, refused and aborted connections, connections closed before the response,
+// HTTP/2 GOAWAY frames and refused streams, temporary DNS failures, proxy tunnel timeouts and
+// CONNECT requests answered with 502, 503 or 504 by the proxy.
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		goAway       http2.GoAwayError
+		streamErr    http2.StreamError
+		proxyTimeout *connectproxy.ErrProxyTimeout
+		proxyStatus  *connectproxy.ErrProxyStatus
+	)
+
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ETIMEDOUT),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &goAway),
+		errors.As(err, &proxyTimeout):
+		return true
+	case errors.As(err, &streamErr):
+		return streamErr.Code == http2.ErrCodeRefusedStream
+	case errors.As(err, &proxyStatus):
+		switch proxyStatus.StatusCode() {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+
+		return false
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retry records an attempt of the request, ended with resp or err, and reports the wait before
+// the next attempt when the retry policy of the client retries the request.
+func (req *Request) retry(attempts *g.Slice[Attempt], start, sent time.Time, resp *http.Response,
+	err error,
+) (time.Duration, bool) {
+	now := time.Now()
+
+	attempt := Attempt{
+		Number:   int(attempts.Len()) + 1,
+		Err:      err,
+		Duration: now.Sub(sent),
+		Elapsed:  now.Sub(start),
+	}
+
+	if resp != nil {
+		attempt.StatusCode = StatusCode(resp.StatusCode)
+		attempt.Headers = Headers(resp.Header)
+	}
+
+	attempts.Push(attempt)
+
+	builder := req.cli.builder
+	if builder == nil || builder.retryPolicy == nil || !req.replayable() || req.request.Context().Err() != nil {
+		return 0, false
+	}
+
+	wait, ok := builder.retryPolicy.Retry(req, attempt)
+	if !ok {
+		return 0, false
+	}
+
+	last := &(*attempts)[attempts.Len()-1]
+	last.Wait = max(wait, 0)
+
+	return last.Wait, true
+}
+
+// wait waits before the next attempt of the request, or until its context is done.
+func (req *Request) wait(wait time.Duration) error {
+	ctx := req.request.Context()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,319 @@
+package surf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+)
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+	sigV4TimeFormat      = "20060102T150405Z"
+)
+
+// sigV4IgnoredHeaders are not signed, as they are changed or added on the way to the server.
+var sigV4IgnoredHeaders = g.SetOf(
+	"authorization",
+	"connection",
+	"expect",
+	"transfer-encoding",
+	"user-agent",
+	"x-amzn-trace-id",
+)
+
+// AWSCredentials are the credentials requests are signed with by SigV4. SessionToken is set for
+// temporary credentials issued by AWS STS.
+type AWSCredentials struct {
+	AccessKeyID     g.String
+	SecretAccessKey g.String
+	SessionToken    g.String
+}
+
+// SigV4Settings provides a fluent interface for signing the requests of the client with AWS
+// Signature Version 4, for AWS services, API Gateway endpoints and S3-compatible storages.
+type SigV4Settings struct {
+	builder  *Builder
+	signer   *sigV4
+	unsigned bool
+}
+
+// UnsignedPayload signs requests without hashing their bodies, sending UNSIGNED-PAYLOAD as the
+// payload hash, as S3 accepts over HTTPS.
+func (svs *SigV4Settings) UnsignedPayload() *SigV4Settings {
+	svs.unsigned = true
+	return svs
+}
+
+// Now sets the clock the requests are signed at, time.Now by default.
+func (svs *SigV4Settings) Now(now func() time.Time) *SigV4Settings {
+	svs.signer.now = now
+	return svs
+}
+
+// Set applies the SigV4 settings to the client. Requests are signed after the request middlewares
+// and the builder headers, every time they are sent. Bodies that are streamed, such as multipart
+// bodies without Multipart.Retry, are sent with UNSIGNED-PAYLOAD. Incomplete settings fail Build.
+func (svs *SigV4Settings) Set() *Builder {
+	signer := svs.signer
+	signer.unsigned = svs.unsigned
+
+	svs.builder.sigv4 = signer
+
+	return svs.builder.addCliMW(func(*Client) error {
+		switch {
+		case signer.region == "":
+			return &ErrSigV4{"region is not set"}
+		case signer.service == "":
+			return &ErrSigV4{"service is not set"}
+		case signer.credentials.AccessKeyID.IsEmpty() || signer.credentials.SecretAccessKey.IsEmpty():
+			return &ErrSigV4{"credentials are not set"}
+		}
+
+		return nil
+	}, 0)
+}
+
+// sigV4 signs requests for a region and a service (AWS Signature Version 4).
+type sigV4 struct {
+	region      string
+	service     string
+	credentials AWSCredentials
+	unsigned    bool             // Sign UNSIGNED-PAYLOAD instead of the body hash
+	now         func() time.Time // Clock of the signatures, time.Now when nil
+}
+
+// time returns the current time of the signatures, in UTC.
+func (s *sigV4) time() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+// sign sets the X-Amz-Date, X-Amz-Content-Sha256, X-Amz-Security-Token and Authorization headers
+// of req, signing its headers and body at the current time.
+func (s *sigV4) sign(req *Request) {
+	r := req.request
+	now := s.time()
+
+	payload := sigV4UnsignedPayload
+	if !s.unsigned && req.replayable() {
+		payload = sha256Hex(req.bodyBytes)
+	}
+
+	r.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+
+	// S3 requires the payload hash, other services only when the body is not signed.
+	if s.service == "s3" || payload == sigV4UnsignedPayload {
+		r.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+
+	if token := s.credentials.SessionToken; !token.IsEmpty() {
+		r.Header.Set("X-Amz-Security-Token", token.Std())
+	}
+
+	signed, canonical := s.canonicalHeaders(r)
+
+	scope, signature := s.signature(now, r, r.URL.Query(), canonical, signed, payload)
+
+	r.Header.Set(header.AUTHORIZATION, sigV4Algorithm+" Credential="+s.credentials.AccessKeyID.Std()+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// presign returns the URL of req with the SigV4 query parameters authorizing it for expires.
+func (s *sigV4) presign(req *Request, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > _sigV4MaxPresignExpiry {
+		return "", &ErrSigV4{"presigned URL expiry must be between 1 second and 7 days"}
+	}
+
+	r := req.request
+	now := s.time()
+
+	payload := sha256Hex(nil)
+	if s.service == "s3" {
+		payload = sigV4UnsignedPayload
+	}
+
+	query := r.URL.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.credentials.AccessKeyID.Std()+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	if token := s.credentials.SessionToken; !token.IsEmpty() {
+		query.Set("X-Amz-Security-Token", token.Std())
+	}
+
+	_, signature := s.signature(now, r, query, "host:"+requestHost(r)+"\n", "host", payload)
+
+	query.Set("X-Amz-Signature", signature)
+
+	u := *r.URL
+	u.RawQuery = canonicalQuery(query)
+
+	return u.String(), nil
+}
+
+// signature returns the credential scope and the signature of the canonical request of r with
+// the query, the canonical and signed headers and the payload hash (SigV4 tasks 1 to 3).
+func (s *sigV4) signature(
+	now time.Time,
+	r *http.Request,
+	query url.Values,
+	canonical, signed, payload string,
+) (string, string) {
+	// S3 object keys are signed encoded once as they are, other services sign normalized and twice
+	// encoded paths.
+	uri := uriEncode(r.URL.Path, false)
+
+	if s.service != "s3" {
+		escaped := r.URL.EscapedPath()
+
+		cleaned := path.Clean("/" + escaped)
+		if strings.HasSuffix(escaped, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+
+		uri = uriEncode(cleaned, false)
+	}
+
+	if uri == "" {
+		uri = "/"
+	}
+
+	request := strings.Join([]string{r.Method, uri, canonicalQuery(query), canonical, signed, payload}, "\n")
+
+	scope := s.scope(now)
+	toSign := sigV4Algorithm + "\n" + now.Format(sigV4TimeFormat) + "\n" + scope + "\n" + sha256Hex([]byte(request))
+
+	key := []byte("AWS4" + s.credentials.SecretAccessKey.Std())
+	for _, part := range []string{now.Format("20060102"), s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// scope returns the credential scope of the date of now.
+func (s *sigV4) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+// canonicalHeaders returns the signed header names of r and their canonical form: the host and the
+// headers of r except the ignored ones, lower-cased and sorted, with trimmed values.
+func (s *sigV4) canonicalHeaders(r *http.Request) (string, string) {
+	headers := map[string]string{"host": requestHost(r)}
+
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+
+		// Header order keys of the transport are not sent.
+		if strings.Contains(name, ":") || sigV4IgnoredHeaders.Contains(name) {
+			continue
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var canonical strings.Builder
+
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// requestHost returns the Host header r is sent with.
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+
+	return r.URL.Host
+}
+
+// canonicalQuery returns the query encoded with uriEncode and sorted by name, then by value.
+func canonicalQuery(query url.Values) string {
+	params := make([][2]string, 0, len(query))
+
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, [2]string{uriEncode(name, true), uriEncode(value, true)})
+		}
+	}
+
+	slices.SortFunc(params, func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a[1], b[1])
+	})
+
+	encoded := make([]string, len(params))
+	for i, param := range params {
+		encoded[i] = param[0] + "=" + param[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// uriEncode percent-encodes every byte of s except the unreserved characters, and the slashes
+// unless encodeSlash is set, with upper-case hex digits.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+
+	for i := range len(s) {
+		switch c := s[i]; {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
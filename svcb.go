@@ -0,0 +1,341 @@
+package surf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// svcbCache remembers the service endpoints of origins learned from their HTTPS records,
+// including the absence of a usable record.
+type svcbCache struct {
+	mu      sync.Mutex
+	entries map[string]svcbEntry
+}
+
+// svcbEntry is the service endpoint of an origin, nil when the origin has none.
+type svcbEntry struct {
+	endpoint *svcbEndpoint
+	expires  time.Time
+}
+
+// svcbEndpoint is the service endpoint an HTTPS record (RFC 9460) publishes for an origin.
+// Only records for the origin host itself (TargetName ".") are used.
+type svcbEndpoint struct {
+	alpn  []string      // Protocols supported by the endpoint, such as h3 and h2
+	port  uint16        // Port of the endpoint, 0 for the port of the origin
+	hints []netip.Addr  // ipv4hint and ipv6hint addresses
+	ech   []byte        // ECHConfigList
+	ttl   time.Duration // Remaining lifetime of the record
+}
+
+// supports reports whether the endpoint advertises the ALPN protocol.
+func (e *svcbEndpoint) supports(protocol string) bool { return slices.Contains(e.alpn, protocol) }
+
+// hinted returns the hint addresses joined with the endpoint port or, without one, port.
+func (e *svcbEndpoint) hinted(port string) []string {
+	if e.port != 0 {
+		port = strconv.Itoa(int(e.port))
+	}
+
+	addresses := make([]string, 0, len(e.hints))
+	for _, hint := range e.hints {
+		addresses = append(addresses, net.JoinHostPort(hint.String(), port))
+	}
+
+	return addresses
+}
+
+// lookup returns the service endpoint of the origin host:port, querying its HTTPS record
+// through resolver when it is not cached. Returns nil when the origin publishes none.
+func (c *svcbCache) lookup(ctx context.Context, resolver *net.Resolver, host, port string) *svcbEndpoint {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	origin := net.JoinHostPort(host, port)
+
+	c.mu.Lock()
+
+	if e, ok := c.entries[origin]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.endpoint
+	}
+
+	c.mu.Unlock()
+
+	// RFC 9460 section 9.1: origins on other ports use a port-prefixed name.
+	name := host
+	if port != "443" {
+		name = "_" + port + "._https." + host
+	}
+
+	records, ttl, err := lookupHTTPS(ctx, resolver, name)
+	if err != nil {
+		records, ttl = nil, _svcbNegativeTTL
+	}
+
+	ttl = max(ttl, time.Second)
+
+	endpoint := newSVCBEndpoint(records)
+	if endpoint != nil {
+		endpoint.ttl = ttl
+	} else {
+		ttl = min(ttl, _svcbNegativeTTL)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]svcbEntry)
+	}
+
+	if _, ok := c.entries[origin]; !ok && len(c.entries) >= _svcbMaxEntries {
+		c.evict()
+	}
+
+	c.entries[origin] = svcbEntry{endpoint: endpoint, expires: time.Now().Add(ttl)}
+
+	return endpoint
+}
+
+// evict removes the expired entries or, when none has expired, an arbitrary one.
+// Called with c.mu held.
+func (c *svcbCache) evict() {
+	now := time.Now()
+
+	for origin, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, origin)
+		}
+	}
+
+	for origin := range c.entries {
+		if len(c.entries) < _svcbMaxEntries {
+			break
+		}
+
+		delete(c.entries, origin)
+	}
+}
+
+// dialContext dials addr with the client dialer. With HTTPSRecords, the ipv4hint and ipv6hint
+// addresses published for the origin are tried in turn when its name does not resolve
+// (RFC 9460 section 7.3).
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := c.dial(ctx, network, addr)
+
+	var dnsErr *net.DNSError
+	if err == nil || c.builder == nil || !c.builder.httpsRecords || !errors.As(err, &dnsErr) {
+		return conn, err
+	}
+
+	host, port, serr := net.SplitHostPort(addr)
+	if serr != nil {
+		return nil, err
+	}
+
+	endpoint := c.svcb.lookup(ctx, c.dialer.Resolver, host, port)
+	if endpoint == nil {
+		return nil, err
+	}
+
+	for _, hinted := range endpoint.hinted(port) {
+		if conn, herr := c.dial(ctx, network, hinted); herr == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// newSVCBEndpoint returns the endpoint of the record with the highest priority that
+// targets the origin host itself, or nil.
+func newSVCBEndpoint(records []dnsmessage.HTTPSResource) *svcbEndpoint {
+	for _, record := range records {
+		if record.Target.String() != "." {
+			continue
+		}
+
+		endpoint := new(svcbEndpoint)
+
+		if value, ok := record.GetParam(dnsmessage.SVCParamALPN); ok {
+			for len(value) > 0 && int(value[0]) < len(value) {
+				endpoint.alpn = append(endpoint.alpn, string(value[1:1+value[0]]))
+				value = value[1+value[0]:]
+			}
+		}
+
+		if value, ok := record.GetParam(dnsmessage.SVCParamPort); ok && len(value) == 2 {
+			endpoint.port = binary.BigEndian.Uint16(value)
+		}
+
+		if value, ok := record.GetParam(dnsmessage.SVCParamIPv4Hint); ok {
+			for ; len(value) >= 4; value = value[4:] {
+				endpoint.hints = append(endpoint.hints, netip.AddrFrom4([4]byte(value[:4])))
+			}
+		}
+
+		if value, ok := record.GetParam(dnsmessage.SVCParamIPv6Hint); ok {
+			for ; len(value) >= 16; value = value[16:] {
+				endpoint.hints = append(endpoint.hints, netip.AddrFrom16([16]byte(value[:16])))
+			}
+		}
+
+		if value, ok := record.GetParam(dnsmessage.SVCParamECH); ok {
+			endpoint.ech = value
+		}
+
+		return endpoint
+	}
+
+	return nil
+}
+
+// lookupHTTPS queries the HTTPS records (RFC 9460) of name from the first name server of
+// /etc/resolv.conf, dialed with the resolver of the client dialer when it has one. Returns the
+// ServiceMode records ordered by priority and the lowest TTL of the answer.
+func lookupHTTPS(ctx context.Context, resolver *net.Resolver, name string) ([]dnsmessage.HTTPSResource, time.Duration, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET})
+	b.StartAdditionals()
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+
+	query, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dial dnsDialFunc
+
+	if resolver != nil && resolver.Dial != nil {
+		dial = resolver.Dial
+	} else {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+
+	msg, err := queryDNS(ctx, dial, "udp", systemNameserver(), query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parseHTTPS(msg)
+}
+
+// parseHTTPS returns the ServiceMode HTTPS records of a response ordered by priority
+// and the lowest TTL of the answer. AliasMode records are ignored.
+func parseHTTPS(msg []byte) ([]dnsmessage.HTTPSResource, time.Duration, error) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return nil, 0, errors.New("HTTPS record lookup failed: " + h.RCode.String())
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		records []dnsmessage.HTTPSResource
+		ttl     uint32
+		first   = true
+	)
+
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if first || rh.TTL < ttl {
+			ttl, first = rh.TTL, false
+		}
+
+		if rh.Type != dnsmessage.TypeHTTPS {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+
+			continue
+		}
+
+		record, err := p.HTTPSResource()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if record.Priority != 0 {
+			records = append(records, record)
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b dnsmessage.HTTPSResource) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+
+	return records, time.Duration(ttl) * time.Second, nil
+}
+
+// systemNameserver returns the address of the first name server of /etc/resolv.conf, read once,
+// or the local resolver without one, as the Go resolver falls back to.
+var systemNameserver = sync.OnceValue(func() string { return readNameserver("/etc/resolv.conf") })
+
+// readNameserver returns the address of the first name server of the resolv.conf file at path,
+// or the local resolver when the file cannot be read or lists none.
+func readNameserver(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+
+		if ip, err := netip.ParseAddr(fields[1]); err == nil {
+			return net.JoinHostPort(ip.String(), "53")
+		}
+	}
+
+	return "127.0.0.1:53"
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/enetx/g"
 	ehttp "github.com/enetx/http"
 	"github.com/enetx/http/httptest"
 	"github.com/enetx/surf"
@@ -269,6 +270,27 @@ func TestAdapterRoundTripDirect(t *testing.T) {
 	}
 }
 
+func TestAdapterRequestProxy(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	proxy, tunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		ProxySelector(func(*surf.Request) g.String { return proxy }).
+		Build().Unwrap()
+
+	resp, err := client.Std().Get(url.Std())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if tunnels.Load() != 1 {
+		t.Fatalf("expected the request to go through the selected proxy, got %d tunnels", tunnels.Load())
+	}
+}
+
 func TestAdapterRedirectHandling(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,218 @@
+package surf_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	_http "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+// altSvcServer starts an HTTP/3 server with handler and an HTTP/2 server on TCP
+// advertising it in the Alt-Svc header. It returns the URL of the HTTP/2 server.
+func altSvcServer(t *testing.T, handler _http.HandlerFunc) string {
+	t.Helper()
+
+	server, conn, _, err := createHTTP3TestServer(handler)
+	if err != nil {
+		t.Skip("Failed to create HTTP/3 test server:", err)
+	}
+
+	go server.Serve(conn)
+
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"; ma=3600, h2=":443"`, port))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	time.Sleep(100 * time.Millisecond)
+
+	return ts.URL
+}
+
+// expectProto sends a GET request to url and checks the protocol of the response.
+func expectProto(t *testing.T, client *surf.Client, url string, proto g.String) {
+	t.Helper()
+
+	resp := client.Get(g.String(url)).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if resp.Ok().Proto != proto {
+		t.Fatalf("expected %s, got %s", proto, resp.Ok().Proto)
+	}
+}
+
+func TestAltSvcUpgrade(t *testing.T) {
+	t.Parallel()
+
+	url := altSvcServer(t, func(w _http.ResponseWriter, _ *_http.Request) { w.WriteHeader(_http.StatusOK) })
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().Build().Unwrap()
+
+	cache := client.GetAltSvc()
+	if cache == nil {
+		t.Fatal("expected Alt-Svc cache with HTTP3 enabled")
+	}
+
+	// First contact goes over TCP, the advertised alternative is used afterwards.
+	expectProto(t, client, url, "HTTP/2.0")
+
+	if cache.Len() != 1 {
+		t.Fatalf("expected one cached alternative, got %d", cache.Len())
+	}
+
+	expectProto(t, client, url, "HTTP/3.0")
+	expectProto(t, client, url, "HTTP/3.0")
+}
+
+func TestAltSvcPersistence(t *testing.T) {
+	t.Parallel()
+
+	url := altSvcServer(t, func(w _http.ResponseWriter, _ *_http.Request) { w.WriteHeader(_http.StatusOK) })
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().Build().Unwrap()
+	expectProto(t, client, url, "HTTP/2.0")
+
+	var buf bytes.Buffer
+	if err := client.GetAltSvc().Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := surf.NewAltSvcCache()
+	if err := cache.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().AltSvc(cache).Build().Unwrap()
+
+	if restored.GetAltSvc() != cache {
+		t.Fatal("expected the client to use the provided cache")
+	}
+
+	expectProto(t, restored, url, "HTTP/3.0")
+
+	cache.Clear()
+
+	if cache.Len() != 0 {
+		t.Fatalf("expected empty cache after Clear, got %d", cache.Len())
+	}
+}
+
+func TestAltSvcBrokenAlternative(t *testing.T) {
+	t.Parallel()
+
+	url := altSvcServer(t, func(w _http.ResponseWriter, _ *_http.Request) { w.WriteHeader(_http.StatusOK) })
+	origin := strings.TrimPrefix(url, "https://")
+
+	cache := surf.NewAltSvcCache()
+
+	state := fmt.Sprintf(`[{"origin":%q,"alternative":":1","expires":%q,"broken_until":%q,"broken_count":1}]`,
+		origin,
+		time.Now().Add(time.Hour).Format(time.RFC3339),
+		time.Now().Add(time.Hour).Format(time.RFC3339),
+	)
+
+	if err := cache.Load(strings.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().AltSvc(cache).Build().Unwrap()
+
+	// The broken alternative is skipped and replaced by the one advertised over TCP.
+	expectProto(t, client, url, "HTTP/2.0")
+	expectProto(t, client, url, "HTTP/3.0")
+}
+
+func TestAltSvcClear(t *testing.T) {
+	t.Parallel()
+
+	url := altSvcServer(t, func(w _http.ResponseWriter, _ *_http.Request) {
+		w.Header().Set("Alt-Svc", "clear")
+		w.WriteHeader(_http.StatusOK)
+	})
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().Build().Unwrap()
+
+	expectProto(t, client, url, "HTTP/2.0")
+	expectProto(t, client, url, "HTTP/3.0")
+
+	if client.GetAltSvc().Len() != 0 {
+		t.Fatalf("expected Alt-Svc: clear to remove the alternative, got %d entries", client.GetAltSvc().Len())
+	}
+
+	expectProto(t, client, url, "HTTP/2.0")
+}
+
+func TestAltSvcForceHTTP3(t *testing.T) {
+	t.Parallel()
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().ForceHTTP3().Build().Unwrap()
+
+	if client.GetAltSvc() != nil {
+		t.Fatal("expected no Alt-Svc cache with ForceHTTP3")
+	}
+}
+
+func TestAltSvcLimits(t *testing.T) {
+	t.Parallel()
+
+	// A max age beyond the range of time.Duration is capped rather than overflowing.
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Alt-Svc", `h3=":443"; ma=10000000000`)
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().Build().Unwrap()
+	client.Get(g.String(ts.URL)).Do()
+
+	if n := client.GetAltSvc().Len(); n != 1 {
+		t.Fatalf("expected the alternative to be recorded, got %d entries", n)
+	}
+
+	// The cache keeps a bounded number of origins.
+	var buf bytes.Buffer
+
+	buf.WriteString("[")
+
+	for i := range 2000 {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+
+		expires := time.Now().Add(time.Duration(i+1) * time.Minute).Format(time.RFC3339)
+		fmt.Fprintf(&buf, `{"origin":"host%d.test:443","alternative":":443","expires":%q}`, i, expires)
+	}
+
+	buf.WriteString("]")
+
+	cache := surf.NewAltSvcCache()
+	if err := cache.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cache.Len(); n != 1024 {
+		t.Fatalf("expected 1024 entries, got %d", n)
+	}
+}
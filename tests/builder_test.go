@@ -627,8 +627,8 @@ func TestBuilderRetry(t *testing.T) {
 		t.Fatal(resp.Err())
 	}
 
-	if resp.Ok().Attempts != 2 {
-		t.Errorf("expected 2 retry attempts, got %d", resp.Ok().Attempts)
+	if resp.Ok().Attempts.Len() != 3 {
+		t.Errorf("expected 3 attempts, got %d", resp.Ok().Attempts.Len())
 	}
 
 	if !resp.Ok().Body.Contains("success") {
@@ -662,8 +662,8 @@ func TestBuilderRetryWithCustomCodes(t *testing.T) {
 		t.Fatal(resp.Err())
 	}
 
-	if resp.Ok().Attempts != 1 {
-		t.Errorf("expected 1 retry attempt, got %d", resp.Ok().Attempts)
+	if resp.Ok().Attempts.Len() != 2 {
+		t.Errorf("expected 2 attempts, got %d", resp.Ok().Attempts.Len())
 	}
 }
 
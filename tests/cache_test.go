@@ -0,0 +1,339 @@
+package surf_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+func expectCache(t *testing.T, resp g.Result[*surf.Response], body g.String, status surf.CacheStatus) {
+	t.Helper()
+
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if resp.Ok().CacheStatus != status {
+		t.Fatalf("expected cache status %s, got %s", status, resp.Ok().CacheStatus)
+	}
+
+	if got := resp.Ok().Body.String().Unwrap(); got != body {
+		t.Fatalf("expected %q, got %q", body, got)
+	}
+}
+
+func TestCacheFreshness(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			fmt.Fprint(w, r.Header.Get("Accept-Language"))
+
+			return
+		}
+
+		fmt.Fprint(w, n)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().Cache(surf.NewMemoryCache(1 << 20)).Set().Build().Unwrap()
+
+	expectCache(t, client.Get(url+"/max-age").Do(), "1", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/max-age").Do(), "1", surf.CacheHit)
+
+	// no-cache in the request forces a request, its response replaces the stored one.
+	expectCache(t, client.Get(url+"/max-age").SetHeaders("Cache-Control", "no-cache").Do(), "2", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/max-age").Do(), "2", surf.CacheHit)
+
+	expectCache(t, client.Get(url+"/no-store").Do(), "3", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/no-store").Do(), "4", surf.CacheMiss)
+
+	expectCache(t, client.Get(url+"/vary").SetHeaders("Accept-Language", "en").Do(), "en", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/vary").SetHeaders("Accept-Language", "en").Do(), "en", surf.CacheHit)
+	expectCache(t, client.Get(url+"/vary").SetHeaders("Accept-Language", "fr").Do(), "fr", surf.CacheMiss)
+
+	// Unsafe requests invalidate the stored response of their URL.
+	client.Post(url + "/max-age").Do().Unwrap().Body.Close()
+
+	if resp := client.Get(url + "/max-age").Do(); resp.IsErr() || resp.Ok().CacheStatus != surf.CacheMiss {
+		t.Fatalf("expected a miss after a POST, got %v", resp)
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	t.Parallel()
+
+	var (
+		requests    atomic.Int32
+		conditional atomic.Int32
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Version", fmt.Sprint(requests.Load()))
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		fmt.Fprint(w, "content")
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().Cache(surf.NewMemoryCache(1 << 20)).Set().Build().Unwrap()
+
+	expectCache(t, client.Get(url).Do(), "content", surf.CacheMiss)
+
+	resp := client.Get(url).Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusOK || resp.Ok().Headers.Get("X-Version") != "2" {
+		t.Fatalf("expected a 200 response with the headers of the 304 response, got %v", resp)
+	}
+
+	expectCache(t, resp, "content", surf.CacheRevalidated)
+
+	if conditional.Load() != 1 {
+		t.Errorf("expected a conditional request, got %d", conditional.Load())
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	t.Parallel()
+
+	var (
+		requests atomic.Int32
+		failing  atomic.Bool
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/swr" {
+			w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		}
+
+		fmt.Fprint(w, n)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().Cache(surf.NewMemoryCache(1 << 20)).Set().Build().Unwrap()
+
+	// A stale response is served while it is revalidated in the background.
+	expectCache(t, client.Get(url+"/swr").Do(), "1", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/swr").Do(), "1", surf.CacheStale)
+
+	for deadline := time.Now().Add(time.Second); requests.Load() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a background revalidation")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	expectCache(t, client.Get(url+"/swr").Do(), "2", surf.CacheStale)
+
+	// A stale response is served when the server fails.
+	resp := client.Get(url + "/sie").Do()
+	if resp.IsErr() || resp.Ok().CacheStatus != surf.CacheMiss {
+		t.Fatalf("expected a miss, got %v", resp)
+	}
+
+	body := resp.Ok().Body.String().Unwrap()
+
+	failing.Store(true)
+
+	expectCache(t, client.Get(url+"/sie").Do(), body, surf.CacheStale)
+
+	// Requests that must be answered from the cache are never sent.
+	sent := requests.Load()
+
+	resp = client.Get(url+"/missing").SetHeaders("Cache-Control", "only-if-cached").Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusGatewayTimeout || requests.Load() != sent {
+		t.Fatalf("expected a 504 response without a request, got %v", resp)
+	}
+}
+
+func TestCacheStores(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, requests.Add(1))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	store := surf.NewDiskCache(g.String(t.TempDir()))
+
+	client := surf.NewClient().Builder().Cache(store).Set().Build().Unwrap()
+	expectCache(t, client.Get(url).Do(), "1", surf.CacheMiss)
+
+	// Another client reads the responses stored on disk, even offline.
+	ts.Close()
+
+	offline := surf.NewClient().Builder().Cache(store).Offline().Set().Build().Unwrap()
+	expectCache(t, offline.Get(url).Do(), "1", surf.CacheHit)
+
+	if resp := offline.Get(url + "/other").Do(); resp.IsErr() || resp.Ok().StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected a 504 response offline, got %v", resp)
+	}
+
+	// The memory store evicts the least recently used values over its budget.
+	memory := surf.NewMemoryCache(10)
+	memory.Set("a", []byte("1234"))
+	memory.Set("b", []byte("1234"))
+	memory.Get("a")
+	memory.Set("c", []byte("1234"))
+
+	if _, ok := memory.Get("b"); ok || memory.Len() != 2 || memory.Size() != 10 {
+		t.Errorf("expected b to be evicted, got %d values of %d bytes", memory.Len(), memory.Size())
+	}
+}
+
+func TestCacheRedirect(t *testing.T) {
+	t.Parallel()
+
+	var redirects, requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			redirects.Add(1)
+			http.Redirect(w, r, "/target", http.StatusFound)
+
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, requests.Add(1))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().Cache(surf.NewMemoryCache(1 << 20)).Set().Build().Unwrap()
+
+	// The target of a redirect is not stored under the URL that redirected to it.
+	expectCache(t, client.Get(url+"/moved").Do(), "1", surf.CacheNone)
+	expectCache(t, client.Get(url+"/moved").Do(), "2", surf.CacheNone)
+
+	if n := redirects.Load(); n != 2 {
+		t.Fatalf("expected the redirect to be followed twice, got %d", n)
+	}
+
+	expectCache(t, client.Get(url+"/target").Do(), "3", surf.CacheMiss)
+	expectCache(t, client.Get(url+"/target").Do(), "3", surf.CacheHit)
+}
+
+func TestDiskCacheClear(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := surf.NewDiskCache(g.String(dir))
+
+	store.Set("key", []byte("value"))
+
+	foreign := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(foreign, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get("key"); ok {
+		t.Error("expected the stored value to be removed")
+	}
+
+	// Files not named after a key are not the cache's and survive Clear.
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("expected foreign file to survive Clear: %v", err)
+	}
+}
+
+func TestCacheProxyPool(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("Please solve the CAPTCHA"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	proxy, _ := countingProxy(t)
+	pool := surf.NewProxyPool(proxy)
+
+	client := surf.NewClient().Builder().
+		ProxyPool(pool).
+		Cache(surf.NewMemoryCache(1 << 20)).Set().
+		Build().Unwrap()
+
+	expectCache(t, client.Get(url).Do(), "Please solve the CAPTCHA", surf.CacheMiss)
+
+	// Stored responses are neither counted as requests through the proxy nor inspected.
+	pool.BanBody("captcha")
+
+	expectCache(t, client.Get(url).Do(), "Please solve the CAPTCHA", surf.CacheHit)
+
+	if stats := proxyStats(pool, proxy); stats.Requests != 1 || stats.Bans != 0 {
+		t.Fatalf("expected a single request and no ban, got %+v", stats)
+	}
+
+	// Range and conditional requests are sent to the server.
+	resp := client.Get(url).SetHeaders("Range", "bytes=0-5").Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusPartialContent || resp.Ok().CacheStatus != surf.CacheNone {
+		t.Fatalf("expected a partial response from the server, got %v", resp)
+	}
+
+	resp.Ok().Body.Close()
+
+	resp = client.Get(url).SetHeaders("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat)).Do()
+	if resp.IsErr() || resp.Ok().CacheStatus != surf.CacheNone || requests.Load() != 3 {
+		t.Fatalf("expected the conditional request to be sent, got %v after %d requests", resp, requests.Load())
+	}
+}
@@ -0,0 +1,349 @@
+package surf_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		requests atomic.Int32
+		healthy  atomic.Bool
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	host := url.StripPrefix("http://")
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(2).
+		CoolDown(50 * time.Millisecond).
+		Set().
+		Build().Unwrap()
+
+	for range 2 {
+		if resp := client.Get(url).Do(); resp.IsErr() || resp.Ok().StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected a 503 response, got %v", resp)
+		}
+	}
+
+	if state := client.CircuitState(host); state != surf.CircuitOpen {
+		t.Fatalf("expected an open circuit, got %s", state)
+	}
+
+	var errCircuit *surf.ErrCircuitOpen
+	if resp := client.Get(url).Do(); !errors.As(resp.Err(), &errCircuit) || requests.Load() != 2 {
+		t.Fatalf("expected ErrCircuitOpen without a request, got %v", resp.Err())
+	}
+
+	stats := client.Circuits()
+	if stats.Len() != 1 || stats[0].Host != host || stats[0].Failures != 2 || stats[0].Until.IsZero() {
+		t.Errorf("unexpected circuit stats %+v", stats)
+	}
+
+	// After the cool-down the circuit is half-open, before any probe is sent.
+	time.Sleep(60 * time.Millisecond)
+
+	if state := client.CircuitState(host); state != surf.CircuitHalfOpen {
+		t.Fatalf("expected a half-open circuit after the cool-down, got %s", state)
+	}
+
+	// A failing probe opens the circuit again.
+	if resp := client.Get(url).Do(); resp.IsErr() || client.CircuitState(host) != surf.CircuitOpen {
+		t.Fatalf("expected a failing probe to open the circuit, got %v", resp.Err())
+	}
+
+	// A successful probe closes it.
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+
+	expectBody(t, client.Get(url).Do(), "ok")
+
+	if state := client.CircuitState(host); state != surf.CircuitClosed || client.Circuits().Len() != 0 {
+		t.Errorf("expected a closed circuit, got %s", state)
+	}
+}
+
+func TestCircuitBreakerFailures(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/captcha" {
+			w.Write([]byte("<html>Please solve the CAPTCHA</html>"))
+			return
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	host := url.StripPrefix("http://")
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(1).
+		FailureStatus(http.StatusTeapot).
+		FailureBody("captcha").
+		Set().
+		Build().Unwrap()
+
+	// Matched bodies are still read in full.
+	expectBody(t, client.Get(url+"/captcha").Do(), "<html>Please solve the CAPTCHA</html>")
+
+	if state := client.CircuitState(host); state != surf.CircuitOpen {
+		t.Fatalf("expected a body pattern to open the circuit, got %s", state)
+	}
+
+	client.ResetCircuits(host)
+
+	if resp := client.Get(url).Do(); resp.IsErr() || client.CircuitState(host) != surf.CircuitOpen {
+		t.Fatalf("expected a failure status to open the circuit, got %v", resp.Err())
+	}
+
+	client.ResetCircuits()
+
+	if client.Circuits().Len() != 0 {
+		t.Error("expected no circuit after a reset")
+	}
+}
+
+func TestCircuitBreakerFailureBodyStalled(t *testing.T) {
+	t.Parallel()
+
+	url, release := stalledServer(t, "<html>Please solve the CAPTCHA</html>")
+	host := url.StripPrefix("https://")
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(1).
+		FailureBody("captcha").
+		Set().
+		Build().Unwrap()
+
+	// The body is matched as it is read, the response is returned before the body is complete.
+	start := time.Now()
+
+	resp := client.Get(url).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("response waited %s for the body", elapsed)
+	}
+
+	release()
+	resp.Ok().Body.String()
+
+	if state := client.CircuitState(host); state != surf.CircuitOpen {
+		t.Fatalf("expected the body to open the circuit once read, got %s", state)
+	}
+}
+
+func TestCircuitBreakerTransportErrors(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	url := g.String(ts.URL)
+	ts.Close()
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(1).
+		Set().
+		RetryPolicy(surf.NewBackoff(3).Base(time.Millisecond)).
+		Build().Unwrap()
+
+	// The retries of a refused connection stop at the open circuit.
+	resp := client.Get(url).Do()
+
+	var errCircuit *surf.ErrCircuitOpen
+	if !errors.As(resp.Err(), &errCircuit) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", resp.Err())
+	}
+
+	client = surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(1).
+		FailureError(func(error) bool { return false }).
+		Set().
+		Build().Unwrap()
+
+	if resp := client.Get(url).Do(); resp.IsOk() || client.CircuitState(url.StripPrefix("http://")) != surf.CircuitClosed {
+		t.Errorf("expected an ignored error to keep the circuit closed, got %v", resp.Err())
+	}
+}
+
+func TestCircuitBreakerMaxCircuits(t *testing.T) {
+	t.Parallel()
+
+	client := surf.NewClient().Builder().
+		Proxy(deadProxy(t)).
+		CircuitBreaker().
+		Threshold(1).
+		Set().
+		Build().Unwrap()
+
+	// 4096 circuits are opened after the first one, which is forgotten.
+	for i := range 4097 {
+		client.Get(g.Format("http://host{}.test/", i)).Do()
+	}
+
+	if circuits := client.Circuits(); circuits.Len() != 4096 || client.CircuitState("host0.test") != surf.CircuitClosed ||
+		client.CircuitState("host1.test") != surf.CircuitOpen {
+		t.Fatalf("expected the least recently used circuit to be forgotten, got %d circuits", circuits.Len())
+	}
+}
+
+func TestCircuitBreakerHalfOpenRounds(t *testing.T) {
+	t.Parallel()
+
+	var (
+		failing atomic.Bool
+		retried atomic.Bool
+	)
+
+	digest := &digestServer{algorithm: "MD5", qop: "auth", nonce: "nonce", stale: make(map[string]bool)}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case failing.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path != "/retry":
+			digest.ServeHTTP(w, r)
+		case retried.CompareAndSwap(false, true):
+			w.WriteHeader(http.StatusRequestTimeout)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	host := url.StripPrefix("http://")
+
+	client := surf.NewClient().Builder().
+		DigestAuth("user", "pass").
+		Retry(1, 0, http.StatusRequestTimeout).
+		CircuitBreaker().
+		Threshold(1).
+		CoolDown(50 * time.Millisecond).
+		FailureBody("captcha").
+		Set().
+		Build().Unwrap()
+
+	// The probe of a half-open circuit answers a Digest challenge, or is retried, and closes it.
+	for _, path := range []g.String{"/api", "/retry"} {
+		failing.Store(true)
+		client.Get(url).Do().Unwrap().Body.Close()
+
+		if state := client.CircuitState(host); state != surf.CircuitOpen {
+			t.Fatalf("expected an open circuit, got %s", state)
+		}
+
+		failing.Store(false)
+		time.Sleep(60 * time.Millisecond)
+
+		resp := client.Get(url + path).Do()
+		if resp.IsErr() || resp.Ok().StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected the probe to succeed, got %v", path, resp)
+		}
+
+		resp.Ok().Body.Close()
+
+		if state := client.CircuitState(host); state != surf.CircuitClosed {
+			t.Fatalf("%s: expected a closed circuit, got %s", path, state)
+		}
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		PerHost(1, time.Hour).
+		Set().
+		CircuitBreaker().
+		Threshold(1).
+		CoolDown(100 * time.Millisecond).
+		Set().
+		Build().Unwrap()
+
+	client.Get(url).Do().Unwrap().Body.Close()
+	time.Sleep(110 * time.Millisecond)
+
+	// A probe cancelled while waiting for the rate limit gives its slot back to the next request.
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		resp := client.Get(url).WithContext(ctx).Do()
+		cancel()
+
+		if !errors.Is(resp.Err(), context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", resp.Err())
+		}
+	}
+}
+
+func TestCircuitBreakerStd(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().
+		Threshold(1).
+		CoolDown(time.Minute).
+		Set().
+		Build().Unwrap()
+
+	std := client.Std()
+
+	resp, err := std.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The open circuit stops the requests of the standard client too.
+	var errCircuit *surf.ErrCircuitOpen
+	if _, err := std.Get(ts.URL); !errors.As(err, &errCircuit) || requests.Load() != 1 {
+		t.Fatalf("expected ErrCircuitOpen without a request, got %v", err)
+	}
+}
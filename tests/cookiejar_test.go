@@ -0,0 +1,279 @@
+package surf_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+func cookieServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/", HttpOnly: true})
+			http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark", Path: "/", MaxAge: 3600})
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "session", Path: "/", MaxAge: -1})
+		default:
+			if c, err := r.Cookie("session"); err == nil {
+				w.Write([]byte(c.Value))
+			}
+		}
+	}))
+
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestCookieJarFormats(t *testing.T) {
+	t.Parallel()
+
+	ts := cookieServer(t)
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().SessionJar(surf.NewCookieJar()).Build().Unwrap()
+	client.Get(url + "/login").Do().Unwrap().Body.Close()
+
+	jar := client.GetCookieJar()
+	if jar == nil || jar.Len() != 2 {
+		t.Fatalf("expected a jar with 2 cookies, got %v", jar)
+	}
+
+	if cookies := jar.All(); cookies[0].Name != "session" || cookies[0].Domain != "127.0.0.1" ||
+		!cookies[0].HttpOnly || cookies[1].Name != "theme" || cookies[1].Expires.IsZero() {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+
+	for _, format := range []surf.CookieFormat{surf.CookieJSON, surf.CookieNetscape, surf.CookieBrowser} {
+		var buf bytes.Buffer
+		if err := jar.Save(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := surf.NewCookieJar()
+		if err := restored.Load(&buf, format); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+
+		client := surf.NewClient().Builder().SessionJar(restored).Build().Unwrap()
+		expectBody(t, client.Get(url).Do(), "s3cr3t")
+
+		if restored.Len() != 2 || !restored.All()[0].HttpOnly {
+			t.Errorf("format %d: unexpected cookies %v", format, restored.All())
+		}
+	}
+}
+
+func TestCookieJarNetscape(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Now().Add(time.Hour).Unix()
+
+	file := "# Netscape HTTP Cookie File\n" +
+		"# https://curl.se/docs/http-cookies.html\n\n" +
+		".example.com\tTRUE\t/\tFALSE\t" + g.Int(expires).String().Std() + "\tid\t42\n" +
+		"#HttpOnly_api.example.com\tFALSE\t/v1\tTRUE\t0\ttoken\tabc\n" +
+		"old.example.com\tFALSE\t/\tFALSE\t1\texpired\tx\n"
+
+	jar := surf.NewCookieJar()
+	if err := jar.Load(strings.NewReader(file), surf.CookieNetscape); err != nil {
+		t.Fatal(err)
+	}
+
+	if jar.Len() != 2 {
+		t.Fatalf("expected 2 unexpired cookies, got %v", jar.All())
+	}
+
+	request := func(rawURL string) string {
+		r, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+
+		var names []string
+		for _, c := range jar.Cookies(r.URL) {
+			names = append(names, c.Name)
+		}
+
+		return strings.Join(names, ",")
+	}
+
+	if got := request("https://api.example.com/v1/users"); got != "token,id" {
+		t.Errorf("expected the token and id cookies, got %q", got)
+	}
+
+	if got := request("http://www.example.com/"); got != "id" {
+		t.Errorf("expected the id cookie on a subdomain, got %q", got)
+	}
+
+	var buf bytes.Buffer
+	jar.Save(&buf, surf.CookieNetscape)
+
+	if !strings.Contains(buf.String(), "#HttpOnly_api.example.com\tFALSE\t/v1\tTRUE\t0\ttoken\tabc\n") {
+		t.Errorf("expected a curl compatible HttpOnly line, got\n%s", buf.String())
+	}
+
+	if err := surf.NewCookieJar().Load(strings.NewReader("bad line\n"), surf.CookieNetscape); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestCookieJarAutosave(t *testing.T) {
+	t.Parallel()
+
+	ts := cookieServer(t)
+	url := g.String(ts.URL)
+	path := g.String(filepath.Join(t.TempDir(), "cookies.json"))
+
+	client := surf.NewClient().Builder().
+		SessionJar(surf.NewCookieJar().Autosave(path, surf.CookieJSON)).
+		Build().Unwrap()
+
+	client.Get(url + "/login").Do().Unwrap().Body.Close()
+
+	// Changes are written in the background, or at once by Flush.
+	if _, err := os.Stat(path.Std()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the file to be written after the request, got %v", err)
+	}
+
+	if err := client.GetCookieJar().Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process restores the session from the file.
+	jar := surf.NewCookieJar()
+	if err := jar.LoadFile(path, surf.CookieJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	expectBody(t, surf.NewClient().Builder().SessionJar(jar).Build().Unwrap().Get(url).Do(), "s3cr3t")
+
+	// Deleted cookies are removed from the file by the background write.
+	client.Get(url + "/logout").Do().Unwrap().Body.Close()
+
+	var data []byte
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if data, _ = os.ReadFile(path.Std()); !strings.Contains(string(data), "s3cr3t") {
+			break
+		}
+	}
+
+	if strings.Contains(string(data), "s3cr3t") || !strings.Contains(string(data), "theme") {
+		t.Errorf("expected the session cookie to be removed, got %s", data)
+	}
+
+	if err := client.GetCookieJar().AutosaveErr(); err != nil {
+		t.Errorf("expected no autosave error, got %v", err)
+	}
+
+	// Failed writes are reported.
+	missing := g.String(filepath.Join(t.TempDir(), "missing", "cookies.json"))
+
+	failing := surf.NewCookieJar().Autosave(missing, surf.CookieJSON)
+	surf.NewClient().Builder().SessionJar(failing).Build().Unwrap().Get(url + "/login").Do().Unwrap().Body.Close()
+
+	if err := failing.Close(); !errors.Is(err, fs.ErrNotExist) || !errors.Is(failing.AutosaveErr(), fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestCookieJarSession(t *testing.T) {
+	t.Parallel()
+
+	ts := cookieServer(t)
+	url := g.String(ts.URL)
+
+	// Session keeps the cookies in the cookiejar.Jar.
+	client := surf.NewClient().Builder().Session().Build().Unwrap()
+	client.Get(url + "/login").Do().Unwrap().Body.Close()
+
+	expectBody(t, client.Get(url).Do(), "s3cr3t")
+
+	if client.GetCookieJar() != nil {
+		t.Error("expected no CookieJar without SessionJar")
+	}
+}
+
+func TestCookieJarMatching(t *testing.T) {
+	t.Parallel()
+
+	jar := surf.NewCookieJar()
+
+	set := func(rawURL string, cookies ...*http.Cookie) {
+		u, _ := url.Parse(rawURL)
+		jar.SetCookies(u, cookies)
+	}
+
+	get := func(rawURL string) string {
+		u, _ := url.Parse(rawURL)
+
+		var pairs []string
+		for _, c := range jar.Cookies(u) {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+
+		return strings.Join(pairs, "; ")
+	}
+
+	set("https://example.com/docs/page",
+		&http.Cookie{Name: "host", Value: "1"},
+		&http.Cookie{Name: "domain", Value: "2", Domain: "example.com", Path: "/"},
+		&http.Cookie{Name: "secure", Value: "3", Path: "/", Secure: true},
+		&http.Cookie{Name: "docs", Value: "4", Path: "/docs/"},
+		&http.Cookie{Name: "suffix", Value: "5", Domain: "com"},
+	)
+
+	for rawURL, expected := range map[string]string{
+		"https://example.com/docs/page": "docs=4; host=1; domain=2; secure=3",
+		"http://example.com/docs":       "host=1; domain=2",
+		"http://example.com/docsx":      "domain=2",
+		"https://www.example.com/docs/": "domain=2",
+		"ftp://example.com/":            "",
+	} {
+		if got := get(rawURL); got != expected {
+			t.Errorf("%s: expected %q, got %q", rawURL, expected, got)
+		}
+	}
+
+	// A replaced cookie keeps its order, a deleted one is no longer sent.
+	set("https://example.com/", &http.Cookie{Name: "host", Value: "6", Path: "/docs"})
+	set("https://example.com/", &http.Cookie{Name: "domain", Domain: "example.com", Path: "/", MaxAge: -1})
+
+	if got := get("https://example.com/docs/page"); got != "docs=4; host=6; secure=3" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+
+	if jar.Len() != 3 {
+		t.Errorf("expected 3 cookies, got %v", jar.All())
+	}
+
+	// Hosts are matched without a trailing dot and with punycode labels.
+	set("https://Example.ORG./", &http.Cookie{Name: "dot", Value: "7"})
+	set("https://bücher.example/", &http.Cookie{Name: "idna", Value: "8", Domain: "BÜCHER.example"})
+
+	if got := get("https://example.org/"); got != "dot=7" {
+		t.Errorf("expected the cookie of the host with a trailing dot, got %q", got)
+	}
+
+	if got := get("https://www.xn--bcher-kva.example/"); got != "idna=8" {
+		t.Errorf("expected the cookie of the internationalized domain, got %q", got)
+	}
+
+	jar.Clear()
+
+	if got := get("https://example.com/docs/page"); got != "" {
+		t.Errorf("expected no cookies after Clear, got %q", got)
+	}
+}
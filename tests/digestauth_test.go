@@ -0,0 +1,210 @@
+package surf_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+// digestServer is an origin server protected by Digest authentication for user:pass.
+type digestServer struct {
+	algorithm string
+	qop       string
+	mu        sync.Mutex
+	nonce     string
+	stale     map[string]bool // Nonces answered with stale=true
+	counts    []string        // Nonce counts of the authorized requests
+	requests  atomic.Int32
+}
+
+func (s *digestServer) hash(data string) string {
+	var h hash.Hash
+
+	switch strings.TrimSuffix(s.algorithm, "-sess") {
+	case "SHA-256":
+		h = sha256.New()
+	case "SHA-512-256":
+		h = sha512.New512_256()
+	default:
+		h = md5.New()
+	}
+
+	h.Write([]byte(data))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// expire makes the current nonce stale.
+func (s *digestServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stale[s.nonce] = true
+	s.nonce += "-next"
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := make(map[string]string)
+
+	for param := range strings.SplitSeq(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "), ", ") {
+		name, value, _ := strings.Cut(param, "=")
+		p[name] = strings.Trim(value, `"`)
+	}
+
+	ha1 := s.hash("user:api:pass")
+	if strings.HasSuffix(s.algorithm, "-sess") {
+		ha1 = s.hash(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+
+	a2 := r.Method + ":" + r.URL.RequestURI()
+	if s.qop == "auth-int" {
+		a2 += ":" + s.hash(string(body))
+	}
+
+	expected := s.hash(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + s.qop + ":" + s.hash(a2))
+
+	valid := p["response"] == expected && p["uri"] == r.URL.RequestURI() && p["opaque"] == "op" &&
+		p["qop"] == s.qop && strings.EqualFold(p["algorithm"], s.algorithm)
+
+	switch {
+	case valid && p["nonce"] == s.nonce:
+		s.counts = append(s.counts, p["nc"])
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+		return
+	case valid && s.stale[p["nonce"]]:
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+			`Digest realm="api", nonce=%q, qop=%q, algorithm=%s, opaque="op", stale=true`, s.nonce, s.qop, s.algorithm))
+	default:
+		// A weaker algorithm is offered first, together with another scheme.
+		if strings.HasPrefix(s.algorithm, "SHA") {
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+				`Basic realm="api", Digest realm="api", nonce=%q, qop=%q, algorithm=MD5, opaque="op"`, s.nonce, s.qop))
+		}
+
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+			`Digest realm="api", nonce=%q, qop=%q, algorithm=%s, opaque="op"`, s.nonce, s.qop, s.algorithm))
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func startDigestServer(t *testing.T, algorithm, qop string) (*digestServer, g.String) {
+	t.Helper()
+
+	s := &digestServer{algorithm: algorithm, qop: qop, nonce: "nonce-" + algorithm, stale: make(map[string]bool)}
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	return s, g.String(ts.URL)
+}
+
+func TestDigestAuth(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range []string{"MD5", "MD5-sess", "SHA-256", "SHA-512-256", "SHA-256-sess"} {
+		for _, qop := range []string{"auth", "auth-int"} {
+			t.Run(algorithm+" "+qop, func(t *testing.T) {
+				t.Parallel()
+
+				server, url := startDigestServer(t, algorithm, qop)
+
+				client := surf.NewClient().Builder().DigestAuth("user", "pass").Build().Unwrap()
+
+				resp := client.Post(url + "/api?x=1").Body("payload").Do()
+				expectBody(t, resp, "POST payload")
+
+				if resp.Ok().Attempts.Len() != 1 || server.requests.Load() != 2 {
+					t.Fatalf("expected one challenge round trip, got %d requests", server.requests.Load())
+				}
+
+				// The cached challenge is answered preemptively.
+				expectBody(t, client.Get(url+"/other").Do(), "GET ")
+
+				if server.requests.Load() != 3 {
+					t.Fatalf("expected a preemptive Digest response, got %d requests", server.requests.Load())
+				}
+
+				if strings.Join(server.counts, ",") != "00000001,00000002" {
+					t.Fatalf("expected incremented nonce counts, got %v", server.counts)
+				}
+			})
+		}
+	}
+}
+
+func TestDigestAuthStaleNonce(t *testing.T) {
+	t.Parallel()
+
+	server, url := startDigestServer(t, "SHA-256", "auth")
+
+	client := surf.NewClient().Builder().DigestAuth("user", "pass").Build().Unwrap()
+
+	expectBody(t, client.Put(url).Body("first").Do(), "PUT first")
+
+	server.expire()
+
+	expectBody(t, client.Put(url).Body("second").Do(), "PUT second")
+
+	// Preemptive request with the stale nonce, then the new nonce.
+	if server.requests.Load() != 4 {
+		t.Fatalf("expected the stale nonce to be replaced, got %d requests", server.requests.Load())
+	}
+
+	if strings.Join(server.counts, ",") != "00000001,00000001" {
+		t.Fatalf("expected the nonce count to restart, got %v", server.counts)
+	}
+}
+
+func TestDigestAuthWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	server, url := startDigestServer(t, "MD5", "auth")
+
+	resp := surf.NewClient().Builder().DigestAuth("user", "wrong").Build().Unwrap().Get(url).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if resp.Ok().StatusCode != http.StatusUnauthorized || server.requests.Load() != 2 {
+		t.Fatalf("expected a single answered challenge and a 401, got %d with %d requests",
+			resp.Ok().StatusCode, server.requests.Load())
+	}
+}
+
+func TestDigestAuthOtherOrigin(t *testing.T) {
+	t.Parallel()
+
+	first, firstURL := startDigestServer(t, "MD5", "auth")
+	second, secondURL := startDigestServer(t, "MD5", "auth")
+
+	client := surf.NewClient().Builder().DigestAuth("user", "pass").Build().Unwrap()
+
+	expectBody(t, client.Get(firstURL).Do(), "GET ")
+	expectBody(t, client.Get(secondURL).Do(), "GET ")
+
+	// The challenge of the first server is not sent to the second one.
+	if first.requests.Load() != 2 || second.requests.Load() != 2 {
+		t.Fatalf("expected a challenge per origin, got %d and %d requests", first.requests.Load(), second.requests.Load())
+	}
+}
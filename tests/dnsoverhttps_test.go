@@ -0,0 +1,234 @@
+package surf_test
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohServer starts a DoH endpoint resolving every A query for host to 127.0.0.1.
+// Requests are counted per method.
+func dohServer(t *testing.T, host string, gets, posts *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/dns-message" {
+			t.Error("DoH request must accept application/dns-message")
+		}
+
+		var (
+			msg []byte
+			err error
+		)
+
+		switch r.Method {
+		case http.MethodGet:
+			gets.Add(1)
+			msg, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			posts.Add(1)
+			msg, err = io.ReadAll(r.Body)
+		}
+
+		var p dnsmessage.Parser
+
+		h, err2 := p.Start(msg)
+		if err != nil || err2 != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if h.ID != 0 {
+			t.Errorf("expected DNS ID 0, got %d", h.ID)
+		}
+
+		q, _ := p.Question()
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+
+		if q.Type == dnsmessage.TypeA && strings.EqualFold(q.Name.String(), host+".") {
+			b.AResource(
+				dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60},
+				dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			)
+		}
+
+		answer, _ := b.Finish()
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answer)
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// dohRoots returns the certificate pool trusting the DoH endpoint ts.
+func dohRoots(ts *httptest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	return pool
+}
+
+// dohTarget starts the plain HTTP server reached through the resolved host name.
+func dohTarget(t *testing.T, host string) string {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+
+	t.Cleanup(ts.Close)
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	return fmt.Sprintf("http://%s:%s/", host, port)
+}
+
+func expectResolved(t *testing.T, client *surf.Client, url string) {
+	t.Helper()
+
+	resp := client.Get(g.String(url)).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if !resp.Ok().StatusCode.IsSuccess() {
+		t.Fatalf("unexpected status %d", resp.Ok().StatusCode)
+	}
+}
+
+func TestDNSOverHTTPSPost(t *testing.T) {
+	t.Parallel()
+
+	var gets, posts atomic.Int32
+
+	ts := dohServer(t, "doh-post.test", &gets, &posts)
+
+	client := surf.NewClient().Builder().
+		DNSOverHTTPS().RootCAs(dohRoots(ts)).AddProvider(g.String(ts.URL + "/dns-query")).
+		Build().Unwrap()
+
+	expectResolved(t, client, dohTarget(t, "doh-post.test"))
+
+	if posts.Load() == 0 || gets.Load() != 0 {
+		t.Fatalf("expected POST queries only, got %d POST and %d GET", posts.Load(), gets.Load())
+	}
+}
+
+func TestDNSOverHTTPSGet(t *testing.T) {
+	t.Parallel()
+
+	var gets, posts atomic.Int32
+
+	ts := dohServer(t, "doh-get.test", &gets, &posts)
+
+	client := surf.NewClient().Builder().
+		DNSOverHTTPS().GET().RootCAs(dohRoots(ts)).AddProvider(g.String(ts.URL + "/dns-query")).
+		Build().Unwrap()
+
+	expectResolved(t, client, dohTarget(t, "doh-get.test"))
+
+	if gets.Load() == 0 || posts.Load() != 0 {
+		t.Fatalf("expected GET queries only, got %d GET and %d POST", gets.Load(), posts.Load())
+	}
+}
+
+func TestDNSOverHTTPSVerify(t *testing.T) {
+	t.Parallel()
+
+	var gets, posts atomic.Int32
+
+	ts := dohServer(t, "doh-verify.test", &gets, &posts)
+
+	// The endpoint certificate is not trusted by the system roots.
+	client := surf.NewClient().Builder().
+		DNSOverHTTPS().AddProvider(g.String(ts.URL + "/dns-query")).
+		Build().Unwrap()
+
+	if resp := client.Get(g.String(dohTarget(t, "doh-verify.test"))).Do(); resp.IsOk() {
+		t.Fatal("expected the lookup to fail with an untrusted endpoint")
+	}
+
+	if gets.Load() != 0 || posts.Load() != 0 {
+		t.Fatalf("expected no query to reach the untrusted endpoint, got %d", gets.Load()+posts.Load())
+	}
+}
+
+func TestDNSOverHTTPSClientTransport(t *testing.T) {
+	t.Parallel()
+
+	var gets, posts atomic.Int32
+
+	ts := dohServer(t, "doh-client.test", &gets, &posts)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// The endpoint host is resolved from the bootstrap address, not through DoH itself.
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		DNSOverHTTPS().ClientTransport().
+		AddProvider(g.String("https://dns.doh.test:"+port+"/dns-query"), g.String("127.0.0.1:"+port)).
+		Build().Unwrap()
+
+	expectResolved(t, client, dohTarget(t, "doh-client.test"))
+
+	if posts.Load() == 0 {
+		t.Fatal("expected queries sent through the client transport")
+	}
+}
+
+func TestDNSOverHTTPSInvalidEndpoint(t *testing.T) {
+	t.Parallel()
+
+	result := surf.NewClient().Builder().DNSOverHTTPS().AddProvider("http://dns.example/dns-query").Build()
+	if result.IsOk() {
+		t.Fatal("expected an error for a non-HTTPS endpoint")
+	}
+}
+
+func TestDNSOverHTTPSProviders(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]func(*surf.DNSOverHTTPS) *surf.Builder{
+		"AdGuard":    (*surf.DNSOverHTTPS).AdGuard,
+		"Google":     (*surf.DNSOverHTTPS).Google,
+		"Cloudflare": (*surf.DNSOverHTTPS).Cloudflare,
+		"Quad9":      (*surf.DNSOverHTTPS).Quad9,
+		"Switch":     (*surf.DNSOverHTTPS).Switch,
+		"CIRAShield": (*surf.DNSOverHTTPS).CIRAShield,
+		"Ali":        (*surf.DNSOverHTTPS).Ali,
+		"Quad101":    (*surf.DNSOverHTTPS).Quad101,
+		"SB":         (*surf.DNSOverHTTPS).SB,
+		"Forge":      (*surf.DNSOverHTTPS).Forge,
+		"LibreDNS":   (*surf.DNSOverHTTPS).LibreDNS,
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			client := provider(surf.NewClient().Builder().DNSOverHTTPS()).Build().Unwrap()
+
+			if client.GetDialer().Resolver == nil {
+				t.Fatal("expected DNS-over-HTTPS resolver to be set")
+			}
+		})
+	}
+}
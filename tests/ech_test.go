@@ -0,0 +1,196 @@
+package surf_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// echKey generates an X25519 ECH key and its ECHConfig with the public name public.test.
+func echKey(t *testing.T, id uint8) (tls.EncryptedClientHelloKey, []byte) {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicName := "public.test"
+
+	var contents []byte
+	contents = append(contents, id)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(key.PublicKey().Bytes())))
+	contents = append(contents, key.PublicKey().Bytes()...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 32, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // extensions
+
+	config := binary.BigEndian.AppendUint16(nil, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	return tls.EncryptedClientHelloKey{Config: config, PrivateKey: key.Bytes(), SendAsRetry: true}, config
+}
+
+// echConfigList serializes ECHConfigs into an ECHConfigList.
+func echConfigList(configs ...[]byte) []byte {
+	var list []byte
+	for _, config := range configs {
+		list = append(list, config...)
+	}
+
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+}
+
+// echServer starts an HTTP/2 TLS server accepting ECH with key, reporting in the response body
+// whether ECH was accepted and the inner server name.
+func echServer(t *testing.T, key tls.EncryptedClientHelloKey) string {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%t %s", r.TLS.ECHAccepted, r.TLS.ServerName)
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key}}
+	ts.StartTLS()
+
+	t.Cleanup(ts.Close)
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	return port
+}
+
+func expectECH(t *testing.T, client *surf.Client, url string, accepted bool) {
+	t.Helper()
+
+	resp := client.Get(g.String(url)).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	body := resp.Ok().Body.String().Unwrap()
+
+	if resp.Ok().ECHAccepted() != accepted || strings.HasPrefix(body.Std(), "true") != accepted {
+		t.Fatalf("expected ECH accepted %t, got %t (server: %s)", accepted, resp.Ok().ECHAccepted(), body)
+	}
+
+	if accepted && !strings.HasSuffix(body.Std(), " ech.test") {
+		t.Fatalf("expected the inner server name ech.test, got %s", body)
+	}
+}
+
+func TestECHConfig(t *testing.T) {
+	t.Parallel()
+
+	key, config := echKey(t, 1)
+	port := echServer(t, key)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		ECHConfig(echConfigList(config)).
+		Resolver().Resolve("ech.test:" + g.String(port) + ":127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", true)
+}
+
+func TestECHWithConfig(t *testing.T) {
+	t.Parallel()
+
+	key, config := echKey(t, 6)
+	port := echServer(t, key)
+
+	// ECH and ECHConfig together record the acceptance of ECH once.
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		ECH().
+		ECHConfig(echConfigList(config)).
+		Resolver().Resolve("ech.test:" + g.String(port) + ":127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", true)
+}
+
+func TestECHFromHTTPSRecord(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	key, config := echKey(t, 2)
+	port := echServer(t, key)
+	dns := httpsServer(t, "_"+port+"._https.ech.test", &queries,
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamALPN, Value: []byte("\x02h2")},
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamECH, Value: echConfigList(config)},
+	)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Firefox().
+		ECH().
+		DNS(g.String(dns)).
+		Resolver().Resolve("ech.test:" + g.String(port) + ":127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", true)
+
+	client.CloseIdleConnections()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", true)
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected 1 HTTPS record query, got %d", queries.Load())
+	}
+}
+
+func TestECHRetryConfigs(t *testing.T) {
+	t.Parallel()
+
+	key, _ := echKey(t, 3)
+	_, stale := echKey(t, 4)
+	port := echServer(t, key)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		ECHConfig(echConfigList(stale)).
+		Resolver().Resolve("ech.test:" + g.String(port) + ":127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", true)
+}
+
+func TestECHGrease(t *testing.T) {
+	t.Parallel()
+
+	key, _ := echKey(t, 5)
+	port := echServer(t, key)
+
+	var queries atomic.Int32
+
+	dns := httpsServer(t, "other.test", &queries)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		ECH().
+		DNS(g.String(dns)).
+		Resolver().Resolve("ech.test:" + g.String(port) + ":127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectECH(t, client, "https://ech.test:"+port+"/", false)
+}
@@ -0,0 +1,231 @@
+package surf_test
+
+import (
+	"net"
+	_http "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+// familyServers starts an HTTP server listening on the same port of 127.0.0.1 and ::1.
+// With ipv4 false, only ::1 is listened on. Returns the port.
+func familyServers(t *testing.T, ipv4 bool) string {
+	t.Helper()
+
+	ln6, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+
+	_, port, _ := net.SplitHostPort(ln6.Addr().String())
+
+	listeners := []net.Listener{ln6}
+
+	if ipv4 {
+		ln4, err := net.Listen("tcp4", "127.0.0.1:"+port)
+		if err != nil {
+			ln6.Close()
+			t.Skip("port is not available on 127.0.0.1:", err)
+		}
+
+		listeners = append(listeners, ln4)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+
+	for _, ln := range listeners {
+		ts := httptest.NewUnstartedServer(handler)
+		ts.Listener.Close()
+		ts.Listener = ln
+		ts.Start()
+
+		t.Cleanup(ts.Close)
+	}
+
+	return port
+}
+
+func expectRemoteIP(t *testing.T, client *surf.Client, url, ip string) {
+	t.Helper()
+
+	resp := client.Get(g.String(url)).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	host, _, _ := net.SplitHostPort(resp.Ok().RemoteAddress().String())
+	if host != ip {
+		t.Fatalf("expected a connection to %s, got %s", ip, host)
+	}
+}
+
+func TestAddressFamilyPreference(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, true)
+	url := "http://dual.test:" + port + "/"
+
+	tests := []struct {
+		name      string
+		configure func(*surf.Builder) *surf.Builder
+		addresses g.String
+		expected  string
+	}{
+		{"PreferIPv4", (*surf.Builder).PreferIPv4, "[::1],127.0.0.1", "127.0.0.1"},
+		{"PreferIPv6", (*surf.Builder).PreferIPv6, "127.0.0.1,[::1]", "::1"},
+		{"IPv4Only", (*surf.Builder).IPv4Only, "[::1],127.0.0.1", "127.0.0.1"},
+		{"IPv6Only", (*surf.Builder).IPv6Only, "127.0.0.1,[::1]", "::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.configure(surf.NewClient().Builder()).
+				Resolver().Resolve("dual.test:*:" + tt.addresses).Set().
+				GetRemoteAddress().
+				Build().Unwrap()
+
+			expectRemoteIP(t, client, url, tt.expected)
+		})
+	}
+}
+
+func TestAddressFamilyOnly(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, false)
+
+	client := surf.NewClient().Builder().
+		IPv4Only().
+		Resolver().Resolve("v6.test:*:[::1]").Set().
+		Build().Unwrap()
+
+	if client.Get(g.String("http://v6.test:" + port + "/")).Do().IsOk() {
+		t.Fatal("expected no connection to an IPv6 only host with IPv4Only")
+	}
+
+	if client.Get(g.String("http://[::1]:" + port + "/")).Do().IsOk() {
+		t.Fatal("expected no connection to an IPv6 literal with IPv4Only")
+	}
+}
+
+func TestHappyEyeballsFallback(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, false)
+
+	client := surf.NewClient().Builder().
+		PreferIPv4().
+		Resolver().Resolve("fallback.test:*:127.0.0.1,[::1]").Set().
+		GetRemoteAddress().
+		Build().Unwrap()
+
+	expectRemoteIP(t, client, "http://fallback.test:"+port+"/", "::1")
+}
+
+func TestHappyEyeballsDelay(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, false)
+
+	// 192.0.2.1 (TEST-NET-1) is not routed, the attempt hangs or fails and ::1 is tried next.
+	client := surf.NewClient().Builder().
+		PreferIPv4().
+		HappyEyeballs(50 * time.Millisecond).
+		Resolver().Resolve("slow.test:*:192.0.2.1,[::1]").Set().
+		GetRemoteAddress().
+		Build().Unwrap()
+
+	start := time.Now()
+
+	expectRemoteIP(t, client, "http://slow.test:"+port+"/", "::1")
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the next address to be tried after the delay, took %s", elapsed)
+	}
+}
+
+func TestAddressFamilyProxy(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, true)
+
+	targets := make(chan string, 1)
+
+	proxy := connectProxy(t, func(target string) { targets <- target })
+
+	client := surf.NewClient().Builder().
+		IPv4Only().
+		Proxy(proxy).
+		Resolver().Resolve("proxied.test:*:[::1],127.0.0.1").Set().
+		Build().Unwrap()
+
+	resp := client.Get(g.String("http://proxied.test:" + port + "/")).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if target := <-targets; !strings.HasPrefix(target, "127.0.0.1:") {
+		t.Fatalf("expected the proxy to connect to the IPv4 address, got %s", target)
+	}
+}
+
+func TestAddressFamilyProxyTarget(t *testing.T) {
+	t.Parallel()
+
+	port := familyServers(t, true)
+
+	targets := make(chan string, 1)
+
+	proxy := connectProxy(t, func(target string) { targets <- target })
+
+	client := surf.NewClient().Builder().IPv4Only().Proxy(proxy).Build().Unwrap()
+
+	resp := client.Get(g.String("http://localhost:" + port + "/")).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	// Without a custom resolver, the target is resolved by the proxy.
+	if target := <-targets; target != "localhost:"+port {
+		t.Fatalf("expected the proxy to receive the hostname, got %s", target)
+	}
+}
+
+func TestAddressFamilyHTTP3(t *testing.T) {
+	t.Parallel()
+
+	server, conn, _, err := createHTTP3TestServer(func(w _http.ResponseWriter, _ *_http.Request) {
+		w.WriteHeader(_http.StatusOK)
+	})
+	if err != nil {
+		t.Skip("Failed to create HTTP/3 test server:", err)
+	}
+
+	go server.Serve(conn)
+
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	for _, configure := range []func(*surf.Builder) *surf.Builder{
+		(*surf.Builder).IPv4Only,
+		(*surf.Builder).PreferIPv6, // ::1 does not answer, 127.0.0.1 is raced after the delay
+	} {
+		client := configure(surf.NewClient().Builder()).
+			Impersonate().Chrome().
+			HTTP3().ForceHTTP3().
+			Resolver().Resolve("h3.test:*:[::1],127.0.0.1").Set().
+			Build().Unwrap()
+
+		expectProto(t, client, "https://h3.test:"+g.Int(port).String().Std()+"/", "HTTP/3.0")
+	}
+}
@@ -38,12 +38,17 @@ func (nw *netHTTPResponseWriter) WriteHeader(statusCode int) {
 	nw.w.WriteHeader(statusCode)
 }
 
-// createHTTP3TestServer creates a local HTTP/3 test server with self-signed certificate
-func createHTTP3TestServer(handler _http.HandlerFunc) (*http3.Server, net.PacketConn, string, error) {
-	// Generate self-signed certificate
+func (nw *netHTTPResponseWriter) Flush() {
+	if f, ok := nw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// createTestCertificate generates a self-signed certificate for localhost and 127.0.0.1.
+func createTestCertificate() (tls.Certificate, error) {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		return nil, nil, "", err
+		return tls.Certificate{}, err
 	}
 
 	template := x509.Certificate{
@@ -61,12 +66,20 @@ func createHTTP3TestServer(handler _http.HandlerFunc) (*http3.Server, net.Packet
 
 	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
 	if err != nil {
-		return nil, nil, "", err
+		return tls.Certificate{}, err
 	}
 
-	cert := tls.Certificate{
+	return tls.Certificate{
 		Certificate: [][]byte{certDER},
 		PrivateKey:  priv,
+	}, nil
+}
+
+// createHTTP3TestServer creates a local HTTP/3 test server with self-signed certificate
+func createHTTP3TestServer(handler _http.HandlerFunc) (*http3.Server, net.PacketConn, string, error) {
+	cert, err := createTestCertificate()
+	if err != nil {
+		return nil, nil, "", err
 	}
 
 	// Create UDP listener
@@ -0,0 +1,249 @@
+package surf_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+	"github.com/enetx/surf/pkg/httpsig"
+)
+
+// startSignatureServer starts a server verifying the signatures and content digests of the
+// requests with the key. It responds with the covered components.
+func startSignatureServer(t *testing.T, key any) g.String {
+	t.Helper()
+
+	k, err := httpsig.NewKey("client", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &httpsig.Verifier{Keys: []*httpsig.Key{k}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		components, err := verifier.Verify(httpsig.RequestMessage(r))
+		if err == nil && r.Header.Get("Content-Digest") != "" {
+			err = httpsig.VerifyContentDigest(r.Header.Get("Content-Digest"), body)
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, err)
+
+			return
+		}
+
+		fmt.Fprint(w, strings.Join(components, " "))
+	}))
+
+	t.Cleanup(ts.Close)
+
+	return g.String(ts.URL)
+}
+
+func TestHTTPSignature(t *testing.T) {
+	t.Parallel()
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	url := startSignatureServer(t, public)
+
+	client := surf.NewClient().Builder().
+		HTTPSignature("client", private).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Post(url+"/orders?id=1").Body(`{"id":1}`).Do(), "@method @target-uri content-digest")
+
+	// Requests without a body are signed without content-digest.
+	expectBody(t, client.Get(url+"/orders").Do(), "@method @target-uri")
+
+	client = surf.NewClient().Builder().
+		SetHeaders("X-Request-Id", "42").
+		HTTPSignature("client", private).
+		Components("@method", "@authority", "@path", "@query", "x-request-id", "content-type").
+		Label("partner").
+		Expires(time.Minute).
+		Nonce().
+		Tag("webhook").
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Put(url+"/orders?id=1").Body("data").Do(),
+		"@method @authority @path @query x-request-id content-type")
+
+	resp := client.Get(url).Do()
+	if resp.IsOk() {
+		t.Fatal("expected a missing covered header to fail the request")
+	}
+}
+
+func TestHTTPSignatureMultipart(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("shared secret")
+	url := startSignatureServer(t, secret)
+
+	client := surf.NewClient().Builder().
+		HTTPSignature("client", secret).
+		Set().
+		Build().Unwrap()
+
+	// Streamed bodies are signed without content-digest.
+	mp := surf.NewMultipart().Field("name", "value")
+
+	expectBody(t, client.Post(url).Multipart(mp).Do(), "@method @target-uri")
+
+	mp = surf.NewMultipart().Field("name", "value").Retry()
+
+	expectBody(t, client.Post(url).Multipart(mp).Do(), "@method @target-uri content-digest")
+}
+
+func TestContentDigest(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if err := httpsig.VerifyContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			fmt.Fprint(w, err)
+			return
+		}
+
+		fmt.Fprint(w, r.Header.Get("Content-Digest"))
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().ContentDigest().Build().Unwrap()
+
+	expectBody(t, client.Post(g.String(ts.URL)).Body(`{"hello": "world"}`).Do(),
+		"sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+}
+
+func TestVerifyHTTPSignature(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("server secret")
+
+	key, err := httpsig.NewKey("server", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &httpsig.Signer{Key: key, Components: []string{"@status", "content-type", "content-digest"}}
+
+	encode := func(encoding string, content []byte) []byte {
+		var buf bytes.Buffer
+
+		var w io.WriteCloser = gzip.NewWriter(&buf)
+		if encoding == "deflate" {
+			w = zlib.NewWriter(&buf)
+		}
+
+		w.Write(content)
+		w.Close()
+
+		return buf.Bytes()
+	}
+
+	large := bytes.Repeat([]byte("signed content "), 1<<20)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "" {
+			encoding = "gzip"
+		}
+
+		content := encode(encoding, []byte("signed content"))
+
+		if r.URL.Path == "/large" {
+			content = encode(encoding, large)
+		}
+
+		// The digest covers the encoded content.
+		digest, _ := httpsig.ContentDigest(content, httpsig.DigestSHA512)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Digest", digest)
+
+		resp := &http.Response{StatusCode: http.StatusOK, Header: w.Header()}
+
+		if r.URL.Path != "/unsigned" {
+			input, signature, _ := signer.Sign(httpsig.ResponseMessage(resp))
+			w.Header().Set("Signature-Input", input)
+			w.Header().Set("Signature", signature)
+		}
+
+		if r.URL.Path == "/tampered" {
+			content = encode(encoding, []byte("tampered content"))
+		}
+
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		VerifyHTTPSignature("other", []byte("other secret")).
+		VerifyHTTPSignature("server", secret).
+		Build().Unwrap()
+
+	var errSignature *httpsig.ErrSignature
+
+	for _, encoding := range []g.String{"gzip", "deflate"} {
+		expectBody(t, client.Get(url+"?encoding="+encoding).Do(), "signed content")
+		expectBody(t, client.Get(url+"/large?encoding="+encoding).Do(), g.String(large))
+
+		// The digest of the body is verified as it is read.
+		resp := client.Get(url + "/tampered?encoding=" + encoding).Do()
+		if resp.IsErr() {
+			t.Fatalf("%s: expected the response before the body is read, got %v", encoding, resp.Err())
+		}
+
+		if body := resp.Ok().Body.String(); !errors.As(body.Err(), &errSignature) {
+			t.Errorf("%s: expected *httpsig.ErrSignature reading a tampered body, got %v", encoding, body)
+		}
+	}
+
+	if resp := client.Get(url + "/unsigned").Do(); !errors.As(resp.Err(), &errSignature) {
+		t.Errorf("expected *httpsig.ErrSignature, got %v", resp.Err())
+	}
+
+	// The keys are registered once however many times the builder is built.
+	builder := surf.NewClient().Builder().VerifyHTTPSignature("server", secret)
+	builder.Build().Unwrap()
+	expectBody(t, builder.Build().Unwrap().Get(url).Do(), "signed content")
+
+	client = surf.NewClient().Builder().VerifyHTTPSignature("server", []byte("wrong")).Build().Unwrap()
+
+	if client.Get(url).Do().IsOk() {
+		t.Error("expected a signature of another key to fail")
+	}
+}
+
+func TestHTTPSignatureErrors(t *testing.T) {
+	t.Parallel()
+
+	if surf.NewClient().Builder().HTTPSignature("client", "secret").Set().Build().IsOk() {
+		t.Error("expected an unsupported key to fail Build")
+	}
+
+	if surf.NewClient().Builder().VerifyHTTPSignature("server", 42).Build().IsOk() {
+		t.Error("expected an unsupported verification key to fail Build")
+	}
+}
@@ -0,0 +1,317 @@
+package surf_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	_http "net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/http2"
+	"github.com/enetx/http3"
+	"github.com/enetx/surf"
+	"github.com/enetx/surf/pkg/quicconn"
+	"github.com/quic-go/quic-go"
+)
+
+// streamConn exposes an extended CONNECT stream as a net.Conn.
+type streamConn struct{ io.ReadWriteCloser }
+
+func (streamConn) LocalAddr() net.Addr              { return &net.UDPAddr{} }
+func (streamConn) RemoteAddr() net.Addr             { return &net.UDPAddr{} }
+func (streamConn) SetDeadline(time.Time) error      { return nil }
+func (streamConn) SetReadDeadline(time.Time) error  { return nil }
+func (streamConn) SetWriteDeadline(time.Time) error { return nil }
+
+// masqueTarget parses the target of a CONNECT-UDP request path using the default URI template.
+func masqueTarget(path string) (*net.UDPAddr, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 5 || parts[0] != ".well-known" || parts[1] != "masque" || parts[2] != "udp" {
+		return nil, fmt.Errorf("unexpected CONNECT-UDP path %q", path)
+	}
+
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(strings.ReplaceAll(parts[3], "%3A", ":"), parts[4]))
+}
+
+// relayUDP forwards datagrams between a CONNECT-UDP tunnel and a UDP socket to target.
+func relayUDP(target *net.UDPAddr, recv func() ([]byte, error), send func([]byte) error) {
+	udp, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		return
+	}
+	defer udp.Close()
+
+	go func() {
+		defer udp.Close()
+
+		for {
+			data, err := recv()
+			if err != nil {
+				return
+			}
+
+			udp.Write(data)
+		}
+	}()
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := udp.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if send(buf[:n]) != nil {
+			return
+		}
+	}
+}
+
+// relayCapsules relays a CONNECT-UDP stream carrying DATAGRAM capsules.
+func relayCapsules(target *net.UDPAddr, stream io.ReadWriteCloser) {
+	pc := quicconn.New(streamConn{stream}, target, quicconn.EncapConnectUDP)
+	defer pc.Close()
+
+	buf := make([]byte, 64*1024)
+
+	relayUDP(target,
+		func() ([]byte, error) {
+			n, _, err := pc.ReadFrom(buf)
+			return buf[:n], err
+		},
+		func(data []byte) error {
+			_, err := pc.WriteTo(data, nil)
+			return err
+		},
+	)
+}
+
+// masqueHTTP3Proxy starts a CONNECT-UDP proxy over HTTP/3, relaying UDP payloads as HTTP
+// datagrams or, when datagrams are disabled, as capsules on the request stream.
+func masqueHTTP3Proxy(t *testing.T, datagrams bool, tunnels *atomic.Int32) string {
+	t.Helper()
+
+	cert, err := createTestCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("Failed to create HTTP/3 proxy listener:", err)
+	}
+
+	server := &http3.Server{
+		TLSConfig:       &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h3"}},
+		EnableDatagrams: datagrams,
+		QUICConfig:      &quic.Config{InitialPacketSize: 1350},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, err := masqueTarget(r.URL.Path)
+			if err != nil || r.Method != http.MethodConnect || r.Proto != "connect-udp" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if r.Header.Get("Capsule-Protocol") != "?1" {
+				t.Error("CONNECT-UDP request must carry Capsule-Protocol: ?1")
+			}
+
+			tunnels.Add(1)
+
+			w.Header().Set("Capsule-Protocol", "?1")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+
+			if !datagrams {
+				out := writerFunc(func(p []byte) (int, error) {
+					n, err := w.Write(p)
+					w.(http.Flusher).Flush()
+					return n, err
+				})
+
+				relayCapsules(target, struct {
+					io.Reader
+					io.Writer
+					io.Closer
+				}{r.Body, out, r.Body})
+
+				return
+			}
+
+			str := w.(http3.HTTPStreamer).HTTPStream()
+			defer str.Close()
+
+			relayUDP(target,
+				func() ([]byte, error) {
+					for {
+						data, err := str.ReceiveDatagram(r.Context())
+						if err != nil || len(data) > 0 && data[0] == 0 {
+							return data[min(len(data), 1):], err
+						}
+					}
+				},
+				func(data []byte) error { return str.SendDatagram(append([]byte{0}, data...)) },
+			)
+		}),
+	}
+
+	go server.Serve(conn)
+
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	return fmt.Sprintf("https://127.0.0.1:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// masqueHTTP2Proxy starts a CONNECT-UDP proxy over HTTP/2 extended CONNECT.
+func masqueHTTP2Proxy(t *testing.T, tunnels *atomic.Int32) string {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("unexpected HTTP/1.1 request")
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.Config.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"h2": func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+			serveExtendedConnectHTTP2(conn, "connect-udp", func(f *http2.MetaHeadersFrame, stream io.ReadWriteCloser) {
+				target, err := masqueTarget(f.PseudoValue("path"))
+				if err != nil {
+					t.Error(err)
+					stream.Close()
+
+					return
+				}
+
+				tunnels.Add(1)
+				relayCapsules(target, stream)
+			})
+		},
+	}
+
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return ts.URL
+}
+
+// masqueOrigin starts the HTTP/3 origin reached through the proxy.
+func masqueOrigin(t *testing.T) string {
+	t.Helper()
+
+	server, conn, addr, err := createHTTP3TestServer(func(w _http.ResponseWriter, r *_http.Request) {
+		fmt.Fprintf(w, "%s via proxy", r.Proto)
+	})
+	if err != nil {
+		t.Skip("Failed to create HTTP/3 test server:", err)
+	}
+
+	go server.Serve(conn)
+
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	return addr
+}
+
+func testMASQUE(t *testing.T, proxy string, tunnels *atomic.Int32) {
+	t.Helper()
+
+	origin := masqueOrigin(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().ForceHTTP3().MASQUE().
+		Proxy(g.String(proxy)).
+		Timeout(10 * time.Second).
+		Build().Unwrap()
+
+	defer client.CloseIdleConnections()
+
+	for range 2 {
+		resp := client.Get(g.String(origin)).Do()
+		if resp.IsErr() {
+			t.Fatal(resp.Err())
+		}
+
+		body := resp.Ok().Body.String().UnwrapOrDefault()
+		if resp.Ok().Proto != "HTTP/3.0" || body != "HTTP/3.0 via proxy" {
+			t.Fatalf("unexpected response %q over %s", body, resp.Ok().Proto)
+		}
+	}
+
+	// The QUIC connection to the origin is reused, so a single tunnel is opened.
+	if tunnels.Load() != 1 {
+		t.Fatalf("expected one CONNECT-UDP tunnel, got %d", tunnels.Load())
+	}
+}
+
+func TestMASQUEOverHTTP3Datagrams(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+	testMASQUE(t, masqueHTTP3Proxy(t, true, &tunnels), &tunnels)
+}
+
+func TestMASQUEOverHTTP3Capsules(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+	testMASQUE(t, masqueHTTP3Proxy(t, false, &tunnels), &tunnels)
+}
+
+func TestMASQUEOverHTTP2(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+	testMASQUE(t, masqueHTTP2Proxy(t, &tunnels), &tunnels)
+}
+
+func TestMASQUEProxyRequiresHTTPS(t *testing.T) {
+	t.Parallel()
+
+	result := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().ForceHTTP3().MASQUE().
+		Proxy("http://127.0.0.1:8080").
+		Build()
+
+	if result.IsOk() {
+		t.Fatal("expected an error for HTTP/3 through a plain HTTP proxy")
+	}
+}
+
+func TestMASQUERequiresOptIn(t *testing.T) {
+	t.Parallel()
+
+	var tunnels atomic.Int32
+
+	// Without MASQUE an HTTPS proxy carries requests over TCP only, so forcing HTTP/3 fails.
+	result := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().ForceHTTP3().
+		Proxy(g.String(masqueHTTP3Proxy(t, true, &tunnels))).
+		Build()
+
+	if result.IsOk() {
+		t.Fatal("expected an error for HTTP/3 through an HTTPS proxy without MASQUE")
+	}
+
+	if tunnels.Load() != 0 {
+		t.Fatalf("expected no CONNECT-UDP tunnel without MASQUE, got %d", tunnels.Load())
+	}
+}
@@ -0,0 +1,279 @@
+package surf_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+// oauth2Server is a token endpoint at /token issuing token-1, token-2... for id:secret, and an API
+// accepting the tokens not revoked.
+type oauth2Server struct {
+	mu        sync.Mutex
+	grants    []string // grant_type of the token requests, with the refresh token if any
+	revoked   map[string]bool
+	expiresIn int
+	delay     time.Duration
+	tokens    atomic.Int32
+	requests  atomic.Int32 // Requests to the API
+}
+
+func (s *oauth2Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/token" {
+		s.requests.Add(1)
+
+		s.mu.Lock()
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		valid := ok && strings.HasPrefix(token, "token-") && !s.revoked[token]
+		s.mu.Unlock()
+
+		if !valid {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", token, body)
+
+		return
+	}
+
+	time.Sleep(s.delay)
+
+	if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+
+		return
+	}
+
+	r.ParseForm()
+
+	grant := r.PostForm.Get("grant_type")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch grant {
+	case "refresh_token":
+		if s.revoked[r.PostForm.Get("refresh_token")] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+
+			return
+		}
+
+		grant += " " + r.PostForm.Get("refresh_token")
+	case "password":
+		grant += " " + r.PostForm.Get("username") + ":" + r.PostForm.Get("password")
+	}
+
+	if scope := r.PostForm.Get("scope"); scope != "" {
+		grant += " scope=" + scope
+	}
+
+	if audience := r.PostForm.Get("audience"); audience != "" {
+		grant += " audience=" + audience
+	}
+
+	s.grants = append(s.grants, grant)
+
+	n := s.tokens.Add(1)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d,"refresh_token":"refresh-%d"}`,
+		n, s.expiresIn, n)
+}
+
+func (s *oauth2Server) revoke(tokens ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range tokens {
+		s.revoked[token] = true
+	}
+}
+
+func startOAuth2Server(t *testing.T) (*oauth2Server, g.String) {
+	t.Helper()
+
+	s := &oauth2Server{revoked: make(map[string]bool), expiresIn: 3600}
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	return s, g.String(ts.URL)
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	t.Parallel()
+
+	server, url := startOAuth2Server(t)
+
+	client := surf.NewClient().Builder().
+		OAuth2().
+		TokenURL(url+"/token").
+		ClientCredentials("id", "secret").
+		Scopes("read", "write").
+		Param("audience", "api").
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url+"/api").Do(), "token-1 ")
+	expectBody(t, client.Post(url+"/api").Body("data").Do(), "token-1 data")
+
+	if strings.Join(server.grants, ",") != "client_credentials scope=read write audience=api" {
+		t.Fatalf("expected a single client credentials token request, got %q", server.grants)
+	}
+
+	// An Authorization header set on the request is left as is.
+	resp := client.Get(url+"/api").SetHeaders("Authorization", "Bearer other").Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusUnauthorized || server.tokens.Load() != 1 {
+		t.Fatalf("expected the request header to be sent once, got %v with %d tokens", resp, server.tokens.Load())
+	}
+}
+
+func TestOAuth2PasswordRefresh(t *testing.T) {
+	t.Parallel()
+
+	server, url := startOAuth2Server(t)
+	server.expiresIn = 60
+
+	client := surf.NewClient().Builder().
+		OAuth2().
+		TokenURL(url+"/token").
+		Password("user", "pass").
+		Client("id", "secret").
+		ExpirySkew(time.Minute).
+		Set().
+		Build().Unwrap()
+
+	// Tokens expire within the skew and are refreshed for every request.
+	expectBody(t, client.Get(url+"/api").Do(), "token-1 ")
+	expectBody(t, client.Get(url+"/api").Do(), "token-2 ")
+	expectBody(t, client.Get(url+"/api").Do(), "token-3 ")
+
+	// A revoked refresh token falls back to the password grant.
+	server.revoke("refresh-3")
+
+	expectBody(t, client.Get(url+"/api").Do(), "token-4 ")
+
+	expected := "password user:pass,refresh_token refresh-1,refresh_token refresh-2,password user:pass"
+	if strings.Join(server.grants, ",") != expected {
+		t.Fatalf("expected rotated refresh tokens, got %q", server.grants)
+	}
+}
+
+func TestOAuth2SingleFlight(t *testing.T) {
+	t.Parallel()
+
+	server, url := startOAuth2Server(t)
+	server.delay = 100 * time.Millisecond
+
+	client := surf.NewClient().Builder().
+		OAuth2().
+		TokenURL(url+"/token").
+		RefreshToken("initial").
+		Client("id", "secret").
+		Set().
+		Build().Unwrap()
+
+	var wg sync.WaitGroup
+
+	for range 20 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			expectBody(t, client.Get(url+"/api").Do(), "token-1 ")
+		}()
+	}
+
+	wg.Wait()
+
+	if strings.Join(server.grants, ",") != "refresh_token initial" {
+		t.Fatalf("expected a single token request, got %q", server.grants)
+	}
+}
+
+func TestOAuth2InvalidToken(t *testing.T) {
+	t.Parallel()
+
+	server, url := startOAuth2Server(t)
+
+	client := surf.NewClient().Builder().
+		OAuth2().
+		TokenURL(url+"/token").
+		ClientCredentials("id", "secret").
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url+"/api").Do(), "token-1 ")
+
+	// The rejected token is replaced and the request is sent again with its body.
+	server.revoke("token-1")
+
+	expectBody(t, client.Put(url+"/api").Body("payload").Do(), "token-2 payload")
+
+	if server.requests.Load() != 3 || server.tokens.Load() != 2 {
+		t.Fatalf("expected a single replay, got %d requests and %d tokens", server.requests.Load(), server.tokens.Load())
+	}
+
+	// A new token rejected as well is not replaced again.
+	server.revoke("token-2", "token-3")
+
+	resp := client.Get(url + "/api").Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the 401 response, got %v", resp)
+	}
+
+	if server.requests.Load() != 5 || server.tokens.Load() != 3 {
+		t.Fatalf("expected a single replay, got %d requests and %d tokens", server.requests.Load(), server.tokens.Load())
+	}
+}
+
+func TestOAuth2Errors(t *testing.T) {
+	t.Parallel()
+
+	server, url := startOAuth2Server(t)
+
+	client := surf.NewClient().Builder().
+		OAuth2().
+		TokenURL(url+"/token").
+		ClientCredentials("id", "wrong").
+		Set().
+		Build().Unwrap()
+
+	resp := client.Get(url + "/api").Do()
+
+	var errOAuth2 *surf.ErrOAuth2
+	if !errors.As(resp.Err(), &errOAuth2) || !strings.Contains(errOAuth2.Msg, "invalid_client: unknown client") {
+		t.Fatalf("expected the token endpoint error, got %v", resp.Err())
+	}
+
+	if server.requests.Load() != 0 {
+		t.Fatalf("expected no request without a token, got %d", server.requests.Load())
+	}
+
+	if surf.NewClient().Builder().OAuth2().ClientCredentials("id", "secret").Set().Build().IsOk() {
+		t.Error("expected a missing token URL to fail Build")
+	}
+
+	if surf.NewClient().Builder().OAuth2().TokenURL(url + "/token").Set().Build().IsOk() {
+		t.Error("expected a missing grant to fail Build")
+	}
+}
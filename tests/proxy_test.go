@@ -0,0 +1,319 @@
+package surf_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+	"github.com/enetx/surf/pkg/connectproxy"
+)
+
+// connectProxy starts an HTTP proxy tunneling CONNECT requests, calling connect with the
+// target of every tunnel. It returns the proxy URL.
+func connectProxy(t *testing.T, connect func(target string)) g.String {
+	t.Helper()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		connect(r.Host)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go func() {
+			io.Copy(upstream, rw)
+			upstream.Close()
+		}()
+
+		io.Copy(conn, upstream)
+	}))
+
+	t.Cleanup(proxy.Close)
+
+	return g.String(proxy.URL)
+}
+
+// countingProxy starts a CONNECT proxy counting its tunnels.
+func countingProxy(t *testing.T) (g.String, *atomic.Int32) {
+	t.Helper()
+
+	var tunnels atomic.Int32
+
+	return connectProxy(t, func(string) { tunnels.Add(1) }), &tunnels
+}
+
+// sessionServer starts an HTTP/2 TLS server setting a session cookie and answering
+// whether the request carried it.
+func sessionServer(t *testing.T) g.String {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err == nil {
+			w.Write([]byte("cookie"))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+		w.Write([]byte("no cookie"))
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+
+	t.Cleanup(ts.Close)
+
+	return g.String(ts.URL)
+}
+
+func expectBody(t *testing.T, resp g.Result[*surf.Response], body g.String) {
+	t.Helper()
+
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if got := resp.Ok().Body.String().Unwrap(); got != body {
+		t.Fatalf("expected %q, got %q", body, got)
+	}
+}
+
+func TestRequestProxy(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, firstTunnels := countingProxy(t)
+	second, secondTunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		Session().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Proxy(first).Do(), "no cookie")
+	expectBody(t, client.Get(url).Proxy(first).Do(), "cookie")
+	expectBody(t, client.Get(url).Proxy(second).Do(), "cookie")
+	expectBody(t, client.Get(url).Proxy(second).Do(), "cookie")
+
+	if firstTunnels.Load() != 1 || secondTunnels.Load() != 1 {
+		t.Fatalf("expected one reused tunnel per proxy, got %d and %d", firstTunnels.Load(), secondTunnels.Load())
+	}
+}
+
+func TestRequestProxyClientDefault(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	proxy, tunnels := countingProxy(t)
+	other, otherTunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().Proxy(proxy).Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+	expectBody(t, client.Get(url).Proxy(other).Do(), "no cookie")
+	expectBody(t, client.Get(url).Proxy(proxy).Do(), "no cookie")
+
+	if tunnels.Load() != 1 || otherTunnels.Load() != 1 {
+		t.Fatalf("expected the client proxy to be reused, got %d and %d tunnels", tunnels.Load(), otherTunnels.Load())
+	}
+}
+
+func TestProxySelector(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, firstTunnels := countingProxy(t)
+	second, secondTunnels := countingProxy(t)
+
+	var requests atomic.Int32
+
+	client := surf.NewClient().Builder().
+		Impersonate().Firefox().
+		ProxySelector(func(*surf.Request) g.String {
+			if requests.Add(1)%2 == 0 {
+				return second
+			}
+
+			return first
+		}).
+		Build().Unwrap()
+
+	for range 4 {
+		if resp := client.Get(url).Do(); resp.IsErr() {
+			t.Fatal(resp.Err())
+		}
+	}
+
+	if firstTunnels.Load() != 1 || secondTunnels.Load() != 1 {
+		t.Fatalf("expected one reused tunnel per proxy, got %d and %d", firstTunnels.Load(), secondTunnels.Load())
+	}
+}
+
+func TestRequestProxyHTTP3(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	proxy, tunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().
+		Build().Unwrap()
+
+	resp := client.Get(url).Proxy(proxy).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if resp.Ok().Proto != "HTTP/2.0" || tunnels.Load() != 1 {
+		t.Fatalf("expected HTTP/2 through the proxy, got %s with %d tunnels", resp.Ok().Proto, tunnels.Load())
+	}
+}
+
+func TestRequestProxyTransportsEviction(t *testing.T) {
+	t.Parallel()
+
+	var open atomic.Int32
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed:
+			open.Add(-1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	url := g.String(ts.URL)
+	proxy, tunnels := countingProxy(t)
+
+	client := surf.NewClient()
+	defer client.CloseIdleConnections()
+
+	// Every user of the proxy has its own transport and tunnel.
+	user := func(i int) g.String { return proxy.Replace("http://", g.Format("http://user{}:pass@", i), 1) }
+
+	const proxies = 300
+
+	for i := range proxies {
+		expectBody(t, client.Get(url).Proxy(user(i)).Do(), "ok")
+	}
+
+	// The transports of the least recently used proxies are dropped with their idle connections.
+	deadline := time.Now().Add(5 * time.Second)
+	for open.Load() >= proxies && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := open.Load(); n >= proxies {
+		t.Fatalf("expected the idle connections of evicted proxy transports to be closed, %d open", n)
+	}
+
+	expectBody(t, client.Get(url).Proxy(user(0)).Do(), "ok")
+
+	if tunnels.Load() != proxies+1 {
+		t.Fatalf("expected a new transport for an evicted proxy, got %d tunnels", tunnels.Load())
+	}
+}
+
+func TestRequestProxyInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := surf.NewClient()
+
+	if client.Get("http://localhost/").Proxy("ftp://127.0.0.1:21").Do().IsOk() {
+		t.Fatal("expected an error for an unsupported proxy scheme")
+	}
+}
+
+func TestProxyChain(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	targets := make(chan string, 2)
+
+	first := connectProxy(t, func(target string) { targets <- target })
+	second, tunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		ProxyChain(first, second).Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	if target := <-targets; "http://"+target != second.Std() {
+		t.Fatalf("expected the first hop to tunnel to the second one, got %s", target)
+	}
+
+	if tunnels.Load() != 1 {
+		t.Fatalf("expected the second hop to tunnel to the target, got %d tunnels", tunnels.Load())
+	}
+}
+
+func TestProxyChainHopError(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, _ := countingProxy(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := g.String("http://" + ln.Addr().String())
+	ln.Close()
+
+	resp := surf.NewClient().Builder().ProxyChain(first, dead).Set().Build().Unwrap().Get(url).Do()
+
+	var hopErr *connectproxy.ErrProxyHop
+	if !errors.As(resp.Err(), &hopErr) || hopErr.Hop != 1 {
+		t.Fatalf("expected the first hop to fail reaching the second one, got %v", resp.Err())
+	}
+
+}
+
+func TestProxyCommaInPassword(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, tunnels := countingProxy(t)
+
+	// A proxy URL with a comma in its password is a single proxy, not a chain.
+	proxy := g.String("http://user:a,b@") + first.StripPrefix("http://")
+
+	expectBody(t, surf.NewClient().Builder().Proxy(proxy).Build().Unwrap().Get(url).Do(), "no cookie")
+	expectBody(t, surf.NewClient().Get(url).Proxy(proxy).Do(), "no cookie")
+
+	if tunnels.Load() != 2 {
+		t.Fatalf("expected 2 tunnels through the proxy, got %d", tunnels.Load())
+	}
+}
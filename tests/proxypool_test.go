@@ -0,0 +1,724 @@
+package surf_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	_http "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+// deadProxy returns the URL of a proxy refusing connections.
+func deadProxy(t *testing.T) g.String {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ln.Addr().String()
+	ln.Close()
+
+	return g.String("http://" + addr)
+}
+
+// statusServer starts a TLS server answering every request with status and body.
+func statusServer(t *testing.T, status int, body string) g.String {
+	t.Helper()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(ts.Close)
+
+	return g.String(ts.URL)
+}
+
+// stalledServer starts a server writing the beginning of body and the rest once release is called.
+func stalledServer(t *testing.T, body string) (g.String, func()) {
+	t.Helper()
+
+	stalled := make(chan struct{})
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body[:len(body)/2]))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Write([]byte(body[len(body)/2:]))
+	}))
+
+	var once sync.Once
+	release := func() { once.Do(func() { close(stalled) }) }
+
+	t.Cleanup(func() {
+		release()
+		ts.Close()
+	})
+
+	return g.String(ts.URL), release
+}
+
+func proxyStats(pool *surf.ProxyPool, proxy g.String) surf.ProxyStats {
+	for _, stats := range pool.Stats() {
+		if stats.Proxy == proxy {
+			return stats
+		}
+	}
+
+	return surf.ProxyStats{}
+}
+
+func TestProxyPoolLoadFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	list := "# proxies\nhttp://127.0.0.1:8080\n\n  socks5://127.0.0.1:1080  \nhttp://127.0.0.1:8080\n"
+
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := surf.NewProxyPool("http://127.0.0.1:3128")
+
+	if err := pool.LoadFile(g.String(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Len() != 3 {
+		t.Fatalf("expected 3 proxies, got %d", pool.Len())
+	}
+
+	if err := pool.LoadFile(g.String(path + ".missing")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestProxyPoolRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, firstTunnels := countingProxy(t)
+	second, secondTunnels := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	for range 4 {
+		expectBody(t, client.Get(url).Do(), "no cookie")
+	}
+
+	if firstTunnels.Load() != 1 || secondTunnels.Load() != 1 {
+		t.Fatalf("expected one reused tunnel per proxy, got %d and %d", firstTunnels.Load(), secondTunnels.Load())
+	}
+
+	for _, stats := range pool.Stats() {
+		if stats.Requests != 2 || stats.Latency <= 0 {
+			t.Fatalf("expected 2 requests with a latency through %s, got %+v", stats.Proxy, stats)
+		}
+	}
+}
+
+func TestProxyPoolConnectFailure(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	dead := deadProxy(t)
+	alive, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(dead, alive).MaxFailures(1)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	if client.Get(url).Do().IsOk() {
+		t.Fatal("expected the request through the dead proxy to fail")
+	}
+
+	for range 3 {
+		expectBody(t, client.Get(url).Do(), "no cookie")
+	}
+
+	stats := proxyStats(pool, dead)
+	if stats.Requests != 1 || stats.Failures != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the dead proxy to be quarantined after one failure, got %+v", stats)
+	}
+
+	if stats := proxyStats(pool, alive); stats.Requests != 3 {
+		t.Fatalf("expected the other requests through the live proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolRetry(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	dead := deadProxy(t)
+	alive, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(dead, alive).MaxFailures(math.MaxInt)
+
+	client := surf.NewClient().Builder().
+		ProxyPool(pool).
+		RetryPolicy(surf.NewBackoff(1).Base(time.Millisecond)).
+		Build().Unwrap()
+
+	// The retry after the failure through the dead proxy goes through the next proxy.
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	if stats := proxyStats(pool, dead); stats.Requests != 1 || stats.Failures != 1 {
+		t.Fatalf("expected one failed request through the dead proxy, got %+v", stats)
+	}
+
+	if stats := proxyStats(pool, alive); stats.Requests != 1 {
+		t.Fatalf("expected the retry through the live proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolRetryBan(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	first, _ := countingProxy(t)
+	second, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second).BanStatus(http.StatusServiceUnavailable)
+
+	client := surf.NewClient().Builder().
+		ProxyPool(pool).
+		Retry(1, time.Millisecond, http.StatusServiceUnavailable).
+		Build().Unwrap()
+
+	// The retried response bans the first proxy, and the retry goes through the second one.
+	expectBody(t, client.Get(g.String(ts.URL)).Do(), "ok")
+
+	if stats := proxyStats(pool, first); stats.Bans != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the first proxy to be banned, got %+v", stats)
+	}
+
+	if stats := proxyStats(pool, second); stats.Requests != 1 || stats.Bans != 0 {
+		t.Fatalf("expected the retry through the second proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolUnsent(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy)
+
+	client := surf.NewClient().Builder().
+		ProxyPool(pool).
+		CircuitBreaker().
+		FailureStatus(http.StatusOK).
+		Threshold(1).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	// Requests rejected by the open circuit give back the proxy they were counted for.
+	if client.Get(url).Do().IsOk() {
+		t.Fatal("expected the open circuit to reject the request")
+	}
+
+	if client.Get(url).Upgrade().IsOk() {
+		t.Fatal("expected the open circuit to reject the handshake")
+	}
+
+	if stats := proxyStats(pool, proxy); stats.Requests != 1 {
+		t.Fatalf("expected one request through the proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolBanStatus(t *testing.T) {
+	t.Parallel()
+
+	url := statusServer(t, http.StatusForbidden, "forbidden")
+	first, _ := countingProxy(t)
+	second, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second).BanStatus(http.StatusForbidden)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	for range 2 {
+		if resp := client.Get(url).Do(); resp.IsErr() || resp.Ok().StatusCode != http.StatusForbidden {
+			t.Fatal("expected the banned response to be returned")
+		}
+	}
+
+	resp := client.Get(url).Do()
+
+	var noProxy *surf.ErrNoProxyAvailable
+	if !errors.As(resp.Err(), &noProxy) {
+		t.Fatalf("expected ErrNoProxyAvailable with every proxy banned, got %v", resp.Err())
+	}
+
+	for _, stats := range pool.Stats() {
+		if stats.Bans != 1 || stats.QuarantinedUntil.IsZero() {
+			t.Fatalf("expected %s to be banned once, got %+v", stats.Proxy, stats)
+		}
+	}
+}
+
+func TestProxyPoolConcurrentBan(t *testing.T) {
+	t.Parallel()
+
+	started, unblock := make(chan g.Unit), make(chan g.Unit)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-unblock
+			w.Write([]byte("ok"))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).BanStatus(http.StatusForbidden)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	done := make(chan g.Result[*surf.Response])
+	go func() { done <- client.Get(url + "/slow").Do() }()
+
+	<-started
+	client.Get(url + "/ban").Do()
+	close(unblock)
+
+	// A request in flight through the proxy when it was banned does not release it.
+	expectBody(t, <-done, "ok")
+
+	if stats := proxyStats(pool, proxy); stats.Bans != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the proxy to stay banned, got %+v", stats)
+	}
+}
+
+func TestProxyPoolBanBody(t *testing.T) {
+	t.Parallel()
+
+	body := "<html>" + strings.Repeat("x", 100<<10) + "Please solve the CAPTCHA</html>"
+	url := statusServer(t, http.StatusOK, "<html>Please solve the CAPTCHA</html>")
+	large := statusServer(t, http.StatusOK, body)
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).BanBody("captcha").Quarantine(time.Hour)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	expectBody(t, client.Get(large).Do(), g.String(body))
+
+	if stats := proxyStats(pool, proxy); stats.Bans != 0 {
+		t.Fatalf("expected patterns to be searched in the beginning of the body only, got %+v", stats)
+	}
+
+	expectBody(t, client.Get(url).Do(), "<html>Please solve the CAPTCHA</html>")
+
+	if stats := proxyStats(pool, proxy); stats.Bans != 1 || stats.QuarantinedUntil.Before(time.Now().Add(time.Minute)) {
+		t.Fatalf("expected the proxy to be quarantined for an hour, got %+v", stats)
+	}
+}
+
+func TestProxyPoolBanBodyStalled(t *testing.T) {
+	t.Parallel()
+
+	url, release := stalledServer(t, "<html>Please solve the CAPTCHA</html>")
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).BanBody("captcha")
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	// The body is matched as it is read, the response is returned before the body is complete.
+	start := time.Now()
+
+	resp := client.Get(url).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("response waited %s for the body", elapsed)
+	}
+
+	release()
+
+	if body := resp.Ok().Body.String(); body.IsErr() || body.Ok() != "<html>Please solve the CAPTCHA</html>" {
+		t.Fatalf("unexpected body %v", body)
+	}
+
+	if stats := proxyStats(pool, proxy); stats.Bans != 1 {
+		t.Fatalf("expected the proxy to be banned once the body is read, got %+v", stats)
+	}
+}
+
+func TestProxyPoolStickyHost(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	other := g.String(strings.Replace(url.Std(), "127.0.0.1", "localhost", 1))
+
+	first, _ := countingProxy(t)
+	second, _ := countingProxy(t)
+	third, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second, third).Strategy(surf.ProxyStickyHost)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	for range 3 {
+		expectBody(t, client.Get(url).Do(), "no cookie")
+		expectBody(t, client.Get(other).Do(), "no cookie")
+	}
+
+	requests := pool.Stats()
+	if requests[0].Requests != 3 || requests[1].Requests != 3 || requests[2].Requests != 0 {
+		t.Fatalf("expected every host to keep its proxy, got %+v", requests)
+	}
+}
+
+func TestProxyPoolStickyHostLimit(t *testing.T) {
+	t.Parallel()
+
+	first, second := deadProxy(t), deadProxy(t)
+
+	pool := surf.NewProxyPool(first, second).Strategy(surf.ProxyStickyHost).MaxFailures(math.MaxInt)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	// 4096 hosts are bound after the first one, which is forgotten and bound to the next proxy.
+	for i := range 4097 {
+		client.Get(g.Format("http://host{}.test/", i)).Do()
+	}
+
+	client.Get("http://host0.test/").Do()
+
+	if stats := pool.Stats(); stats[0].Requests != 2049 || stats[1].Requests != 2049 {
+		t.Fatalf("expected the least recently used host to be forgotten, got %+v", stats)
+	}
+}
+
+func TestProxyPoolStickySession(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, _ := countingProxy(t)
+	second, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second).Strategy(surf.ProxyStickySession)
+
+	clients := []*surf.Client{
+		surf.NewClient().Builder().Session().ProxyPool(pool).Build().Unwrap(),
+		surf.NewClient().Builder().Session().ProxyPool(pool).Build().Unwrap(),
+	}
+
+	for _, client := range clients {
+		expectBody(t, client.Get(url).Do(), "no cookie")
+		expectBody(t, client.Get(url).Do(), "cookie")
+	}
+
+	for _, stats := range pool.Stats() {
+		if stats.Requests != 2 {
+			t.Fatalf("expected every client to keep its proxy, got %+v", pool.Stats())
+		}
+	}
+}
+
+func TestProxyPoolProxySession(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	first, _ := countingProxy(t)
+	second, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(first, second).Strategy(surf.ProxyStickySession)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+	other := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	for range 2 {
+		expectBody(t, client.Get(url).ProxySession("a").Do(), "no cookie")
+		expectBody(t, client.Get(url).ProxySession("b").Do(), "no cookie")
+	}
+
+	// A session keeps its proxy across the clients sharing the pool.
+	for range 2 {
+		expectBody(t, other.Get(url).ProxySession("a").Do(), "no cookie")
+	}
+
+	if stats := pool.Stats(); stats[0].Requests != 4 || stats[1].Requests != 2 {
+		t.Fatalf("expected every session to keep its proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolRequestProxy(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	pooled, pooledTunnels := countingProxy(t)
+	proxy, tunnels := countingProxy(t)
+
+	pool := surf.NewProxyPool(pooled)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	expectBody(t, client.Get(url).Proxy(proxy).Do(), "no cookie")
+
+	if pooledTunnels.Load() != 0 || tunnels.Load() != 1 {
+		t.Fatalf("expected the request proxy to take precedence, got %d and %d tunnels", pooledTunnels.Load(), tunnels.Load())
+	}
+}
+
+func TestProxyPoolStd(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	dead := deadProxy(t)
+	alive, tunnels := countingProxy(t)
+
+	pool := surf.NewProxyPool(dead, alive).MaxFailures(1)
+
+	client := surf.NewClient().Builder().
+		ProxyPool(pool).
+		RateLimit().
+		PerHost(2, time.Hour).
+		Set().
+		Build().Unwrap()
+
+	std := client.Std()
+
+	// The failure through the dead proxy is reported to the pool.
+	if _, err := std.Get(url.Std()); err == nil {
+		t.Fatal("expected the request through the dead proxy to fail")
+	}
+
+	if stats := proxyStats(pool, dead); stats.Failures != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the dead proxy to be quarantined, got %+v", stats)
+	}
+
+	resp, err := std.Get(url.Std())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// A request cancelled while waiting for the rate limit gives back the proxy it was counted for.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req, _ := _http.NewRequestWithContext(ctx, _http.MethodGet, url.Std(), nil)
+	if _, err := std.Do(req); err == nil {
+		t.Fatal("expected the rate limited request to fail")
+	}
+
+	if stats := proxyStats(pool, alive); stats.Requests != 1 || tunnels.Load() != 1 {
+		t.Fatalf("expected one request through the live proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	dead := deadProxy(t)
+	alive, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(dead, alive).MaxFailures(1).HealthCheck(url, 0)
+	defer pool.Close()
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	pool.Check(context.Background())
+
+	if stats := proxyStats(pool, dead); stats.Failures != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the dead proxy to be quarantined by the health check, got %+v", stats)
+	}
+
+	if stats := proxyStats(pool, alive); stats.Latency <= 0 || !stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected the latency of the live proxy to be measured, got %+v", stats)
+	}
+
+	for range 2 {
+		expectBody(t, client.Get(url).Do(), "no cookie")
+	}
+
+	if stats := proxyStats(pool, dead); stats.Requests != 0 {
+		t.Fatalf("expected no request through the quarantined proxy, got %+v", stats)
+	}
+}
+
+func TestProxyPoolHealthCheckRelease(t *testing.T) {
+	t.Parallel()
+
+	url := statusServer(t, http.StatusTooManyRequests, "")
+	ok := statusServer(t, http.StatusOK, "ok")
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).BanStatus(http.StatusTooManyRequests)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	client.Get(url).Do()
+
+	if client.Get(url).Do().IsOk() {
+		t.Fatal("expected the banned proxy to be quarantined")
+	}
+
+	pool.HealthCheck(ok, 20*time.Millisecond)
+	defer pool.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !proxyStats(pool, proxy).QuarantinedUntil.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background health check to release the proxy")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expectBody(t, client.Get(ok).Do(), "ok")
+}
+
+func TestProxyPoolHealthCheckStatus(t *testing.T) {
+	t.Parallel()
+
+	failing := statusServer(t, http.StatusBadGateway, "upstream down")
+	ok := statusServer(t, http.StatusOK, strings.Repeat("ok", 1024))
+	proxy, tunnels := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).MaxFailures(1).HealthCheck(failing, 0)
+	defer pool.Close()
+
+	pool.Check(context.Background())
+
+	if stats := proxyStats(pool, proxy); stats.Failures != 1 || stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected a 5xx health check to count a failure, got %+v", stats)
+	}
+
+	pool.HealthCheck(ok, 0)
+	tunnels.Store(0)
+
+	for range 3 {
+		pool.Check(context.Background())
+	}
+
+	if stats := proxyStats(pool, proxy); !stats.QuarantinedUntil.IsZero() {
+		t.Fatalf("expected a successful health check to release the proxy, got %+v", stats)
+	}
+
+	// The responses are drained, the checks reuse the connection through the proxy.
+	if tunnels.Load() != 1 {
+		t.Fatalf("expected the health checks to share a tunnel, got %d", tunnels.Load())
+	}
+}
+
+func TestProxyPoolHealthCheckOwner(t *testing.T) {
+	t.Parallel()
+
+	agents := make(chan string, 1)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+	}))
+	defer ts.Close()
+
+	proxy, _ := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).HealthCheck(g.String(ts.URL), 0)
+	defer pool.Close()
+
+	surf.NewClient().Builder().ProxyPool(pool).UserAgent("owner").Build().Unwrap()
+
+	pool.Check(context.Background())
+
+	if agent := <-agents; agent != "owner" {
+		t.Fatalf("expected the health check to be sent as the owner client, got %q", agent)
+	}
+
+	if stats := proxyStats(pool, proxy); stats.Latency <= 0 {
+		t.Fatalf("expected the latency of the proxy to be measured, got %+v", stats)
+	}
+}
+
+func TestProxyPoolEvict(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ban" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	proxy, tunnels := countingProxy(t)
+
+	pool := surf.NewProxyPool(proxy).BanStatus(http.StatusTooManyRequests).Quarantine(20 * time.Millisecond)
+
+	client := surf.NewClient().Builder().ProxyPool(pool).Build().Unwrap()
+
+	for range 2 {
+		client.Get(url).Do().Unwrap().Body.Close()
+	}
+
+	if tunnels.Load() != 1 {
+		t.Fatalf("expected the tunnel to be reused, got %d tunnels", tunnels.Load())
+	}
+
+	// A banned proxy gets a new transport once released from quarantine.
+	client.Get(url + "/ban").Do().Unwrap().Body.Close()
+	time.Sleep(30 * time.Millisecond)
+	client.Get(url).Do().Unwrap().Body.Close()
+
+	if tunnels.Load() != 2 {
+		t.Fatalf("expected a new tunnel after the ban, got %d tunnels", tunnels.Load())
+	}
+
+	pool.Remove(proxy)
+
+	var noProxy *surf.ErrNoProxyAvailable
+	if resp := client.Get(url).Do(); pool.Len() != 0 || !errors.As(resp.Err(), &noProxy) {
+		t.Fatalf("expected ErrNoProxyAvailable after Remove, got %v", resp.Err())
+	}
+}
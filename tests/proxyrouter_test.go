@@ -0,0 +1,298 @@
+package surf_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/surf"
+	"github.com/wzshiming/socks5"
+)
+
+// socks5Proxy starts a SOCKS5 proxy counting its connections. It returns the proxy URL.
+func socks5Proxy(t *testing.T) (g.String, *atomic.Int32) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	var connections atomic.Int32
+
+	server := socks5.NewServer()
+	server.ProxyDial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		connections.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+
+	go server.Serve(listener)
+
+	return g.String("socks5://" + listener.Addr().String()), &connections
+}
+
+func TestProxyRouterRules(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	local := strings.Replace(url.Std(), "127.0.0.1", "localhost", 1)
+
+	regexpProxy, regexpTunnels := countingProxy(t)
+	cidrProxy, cidrTunnels := countingProxy(t)
+	clientProxy, clientTunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		Proxy(clientProxy).
+		ProxyRouter().
+		Domain("localhost", surf.ProxyDirect).
+		Regexp(regexp.MustCompile(`/regexp$`), regexpProxy).
+		CIDR("127.0.0.0/8", cidrProxy).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(g.String(local)).Do(), "no cookie")
+	expectBody(t, client.Get(url+"/regexp").Do(), "no cookie")
+	expectBody(t, client.Get(url+"/cidr").Do(), "no cookie")
+	expectBody(t, client.Get(url+"/cidr").Do(), "no cookie")
+
+	if regexpTunnels.Load() != 1 || cidrTunnels.Load() != 1 || clientTunnels.Load() != 0 {
+		t.Fatalf("expected one tunnel per matching rule and none through the client proxy, got %d, %d and %d",
+			regexpTunnels.Load(), cidrTunnels.Load(), clientTunnels.Load())
+	}
+
+	// A proxy set on the request takes precedence over the router.
+	expectBody(t, client.Get(url+"/regexp").Proxy(clientProxy).Do(), "no cookie")
+
+	if clientTunnels.Load() != 1 {
+		t.Fatalf("expected the request proxy to be used, got %d tunnels", clientTunnels.Load())
+	}
+}
+
+func TestProxyRouterDefault(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	fallback, fallbackTunnels := countingProxy(t)
+	clientProxy, clientTunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Proxy(clientProxy).
+		ProxyRouter().
+		Domain("example.com", "http://127.0.0.1:1").
+		Default(fallback).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	if fallbackTunnels.Load() != 1 || clientTunnels.Load() != 0 {
+		t.Fatalf("expected the default proxy, got %d tunnels and %d through the client proxy",
+			fallbackTunnels.Load(), clientTunnels.Load())
+	}
+
+	// Without a default the proxy of the client is used.
+	client = surf.NewClient().Builder().
+		Proxy(clientProxy).
+		ProxyRouter().
+		Domain("example.com", "http://127.0.0.1:1").
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	if clientTunnels.Load() != 1 {
+		t.Fatalf("expected the client proxy, got %d tunnels", clientTunnels.Load())
+	}
+}
+
+func TestProxyRouterNoProxy(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	proxy, tunnels := countingProxy(t)
+	port := url.Std()[strings.LastIndex(url.Std(), ":")+1:]
+
+	testCases := []struct {
+		noProxy g.String
+		tunnels int32
+	}{
+		{"127.0.0.1", 0},
+		{"other.example, 127.0.0.0/8", 0},
+		{"127.0.0.1:" + g.String(port), 0},
+		{"127.0.0.1:1", 1},
+		{"*", 0},
+		{".example.com", 1},
+	}
+
+	for _, tc := range testCases {
+		tunnels.Store(0)
+
+		client := surf.NewClient().Builder().
+			ProxyRouter().
+			CIDR("0.0.0.0/0", proxy).
+			NoProxy(tc.noProxy).
+			Set().
+			Build().Unwrap()
+
+		expectBody(t, client.Get(url).Do(), "no cookie")
+
+		if tunnels.Load() != tc.tunnels {
+			t.Errorf("NO_PROXY %q: expected %d tunnels, got %d", tc.noProxy, tc.tunnels, tunnels.Load())
+		}
+	}
+}
+
+func TestProxyRouterNoProxyEnvironment(t *testing.T) {
+	t.Setenv("NO_PROXY", "localhost,127.0.0.0/8")
+
+	url := sessionServer(t)
+	proxy, tunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		ProxyRouter().
+		Default(proxy).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "no cookie")
+
+	if tunnels.Load() != 0 {
+		t.Fatalf("expected NO_PROXY to bypass the proxy, got %d tunnels", tunnels.Load())
+	}
+}
+
+func TestProxyRouterPAC(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+	httpProxy, httpTunnels := countingProxy(t)
+	socksProxy, socksConnections := socks5Proxy(t)
+
+	script := g.String(`
+		function FindProxyForURL(url, host) {
+			if (shExpMatch(url, "*/direct"))
+				return "DIRECT";
+			if (shExpMatch(url, "*/socks") && isInNet(dnsResolve(host), "127.0.0.0", "255.0.0.0"))
+				return "SOCKS5 ` + strings.TrimPrefix(socksProxy.Std(), "socks5://") + `; DIRECT";
+			return "PROXY ` + strings.TrimPrefix(httpProxy.Std(), "http://") + `";
+		}
+	`)
+
+	path := filepath.Join(t.TempDir(), "proxy.pac")
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range []*surf.Client{
+		surf.NewClient().Builder().ProxyRouter().PAC(script).Set().Build().Unwrap(),
+		surf.NewClient().Builder().ProxyRouter().PACFile(g.String(path)).Set().Build().Unwrap(),
+	} {
+		httpTunnels.Store(0)
+		socksConnections.Store(0)
+
+		expectBody(t, client.Get(url+"/direct").Do(), "no cookie")
+		expectBody(t, client.Get(url+"/socks").Do(), "no cookie")
+		expectBody(t, client.Get(url+"/http").Do(), "no cookie")
+
+		if httpTunnels.Load() != 1 || socksConnections.Load() != 1 {
+			t.Fatalf("expected one connection through each proxy, got %d and %d",
+				httpTunnels.Load(), socksConnections.Load())
+		}
+	}
+}
+
+func TestProxyRouterPACFailover(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := listener.Addr().String()
+	listener.Close()
+
+	client := surf.NewClient().Builder().
+		ProxyRouter().
+		PAC(g.String(`function FindProxyForURL(url, host) { return "PROXY ` + down + `; DIRECT"; }`)).
+		Set().
+		Build().Unwrap()
+
+	// Only the first entry of the result is used, DIRECT is not tried after the proxy fails.
+	if client.Get(url).Do().IsOk() {
+		t.Fatal("expected the request to fail through the first proxy of the result")
+	}
+}
+
+func TestProxyRouterConcurrency(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+
+	client := surf.NewClient().Builder().
+		ProxyRouter().
+		PAC(`function FindProxyForURL(url, host) {
+			if (shExpMatch(url, "*/slow")) while (true) {}
+			return "DIRECT";
+		}`).
+		Set().
+		Build().Unwrap()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evaluating := make(chan struct{})
+	go func() {
+		close(evaluating)
+		client.Get(url + "/slow").WithContext(ctx).Do()
+	}()
+
+	<-evaluating
+	time.Sleep(50 * time.Millisecond)
+
+	// The PAC evaluation of a request does not hold back the other requests of the client.
+	start := time.Now()
+	expectBody(t, client.Get(url+"/fast").Do(), "no cookie")
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("request waited %s for the PAC evaluation of another request", elapsed)
+	}
+}
+
+func TestProxyRouterErrors(t *testing.T) {
+	t.Parallel()
+
+	if surf.NewClient().Builder().ProxyRouter().PAC("function FindProxyForURL(url, host) {").Set().Build().IsOk() {
+		t.Error("expected an invalid PAC script to fail Build")
+	}
+
+	if surf.NewClient().Builder().ProxyRouter().PACFile("/nonexistent/proxy.pac").Set().Build().IsOk() {
+		t.Error("expected a missing PAC file to fail Build")
+	}
+
+	if surf.NewClient().Builder().ProxyRouter().CIDR("10.0.0.0/33", surf.ProxyDirect).Set().Build().IsOk() {
+		t.Error("expected an invalid prefix to fail Build")
+	}
+
+	client := surf.NewClient().Builder().
+		ProxyRouter().
+		PAC("function FindProxyForURL(url, host) { return missing(host); }").
+		Set().
+		Build().Unwrap()
+
+	if client.Get("http://127.0.0.1:1/").Do().IsOk() {
+		t.Error("expected a failing PAC script to fail the request")
+	}
+}
@@ -0,0 +1,279 @@
+package surf_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+func TestRateLimitPerHost(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		PerHost(2, 100*time.Millisecond).
+		Set().
+		Build().Unwrap()
+
+	start := time.Now()
+
+	// Two requests are sent at once, the next two wait for the bucket to refill.
+	for range 4 {
+		expectBody(t, client.Get(url).Do(), "ok")
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the requests to be limited, took %s", elapsed)
+	}
+
+	// Waiting requests honor the deadline of their context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	for range 2 {
+		client.Get(url).Do()
+	}
+
+	if resp := client.Get(url).WithContext(ctx).Do(); !errors.Is(resp.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", resp.Err())
+	}
+}
+
+func TestRateLimitMaxBuckets(t *testing.T) {
+	t.Parallel()
+
+	client := surf.NewClient().Builder().
+		Proxy(deadProxy(t)).
+		RateLimit().
+		PerHost(1, time.Hour).
+		Set().
+		Build().Unwrap()
+
+	// 1024 buckets are kept: the first host is forgotten once the last one is requested, though
+	// its bucket is not full.
+	for i := range 1025 {
+		client.Get(g.Format("http://host{}.test/", i)).Do()
+	}
+
+	if limits := client.RateLimits(); limits.Len() != 1024 {
+		t.Fatalf("expected 1024 buckets, got %d", limits.Len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if resp := client.Get("http://host0.test/").WithContext(ctx).Do(); errors.Is(resp.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected the bucket of the least recently used host to be forgotten, got %v", resp.Err())
+	}
+}
+
+func TestRateLimitMaxInFlight(t *testing.T) {
+	t.Parallel()
+
+	var (
+		inFlight atomic.Int32
+		peak     atomic.Int32
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		MaxInFlight(2).
+		Set().
+		Build().Unwrap()
+
+	var (
+		wg      sync.WaitGroup
+		waiting atomic.Bool
+	)
+
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp := client.Get(url).Do()
+			if resp.IsErr() {
+				t.Error(resp.Err())
+				return
+			}
+
+			resp.Ok().Body.Close()
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range 10 {
+			for _, s := range client.RateLimits() {
+				if s.Scope == "host" && s.Waiting > 0 && s.InFlight <= 2 {
+					waiting.Store(true)
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", peak.Load())
+	}
+
+	if !waiting.Load() {
+		t.Error("expected RateLimits to report waiting requests")
+	}
+
+	if client.RateLimits().Len() != 0 {
+		t.Errorf("expected no in-flight requests left, got %+v", client.RateLimits())
+	}
+}
+
+func TestRateLimitStd(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		Global(1, 50*time.Millisecond).
+		Set().
+		Build().Unwrap()
+
+	std := client.Std()
+	start := time.Now()
+
+	for range 3 {
+		resp, err := std.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the requests of Std to be limited, took %s", elapsed)
+	}
+
+	stats := client.RateLimits()
+	if stats.Len() != 1 || stats[0].Scope != "global" || stats[0].Tokens > 0.5 {
+		t.Errorf("unexpected rate limit stats %+v", stats)
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		Global(10, time.Hour).
+		PerHost(1, time.Hour).
+		Set().
+		Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "ok")
+
+	// A request cancelled while waiting for the host gives its global token back.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if resp := client.Get(url).WithContext(ctx).Do(); !errors.Is(resp.Err(), context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", resp.Err())
+	}
+
+	for _, s := range client.RateLimits() {
+		if s.Scope == "global" && s.Tokens < 8.9 {
+			t.Errorf("expected 9 global tokens, got %v", s.Tokens)
+		}
+	}
+}
+
+func TestRateLimitUnclosedBody(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RateLimit().
+		MaxInFlight(1).
+		Set().
+		Build().Unwrap()
+
+	if resp := client.Get(url).Do(); resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	// The slot of a body that is never closed is released when the body is garbage collected.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan g.Result[*surf.Response], 1)
+	go func() { done <- client.Get(url).WithContext(ctx).Do() }()
+
+	for {
+		runtime.GC()
+
+		select {
+		case resp := <-done:
+			expectBody(t, resp, "ok")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -361,8 +361,8 @@ func TestRequestRetry(t *testing.T) {
 	}
 
 	// Should have retried 2 times (3 total attempts)
-	if resp.Ok().Attempts != 2 {
-		t.Errorf("expected 2 retry attempts, got %d", resp.Ok().Attempts)
+	if resp.Ok().Attempts.Len() != 3 {
+		t.Errorf("expected 3 attempts, got %d", resp.Ok().Attempts.Len())
 	}
 
 	if !resp.Ok().Body.Contains("success") {
@@ -0,0 +1,376 @@
+package surf_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/surf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer starts a UDP DNS server answering A queries for names starting with "found"
+// with 127.0.0.1 and the given TTL, and all other queries with NXDOMAIN and a SOA record.
+// A and NXDOMAIN answers are counted; a positive delay holds every answer back.
+func dnsServer(t *testing.T, ttl uint32, delay time.Duration, queries *atomic.Int32) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			found := strings.HasPrefix(q.Name.String(), "found")
+			if q.Type == dnsmessage.TypeA || !found {
+				queries.Add(1)
+			}
+
+			rh := dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true}
+			if !found {
+				rh.RCode = dnsmessage.RCodeNameError
+			}
+
+			b := dnsmessage.NewBuilder(nil, rh)
+			b.StartQuestions()
+			b.Question(q)
+
+			if found && q.Type == dnsmessage.TypeA {
+				b.StartAnswers()
+				b.AResource(
+					dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl},
+					dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				)
+			}
+
+			if !found {
+				b.StartAuthorities()
+				b.SOAResource(
+					dnsmessage.ResourceHeader{
+						Name:  dnsmessage.MustNewName("test."),
+						Class: dnsmessage.ClassINET,
+						TTL:   300,
+					},
+					dnsmessage.SOAResource{
+						NS:     dnsmessage.MustNewName("ns.test."),
+						MBox:   dnsmessage.MustNewName("admin.test."),
+						MinTTL: 300,
+					},
+				)
+			}
+
+			answer, err := b.Finish()
+			if err != nil {
+				continue
+			}
+
+			go func() {
+				time.Sleep(delay)
+				pc.WriteTo(answer, addr)
+			}()
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+func lookup(client *surf.Client, host string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return client.GetDialer().Resolver.LookupNetIP(ctx, "ip4", host)
+}
+
+func TestResolverCache(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).Set().
+		Build().Unwrap()
+
+	for range 3 {
+		addrs, err := lookup(client, "found.cache.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(addrs) != 1 || addrs[0] != netip.MustParseAddr("127.0.0.1") {
+			t.Fatalf("unexpected addresses %v", addrs)
+		}
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected 1 upstream query, got %d", queries.Load())
+	}
+
+	stats := client.GetResolver().Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	client.GetResolver().Clear()
+
+	if _, err := lookup(client, "found.cache.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if queries.Load() != 2 {
+		t.Fatalf("expected a new upstream query after Clear, got %d", queries.Load())
+	}
+}
+
+func TestResolverTTL(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).MaxTTL(50 * time.Millisecond).Set().
+		Build().Unwrap()
+
+	if _, err := lookup(client, "found.ttl.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := lookup(client, "found.ttl.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if queries.Load() != 2 {
+		t.Fatalf("expected the expired answer to be queried again, got %d queries", queries.Load())
+	}
+}
+
+func TestResolverMaxTTLDisabled(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).MaxTTL(0).Set().
+		Build().Unwrap()
+
+	for range 2 {
+		if _, err := lookup(client, "found.maxttl.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected the answer to be cached for its TTL, got %d queries", queries.Load())
+	}
+}
+
+func TestResolverCoalesce(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 200*time.Millisecond, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).Set().
+		Build().Unwrap()
+
+	// The first query gives up before the answer, the identical query waiting for it still gets it.
+	first := make(chan error, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := client.GetDialer().Resolver.LookupNetIP(ctx, "ip4", "found.coalesce.test")
+		first <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Another network keeps the lookups of net.Resolver apart, their A queries are identical.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.GetDialer().Resolver.LookupNetIP(ctx, "ip", "found.coalesce.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-first; err == nil {
+		t.Error("expected the first query to time out")
+	}
+
+	if stats := client.GetResolver().Stats(); queries.Load() != 1 || stats.Coalesced == 0 {
+		t.Fatalf("expected a single upstream query, got %d and %+v", queries.Load(), stats)
+	}
+}
+
+func TestResolverNegativeCache(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).Set().
+		Build().Unwrap()
+
+	for range 2 {
+		if _, err := lookup(client, "missing.test."); err == nil {
+			t.Fatal("expected NXDOMAIN")
+		}
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected 1 upstream query, got %d", queries.Load())
+	}
+
+	if stats := client.GetResolver().Stats(); stats.Negative != 1 {
+		t.Fatalf("expected 1 negative hit, got %+v", stats)
+	}
+}
+
+func TestResolverNegativeCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).NegativeTTL(0).Set().
+		Build().Unwrap()
+
+	for range 2 {
+		if _, err := lookup(client, "missing.test."); err == nil {
+			t.Fatal("expected NXDOMAIN")
+		}
+	}
+
+	if queries.Load() != 2 {
+		t.Fatalf("expected 2 upstream queries, got %d", queries.Load())
+	}
+}
+
+func TestResolverRace(t *testing.T) {
+	t.Parallel()
+
+	var slow, fast atomic.Int32
+
+	slowDNS := dnsServer(t, 300, 3*time.Second, &slow)
+	fastDNS := dnsServer(t, 300, 0, &fast)
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(slowDNS), g.String(fastDNS)).Set().
+		Build().Unwrap()
+
+	start := time.Now()
+
+	if _, err := lookup(client, "found.race.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the fast upstream to win, lookup took %s", elapsed)
+	}
+
+	if fast.Load() != 1 {
+		t.Fatalf("expected 1 query to the fast upstream, got %d", fast.Load())
+	}
+}
+
+func TestResolverDNSUpstream(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+
+	client := surf.NewClient().Builder().
+		Resolver().Set().
+		DNS(g.String(dns)).
+		Build().Unwrap()
+
+	for range 2 {
+		if _, err := lookup(client, "found.upstream.test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected the DNS server to be a cached upstream, got %d queries", queries.Load())
+	}
+}
+
+func TestResolverOverride(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+
+	dns := dnsServer(t, 300, 0, &queries)
+	target := dohTarget(t, "override.test")
+
+	client := surf.NewClient().Builder().
+		Resolver().Upstream(g.String(dns)).Resolve("override.test:80:127.0.0.1").Set().
+		Build().Unwrap()
+
+	expectResolved(t, client, target)
+
+	if queries.Load() != 0 {
+		t.Fatalf("expected no upstream query, got %d", queries.Load())
+	}
+
+	if client.GetResolver().Stats().Overrides == 0 {
+		t.Fatal("expected the override to answer the lookup")
+	}
+}
+
+func TestResolverInvalidSettings(t *testing.T) {
+	t.Parallel()
+
+	for _, builder := range []*surf.Builder{
+		surf.NewClient().Builder().Resolver().Resolve("override.test:127.0.0.1").Set(),
+		surf.NewClient().Builder().Resolver().Resolve("override.test:http:127.0.0.1").Set(),
+		surf.NewClient().Builder().Resolver().Resolve("override.test:80:localhost").Set(),
+		surf.NewClient().Builder().Resolver().Upstream("dns.test:53").Set(),
+	} {
+		if builder.Build().IsOk() {
+			t.Fatal("expected an error for invalid resolver settings")
+		}
+	}
+}
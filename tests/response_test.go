@@ -85,8 +85,8 @@ func TestResponseBasics(t *testing.T) {
 	}
 
 	// Test Attempts
-	if response.Attempts != 0 {
-		t.Errorf("expected 0 attempts, got %d", response.Attempts)
+	if response.Attempts.Len() != 1 {
+		t.Errorf("expected 1 attempt, got %d", response.Attempts.Len())
 	}
 
 	// Test Cookies
@@ -333,8 +333,8 @@ func TestResponseWithRetry(t *testing.T) {
 	response := resp.Ok()
 
 	// Test Attempts count
-	if response.Attempts != 2 {
-		t.Errorf("expected 2 retry attempts, got %d", response.Attempts)
+	if response.Attempts.Len() != 3 {
+		t.Errorf("expected 3 attempts, got %d", response.Attempts.Len())
 	}
 
 	if !response.Body.Contains("success") {
@@ -0,0 +1,317 @@
+package surf_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/http2"
+	"github.com/enetx/surf"
+	"github.com/enetx/surf/pkg/connectproxy"
+)
+
+func TestBackoffRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("Retry-After", time.Now().Add(-time.Second).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().
+		RetryPolicy(surf.NewBackoff(3).Base(time.Millisecond)).
+		Build().Unwrap()
+
+	resp := client.Get(g.String(ts.URL)).Do()
+	expectBody(t, resp, "ok")
+
+	attempts := resp.Ok().Attempts
+	if attempts.Len() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts.Len())
+	}
+
+	for i, attempt := range attempts {
+		if attempt.Number != i+1 || attempt.Err != nil || attempt.Wait != 0 || attempt.Headers == nil {
+			t.Errorf("unexpected attempt %+v", attempt)
+		}
+	}
+
+	if attempts[0].StatusCode != http.StatusTooManyRequests || attempts[1].StatusCode != http.StatusServiceUnavailable ||
+		attempts[2].StatusCode != http.StatusOK {
+		t.Errorf("unexpected status codes %d, %d and %d", attempts[0].StatusCode, attempts[1].StatusCode,
+			attempts[2].StatusCode)
+	}
+}
+
+func TestBackoffLimits(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		if r.URL.Path == "/later" {
+			w.Header().Set("Retry-After", "60")
+		}
+
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		RetryPolicy(surf.NewBackoff(2).Base(time.Millisecond).Cap(2 * time.Millisecond)).
+		Build().Unwrap()
+
+	resp := client.Get(url).Do()
+	if resp.IsErr() || resp.Ok().StatusCode != http.StatusBadGateway || resp.Ok().Attempts.Len() != 3 {
+		t.Fatalf("expected the last response after 2 retries, got %v", resp)
+	}
+
+	for _, attempt := range resp.Ok().Attempts {
+		if attempt.Wait > 2*time.Millisecond {
+			t.Errorf("expected waits capped at 2ms, got %s", attempt.Wait)
+		}
+	}
+
+	// POST requests are only retried with an Idempotency-Key or NonIdempotent.
+	requests.Store(0)
+
+	if resp := client.Post(url).Body("data").Do(); resp.IsErr() || requests.Load() != 1 {
+		t.Errorf("expected a POST request not to be retried, sent %d times", requests.Load())
+	}
+
+	requests.Store(0)
+
+	if resp := client.Post(url).SetHeaders("Idempotency-Key", "1").Body("data").Do(); resp.IsErr() ||
+		requests.Load() != 3 {
+		t.Errorf("expected an idempotent POST request to be retried, sent %d times", requests.Load())
+	}
+
+	// Retries stop when the next attempt would exceed the maximum elapsed time.
+	client = surf.NewClient().Builder().
+		RetryPolicy(surf.NewBackoff(5).MaxElapsed(time.Second)).
+		Build().Unwrap()
+
+	requests.Store(0)
+
+	if resp := client.Get(url + "/later").Do(); resp.IsErr() || resp.Ok().Attempts.Len() != 1 ||
+		requests.Load() != 1 {
+		t.Errorf("expected a Retry-After beyond MaxElapsed not to be retried, sent %d times", requests.Load())
+	}
+}
+
+func TestBackoffNetworkErrors(t *testing.T) {
+	t.Parallel()
+
+	// A listener that resets the first connection and serves the next ones.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conns atomic.Int32
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("recovered"))
+	}))
+	ts.Listener = &resettingListener{Listener: ln, conns: &conns}
+	ts.Start()
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().
+		DisableKeepAlive().
+		RetryPolicy(surf.NewBackoff(2).Base(time.Millisecond)).
+		Build().Unwrap()
+
+	resp := client.Get(g.String(ts.URL)).Do()
+	expectBody(t, resp, "recovered")
+
+	attempt := resp.Ok().Attempts[0]
+	if attempt.Err == nil || attempt.StatusCode != 0 || attempt.Headers != nil {
+		t.Errorf("expected a failed first attempt, got %+v", attempt)
+	}
+
+	// The attempts of a failed request are reachable from its error.
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Close()
+
+	var errAttempts *surf.ErrAttempts
+
+	resp = client.Get(g.String("http://" + ln.Addr().String())).Do()
+	if !errors.As(resp.Err(), &errAttempts) || errAttempts.Attempts.Len() != 3 ||
+		!errors.Is(resp.Err(), syscall.ECONNREFUSED) {
+		t.Errorf("expected 3 refused attempts, got %v", resp.Err())
+	}
+
+	// Canceled requests are not retried.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if resp := client.Get(g.String(ts.URL)).WithContext(ctx).Do(); !errors.Is(resp.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", resp.Err())
+	}
+}
+
+// resettingListener closes the first connection it accepts without a response.
+type resettingListener struct {
+	net.Listener
+	conns *atomic.Int32
+}
+
+func (l *resettingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || l.conns.Add(1) > 1 {
+			return conn, err
+		}
+
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+
+		io.CopyN(io.Discard, conn, 1)
+		conn.Close()
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{io.ErrUnexpectedEOF, true},
+		{http2.GoAwayError{ErrCode: http2.ErrCodeNo}, true},
+		{http2.StreamError{Code: http2.ErrCodeRefusedStream}, true},
+		{http2.StreamError{Code: http2.ErrCodeProtocol}, false},
+		{errors.New("http2: server sent GOAWAY and closed the connection"), false},
+		{&net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&connectproxy.ErrProxyTimeout{Msg: "proxy"}, true},
+		{&connectproxy.ErrProxyHop{Hop: 1, Err: &connectproxy.ErrProxyStatus{Msg: "502 Bad Gateway"}}, true},
+		{&connectproxy.ErrProxyStatus{Msg: "403 Forbidden"}, false},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{errors.New("tls: bad certificate"), false},
+		{nil, false},
+	}
+
+	for _, tc := range testCases {
+		if surf.IsRetryableError(tc.err) != tc.retryable {
+			t.Errorf("%v: expected retryable %t", tc.err, tc.retryable)
+		}
+	}
+}
+
+func TestRetryPolicyCustom(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	policy := retryFunc(func(_ *surf.Request, attempt surf.Attempt) (time.Duration, bool) {
+		return time.Millisecond, attempt.StatusCode == http.StatusConflict
+	})
+
+	client := surf.NewClient().Builder().RetryPolicy(policy).Build().Unwrap()
+
+	resp := client.Get(g.String(ts.URL)).Do()
+	expectBody(t, resp, "ok")
+
+	if attempts := resp.Ok().Attempts; attempts.Len() != 2 || attempts[0].Wait != time.Millisecond {
+		t.Errorf("unexpected attempts %+v", attempts)
+	}
+}
+
+type retryFunc func(*surf.Request, surf.Attempt) (time.Duration, bool)
+
+func (f retryFunc) Retry(req *surf.Request, attempt surf.Attempt) (time.Duration, bool) {
+	return f(req, attempt)
+}
+
+func TestBackoffProxyStatus(t *testing.T) {
+	t.Parallel()
+
+	url := sessionServer(t)
+
+	var connects atomic.Int32
+
+	// The proxy fails to reach the target once, then tunnels.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if connects.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go io.Copy(upstream, rw)
+		io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	client := surf.NewClient().Builder().
+		Proxy(g.String(proxy.URL)).
+		RetryPolicy(surf.NewBackoff(1).Base(time.Millisecond)).
+		Build().Unwrap()
+
+	resp := client.Get(url).Do()
+	expectBody(t, resp, "no cookie")
+
+	var proxyStatus *connectproxy.ErrProxyStatus
+	if attempts := resp.Ok().Attempts; attempts.Len() != 2 || !errors.As(attempts[0].Err, &proxyStatus) ||
+		proxyStatus.StatusCode() != http.StatusBadGateway {
+		t.Errorf("expected a retried 502 from the proxy, got %+v", attempts)
+	}
+}
@@ -0,0 +1,251 @@
+package surf_test
+
+import (
+	"encoding/binary"
+	"net"
+	_http "net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// httpsServer starts a UDP DNS server answering HTTPS queries for host with a ServiceMode
+// record with params and every other query with an empty answer. HTTPS queries are counted.
+func httpsServer(t *testing.T, host string, queries *atomic.Int32, params ...dnsmessage.SVCParam) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var p dnsmessage.Parser
+
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+
+			if q.Type == dnsmessage.TypeHTTPS && strings.EqualFold(q.Name.String(), host+".") {
+				queries.Add(1)
+
+				if len(params) != 0 {
+					b.HTTPSResource(
+						dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300},
+						dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{
+							Priority: 1,
+							Target:   dnsmessage.MustNewName("."),
+							Params:   params,
+						}},
+					)
+				}
+			}
+
+			if answer, err := b.Finish(); err == nil {
+				pc.WriteTo(answer, addr)
+			}
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+func portParam(port int) dnsmessage.SVCParam {
+	return dnsmessage.SVCParam{Key: dnsmessage.SVCParamPort, Value: binary.BigEndian.AppendUint16(nil, uint16(port))}
+}
+
+func TestHTTPSRecordsHTTP3(t *testing.T) {
+	t.Parallel()
+
+	server, conn, _, err := createHTTP3TestServer(func(w _http.ResponseWriter, _ *_http.Request) {
+		w.WriteHeader(_http.StatusOK)
+	})
+	if err != nil {
+		t.Skip("Failed to create HTTP/3 test server:", err)
+	}
+
+	go server.Serve(conn)
+
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	var queries atomic.Int32
+
+	dns := httpsServer(t, "h3.test", &queries,
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamALPN, Value: []byte("\x02h3\x02h2")},
+		portParam(conn.LocalAddr().(*net.UDPAddr).Port),
+	)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().
+		HTTPSRecords().
+		DNS(g.String(dns)).
+		Resolver().Resolve("h3.test:*:127.0.0.1").Set().
+		Build().Unwrap()
+
+	// Nothing listens on TCP port 443, the first request has to go over HTTP/3.
+	expectProto(t, client, "https://h3.test/", "HTTP/3.0")
+	expectProto(t, client, "https://h3.test/", "HTTP/3.0")
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected 1 HTTPS record query, got %d", queries.Load())
+	}
+
+	if client.GetAltSvc().Len() != 1 {
+		t.Fatal("expected the h3 endpoint to be recorded as an alternative")
+	}
+}
+
+func TestHTTPSRecordsWithoutH3(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	var queries atomic.Int32
+
+	dns := httpsServer(t, "_"+port+"._https.h2.test", &queries,
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamALPN, Value: []byte("\x02h2")},
+	)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().
+		HTTPSRecords().
+		DNS(g.String(dns)).
+		Resolver().Resolve("h2.test:*:127.0.0.1").Set().
+		Build().Unwrap()
+
+	resp := client.Get(g.String("https://h2.test:" + port + "/")).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected a query for the port-prefixed name, got %d", queries.Load())
+	}
+
+	if client.GetAltSvc().Len() != 0 {
+		t.Fatal("expected no HTTP/3 alternative without h3 in the record")
+	}
+}
+
+func TestHTTPSRecordsHints(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("hinted"))
+	}))
+	t.Cleanup(ts.Close)
+
+	port, _ := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+
+	var queries atomic.Int32
+
+	dns := httpsServer(t, "hint.test", &queries,
+		portParam(port),
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamIPv4Hint, Value: []byte{127, 0, 0, 1}},
+	)
+
+	client := surf.NewClient().Builder().
+		HTTPSRecords().
+		DNS(g.String(dns)).
+		Build().Unwrap()
+
+	resp := client.Get("https://hint.test/").Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if body := resp.Ok().Body.String().Unwrap(); body != "hinted" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	client = surf.NewClient().Builder().DNS(g.String(dns)).Build().Unwrap()
+
+	if client.Get("https://hint.test/").Do().IsOk() {
+		t.Fatal("expected hints to be ignored without HTTPSRecords")
+	}
+}
+
+func TestHTTPSRecordsProxied(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	var queries atomic.Int32
+
+	dns := httpsServer(t, "_"+port+"._https.localhost", &queries,
+		dnsmessage.SVCParam{Key: dnsmessage.SVCParamALPN, Value: []byte("\x02h2")},
+	)
+
+	proxy, tunnels := countingProxy(t)
+
+	client := surf.NewClient().Builder().
+		Impersonate().Chrome().
+		HTTP3().
+		HTTPSRecords().
+		ECH().
+		DNS(g.String(dns)).
+		Proxy(proxy).
+		Build().Unwrap()
+
+	url := g.String("https://localhost:" + port + "/")
+
+	// The HTTPS record is not looked up locally for requests sent through the proxy.
+	if resp := client.Get(url).Do(); resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if queries.Load() != 0 || tunnels.Load() != 1 {
+		t.Fatalf("expected no HTTPS record query through the proxy, got %d", queries.Load())
+	}
+
+	if resp := client.Get(url).Proxy(surf.ProxyDirect).Do(); resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if queries.Load() != 1 {
+		t.Fatalf("expected an HTTPS record query without the proxy, got %d", queries.Load())
+	}
+}
@@ -0,0 +1,177 @@
+package surf_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/surf"
+)
+
+func TestThrottleRateLimitHeaders(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		remaining := 3 - len(times)
+		mu.Unlock()
+
+		w.Header().Set("RateLimit-Limit", "3")
+		w.Header().Set("RateLimit-Remaining", fmt.Sprint(max(remaining, 0)))
+		w.Header().Set("RateLimit-Reset", "1")
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+	host := url.StripPrefix("http://")
+
+	client := surf.NewClient().Builder().Throttle().Set().Build().Unwrap()
+
+	expectBody(t, client.Get(url).Do(), "ok")
+
+	stats := client.Throttles()
+	if stats.Len() != 1 || stats[0].Host != host || stats[0].Limit != 3 || stats[0].Remaining != 2 ||
+		stats[0].Reset.IsZero() {
+		t.Fatalf("unexpected throttle stats %+v", stats)
+	}
+
+	// The last requests of the quota are spread over the window, the next one waits for the reset.
+	for range 3 {
+		expectBody(t, client.Get(url).Do(), "ok")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if gap := times[3].Sub(times[2]); gap < 500*time.Millisecond {
+		t.Errorf("expected the request after an exhausted quota to wait for the reset, waited %s", gap)
+	}
+}
+
+func TestThrottleAIMD(t *testing.T) {
+	t.Parallel()
+
+	var throttled atomic.Bool
+
+	throttled.Store(true)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if throttled.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().
+		Throttle().
+		MaxConcurrency(8).
+		Set().
+		Build().Unwrap()
+
+	concurrency := func() int {
+		stats := client.Throttles()
+		if stats.Len() != 1 {
+			t.Fatalf("expected the state of one host, got %+v", stats)
+		}
+
+		return stats[0].Concurrency
+	}
+
+	// Sequential throttled responses each halve the concurrency.
+	for range 2 {
+		client.Get(url).Do()
+	}
+
+	if c := concurrency(); c != 2 {
+		t.Fatalf("expected a concurrency of 2, got %d", c)
+	}
+
+	// A burst of throttled responses to requests sent together halves it once.
+	var wg sync.WaitGroup
+
+	for range 2 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			client.Get(url).Do()
+		}()
+	}
+
+	wg.Wait()
+
+	if c := concurrency(); c != 1 {
+		t.Fatalf("expected a concurrency of 1, got %d", c)
+	}
+
+	// Successful responses recover it gradually.
+	throttled.Store(false)
+
+	for range 4 {
+		expectBody(t, client.Get(url).Do(), "ok")
+	}
+
+	if c := concurrency(); c < 2 || c > 4 {
+		t.Errorf("expected a gradual recovery, got a concurrency of %d", c)
+	}
+}
+
+func TestThrottleRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	url := g.String(ts.URL)
+
+	client := surf.NewClient().Builder().Throttle().Set().Build().Unwrap()
+
+	client.Get(url).Do()
+
+	if stats := client.Throttles(); stats.Len() != 1 || stats[0].Blocked.IsZero() {
+		t.Fatalf("expected the host to be blocked, got %+v", stats)
+	}
+
+	start := time.Now()
+
+	expectBody(t, client.Get(url).Do(), "ok")
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the request to wait for Retry-After, waited %s", elapsed)
+	}
+
+	if stats := client.Throttles(); stats[0].Remaining != 10 || stats[0].Limit != -1 ||
+		time.Until(stats[0].Reset) < 50*time.Second {
+		t.Errorf("unexpected X-RateLimit state %+v", stats[0])
+	}
+}
@@ -0,0 +1,593 @@
+package surf_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	_http "net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/http/httptest"
+	"github.com/enetx/http2"
+	"github.com/enetx/surf"
+	"github.com/enetx/surf/pkg/websocket"
+	"golang.org/x/net/http2/hpack"
+)
+
+// websocketEcho returns a handler that completes the opening handshake and echoes messages.
+// The handshake request is passed to inspect before the upgrade.
+func websocketEcho(t *testing.T, inspect func(r *http.Request, h http.Header)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+
+		h.Set("Upgrade", "websocket")
+		h.Set("Connection", "Upgrade")
+		h.Set("Sec-WebSocket-Accept", websocket.AcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+
+		var opts websocket.Options
+
+		if strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+			opts.Deflate = &websocket.Deflate{}
+			h.Set("Sec-WebSocket-Extensions", opts.Deflate.String())
+		}
+
+		if inspect != nil {
+			inspect(r, h)
+		}
+
+		w.WriteHeader(http.StatusSwitchingProtocols)
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		opts.Server = true
+		opts.Reader = brw.Reader
+		opts.Subprotocol = h.Get("Sec-WebSocket-Protocol")
+
+		ws := websocket.NewConn(conn, opts)
+		defer ws.Close()
+
+		for {
+			mt, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := ws.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func echoMessage(t *testing.T, conn *websocket.Conn, text string) {
+	t.Helper()
+
+	if err := conn.WriteText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	mt, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mt != websocket.TextMessage || string(data) != text {
+		t.Fatalf("unexpected echo: type %d, %q", mt, data)
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(websocketEcho(t, func(r *http.Request, _ http.Header) {
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("unexpected version %q", r.Header.Get("Sec-WebSocket-Version"))
+		}
+
+		if r.Header.Get("Origin") == "" {
+			t.Error("expected Origin header")
+		}
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient()
+
+	conn := client.WebSocket(g.String(strings.Replace(ts.URL, "http://", "ws://", 1)))
+	if conn.IsErr() {
+		t.Fatal(conn.Err())
+	}
+
+	ws := conn.Ok()
+	defer ws.Close()
+
+	if !ws.Compressed() {
+		t.Error("expected permessage-deflate to be negotiated")
+	}
+
+	echoMessage(t, ws, "hello")
+	echoMessage(t, ws, strings.Repeat("surf ", 500))
+}
+
+func TestWebSocketSubprotocol(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(websocketEcho(t, func(r *http.Request, h http.Header) {
+		if r.Header.Get("Sec-WebSocket-Protocol") != "chat, superchat" {
+			t.Errorf("unexpected subprotocol offer %q", r.Header.Get("Sec-WebSocket-Protocol"))
+		}
+
+		h.Set("Sec-WebSocket-Protocol", "superchat")
+	}))
+	defer ts.Close()
+
+	conn := surf.NewClient().
+		Get(g.String(ts.URL)).
+		SetHeaders("Sec-WebSocket-Protocol", "chat, superchat").
+		Upgrade()
+
+	if conn.IsErr() {
+		t.Fatal(conn.Err())
+	}
+
+	ws := conn.Ok()
+	defer ws.Close()
+
+	if ws.Subprotocol() != "superchat" {
+		t.Errorf("expected superchat subprotocol, got %q", ws.Subprotocol())
+	}
+
+	echoMessage(t, ws, "hello")
+}
+
+func TestWebSocketImpersonate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		browser    func(*surf.Impersonate) *surf.Builder
+		fetchMode  string
+		extensions string
+	}{
+		{"chrome", (*surf.Impersonate).Chrome, "", websocket.ChromeExtensions},
+		{"firefox", (*surf.Impersonate).Firefox, "websocket", websocket.FirefoxExtensions},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewTLSServer(websocketEcho(t, func(r *http.Request, _ http.Header) {
+				if r.ProtoMajor != 1 {
+					t.Errorf("expected HTTP/1.1 handshake, got %s", r.Proto)
+				}
+
+				if r.Header.Get("Sec-Fetch-Mode") != tc.fetchMode || r.Header.Get("Sec-Ch-Ua") != "" {
+					t.Error("unexpected navigation headers on WebSocket handshake")
+				}
+
+				if r.Header.Get("Pragma") != "no-cache" {
+					t.Errorf("expected Pragma: no-cache, got %q", r.Header.Get("Pragma"))
+				}
+
+				if got := r.Header.Get("Sec-WebSocket-Extensions"); got != tc.extensions {
+					t.Errorf("expected the extensions %q, got %q", tc.extensions, got)
+				}
+			}))
+			defer ts.Close()
+
+			client := tc.browser(surf.NewClient().Builder().Impersonate()).Build().Unwrap()
+
+			conn := client.WebSocket(g.String(strings.Replace(ts.URL, "https://", "wss://", 1)))
+			if conn.IsErr() {
+				t.Fatal(conn.Err())
+			}
+
+			ws := conn.Ok()
+			defer ws.Close()
+
+			echoMessage(t, ws, "impersonated")
+		})
+	}
+}
+
+func TestWebSocketGates(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	client := surf.NewClient().Builder().
+		CircuitBreaker().Threshold(1).CoolDown(time.Minute).Set().
+		RateLimit().MaxInFlight(1).Set().
+		Build().Unwrap()
+
+	if conn := client.WebSocket(g.String(down.URL)); conn.IsOk() {
+		t.Fatal("expected a handshake error")
+	}
+
+	// The failed handshake opened the circuit of the host.
+	var errCircuit *surf.ErrCircuitOpen
+	if conn := client.WebSocket(g.String(down.URL)); !errors.As(conn.Err(), &errCircuit) || requests.Load() != 1 {
+		t.Fatalf("expected ErrCircuitOpen without a request, got %v", conn.Err())
+	}
+
+	ts := httptest.NewServer(websocketEcho(t, nil))
+	defer ts.Close()
+
+	conn := client.WebSocket(g.String(ts.URL))
+	if conn.IsErr() {
+		t.Fatal(conn.Err())
+	}
+
+	ws := conn.Ok()
+	defer ws.Close()
+
+	// The open connection does not hold the in-flight slot of the host.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if conn := client.Get(g.String(ts.URL)).WithContext(ctx).Upgrade(); conn.IsErr() {
+		t.Fatalf("expected a second connection, got %v", conn.Err())
+	} else {
+		conn.Ok().Close()
+	}
+
+	echoMessage(t, ws, "gated")
+}
+
+func TestWebSocketRequestMiddlewareContext(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(websocketEcho(t, nil))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The context set by the middleware is the one the handshake is sent with.
+	client := surf.NewClient().Builder().WithContext(ctx).Build().Unwrap()
+
+	if conn := client.WebSocket(g.String(ts.URL)); !errors.Is(conn.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", conn.Err())
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	client := surf.NewClient()
+
+	var herr *surf.ErrWebSocketHandshake
+
+	if err := client.WebSocket(g.String(ts.URL)).Err(); !errors.As(err, &herr) {
+		t.Fatalf("expected ErrWebSocketHandshake for 403 response, got %v", err)
+	}
+
+	if err := client.Post(g.String(ts.URL)).Body("data").Upgrade().Err(); !errors.As(err, &herr) {
+		t.Fatalf("expected ErrWebSocketHandshake for POST, got %v", err)
+	}
+
+	if err := client.WebSocket("ftp://example.com").Err(); !errors.As(err, &herr) {
+		t.Fatalf("expected ErrWebSocketHandshake for unsupported scheme, got %v", err)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", "invalid")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer bad.Close()
+
+	if err := client.WebSocket(g.String(bad.URL)).Err(); !errors.As(err, &herr) {
+		t.Fatalf("expected ErrWebSocketHandshake for invalid accept key, got %v", err)
+	}
+}
+
+// serveExtendedConnectHTTP2 is a minimal HTTP/2 server that enables extended CONNECT (RFC 8441).
+// Regular requests get an empty 200 response, accepted streams for protocol are passed to handle.
+func serveExtendedConnectHTTP2(
+	conn *tls.Conn,
+	protocol string,
+	handle func(f *http2.MetaHeadersFrame, stream io.ReadWriteCloser),
+) {
+	defer conn.Close()
+
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil {
+		return
+	}
+
+	var (
+		mu     sync.Mutex
+		fr     = http2.NewFramer(conn, conn)
+		enc    bytes.Buffer
+		henc   = hpack.NewEncoder(&enc)
+		stream = make(map[uint32]*io.PipeWriter)
+	)
+
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	writeHeaders := func(id uint32, endStream bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		enc.Reset()
+		henc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+
+		return fr.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      id,
+			BlockFragment: enc.Bytes(),
+			EndStream:     endStream,
+			EndHeaders:    true,
+		})
+	}
+
+	mu.Lock()
+	fr.WriteSettings(http2.Setting{ID: http2.SettingEnableConnectProtocol, Val: 1})
+	mu.Unlock()
+
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return
+		}
+
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				mu.Lock()
+				fr.WriteSettingsAck()
+				mu.Unlock()
+			}
+		case *http2.MetaHeadersFrame:
+			id := f.StreamID
+
+			if f.PseudoValue("method") != http.MethodConnect || f.PseudoValue("protocol") != protocol {
+				writeHeaders(id, true)
+				continue
+			}
+
+			if writeHeaders(id, false) != nil {
+				return
+			}
+
+			pr, pw := io.Pipe()
+			stream[id] = pw
+
+			out := writerFunc(func(p []byte) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				for chunk := range slices.Chunk(p, 16384) {
+					if err := fr.WriteData(id, false, chunk); err != nil {
+						return 0, err
+					}
+				}
+
+				return len(p), nil
+			})
+
+			go handle(f, struct {
+				io.Reader
+				io.Writer
+				io.Closer
+			}{pr, out, pr})
+		case *http2.DataFrame:
+			if pw, ok := stream[f.StreamID]; ok && len(f.Data()) > 0 {
+				pw.Write(f.Data())
+
+				mu.Lock()
+				fr.WriteWindowUpdate(0, uint32(len(f.Data())))
+				fr.WriteWindowUpdate(f.StreamID, uint32(len(f.Data())))
+				mu.Unlock()
+			}
+		}
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestWebSocketHTTP2ExtendedConnect(t *testing.T) {
+	t.Parallel()
+
+	var connects atomic.Int32
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("unexpected HTTP/1.1 request")
+	}))
+
+	ts.EnableHTTP2 = true
+	ts.Config.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+		"h2": func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+			serveExtendedConnectHTTP2(conn, "websocket", func(f *http2.MetaHeadersFrame, stream io.ReadWriteCloser) {
+				connects.Add(1)
+
+				if f.PseudoValue("path") == "" || f.PseudoValue("scheme") != "https" {
+					t.Error("extended CONNECT must carry :path and :scheme")
+				}
+
+				ws := websocket.NewConn(stream, websocket.Options{Server: true})
+
+				for {
+					mt, data, err := ws.ReadMessage()
+					if err != nil {
+						return
+					}
+
+					if ws.WriteMessage(mt, data) != nil {
+						return
+					}
+				}
+			})
+		},
+	}
+
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().Build().Unwrap()
+
+	// The WebSocket reuses the HTTP/2 connection opened by this request.
+	if resp := client.Get(g.String(ts.URL)).Do(); resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	conn := client.WebSocket(g.String(strings.Replace(ts.URL, "https://", "wss://", 1)))
+	if conn.IsErr() {
+		t.Fatal(conn.Err())
+	}
+
+	ws := conn.Ok()
+	defer ws.Close()
+
+	echoMessage(t, ws, "over h2")
+	echoMessage(t, ws, strings.Repeat("surf ", 20000))
+
+	if connects.Load() != 1 {
+		t.Fatalf("expected one extended CONNECT stream, got %d", connects.Load())
+	}
+}
+
+func TestWebSocketHTTP2Fallback(t *testing.T) {
+	t.Parallel()
+
+	var upgrades atomic.Int32
+
+	echo := websocketEcho(t, nil)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			return
+		}
+
+		if r.ProtoMajor != 1 {
+			t.Errorf("expected HTTP/1.1 handshake, got %s", r.Proto)
+		}
+
+		upgrades.Add(1)
+		echo(w, r)
+	}))
+
+	// The server does not enable extended CONNECT, so the handshake falls back to HTTP/1.1.
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().Build().Unwrap()
+
+	resp := client.Get(g.String(ts.URL)).Do()
+	if resp.IsErr() {
+		t.Fatal(resp.Err())
+	}
+
+	if resp.Ok().Proto != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2 connection, got %s", resp.Ok().Proto)
+	}
+
+	conn := client.WebSocket(g.String(strings.Replace(ts.URL, "https://", "wss://", 1)))
+	if conn.IsErr() {
+		t.Fatal(conn.Err())
+	}
+
+	ws := conn.Ok()
+	defer ws.Close()
+
+	echoMessage(t, ws, "over h1")
+
+	if upgrades.Load() != 1 {
+		t.Fatalf("expected one HTTP/1.1 upgrade, got %d", upgrades.Load())
+	}
+}
+
+func TestWebSocketHTTP3ExtendedConnect(t *testing.T) {
+	t.Parallel()
+
+	var connects atomic.Int32
+
+	handler := _http.HandlerFunc(func(w _http.ResponseWriter, r *_http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(_http.StatusOK)
+			return
+		}
+
+		if r.Proto != "websocket" {
+			w.WriteHeader(_http.StatusBadRequest)
+			return
+		}
+
+		connects.Add(1)
+
+		w.WriteHeader(_http.StatusOK)
+		w.(_http.Flusher).Flush()
+
+		out := writerFunc(func(p []byte) (int, error) {
+			n, err := w.Write(p)
+			w.(_http.Flusher).Flush()
+			return n, err
+		})
+
+		ws := websocket.NewConn(struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{r.Body, out, r.Body}, websocket.Options{Server: true})
+
+		for {
+			mt, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if ws.WriteMessage(mt, data) != nil {
+				return
+			}
+		}
+	})
+
+	addr := altSvcServer(t, handler)
+
+	client := surf.NewClient().Builder().Impersonate().Chrome().HTTP3().Build().Unwrap()
+
+	// The first request discovers the HTTP/3 alternative, the WebSocket reuses
+	// the HTTP/3 connection opened by the second one.
+	expectProto(t, client, addr, "HTTP/2.0")
+	expectProto(t, client, addr, "HTTP/3.0")
+
+	ws := client.WebSocket(g.String(strings.Replace(addr, "https://", "wss://", 1)))
+	if ws.IsErr() {
+		t.Fatal(ws.Err())
+	}
+
+	defer ws.Ok().Close()
+
+	echoMessage(t, ws.Ok(), "over h3")
+
+	if connects.Load() != 1 {
+		t.Fatalf("expected one extended CONNECT stream, got %d", connects.Load())
+	}
+}
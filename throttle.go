@@ -0,0 +1,340 @@
+package surf
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+)
+
+// ThrottleStats reports the adaptive throttling state of a host.
+type ThrottleStats struct {
+	Host        g.String  // Host and port of the requests
+	Concurrency int       // Requests allowed in flight at once
+	InFlight    int       // Requests waiting for their response
+	Waiting     int       // Requests waiting to be sent
+	Limit       int       // Quota announced by the host, -1 when unknown
+	Remaining   int       // Requests left in the quota, -1 when unknown
+	Reset       time.Time // End of the quota window, zero when unknown
+	Blocked     time.Time // Requests are held until then after a Retry-After
+}
+
+// ThrottleSettings provides a fluent interface for throttling the requests of the client from
+// the rate limit feedback of the hosts.
+type ThrottleSettings struct {
+	builder  *Builder
+	throttle *throttle
+}
+
+// MaxConcurrency sets the requests in flight to a host at most, and at first, 32 by default.
+func (ts *ThrottleSettings) MaxConcurrency(requests int) *ThrottleSettings {
+	ts.throttle.maxWindow = float64(max(requests, 1))
+	return ts
+}
+
+// Headroom sets the fraction of the quota of a host below which requests are spread evenly over
+// the rest of the quota window, 0.2 by default.
+func (ts *ThrottleSettings) Headroom(fraction float64) *ThrottleSettings {
+	ts.throttle.headroom = fraction
+	return ts
+}
+
+// Decrease sets the factor the concurrency of a host is multiplied by after a throttled response,
+// 0.5 by default.
+func (ts *ThrottleSettings) Decrease(factor float64) *ThrottleSettings {
+	ts.throttle.decrease = factor
+	return ts
+}
+
+// StatusCodes sets the status codes of the throttled responses, 429 and 503 by default.
+func (ts *ThrottleSettings) StatusCodes(codes ...int) *ThrottleSettings {
+	ts.throttle.codes = g.SliceOf(codes...)
+	return ts
+}
+
+// Set applies the throttle settings to the client.
+func (ts *ThrottleSettings) Set() *Builder {
+	ts.builder.throttle = ts.throttle
+	return ts.builder
+}
+
+// throttle schedules the requests of a client per host, from the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, their X-RateLimit- variants and Retry-After,
+// with an AIMD limit of the requests in flight.
+type throttle struct {
+	mu        sync.Mutex
+	hosts     map[g.String]*throttledHost
+	maxWindow float64
+	headroom  float64
+	decrease  float64
+	codes     g.Slice[int]
+}
+
+// throttledHost is the throttling state of a host.
+type throttledHost struct {
+	window    float64 // Requests allowed in flight, raised by 1/window per success
+	inFlight  int
+	waiting   int
+	limit     int       // Announced quota, -1 when unknown
+	remaining int       // Requests left in the quota, -1 when unknown
+	reset     time.Time // End of the quota window
+	blocked   time.Time // End of a Retry-After delay
+	last      time.Time // Last request sent
+	decreased time.Time // Last decrease of the window
+	changed   chan g.Unit
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		hosts:     make(map[g.String]*throttledHost),
+		maxWindow: _throttleMaxConcurrency,
+		headroom:  _throttleHeadroom,
+		decrease:  _throttleDecrease,
+		codes:     g.SliceOf(http.StatusTooManyRequests, http.StatusServiceUnavailable),
+	}
+}
+
+// host returns the state of host. Called with t.mu held.
+func (t *throttle) host(host g.String) *throttledHost {
+	h := t.hosts[host]
+	if h == nil {
+		if len(t.hosts) >= _throttleMaxHosts {
+			t.prune()
+		}
+
+		h = &throttledHost{window: t.maxWindow, limit: -1, remaining: -1, changed: make(chan g.Unit)}
+		t.hosts[host] = h
+	}
+
+	return h
+}
+
+// prune removes the idle hosts without throttling state. Called with t.mu held.
+func (t *throttle) prune() {
+	now := time.Now()
+
+	for host, h := range t.hosts {
+		if h.inFlight == 0 && h.waiting == 0 && h.window >= t.maxWindow && h.delay(now, t.headroom) <= 0 {
+			delete(t.hosts, host)
+		}
+	}
+}
+
+// delay returns how long the next request to the host is held, by a Retry-After delay, an
+// exhausted quota or the pacing of the rest of the quota.
+func (h *throttledHost) delay(now time.Time, headroom float64) time.Duration {
+	until := h.blocked
+
+	if h.remaining >= 0 && now.Before(h.reset) {
+		switch {
+		case h.remaining == 0:
+			until = later(until, h.reset)
+		case h.limit <= 0 || float64(h.remaining) <= float64(h.limit)*headroom:
+			until = later(until, h.last.Add(h.reset.Sub(h.last)/time.Duration(h.remaining+1)))
+		}
+	}
+
+	return until.Sub(now)
+}
+
+// later returns the later of two times.
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
+
+// notify wakes the requests waiting for the host.
+func (h *throttledHost) notify() {
+	close(h.changed)
+	h.changed = make(chan g.Unit)
+}
+
+// wait waits until req may be sent to its host, or until its context is done. The request is in
+// flight until observe is called with its response or error.
+func (t *throttle) wait(req *Request) error {
+	ctx := req.request.Context()
+	key := g.String(req.request.URL.Host)
+
+	for {
+		t.mu.Lock()
+
+		h := t.host(key)
+		now := time.Now()
+		delay := h.delay(now, t.headroom)
+
+		if delay <= 0 && h.inFlight < max(int(h.window), 1) {
+			h.inFlight++
+			h.last = now
+
+			if h.remaining > 0 {
+				h.remaining--
+			}
+
+			t.mu.Unlock()
+
+			return nil
+		}
+
+		changed := h.changed
+		h.waiting++
+
+		t.mu.Unlock()
+
+		if err := sleep(ctx, delay, changed); err != nil {
+			t.mu.Lock()
+			h.waiting--
+			t.mu.Unlock()
+
+			return err
+		}
+
+		t.mu.Lock()
+		h.waiting--
+		t.mu.Unlock()
+	}
+}
+
+// sleep waits for delay when it is positive, a change of state or the end of ctx.
+func sleep(ctx context.Context, delay time.Duration, changed <-chan g.Unit) error {
+	var timeout <-chan time.Time
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case <-timeout:
+	case <-changed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// observe ends the flight of req, sent at sent, and updates the state of its host from the
+// response: its rate limit headers, and the window, decreased after a throttled response and
+// increased after another response.
+func (t *throttle) observe(req *Request, sent time.Time, resp *http.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.host(g.String(req.request.URL.Host))
+	h.inFlight = max(h.inFlight-1, 0)
+
+	defer h.notify()
+
+	if resp == nil {
+		return
+	}
+
+	now := time.Now()
+	headers := Headers(resp.Header)
+
+	if limit, remaining, reset, ok := rateLimitHeaders(headers, now); ok {
+		h.limit, h.remaining, h.reset = limit, remaining, reset
+	}
+
+	if t.codes.Contains(resp.StatusCode) {
+		if delay, ok := retryAfter(headers, now); ok {
+			h.blocked = later(h.blocked, now.Add(delay))
+		}
+
+		// Responses to requests sent before the last decrease belong to the same burst.
+		if sent.After(h.decreased) {
+			h.window = max(h.window*t.decrease, 1)
+			h.decreased = now
+		}
+	} else if h.window < t.maxWindow {
+		h.window = min(h.window+1/h.window, t.maxWindow)
+	}
+}
+
+// rateLimitHeaders returns the quota, the requests left and the end of the window announced by
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, or their X-RateLimit-
+// variants. Resets are delays in seconds, or Unix times in the X-RateLimit- variants.
+func rateLimitHeaders(headers Headers, now time.Time) (int, int, time.Time, bool) {
+	for _, prefix := range []g.String{"RateLimit-", "X-RateLimit-"} {
+		remaining := leadingInt(headers.Get(prefix + "Remaining"))
+		reset := leadingInt(headers.Get(prefix + "Reset"))
+
+		if remaining < 0 || reset < 0 {
+			continue
+		}
+
+		end := now.Add(time.Duration(reset) * time.Second)
+		if reset > 1e9 {
+			end = time.Unix(int64(reset), 0)
+		}
+
+		return leadingInt(headers.Get(prefix + "Limit")), remaining, end, true
+	}
+
+	return 0, 0, time.Time{}, false
+}
+
+// leadingInt returns the integer a header value starts with, such as 100 in "100, 100;w=60",
+// or -1.
+func leadingInt(value g.String) int {
+	s := value.Std()
+	if i := strings.IndexAny(s, ",;"); i >= 0 {
+		s = s[:i]
+	}
+
+	n := g.String(s).Trim().TryInt()
+	if n.IsErr() || n.Ok() < 0 {
+		return -1
+	}
+
+	return int(n.Ok())
+}
+
+// stats returns the throttling state of the hosts.
+func (t *throttle) stats() g.Slice[ThrottleStats] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	stats := make(g.Slice[ThrottleStats], 0, len(t.hosts))
+
+	for host, h := range t.hosts {
+		s := ThrottleStats{
+			Host:        host,
+			Concurrency: max(int(h.window), 1),
+			InFlight:    h.inFlight,
+			Waiting:     h.waiting,
+			Limit:       -1,
+			Remaining:   -1,
+		}
+
+		if h.remaining >= 0 && now.Before(h.reset) {
+			s.Limit, s.Remaining, s.Reset = h.limit, h.remaining, h.reset
+		}
+
+		if now.Before(h.blocked) {
+			s.Blocked = h.blocked
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// Throttles returns the adaptive throttling state of the hosts requested by the client.
+func (c *Client) Throttles() g.Slice[ThrottleStats] {
+	if c.builder == nil || c.builder.throttle == nil {
+		return nil
+	}
+
+	return c.builder.throttle.stats()
+}
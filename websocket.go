@@ -0,0 +1,270 @@
+package surf
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/enetx/g"
+	"github.com/enetx/http"
+	"github.com/enetx/surf/header"
+	"github.com/enetx/surf/pkg/websocket"
+	"golang.org/x/net/http/httpguts"
+)
+
+// WebSocket opens a WebSocket connection to the specified ws:// or wss:// URL.
+// It is a shortcut for Get(rawURL).Upgrade().
+func (c *Client) WebSocket(rawURL g.String) g.Result[*websocket.Conn] { return c.Get(rawURL).Upgrade() }
+
+// Upgrade performs the WebSocket opening handshake (RFC 6455) for the request and returns
+// a message-oriented connection with ping/pong handling, close codes, fragmentation and
+// permessage-deflate compression.
+//
+// The handshake is sent through the client's own transport, so the JA fingerprint, proxy
+// dialer, cookie jar, request middlewares and impersonated header order all apply to it, and
+// it passes the circuit breaker, rate limits and throttling of the client like any request.
+// The extensions offered by the impersonated browser are sent unless the
+// Sec-WebSocket-Extensions header is set. The in-flight slot of the handshake under
+// MaxInFlight is released once the response is received.
+// The ws and wss schemes are mapped to http and https. Subprotocols can be requested by
+// setting the Sec-WebSocket-Protocol header before calling Upgrade.
+//
+// Over HTTPS, an existing HTTP/2 or HTTP/3 connection to the origin is reused through extended
+// CONNECT (RFC 8441, RFC 9220) when the server enabled it in its SETTINGS, as browsers do.
+// Otherwise the handshake is sent as an HTTP/1.1 Upgrade request on a new connection.
+//
+// The client timeout is not applied because it would bound the lifetime of the connection;
+// the handshake is still limited by the dial, TLS and response header timeouts.
+// Response middlewares are not run for the 101 Switching Protocols response.
+func (req *Request) Upgrade() g.Result[*websocket.Conn] {
+	if req.err != nil {
+		return g.Err[*websocket.Conn](req.err)
+	}
+
+	r := req.request
+
+	if r.Method != http.MethodGet {
+		return g.Err[*websocket.Conn](&ErrWebSocketHandshake{fmt.Sprintf("method %s is not allowed", r.Method)})
+	}
+
+	switch strings.ToLower(r.URL.Scheme) {
+	case "ws", "http":
+		r.URL.Scheme = "http"
+	case "wss", "https":
+		r.URL.Scheme = "https"
+	default:
+		return g.Err[*websocket.Conn](&ErrWebSocketHandshake{fmt.Sprintf("unsupported URL scheme %q", r.URL.Scheme)})
+	}
+
+	req.upgrade = true
+
+	key := websocket.NewKey()
+
+	r.Header.Set(header.CONNECTION, "Upgrade")
+	r.Header.Set(header.UPGRADE, "websocket")
+	r.Header.Set(header.SEC_WEBSOCKET_VERSION, websocket.Version)
+	r.Header.Set(header.SEC_WEBSOCKET_KEY, key)
+
+	builder := req.cli.builder
+
+	// Offer the extensions of the impersonated browser
+	if _, ok := r.Header[http.CanonicalHeaderKey(header.SEC_WEBSOCKET_EXTENSIONS)]; !ok {
+		extensions := websocket.ChromeExtensions
+		if builder != nil && builder.browser == firefoxBrowser {
+			extensions = websocket.FirefoxExtensions
+		}
+
+		r.Header.Set(header.SEC_WEBSOCKET_EXTENSIONS, extensions)
+	}
+
+	if r.Header.Get(header.ORIGIN) == "" {
+		r.Header.Set(header.ORIGIN, r.URL.Scheme+"://"+r.URL.Host)
+	}
+
+	if err := req.cli.applyReqMW(req); err != nil {
+		req.unsent()
+		return g.Err[*websocket.Conn](err)
+	}
+
+	// Middlewares may replace the request, e.g. to set its context
+	r = req.request
+
+	if err := req.cli.routeProxy(req); err != nil {
+		req.unsent()
+		return g.Err[*websocket.Conn](err)
+	}
+
+	proxied, err := req.cli.proxyClient(req.proxy)
+	if err != nil {
+		req.unsent()
+		return g.Err[*websocket.Conn](err)
+	}
+
+	cli := *proxied
+	cli.Timeout = 0
+
+	if builder != nil && builder.breaker != nil {
+		if err := builder.breaker.allow(req); err != nil {
+			req.unsent()
+			return g.Err[*websocket.Conn](err)
+		}
+	}
+
+	release, err := req.cli.admit(req)
+	if err != nil {
+		if builder != nil && builder.breaker != nil {
+			builder.breaker.record(req, circuitIgnored)
+		}
+
+		req.unsent()
+
+		return g.Err[*websocket.Conn](err)
+	}
+
+	sent := time.Now()
+
+	resp, err := cli.Do(r)
+
+	req.cli.settle(req, sent, resp, err, release)
+
+	if builder != nil && builder.proxyPool != nil && r.Context().Err() == nil {
+		builder.proxyPool.report(req.proxy, time.Since(sent), err)
+	}
+
+	if builder != nil && builder.breaker != nil {
+		builder.breaker.observe(req, resp, err)
+
+		// Response middlewares matching the failure patterns are not run for the handshake
+		if err == nil {
+			builder.breaker.release(req, resp)
+		}
+	}
+
+	if err != nil {
+		return g.Err[*websocket.Conn](err)
+	}
+
+	conn, err := req.handshake(resp, key)
+	if err != nil {
+		resp.Body.Close()
+		return g.Err[*websocket.Conn](err)
+	}
+
+	return g.Ok(conn)
+}
+
+// handshake validates the server's opening handshake response and wraps the upgraded stream.
+func (req *Request) handshake(resp *http.Response, key string) (*websocket.Conn, error) {
+	fail := func(format string, args ...any) error {
+		return &ErrWebSocketHandshake{fmt.Sprintf(`%s "%s": `+format,
+			append([]any{req.request.Method, req.request.URL.String()}, args...)...)}
+	}
+
+	switch {
+	case resp.ProtoMajor >= 2:
+		// Extended CONNECT: any 2xx status opens the stream, no upgrade headers or accept key are sent.
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fail("unexpected response status %s", resp.Status)
+		}
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		return nil, fail("unexpected response status %s", resp.Status)
+	case !strings.EqualFold(resp.Header.Get(header.UPGRADE), "websocket") ||
+		!httpguts.HeaderValuesContainsToken(resp.Header.Values(header.CONNECTION), "upgrade"):
+		return nil, fail("missing upgrade headers")
+	case resp.Header.Get(header.SEC_WEBSOCKET_ACCEPT) != websocket.AcceptKey(key):
+		return nil, fail("invalid Sec-WebSocket-Accept")
+	}
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return nil, fail("response body is not writable")
+	}
+
+	opts, err := negotiate(req.request.Header, resp.Header)
+	if err != nil {
+		return nil, fail("%v", err)
+	}
+
+	return websocket.NewConn(rwc, opts), nil
+}
+
+// negotiate checks the extensions and subprotocol selected by the server against the offer.
+func negotiate(offer, answer http.Header) (websocket.Options, error) {
+	var opts websocket.Options
+
+	deflate, accepted, err := websocket.ParseDeflate(answer.Get(header.SEC_WEBSOCKET_EXTENSIONS))
+	if err != nil {
+		return opts, err
+	}
+
+	if accepted {
+		if !strings.Contains(offer.Get(header.SEC_WEBSOCKET_EXTENSIONS), "permessage-deflate") {
+			return opts, fmt.Errorf("server accepted permessage-deflate which was not offered")
+		}
+
+		opts.Deflate = &deflate
+	}
+
+	if protocol := answer.Get(header.SEC_WEBSOCKET_PROTOCOL); protocol != "" {
+		if !httpguts.HeaderValuesContainsToken(offer.Values(header.SEC_WEBSOCKET_PROTOCOL), protocol) {
+			return opts, fmt.Errorf("server selected subprotocol %q which was not offered", protocol)
+		}
+
+		opts.Subprotocol = protocol
+	}
+
+	return opts, nil
+}
+
+// extendedConnectRequest converts a WebSocket opening handshake into an extended CONNECT
+// request (RFC 8441, RFC 9220) for the given protocol, carried in Proto as the HTTP/3
+// transport expects. The hop-by-hop upgrade headers and the key are not used over HTTP/2
+// and HTTP/3. Data written to the returned pipe is sent on the request stream.
+func extendedConnectRequest(req *http.Request, protocol string) (*http.Request, *io.PipeWriter) {
+	pr, pw := io.Pipe()
+
+	r := req.Clone(req.Context())
+	r.Method = http.MethodConnect
+	r.Proto = protocol
+	r.Body = pr
+	r.ContentLength = -1
+	r.GetBody = nil
+
+	r.Header.Del(header.CONNECTION)
+	r.Header.Del(header.UPGRADE)
+	r.Header.Del(header.SEC_WEBSOCKET_KEY)
+
+	// :protocol follows the other pseudo-headers, as sent by browsers.
+	if order, ok := r.Header[http.PHeaderOrderKey]; ok {
+		r.Header[http.PHeaderOrderKey] = append(order, ":protocol")
+	}
+
+	return r, pw
+}
+
+// extendedConnectResponse turns the response body of a successful extended CONNECT into
+// a bidirectional stream writing into the request pipe.
+func extendedConnectResponse(resp *http.Response, pw *io.PipeWriter) *http.Response {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		pw.Close()
+		return resp
+	}
+
+	resp.Body = &connectStream{ReadCloser: resp.Body, w: pw}
+
+	return resp
+}
+
+// connectStream is the data stream of an extended CONNECT request.
+type connectStream struct {
+	io.ReadCloser
+	w *io.PipeWriter
+}
+
+func (s *connectStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+func (s *connectStream) Close() error {
+	s.w.Close()
+	return s.ReadCloser.Close()
+}